@@ -25,11 +25,20 @@ func main() {
 		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_users_composite ON users(tenant_id, is_active, created_at)",
 		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_users_tenant_role ON users(tenant_id, role_id)",
 		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_users_email_tenant ON users(email, tenant_id)",
+		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_tenant_users_tenant_role ON tenant_users(tenant_id, role_id)",
+		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_invitations_tenant_pending ON invitations(tenant_id, email, accepted_at, revoked_at)",
 		
 		// Session management indexes
 		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_user_sessions_cleanup ON user_sessions(expires_at, is_revoked)",
 		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_user_sessions_tenant_user ON user_sessions(tenant_id, user_id)",
 		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_user_sessions_token_active ON user_sessions(token_hash, is_revoked)",
+		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_user_sessions_family_active ON user_sessions(family_id, is_revoked)",
+		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_oauth_refresh_tokens_client_user ON oauth_refresh_tokens(client_id, user_id, revoked_at)",
+		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_security_events_user_type ON security_events(user_id, type, created_at)",
+		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_password_reset_tokens_user_unused ON password_reset_tokens(user_id, used_at)",
+		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_magic_link_tokens_user_unused ON magic_link_tokens(user_id, used_at)",
+		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_password_histories_user_created ON password_histories(user_id, created_at DESC)",
+		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_recovery_codes_user_unused ON recovery_codes(user_id, used_at)",
 		
 		// Notification indexes
 		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_notifications_tenant_status ON notifications(tenant_id, status)",
@@ -45,6 +54,7 @@ func main() {
 		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_roles_tenant_system ON roles(tenant_id, is_system_role)",
 		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_permissions_resource_action ON permissions(resource, action)",
 		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_permissions_system ON permissions(is_system_permission)",
+		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_access_policies_tenant_enabled ON access_policies(tenant_id, is_enabled)",
 		
 		// Audit log indexes
 		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_audit_logs_composite ON audit_logs(tenant_id, action, created_at)",
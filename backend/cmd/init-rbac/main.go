@@ -13,6 +13,13 @@ func main() {
 	config.LoadConfig()
 	config.InitDatabase()
 
+	// Let running API instances know the roles changed
+	config.InitRedis()
+	defer config.CloseRedis()
+	if err := services.RegisterPermissionCacheCallbacks(config.DB); err != nil {
+		log.Fatalf("Failed to set up permission cache invalidation: %v", err)
+	}
+
 	// Initialize RBAC
 	rbacService := services.NewRBACService(config.DB)
 	
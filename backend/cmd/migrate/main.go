@@ -14,6 +14,13 @@ func main() {
 	config.LoadConfig()
 	config.InitDatabase()
 
+	// Let running API instances know the roles changed
+	config.InitRedis()
+	defer config.CloseRedis()
+	if err := services.RegisterPermissionCacheCallbacks(config.DB); err != nil {
+		log.Fatalf("Failed to set up permission cache invalidation: %v", err)
+	}
+
 	// Auto migrate all models
 	err := config.DB.AutoMigrate(
 		&models.User{},
@@ -26,15 +33,34 @@ func main() {
 		&models.SystemUser{},
 		&models.SystemAuditLog{},
 		&models.TenantUser{},
+		&models.Invitation{},
 		&models.TenantSettings{},
 		&models.TenantModule{},
 		&models.Module{},
 		&models.DomainMapping{},
 		&models.AuditLog{},
 		&models.UserSession{},
+		&models.SecurityEvent{},
+		&models.PasswordResetToken{},
+		&models.MagicLinkToken{},
+		&models.PasswordHistory{},
+		&models.UserTwoFactor{},
+		&models.RecoveryCode{},
+		&models.SigningKey{},
+		&models.TenantOIDCProvider{},
+		&models.TenantSAMLProvider{},
+		&models.APIKey{},
+		&models.SCIMToken{},
+		&models.OAuthClient{},
+		&models.OAuthAuthorizationCode{},
+		&models.OAuthRefreshToken{},
+		&models.OAuthConsent{},
+		&models.UserIdentity{},
+		&models.SSOLoginState{},
 		&models.Notification{},
 		&models.UserNotification{},
 		&models.CustomerProfile{},
+		&models.AccessPolicy{},
 	)
 	if err != nil {
 		log.Fatalf("Failed to migrate database: %v", err)
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"log"
+
+	"golang_saas/config"
+	"golang_saas/services"
+)
+
+// Rotates the JWT signing keys. Run it on a schedule (e.g. monthly): each run
+// activates the key published by the previous run and publishes the next one.
+func main() {
+	// Initialize database
+	config.LoadConfig()
+	config.InitDatabase()
+
+	signingKeyService := services.NewSigningKeyService(config.DB)
+	if err := signingKeyService.Rotate(); err != nil {
+		log.Fatalf("Failed to rotate signing keys: %v", err)
+	}
+
+	log.Println("JWT signing keys rotated successfully")
+}
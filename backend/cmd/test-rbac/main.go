@@ -16,6 +16,13 @@ func main() {
 	config.LoadConfig()
 	config.InitDatabase()
 
+	// Let running API instances know the roles changed
+	config.InitRedis()
+	defer config.CloseRedis()
+	if err := services.RegisterPermissionCacheCallbacks(config.DB); err != nil {
+		log.Fatalf("Failed to set up permission cache invalidation: %v", err)
+	}
+
 	// Test RBAC System
 	testRBACSystem()
 }
@@ -79,7 +86,7 @@ func testRBACSystem() {
 	log.Println("5. Testing Permission Checking...")
 	
 	// System admin should have system permissions
-	hasPermission, err := rbacService.CheckUserPermission(systemAdmin.ID, "tenant.create", nil)
+	hasPermission, err := rbacService.CheckUserPermission(systemAdmin.ID, "tenant.create", nil, nil)
 	if err != nil {
 		log.Printf("Error: %v", err)
 	} else if hasPermission {
@@ -89,7 +96,7 @@ func testRBACSystem() {
 	}
 
 	// Tenant admin should have tenant permissions
-	hasPermission, err = rbacService.CheckUserPermission(tenantAdmin.ID, "tenant_user.create", &tenant.ID)
+	hasPermission, err = rbacService.CheckUserPermission(tenantAdmin.ID, "tenant_user.create", &tenant.ID, nil)
 	if err != nil {
 		log.Printf("Error: %v", err)
 	} else if hasPermission {
@@ -99,7 +106,7 @@ func testRBACSystem() {
 	}
 
 	// Tenant admin should NOT have system permissions
-	hasPermission, err = rbacService.CheckUserPermission(tenantAdmin.ID, "tenant.create", nil)
+	hasPermission, err = rbacService.CheckUserPermission(tenantAdmin.ID, "tenant.create", nil, nil)
 	if err != nil {
 		log.Printf("Error: %v", err)
 	} else if !hasPermission {
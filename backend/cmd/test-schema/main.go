@@ -7,6 +7,7 @@ import (
 	"time"
 	"golang_saas/config"
 	"golang_saas/models"
+	"golang_saas/services"
 	"github.com/google/uuid"
 	"gorm.io/datatypes"
 )
@@ -16,6 +17,13 @@ func main() {
 	config.LoadConfig()
 	config.InitDatabase()
 
+	// Let running API instances know the roles changed
+	config.InitRedis()
+	defer config.CloseRedis()
+	if err := services.RegisterPermissionCacheCallbacks(config.DB); err != nil {
+		log.Fatalf("Failed to set up permission cache invalidation: %v", err)
+	}
+
 	log.Println("Starting database schema tests...")
 
 	// Test 1: Create and validate a Plan
@@ -221,7 +229,7 @@ func testUserSessionModel() error {
 
 	session := models.UserSession{
 		UserID:           user.ID,
-		TenantID:         tenant.ID,
+		TenantID:         &tenant.ID,
 		TokenHash:        "hashed_token_" + uuid.New().String(),
 		RefreshTokenHash: stringPtr("hashed_refresh_token"),
 		IPAddress:        stringPtr("192.168.1.1"),
@@ -1,9 +1,14 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"math"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
@@ -26,12 +31,15 @@ type Config struct {
 	JWTSecret            string
 	JWTExpireHours       int
 	JWTRefreshExpireHours int
+	JWTSigningAlgorithm  string
 
 	// Application configuration
-	AppEnv    string
-	AppPort   string
-	AppDomain string
-	AppName   string
+	AppEnv      string
+	AppPort     string
+	AppDomain   string
+	AppName     string
+	PublicURL   string
+	FrontendURL string
 
 	// Email configuration
 	SMTPHost     string
@@ -49,11 +57,34 @@ type Config struct {
 	RateLimitRequests int
 	RateLimitWindow   int
 
+	// Login throttling
+	LoginMaxFailures    int
+	LoginIPMaxFailures  int
+	LoginLockoutMinutes int
+
 	// Security
-	BCryptCost int
+	BCryptCost    int
+	EncryptionKey string
+
+	// Impersonation
+	ImpersonationTokenMinutes int
+
+	// Password hashing
+	PasswordHashAlgorithm string
+	Argon2Memory          int
+	Argon2Iterations      int
+	Argon2Parallelism     int
+	// Base URL of a Pwned Passwords compatible range API, such as
+	// https://api.pwnedpasswords.com, consulted when a password policy blocks breached
+	// passwords. Empty uses only the bundled list.
+	PwnedPasswordsURL string
 
 	// CORS
 	CORSAllowedOrigins string
+
+	// Reverse proxies whose X-Forwarded-For header is trusted for the client IP, as
+	// comma-separated IPs or CIDRs. Without any, the connection's address is used.
+	TrustedProxies string
 }
 
 var AppConfig *Config
@@ -82,12 +113,15 @@ func LoadConfig() {
 		JWTSecret:            getEnv("JWT_SECRET", "your-super-secret-jwt-key"),
 		JWTExpireHours:       getEnvAsInt("JWT_EXPIRE_HOURS", 24),
 		JWTRefreshExpireHours: getEnvAsInt("JWT_REFRESH_EXPIRE_HOURS", 168),
+		JWTSigningAlgorithm:  getEnv("JWT_SIGNING_ALGORITHM", "RS256"),
 
 		// Application
-		AppEnv:    getEnv("APP_ENV", "development"),
-		AppPort:   getEnv("APP_PORT", "3000"),
-		AppDomain: getEnv("APP_DOMAIN", "localhost:3000"),
-		AppName:   getEnv("APP_NAME", "GoLang SaaS Platform"),
+		AppEnv:      getEnv("APP_ENV", "development"),
+		AppPort:     getEnv("APP_PORT", "3000"),
+		AppDomain:   getEnv("APP_DOMAIN", "localhost:3000"),
+		AppName:     getEnv("APP_NAME", "GoLang SaaS Platform"),
+		PublicURL:   getEnv("PUBLIC_URL", "http://localhost:3000"),
+		FrontendURL: getEnv("FRONTEND_URL", "http://localhost:3001"),
 
 		// Email
 		SMTPHost:     getEnv("SMTP_HOST", ""),
@@ -105,12 +139,73 @@ func LoadConfig() {
 		RateLimitRequests: getEnvAsInt("RATE_LIMIT_REQUESTS", 100),
 		RateLimitWindow:   getEnvAsInt("RATE_LIMIT_WINDOW", 3600),
 
+		// Login throttling
+		LoginMaxFailures:    getEnvAsInt("LOGIN_MAX_FAILURES", 5),
+		LoginIPMaxFailures:  getEnvAsInt("LOGIN_IP_MAX_FAILURES", 50),
+		LoginLockoutMinutes: getEnvAsInt("LOGIN_LOCKOUT_MINUTES", 15),
+
 		// Security
-		BCryptCost: getEnvAsInt("BCRYPT_COST", 12),
+		BCryptCost:    getEnvAsInt("BCRYPT_COST", 12),
+		EncryptionKey: getEnv("ENCRYPTION_KEY", ""),
+
+		// Impersonation
+		ImpersonationTokenMinutes: getEnvAsInt("IMPERSONATION_TOKEN_MINUTES", 30),
+
+		// Password hashing
+		PasswordHashAlgorithm: getEnv("PASSWORD_HASH_ALGORITHM", "argon2id"),
+		Argon2Memory:          getEnvAsInt("ARGON2_MEMORY_KIB", 65536),
+		Argon2Iterations:      getEnvAsInt("ARGON2_ITERATIONS", 3),
+		Argon2Parallelism:     getEnvAsInt("ARGON2_PARALLELISM", 2),
+		PwnedPasswordsURL:     getEnv("PWNED_PASSWORDS_URL", ""),
 
 		// CORS
 		CORSAllowedOrigins: getEnv("CORS_ALLOWED_ORIGINS", "http://localhost:3001,http://localhost:3000"),
+
+		// Proxies
+		TrustedProxies: getEnv("TRUSTED_PROXIES", ""),
+	}
+
+	if err := AppConfig.validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+}
+
+// validate rejects settings that would only fail once in use
+func (c *Config) validate() error {
+	switch c.PasswordHashAlgorithm {
+	case "argon2id", "bcrypt":
+	default:
+		return fmt.Errorf("PASSWORD_HASH_ALGORITHM must be argon2id or bcrypt, got %q", c.PasswordHashAlgorithm)
+	}
+	if c.Argon2Iterations < 1 {
+		return errors.New("ARGON2_ITERATIONS must be at least 1")
+	}
+	if c.Argon2Parallelism < 1 || c.Argon2Parallelism > 255 {
+		return errors.New("ARGON2_PARALLELISM must be between 1 and 255")
+	}
+	if c.Argon2Memory < 8*c.Argon2Parallelism || int64(c.Argon2Memory) > math.MaxUint32 {
+		return fmt.Errorf("ARGON2_MEMORY_KIB must be between 8 x ARGON2_PARALLELISM and %d", uint32(math.MaxUint32))
+	}
+	if c.BCryptCost < 4 || c.BCryptCost > 31 {
+		return errors.New("BCRYPT_COST must be between 4 and 31")
+	}
+	if c.PwnedPasswordsURL != "" {
+		if u, err := url.Parse(c.PwnedPasswordsURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("PWNED_PASSWORDS_URL must be an http(s) URL, got %q", c.PwnedPasswordsURL)
+		}
+	}
+	return nil
+}
+
+// TrustedProxyList returns the configured trusted proxies, or nil if there are none
+func (c *Config) TrustedProxyList() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(c.TrustedProxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
 	}
+	return proxies
 }
 
 func getEnv(key, defaultValue string) string {
@@ -77,15 +77,34 @@ func InitDatabase() {
 		&models.SystemUser{},
 		&models.SystemAuditLog{},
 		&models.TenantUser{},
+		&models.Invitation{},
 		&models.TenantSettings{},
 		&models.TenantModule{},
 		&models.Module{},
 		&models.DomainMapping{},
 		&models.AuditLog{},
 		&models.UserSession{},
+		&models.SecurityEvent{},
+		&models.PasswordResetToken{},
+		&models.MagicLinkToken{},
+		&models.PasswordHistory{},
+		&models.UserTwoFactor{},
+		&models.RecoveryCode{},
+		&models.SigningKey{},
+		&models.TenantOIDCProvider{},
+		&models.TenantSAMLProvider{},
+		&models.APIKey{},
+		&models.SCIMToken{},
+		&models.OAuthClient{},
+		&models.OAuthAuthorizationCode{},
+		&models.OAuthRefreshToken{},
+		&models.OAuthConsent{},
+		&models.UserIdentity{},
+		&models.SSOLoginState{},
 		&models.Notification{},
 		&models.UserNotification{},
 		&models.CustomerProfile{},
+		&models.AccessPolicy{},
 	)
 	if err != nil {
 		log.Fatal("Failed to auto-migrate models:", err)
@@ -93,6 +112,9 @@ func InitDatabase() {
 
 	log.Println("Database connected and migrated successfully")
 
+	dropLegacyIndexes()
+	backfillTenantMemberships()
+
 	// Seed initial data
 	seedInitialData()
 }
@@ -153,6 +175,46 @@ func GetTenantDB(tenantID string) *gorm.DB {
 	return tenantDB
 }
 
+// dropLegacyIndexes removes indexes that AutoMigrate leaves behind after a model stops
+// declaring them
+func dropLegacyIndexes() {
+	// Tenant settings were unique per tenant before they were keyed by (tenant, key)
+	migrator := DB.Migrator()
+	if migrator.HasIndex(&models.TenantSettings{}, "idx_tenant_settings_tenant_id") {
+		if err := migrator.DropIndex(&models.TenantSettings{}, "idx_tenant_settings_tenant_id"); err != nil {
+			log.Printf("Warning: Could not drop legacy tenant settings index: %v", err)
+		}
+	}
+}
+
+// backfillTenantMemberships creates the membership of every tenant user in their primary
+// tenant, for users created before memberships carried the role
+func backfillTenantMemberships() {
+	err := DB.Exec("UPDATE tenant_users SET role_id = (SELECT role_id FROM users WHERE users.id = tenant_users.user_id) WHERE role_id IS NULL").Error
+	if err != nil {
+		log.Printf("Warning: Could not backfill tenant membership roles: %v", err)
+	}
+
+	var users []models.User
+	err = DB.Where("tenant_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM tenant_users WHERE tenant_users.user_id = users.id AND tenant_users.tenant_id = users.tenant_id)").
+		Find(&users).Error
+	if err != nil {
+		log.Printf("Warning: Could not backfill tenant memberships: %v", err)
+		return
+	}
+
+	for _, user := range users {
+		membership := models.TenantUser{
+			UserID:   user.ID,
+			TenantID: *user.TenantID,
+			RoleID:   user.RoleID,
+		}
+		if err := DB.Create(&membership).Error; err != nil {
+			log.Printf("Warning: Could not create tenant membership for user %s: %v", user.ID, err)
+		}
+	}
+}
+
 func seedInitialData() {
 	// Create default plans
 	plans := []models.Plan{
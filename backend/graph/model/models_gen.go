@@ -11,6 +11,37 @@ import (
 	"time"
 )
 
+type LoginResult interface {
+	IsLoginResult()
+}
+
+type AccessPolicy struct {
+	ID          string       `json:"id"`
+	TenantID    string       `json:"tenantId"`
+	Name        string       `json:"name"`
+	Description *string      `json:"description,omitempty"`
+	Effect      PolicyEffect `json:"effect"`
+	Permissions []string     `json:"permissions"`
+	Condition   string       `json:"condition"`
+	Timezone    string       `json:"timezone"`
+	IsEnabled   bool         `json:"isEnabled"`
+	CreatedAt   time.Time    `json:"createdAt"`
+	UpdatedAt   time.Time    `json:"updatedAt"`
+}
+
+type APIKey struct {
+	ID          string     `json:"id"`
+	TenantID    string     `json:"tenantId"`
+	UserID      string     `json:"userId"`
+	Name        string     `json:"name"`
+	Prefix      string     `json:"prefix"`
+	Permissions []string   `json:"permissions"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt  *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt   *time.Time `json:"revokedAt,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+}
+
 type AssignPermissionInput struct {
 	UserID        string   `json:"userId"`
 	PermissionIds []string `json:"permissionIds"`
@@ -22,11 +53,32 @@ type AssignRoleInput struct {
 }
 
 type AuthPayload struct {
-	Token        string         `json:"token"`
-	RefreshToken string         `json:"refreshToken"`
-	User         *models.User   `json:"user"`
-	Tenant       *models.Tenant `json:"tenant,omitempty"`
-	Permissions  []string       `json:"permissions"`
+	Token         string         `json:"token"`
+	RefreshToken  string         `json:"refreshToken"`
+	User          *models.User   `json:"user"`
+	Tenant        *models.Tenant `json:"tenant,omitempty"`
+	Permissions   []string       `json:"permissions"`
+	RecoveryCodes []string       `json:"recoveryCodes,omitempty"`
+}
+
+func (AuthPayload) IsLoginResult() {}
+
+type CreateAccessPolicyInput struct {
+	TenantID    string       `json:"tenantId"`
+	Name        string       `json:"name"`
+	Description *string      `json:"description,omitempty"`
+	Effect      PolicyEffect `json:"effect"`
+	Permissions []string     `json:"permissions"`
+	Condition   string       `json:"condition"`
+	Timezone    *string      `json:"timezone,omitempty"`
+	IsEnabled   *bool        `json:"isEnabled,omitempty"`
+}
+
+type CreateAPIKeyInput struct {
+	TenantID    string     `json:"tenantId"`
+	Name        string     `json:"name"`
+	Permissions []string   `json:"permissions"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
 }
 
 type CreateCustomerInput struct {
@@ -39,6 +91,16 @@ type CreateCustomerInput struct {
 	Preferences map[string]any `json:"preferences,omitempty"`
 	Tags        map[string]any `json:"tags,omitempty"`
 	Metadata    map[string]any `json:"metadata,omitempty"`
+	UserID      *string        `json:"userId,omitempty"`
+}
+
+type CreateOAuthClientInput struct {
+	TenantID       *string  `json:"tenantId,omitempty"`
+	Name           string   `json:"name"`
+	RedirectUris   []string `json:"redirectUris,omitempty"`
+	Scopes         []string `json:"scopes,omitempty"`
+	GrantTypes     []string `json:"grantTypes,omitempty"`
+	IsConfidential *bool    `json:"isConfidential,omitempty"`
 }
 
 type CreateRoleInput struct {
@@ -68,9 +130,25 @@ type CreateUserInput struct {
 	RoleID    string `json:"roleId"`
 }
 
+type CreatedAPIKey struct {
+	APIKey *APIKey `json:"apiKey"`
+	Key    string  `json:"key"`
+}
+
+type CreatedOAuthClient struct {
+	Client       *OAuthClient `json:"client"`
+	ClientSecret *string      `json:"clientSecret,omitempty"`
+}
+
+type CreatedScimToken struct {
+	ScimToken *ScimToken `json:"scimToken"`
+	Token     string     `json:"token"`
+}
+
 type CustomerProfile struct {
 	ID          string         `json:"id"`
 	TenantID    string         `json:"tenantId"`
+	UserID      *string        `json:"userId,omitempty"`
 	Email       string         `json:"email"`
 	FirstName   string         `json:"firstName"`
 	LastName    string         `json:"lastName"`
@@ -85,15 +163,101 @@ type CustomerProfile struct {
 	UpdatedAt   time.Time      `json:"updatedAt"`
 }
 
+type GroupRoleMapping struct {
+	Group string `json:"group"`
+	Role  string `json:"role"`
+}
+
+type GroupRoleMappingInput struct {
+	Group string `json:"group"`
+	Role  string `json:"role"`
+}
+
+type ImpersonationPayload struct {
+	Token       string         `json:"token"`
+	ExpiresAt   time.Time      `json:"expiresAt"`
+	User        *models.User   `json:"user"`
+	Tenant      *models.Tenant `json:"tenant,omitempty"`
+	Permissions []string       `json:"permissions"`
+}
+
+type Invitation struct {
+	ID        string       `json:"id"`
+	TenantID  string       `json:"tenantId"`
+	Email     string       `json:"email"`
+	Role      *models.Role `json:"role"`
+	InvitedBy *models.User `json:"invitedBy,omitempty"`
+	ExpiresAt time.Time    `json:"expiresAt"`
+	CreatedAt time.Time    `json:"createdAt"`
+}
+
 type LoginInput struct {
 	Email      string  `json:"email"`
 	Password   string  `json:"password"`
 	TenantSlug *string `json:"tenantSlug,omitempty"`
 }
 
+type MfaChallenge struct {
+	ChallengeToken     string    `json:"challengeToken"`
+	ExpiresAt          time.Time `json:"expiresAt"`
+	EnrollmentRequired bool      `json:"enrollmentRequired"`
+}
+
+func (MfaChallenge) IsLoginResult() {}
+
 type Mutation struct {
 }
 
+type OAuthAuthorizationRequest struct {
+	Client          *OAuthClient   `json:"client"`
+	Tenant          *models.Tenant `json:"tenant"`
+	Scopes          []string       `json:"scopes"`
+	ConsentRequired bool           `json:"consentRequired"`
+}
+
+type OAuthAuthorizeInput struct {
+	ClientID            string  `json:"clientId"`
+	RedirectURI         *string `json:"redirectUri,omitempty"`
+	ResponseType        string  `json:"responseType"`
+	Scope               *string `json:"scope,omitempty"`
+	State               *string `json:"state,omitempty"`
+	Nonce               *string `json:"nonce,omitempty"`
+	CodeChallenge       *string `json:"codeChallenge,omitempty"`
+	CodeChallengeMethod *string `json:"codeChallengeMethod,omitempty"`
+}
+
+type OAuthClient struct {
+	ID             string     `json:"id"`
+	TenantID       *string    `json:"tenantId,omitempty"`
+	ClientID       string     `json:"clientId"`
+	Name           string     `json:"name"`
+	RedirectUris   []string   `json:"redirectUris"`
+	Scopes         []string   `json:"scopes"`
+	GrantTypes     []string   `json:"grantTypes"`
+	IsConfidential bool       `json:"isConfidential"`
+	LastUsedAt     *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt      *time.Time `json:"revokedAt,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+type OAuthConsent struct {
+	Client    *OAuthClient `json:"client"`
+	Scopes    []string     `json:"scopes"`
+	CreatedAt time.Time    `json:"createdAt"`
+	UpdatedAt time.Time    `json:"updatedAt"`
+}
+
+type OidcProviderInput struct {
+	Issuer            string                   `json:"issuer"`
+	ClientID          string                   `json:"clientId"`
+	ClientSecret      *string                  `json:"clientSecret,omitempty"`
+	Scopes            []string                 `json:"scopes,omitempty"`
+	ClaimMappings     *SsoClaimMappingsInput   `json:"claimMappings,omitempty"`
+	GroupRoleMappings []*GroupRoleMappingInput `json:"groupRoleMappings,omitempty"`
+	DefaultRoleID     *string                  `json:"defaultRoleId,omitempty"`
+	IsEnabled         *bool                    `json:"isEnabled,omitempty"`
+}
+
 type PaginatedCustomers struct {
 	Customers  []*CustomerProfile `json:"customers"`
 	Total      int32              `json:"total"`
@@ -139,6 +303,13 @@ type PaginationInput struct {
 	Limit *int32 `json:"limit,omitempty"`
 }
 
+type PasswordChangeRequired struct {
+	ChangeToken string    `json:"changeToken"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+func (PasswordChangeRequired) IsLoginResult() {}
+
 type PermissionCheck struct {
 	HasPermission bool    `json:"hasPermission"`
 	Permission    string  `json:"permission"`
@@ -166,11 +337,105 @@ type RolePermissionMatrix struct {
 	Permissions []string `json:"permissions"`
 }
 
+type SamlProviderInput struct {
+	IdpMetadataXML    *string                  `json:"idpMetadataXml,omitempty"`
+	AttributeMappings *SsoClaimMappingsInput   `json:"attributeMappings,omitempty"`
+	GroupRoleMappings []*GroupRoleMappingInput `json:"groupRoleMappings,omitempty"`
+	DefaultRoleID     *string                  `json:"defaultRoleId,omitempty"`
+	IsEnabled         *bool                    `json:"isEnabled,omitempty"`
+}
+
+type ScimToken struct {
+	ID         string     `json:"id"`
+	TenantID   string     `json:"tenantId"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+type SsoClaimMappings struct {
+	Email     string `json:"email"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Groups    string `json:"groups"`
+}
+
+type SsoClaimMappingsInput struct {
+	Email     *string `json:"email,omitempty"`
+	FirstName *string `json:"firstName,omitempty"`
+	LastName  *string `json:"lastName,omitempty"`
+	Groups    *string `json:"groups,omitempty"`
+}
+
 type TenantFilter struct {
 	Status *models.TenantStatus `json:"status,omitempty"`
 	Name   *string              `json:"name,omitempty"`
 }
 
+type TenantMembership struct {
+	Tenant    *models.Tenant `json:"tenant"`
+	Role      *models.Role   `json:"role"`
+	IsPrimary bool           `json:"isPrimary"`
+	IsCurrent bool           `json:"isCurrent"`
+}
+
+type TenantOidcProvider struct {
+	ID                string              `json:"id"`
+	TenantID          string              `json:"tenantId"`
+	Issuer            string              `json:"issuer"`
+	ClientID          string              `json:"clientId"`
+	Scopes            []string            `json:"scopes"`
+	ClaimMappings     *SsoClaimMappings   `json:"claimMappings"`
+	GroupRoleMappings []*GroupRoleMapping `json:"groupRoleMappings"`
+	DefaultRoleID     *string             `json:"defaultRoleId,omitempty"`
+	IsEnabled         bool                `json:"isEnabled"`
+	LoginURL          string              `json:"loginUrl"`
+	RedirectURI       string              `json:"redirectUri"`
+	CreatedAt         time.Time           `json:"createdAt"`
+	UpdatedAt         time.Time           `json:"updatedAt"`
+}
+
+type TenantSamlProvider struct {
+	ID                string              `json:"id"`
+	TenantID          string              `json:"tenantId"`
+	IdpEntityID       *string             `json:"idpEntityId,omitempty"`
+	IdpSsoURL         *string             `json:"idpSsoUrl,omitempty"`
+	AttributeMappings *SsoClaimMappings   `json:"attributeMappings"`
+	GroupRoleMappings []*GroupRoleMapping `json:"groupRoleMappings"`
+	DefaultRoleID     *string             `json:"defaultRoleId,omitempty"`
+	IsEnabled         bool                `json:"isEnabled"`
+	SpEntityID        string              `json:"spEntityId"`
+	SpAcsURL          string              `json:"spAcsUrl"`
+	SpMetadataURL     string              `json:"spMetadataUrl"`
+	SpCertificate     string              `json:"spCertificate"`
+	LoginURL          string              `json:"loginUrl"`
+	CreatedAt         time.Time           `json:"createdAt"`
+	UpdatedAt         time.Time           `json:"updatedAt"`
+}
+
+type TenantSetting struct {
+	Key       string         `json:"key"`
+	Value     map[string]any `json:"value,omitempty"`
+	UpdatedAt time.Time      `json:"updatedAt"`
+}
+
+type TwoFactorEnrollment struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauthUri"`
+}
+
+type UpdateAccessPolicyInput struct {
+	Name        *string       `json:"name,omitempty"`
+	Description *string       `json:"description,omitempty"`
+	Effect      *PolicyEffect `json:"effect,omitempty"`
+	Permissions []string      `json:"permissions,omitempty"`
+	Condition   *string       `json:"condition,omitempty"`
+	Timezone    *string       `json:"timezone,omitempty"`
+	IsEnabled   *bool         `json:"isEnabled,omitempty"`
+}
+
 type UpdateCustomerInput struct {
 	FirstName   *string        `json:"firstName,omitempty"`
 	LastName    *string        `json:"lastName,omitempty"`
@@ -180,6 +445,7 @@ type UpdateCustomerInput struct {
 	IsActive    *bool          `json:"isActive,omitempty"`
 	Tags        map[string]any `json:"tags,omitempty"`
 	Metadata    map[string]any `json:"metadata,omitempty"`
+	UserID      *string        `json:"userId,omitempty"`
 }
 
 type UpdateRoleInput struct {
@@ -209,6 +475,18 @@ type UserFilter struct {
 	TenantID *string `json:"tenantId,omitempty"`
 }
 
+type UserSession struct {
+	ID              string    `json:"id"`
+	TenantID        *string   `json:"tenantId,omitempty"`
+	IPAddress       *string   `json:"ipAddress,omitempty"`
+	UserAgent       *string   `json:"userAgent,omitempty"`
+	SignedInAt      time.Time `json:"signedInAt"`
+	LastActivity    time.Time `json:"lastActivity"`
+	ExpiresAt       time.Time `json:"expiresAt"`
+	IsCurrent       bool      `json:"isCurrent"`
+	IsImpersonation bool      `json:"isImpersonation"`
+}
+
 type ActionType string
 
 const (
@@ -335,6 +613,61 @@ func (e PermissionScope) MarshalJSON() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+type PolicyEffect string
+
+const (
+	PolicyEffectAllow PolicyEffect = "ALLOW"
+	PolicyEffectDeny  PolicyEffect = "DENY"
+)
+
+var AllPolicyEffect = []PolicyEffect{
+	PolicyEffectAllow,
+	PolicyEffectDeny,
+}
+
+func (e PolicyEffect) IsValid() bool {
+	switch e {
+	case PolicyEffectAllow, PolicyEffectDeny:
+		return true
+	}
+	return false
+}
+
+func (e PolicyEffect) String() string {
+	return string(e)
+}
+
+func (e *PolicyEffect) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = PolicyEffect(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid PolicyEffect", str)
+	}
+	return nil
+}
+
+func (e PolicyEffect) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *PolicyEffect) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e PolicyEffect) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
 type ResourceType string
 
 const (
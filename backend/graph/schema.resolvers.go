@@ -22,7 +22,7 @@ import (
 func (r *mutationResolver) RefreshToken(ctx context.Context, refreshToken string) (*model.AuthPayload, error) {
 	authService := services.NewAuthService(r.DB)
 
-	authResponse, err := authService.RefreshToken(refreshToken)
+	authResponse, err := authService.RefreshToken(ctx, refreshToken)
 	if err != nil {
 		return nil, err
 	}
@@ -54,16 +54,287 @@ func (r *mutationResolver) Register(ctx context.Context, input model.RegisterInp
 }
 
 // Login is the resolver for the login field.
-func (r *mutationResolver) Login(ctx context.Context, input model.LoginInput) (*model.AuthPayload, error) {
+func (r *mutationResolver) Login(ctx context.Context, input model.LoginInput) (model.LoginResult, error) {
 	authService := services.NewAuthService(r.DB)
 
 	return authService.Login(ctx, input)
 }
 
+// VerifyMfaChallenge is the resolver for the verifyMfaChallenge field.
+func (r *mutationResolver) VerifyMfaChallenge(ctx context.Context, challengeToken string, code string) (*model.AuthPayload, error) {
+	authService := services.NewAuthService(r.DB)
+	return authService.VerifyMFAChallenge(ctx, challengeToken, code)
+}
+
+// ChangeExpiredPassword is the resolver for the changeExpiredPassword field.
+func (r *mutationResolver) ChangeExpiredPassword(ctx context.Context, changeToken string, newPassword string) (model.LoginResult, error) {
+	authService := services.NewAuthService(r.DB)
+	return authService.ChangeExpiredPassword(ctx, changeToken, newPassword)
+}
+
 // Logout is the resolver for the logout field.
 func (r *mutationResolver) Logout(ctx context.Context) (bool, error) {
-	// For JWT, logout is typically handled client-side by removing the token
-	// Here we could implement token blacklisting if needed
+	session, ok := middleware.GetSessionFromContext(ctx)
+	if !ok || session == nil {
+		return false, middleware.ErrUnauthorized
+	}
+
+	authService := services.NewAuthService(r.DB)
+	if err := authService.Logout(ctx, session.ID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// LogoutAllDevices is the resolver for the logoutAllDevices field.
+func (r *mutationResolver) LogoutAllDevices(ctx context.Context) (bool, error) {
+	user, err := middleware.RequireUserSession(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	authService := services.NewAuthService(r.DB)
+	if err := authService.LogoutAllDevices(ctx, user.ID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SwitchTenant is the resolver for the switchTenant field.
+func (r *mutationResolver) SwitchTenant(ctx context.Context, tenantID string) (*model.AuthPayload, error) {
+	user, err := middleware.RequireUserSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	session, ok := middleware.GetSessionFromContext(ctx)
+	if !ok || session == nil {
+		return nil, middleware.ErrUnauthorized
+	}
+
+	tenantUUID, err := uuid.Parse(tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenant ID: %v", err)
+	}
+
+	authService := services.NewAuthService(r.DB)
+	return authService.SwitchTenant(ctx, user.ID, tenantUUID, session.ID)
+}
+
+// RevokeSession is the resolver for the revokeSession field.
+func (r *mutationResolver) RevokeSession(ctx context.Context, id string) (bool, error) {
+	user, err := middleware.RequireUserSession(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	sessionUUID, err := uuid.Parse(id)
+	if err != nil {
+		return false, fmt.Errorf("invalid session ID: %v", err)
+	}
+
+	sessionService := services.NewSessionService(r.DB)
+	if err := sessionService.RevokeUserSession(user.ID, sessionUUID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RequestPasswordReset is the resolver for the requestPasswordReset field.
+func (r *mutationResolver) RequestPasswordReset(ctx context.Context, email string, tenantSlug *string) (bool, error) {
+	authService := services.NewAuthService(r.DB)
+	if err := authService.RequestPasswordReset(ctx, email, tenantSlug); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ResetPassword is the resolver for the resetPassword field.
+func (r *mutationResolver) ResetPassword(ctx context.Context, token string, newPassword string) (bool, error) {
+	authService := services.NewAuthService(r.DB)
+	if err := authService.ResetPassword(ctx, token, newPassword); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RequestMagicLink is the resolver for the requestMagicLink field.
+func (r *mutationResolver) RequestMagicLink(ctx context.Context, email string, tenantSlug string) (bool, error) {
+	authService := services.NewAuthService(r.DB)
+	if err := authService.RequestMagicLink(ctx, email, tenantSlug); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ConsumeMagicLink is the resolver for the consumeMagicLink field.
+func (r *mutationResolver) ConsumeMagicLink(ctx context.Context, token string) (*model.AuthPayload, error) {
+	authService := services.NewAuthService(r.DB)
+	return authService.ConsumeMagicLink(ctx, token)
+}
+
+// VerifyEmail is the resolver for the verifyEmail field.
+func (r *mutationResolver) VerifyEmail(ctx context.Context, token string) (bool, error) {
+	authService := services.NewAuthService(r.DB)
+	if err := authService.VerifyEmail(ctx, token); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ResendVerification is the resolver for the resendVerification field.
+func (r *mutationResolver) ResendVerification(ctx context.Context, email string, tenantSlug *string) (bool, error) {
+	authService := services.NewAuthService(r.DB)
+	if err := authService.ResendVerification(ctx, email, tenantSlug); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// AcceptInvitation is the resolver for the acceptInvitation field.
+func (r *mutationResolver) AcceptInvitation(ctx context.Context, token string, password string, firstName *string, lastName *string) (model.LoginResult, error) {
+	authService := services.NewAuthService(r.DB)
+	return authService.AcceptInvitation(ctx, token, password, firstName, lastName)
+}
+
+// EnrollTwoFactor is the resolver for the enrollTwoFactor field.
+func (r *mutationResolver) EnrollTwoFactor(ctx context.Context, challengeToken *string) (*model.TwoFactorEnrollment, error) {
+	// Users required to enroll before their first sign-in authenticate with the login challenge
+	if challengeToken != nil {
+		authService := services.NewAuthService(r.DB)
+		return authService.EnrollTwoFactorWithChallenge(ctx, *challengeToken)
+	}
+
+	user, err := middleware.RequireUserSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	twoFactorService := services.NewTwoFactorService(r.DB)
+	return twoFactorService.Enroll(ctx, user)
+}
+
+// EnableTwoFactor is the resolver for the enableTwoFactor field.
+func (r *mutationResolver) EnableTwoFactor(ctx context.Context, code string) ([]string, error) {
+	user, err := middleware.RequireUserSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	twoFactorService := services.NewTwoFactorService(r.DB)
+	return twoFactorService.Enable(ctx, user, code)
+}
+
+// DisableTwoFactor is the resolver for the disableTwoFactor field.
+func (r *mutationResolver) DisableTwoFactor(ctx context.Context, code string) (bool, error) {
+	user, err := middleware.RequireUserSession(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	twoFactorService := services.NewTwoFactorService(r.DB)
+	if err := twoFactorService.Disable(ctx, user, code); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RegenerateRecoveryCodes is the resolver for the regenerateRecoveryCodes field.
+func (r *mutationResolver) RegenerateRecoveryCodes(ctx context.Context, code string) ([]string, error) {
+	user, err := middleware.RequireUserSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	twoFactorService := services.NewTwoFactorService(r.DB)
+	return twoFactorService.RegenerateRecoveryCodes(ctx, user, code)
+}
+
+// CompleteSsoLogin is the resolver for the completeSsoLogin field.
+func (r *mutationResolver) CompleteSsoLogin(ctx context.Context, ticket string) (model.LoginResult, error) {
+	authService := services.NewAuthService(r.DB)
+	return authService.CompleteSSOLogin(ctx, ticket)
+}
+
+// CreateAPIKey is the resolver for the createApiKey field.
+func (r *mutationResolver) CreateAPIKey(ctx context.Context, input model.CreateAPIKeyInput) (*model.CreatedAPIKey, error) {
+	// API keys cannot mint further keys
+	user, err := middleware.RequireUserSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKeyService := services.NewAPIKeyService(r.DB)
+	return apiKeyService.CreateAPIKey(ctx, user, input)
+}
+
+// RevokeAPIKey is the resolver for the revokeApiKey field.
+func (r *mutationResolver) RevokeAPIKey(ctx context.Context, id string) (bool, error) {
+	user, err := middleware.RequireUserSession(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	keyUUID, err := uuid.Parse(id)
+	if err != nil {
+		return false, fmt.Errorf("invalid API key ID: %v", err)
+	}
+
+	apiKeyService := services.NewAPIKeyService(r.DB)
+	apiKey, err := apiKeyService.GetAPIKey(keyUUID)
+	if err != nil {
+		return false, err
+	}
+
+	// Users revoke their own keys; tenant admins can revoke any key in the tenant
+	if apiKey.UserID != user.ID {
+		if err := requireTenantPermission(ctx, r.DB, "tenant_setting.update", apiKey.TenantID); err != nil {
+			return false, err
+		}
+	}
+
+	if err := apiKeyService.RevokeAPIKey(ctx, keyUUID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ApproveOAuthAuthorization is the resolver for the approveOAuthAuthorization field.
+func (r *mutationResolver) ApproveOAuthAuthorization(ctx context.Context, input model.OAuthAuthorizeInput) (string, error) {
+	user, err := middleware.RequireUserSession(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	oauthService := services.NewOAuthService(r.DB)
+	return oauthService.ApproveAuthorization(ctx, user.ID, input)
+}
+
+// DenyOAuthAuthorization is the resolver for the denyOAuthAuthorization field.
+func (r *mutationResolver) DenyOAuthAuthorization(ctx context.Context, input model.OAuthAuthorizeInput) (string, error) {
+	if _, err := middleware.RequireUserSession(ctx); err != nil {
+		return "", err
+	}
+
+	oauthService := services.NewOAuthService(r.DB)
+	return oauthService.DenyAuthorization(ctx, input)
+}
+
+// RevokeOAuthConsent is the resolver for the revokeOAuthConsent field.
+func (r *mutationResolver) RevokeOAuthConsent(ctx context.Context, clientID string) (bool, error) {
+	user, err := middleware.RequireUserSession(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	clientUUID, err := uuid.Parse(clientID)
+	if err != nil {
+		return false, fmt.Errorf("invalid OAuth client ID: %v", err)
+	}
+
+	oauthService := services.NewOAuthService(r.DB)
+	if err := oauthService.RevokeConsent(ctx, user.ID, clientUUID); err != nil {
+		return false, err
+	}
 	return true, nil
 }
 
@@ -84,18 +355,194 @@ func (r *mutationResolver) UpdateTenant(ctx context.Context, id string, input mo
 		return nil, err
 	}
 
-	tenantService := services.NewTenantService(r.DB)
-	return tenantService.UpdateTenant(ctx, id, input)
-}
+	tenantService := services.NewTenantService(r.DB)
+	return tenantService.UpdateTenant(ctx, id, input)
+}
+
+// DeleteTenant is the resolver for the deleteTenant field.
+func (r *mutationResolver) DeleteTenant(ctx context.Context, id string) (bool, error) {
+	if err := requireSystemPermission(ctx, r.DB, "tenant.delete"); err != nil {
+		return false, err
+	}
+
+	tenantService := services.NewTenantService(r.DB)
+	return tenantService.DeleteTenant(ctx, id)
+}
+
+// UpdateTenantSetting is the resolver for the updateTenantSetting field.
+func (r *mutationResolver) UpdateTenantSetting(ctx context.Context, tenantID string, key string, value map[string]any) (*model.TenantSetting, error) {
+	tenantUUID, err := uuid.Parse(tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenant ID: %v", err)
+	}
+
+	if err := requireTenantPermission(ctx, r.DB, "tenant_setting.update", tenantUUID); err != nil {
+		return nil, err
+	}
+
+	settingsService := services.NewTenantSettingsService(r.DB)
+	return settingsService.UpdateSetting(ctx, tenantUUID, key, value)
+}
+
+// ConfigureOidcProvider is the resolver for the configureOidcProvider field.
+func (r *mutationResolver) ConfigureOidcProvider(ctx context.Context, tenantID string, input model.OidcProviderInput) (*model.TenantOidcProvider, error) {
+	tenantUUID, err := uuid.Parse(tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenant ID: %v", err)
+	}
+
+	if err := requireTenantPermission(ctx, r.DB, "tenant_setting.update", tenantUUID); err != nil {
+		return nil, err
+	}
+
+	ssoService := services.NewSSOService(r.DB)
+	return ssoService.ConfigureOIDCProvider(ctx, tenantUUID, input)
+}
+
+// DeleteOidcProvider is the resolver for the deleteOidcProvider field.
+func (r *mutationResolver) DeleteOidcProvider(ctx context.Context, tenantID string) (bool, error) {
+	tenantUUID, err := uuid.Parse(tenantID)
+	if err != nil {
+		return false, fmt.Errorf("invalid tenant ID: %v", err)
+	}
+
+	if err := requireTenantPermission(ctx, r.DB, "tenant_setting.update", tenantUUID); err != nil {
+		return false, err
+	}
+
+	ssoService := services.NewSSOService(r.DB)
+	if err := ssoService.DeleteOIDCProvider(ctx, tenantUUID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ConfigureSamlProvider is the resolver for the configureSamlProvider field.
+func (r *mutationResolver) ConfigureSamlProvider(ctx context.Context, tenantID string, input model.SamlProviderInput) (*model.TenantSamlProvider, error) {
+	tenantUUID, err := uuid.Parse(tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenant ID: %v", err)
+	}
+
+	if err := requireTenantPermission(ctx, r.DB, "tenant_setting.update", tenantUUID); err != nil {
+		return nil, err
+	}
+
+	ssoService := services.NewSSOService(r.DB)
+	return ssoService.ConfigureSAMLProvider(ctx, tenantUUID, input)
+}
+
+// DeleteSamlProvider is the resolver for the deleteSamlProvider field.
+func (r *mutationResolver) DeleteSamlProvider(ctx context.Context, tenantID string) (bool, error) {
+	tenantUUID, err := uuid.Parse(tenantID)
+	if err != nil {
+		return false, fmt.Errorf("invalid tenant ID: %v", err)
+	}
+
+	if err := requireTenantPermission(ctx, r.DB, "tenant_setting.update", tenantUUID); err != nil {
+		return false, err
+	}
+
+	ssoService := services.NewSSOService(r.DB)
+	if err := ssoService.DeleteSAMLProvider(ctx, tenantUUID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CreateScimToken is the resolver for the createScimToken field.
+func (r *mutationResolver) CreateScimToken(ctx context.Context, tenantID string, name string) (*model.CreatedScimToken, error) {
+	// API keys cannot mint provisioning tokens
+	user, err := middleware.RequireUserSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tenantUUID, err := uuid.Parse(tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenant ID: %v", err)
+	}
+
+	if err := requireTenantPermission(ctx, r.DB, "tenant_setting.update", tenantUUID); err != nil {
+		return nil, err
+	}
+
+	scimTokenService := services.NewSCIMTokenService(r.DB)
+	return scimTokenService.CreateSCIMToken(ctx, user, tenantUUID, name)
+}
+
+// RevokeScimToken is the resolver for the revokeScimToken field.
+func (r *mutationResolver) RevokeScimToken(ctx context.Context, id string) (bool, error) {
+	tokenUUID, err := uuid.Parse(id)
+	if err != nil {
+		return false, fmt.Errorf("invalid SCIM token ID: %v", err)
+	}
+
+	scimTokenService := services.NewSCIMTokenService(r.DB)
+	scimToken, err := scimTokenService.GetSCIMToken(tokenUUID)
+	if err != nil {
+		return false, err
+	}
+
+	if err := requireTenantPermission(ctx, r.DB, "tenant_setting.update", scimToken.TenantID); err != nil {
+		return false, err
+	}
+
+	if err := scimTokenService.RevokeSCIMToken(ctx, tokenUUID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CreateOAuthClient is the resolver for the createOAuthClient field.
+func (r *mutationResolver) CreateOAuthClient(ctx context.Context, input model.CreateOAuthClientInput) (*model.CreatedOAuthClient, error) {
+	// API keys cannot register clients
+	user, err := middleware.RequireUserSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.TenantID != nil {
+		tenantUUID, err := uuid.Parse(*input.TenantID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tenant ID: %v", err)
+		}
+		if err := requireTenantPermission(ctx, r.DB, "tenant_setting.update", tenantUUID); err != nil {
+			return nil, err
+		}
+	} else if err := requireSystemPermission(ctx, r.DB, "system.manage"); err != nil {
+		return nil, err
+	}
+
+	oauthClientService := services.NewOAuthClientService(r.DB)
+	return oauthClientService.CreateOAuthClient(ctx, user, input)
+}
+
+// RevokeOAuthClient is the resolver for the revokeOAuthClient field.
+func (r *mutationResolver) RevokeOAuthClient(ctx context.Context, id string) (bool, error) {
+	clientUUID, err := uuid.Parse(id)
+	if err != nil {
+		return false, fmt.Errorf("invalid OAuth client ID: %v", err)
+	}
+
+	oauthClientService := services.NewOAuthClientService(r.DB)
+	client, err := oauthClientService.GetOAuthClient(clientUUID)
+	if err != nil {
+		return false, err
+	}
 
-// DeleteTenant is the resolver for the deleteTenant field.
-func (r *mutationResolver) DeleteTenant(ctx context.Context, id string) (bool, error) {
-	if err := requireSystemPermission(ctx, r.DB, "tenant.delete"); err != nil {
+	if client.TenantID != nil {
+		if err := requireTenantPermission(ctx, r.DB, "tenant_setting.update", *client.TenantID); err != nil {
+			return false, err
+		}
+	} else if err := requireSystemPermission(ctx, r.DB, "system.manage"); err != nil {
 		return false, err
 	}
 
-	tenantService := services.NewTenantService(r.DB)
-	return tenantService.DeleteTenant(ctx, id)
+	if err := oauthClientService.RevokeOAuthClient(ctx, clientUUID); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
 // CreateUser is the resolver for the createUser field.
@@ -142,8 +589,12 @@ func (r *mutationResolver) UpdateUser(ctx context.Context, id string, input mode
 	}
 
 	// Check appropriate permissions
-	if existingUser.TenantID != nil {
-		if err := requireTenantPermission(ctx, r.DB, "tenant_user.update", *existingUser.TenantID); err != nil {
+	tenantID, err := managedUserTenant(ctx, r.DB, &existingUser)
+	if err != nil {
+		return nil, err
+	}
+	if tenantID != nil {
+		if err := requireTenantPermission(ctx, r.DB, "tenant_user.update", *tenantID); err != nil {
 			return nil, err
 		}
 	} else {
@@ -153,7 +604,7 @@ func (r *mutationResolver) UpdateUser(ctx context.Context, id string, input mode
 	}
 
 	userService := services.NewUserService(r.DB)
-	return userService.UpdateUser(ctx, id, input)
+	return userService.UpdateUser(ctx, id, tenantID, input)
 }
 
 // DeleteUser is the resolver for the deleteUser field.
@@ -171,8 +622,12 @@ func (r *mutationResolver) DeleteUser(ctx context.Context, id string) (bool, err
 	}
 
 	// Check appropriate permissions
-	if existingUser.TenantID != nil {
-		if err := requireTenantPermission(ctx, r.DB, "tenant_user.delete", *existingUser.TenantID); err != nil {
+	tenantID, err := managedUserTenant(ctx, r.DB, &existingUser)
+	if err != nil {
+		return false, err
+	}
+	if tenantID != nil {
+		if err := requireTenantPermission(ctx, r.DB, "tenant_user.delete", *tenantID); err != nil {
 			return false, err
 		}
 	} else {
@@ -182,22 +637,255 @@ func (r *mutationResolver) DeleteUser(ctx context.Context, id string) (bool, err
 	}
 
 	userService := services.NewUserService(r.DB)
-	return userService.DeleteUser(ctx, id)
+	return userService.DeleteUser(ctx, id, tenantID)
+}
+
+// UnlockUser is the resolver for the unlockUser field.
+func (r *mutationResolver) UnlockUser(ctx context.Context, userID string) (bool, error) {
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return false, fmt.Errorf("invalid user ID: %v", err)
+	}
+
+	var existingUser models.User
+	err = r.DB.First(&existingUser, "id = ?", userUUID).Error
+	if err != nil {
+		return false, errors.New("user not found")
+	}
+
+	tenantID, err := managedUserTenant(ctx, r.DB, &existingUser)
+	if err != nil {
+		return false, err
+	}
+	if tenantID != nil {
+		if err := requireTenantPermission(ctx, r.DB, "tenant_user.update", *tenantID); err != nil {
+			return false, err
+		}
+	} else {
+		if err := requireSystemPermission(ctx, r.DB, "system_user.update"); err != nil {
+			return false, err
+		}
+	}
+
+	throttleService := services.NewLoginThrottleService(r.DB)
+	if err := throttleService.Unlock(ctx, &existingUser, tenantID); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// RevokeUserSessions is the resolver for the revokeUserSessions field.
+func (r *mutationResolver) RevokeUserSessions(ctx context.Context, userID string) (bool, error) {
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return false, fmt.Errorf("invalid user ID: %v", err)
+	}
+
+	var existingUser models.User
+	err = r.DB.First(&existingUser, "id = ?", userUUID).Error
+	if err != nil {
+		return false, errors.New("user not found")
+	}
+
+	// Tenant admins manage the user's sessions in their tenant only
+	tenantID, err := managedUserTenant(ctx, r.DB, &existingUser)
+	if err != nil {
+		return false, err
+	}
+	if tenantID != nil {
+		if err := requireTenantPermission(ctx, r.DB, "tenant_user.update", *tenantID); err != nil {
+			return false, err
+		}
+	} else {
+		if err := requireSystemPermission(ctx, r.DB, "system_user.update"); err != nil {
+			return false, err
+		}
+	}
+
+	sessionService := services.NewSessionService(r.DB)
+	if err := sessionService.RevokeUserSessionsInTenant(existingUser.ID, tenantID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ImpersonateUser is the resolver for the impersonateUser field.
+func (r *mutationResolver) ImpersonateUser(ctx context.Context, userID string, reason string) (*model.ImpersonationPayload, error) {
+	if err := requireSystemPermission(ctx, r.DB, "user.impersonate"); err != nil {
+		return nil, err
+	}
+
+	// Impersonation tokens and API keys cannot start another impersonation
+	impersonator, err := middleware.RequireUserSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user ID: %v", err)
+	}
+
+	impersonationService := services.NewImpersonationService(r.DB)
+	return impersonationService.ImpersonateUser(ctx, impersonator, userUUID, reason)
+}
+
+// InviteUser is the resolver for the inviteUser field.
+func (r *mutationResolver) InviteUser(ctx context.Context, email string, roleID string) (*model.Invitation, error) {
+	// The roles a user may grant are checked against everything they hold, which an API key
+	// or impersonation token does not carry
+	inviter, err := middleware.RequireUserSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	roleUUID, err := uuid.Parse(roleID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid role ID: %v", err)
+	}
+
+	var role models.Role
+	if err := r.DB.First(&role, "id = ?", roleUUID).Error; err != nil {
+		return nil, errors.New("role not found")
+	}
+	if role.TenantID == nil {
+		return nil, errors.New("invitations can only grant tenant roles")
+	}
+
+	if err := requireTenantPermission(ctx, r.DB, "tenant_user.create", *role.TenantID); err != nil {
+		return nil, err
+	}
+
+	invitationService := services.NewInvitationService(r.DB)
+	return invitationService.InviteUser(ctx, inviter, email, &role)
+}
+
+// ResendInvitation is the resolver for the resendInvitation field.
+func (r *mutationResolver) ResendInvitation(ctx context.Context, id string) (*model.Invitation, error) {
+	// The roles a user may grant are checked against everything they hold, which an API key
+	// or impersonation token does not carry
+	sender, err := middleware.RequireUserSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	invitationUUID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid invitation ID: %v", err)
+	}
+
+	invitationService := services.NewInvitationService(r.DB)
+	tenantID, err := invitationService.GetInvitationTenant(invitationUUID)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireTenantPermission(ctx, r.DB, "tenant_user.create", tenantID); err != nil {
+		return nil, err
+	}
+
+	return invitationService.ResendInvitation(ctx, sender, invitationUUID)
+}
+
+// RevokeInvitation is the resolver for the revokeInvitation field.
+func (r *mutationResolver) RevokeInvitation(ctx context.Context, id string) (bool, error) {
+	invitationUUID, err := uuid.Parse(id)
+	if err != nil {
+		return false, fmt.Errorf("invalid invitation ID: %v", err)
+	}
+
+	invitationService := services.NewInvitationService(r.DB)
+	tenantID, err := invitationService.GetInvitationTenant(invitationUUID)
+	if err != nil {
+		return false, err
+	}
+	if err := requireTenantPermission(ctx, r.DB, "tenant_user.create", tenantID); err != nil {
+		return false, err
+	}
+
+	if err := invitationService.RevokeInvitation(ctx, invitationUUID); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
 // CreateRole is the resolver for the createRole field.
 func (r *mutationResolver) CreateRole(ctx context.Context, input model.CreateRoleInput) (*models.Role, error) {
-	panic(fmt.Errorf("not implemented: CreateRole - createRole"))
+	var tenantUUID *uuid.UUID
+	if input.TenantID != nil {
+		id, err := uuid.Parse(*input.TenantID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tenant ID: %v", err)
+		}
+		tenantUUID = &id
+	}
+
+	if err := requireRolePermission(ctx, r.DB, "create", tenantUUID); err != nil {
+		return nil, err
+	}
+	user, err := middleware.RequireUserSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	roleService := services.NewRoleService(r.DB)
+	return roleService.CreateRole(ctx, user, input)
 }
 
 // UpdateRole is the resolver for the updateRole field.
 func (r *mutationResolver) UpdateRole(ctx context.Context, id string, input model.UpdateRoleInput) (*models.Role, error) {
-	panic(fmt.Errorf("not implemented: UpdateRole - updateRole"))
+	roleUUID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid role ID: %v", err)
+	}
+
+	roleService := services.NewRoleService(r.DB)
+	role, err := roleService.GetRole(roleUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireRolePermission(ctx, r.DB, "update", role.TenantID); err != nil {
+		return nil, err
+	}
+	user, err := middleware.RequireUserSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return roleService.UpdateRole(ctx, user, roleUUID, input)
 }
 
 // DeleteRole is the resolver for the deleteRole field.
-func (r *mutationResolver) DeleteRole(ctx context.Context, id string) (bool, error) {
-	panic(fmt.Errorf("not implemented: DeleteRole - deleteRole"))
+func (r *mutationResolver) DeleteRole(ctx context.Context, id string, reassignToRoleID *string) (bool, error) {
+	roleUUID, err := uuid.Parse(id)
+	if err != nil {
+		return false, fmt.Errorf("invalid role ID: %v", err)
+	}
+
+	var reassignTo *uuid.UUID
+	if reassignToRoleID != nil {
+		targetUUID, err := uuid.Parse(*reassignToRoleID)
+		if err != nil {
+			return false, fmt.Errorf("invalid role ID: %v", err)
+		}
+		reassignTo = &targetUUID
+	}
+
+	roleService := services.NewRoleService(r.DB)
+	role, err := roleService.GetRole(roleUUID)
+	if err != nil {
+		return false, err
+	}
+
+	if err := requireRolePermission(ctx, r.DB, "delete", role.TenantID); err != nil {
+		return false, err
+	}
+
+	if err := roleService.DeleteRole(ctx, roleUUID, reassignTo); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
 // AssignRole is the resolver for the assignRole field.
@@ -215,8 +903,12 @@ func (r *mutationResolver) AssignRole(ctx context.Context, input model.AssignRol
 	}
 
 	// Check appropriate permissions
-	if existingUser.TenantID != nil {
-		if err := requireTenantPermission(ctx, r.DB, "tenant_role.update", *existingUser.TenantID); err != nil {
+	tenantID, err := managedUserTenant(ctx, r.DB, &existingUser)
+	if err != nil {
+		return nil, err
+	}
+	if tenantID != nil {
+		if err := requireTenantPermission(ctx, r.DB, "tenant_role.update", *tenantID); err != nil {
 			return nil, err
 		}
 	} else {
@@ -226,7 +918,7 @@ func (r *mutationResolver) AssignRole(ctx context.Context, input model.AssignRol
 	}
 
 	userService := services.NewUserService(r.DB)
-	return userService.AssignRole(ctx, input)
+	return userService.AssignRole(ctx, tenantID, input)
 }
 
 // AssignPermissions is the resolver for the assignPermissions field.
@@ -244,8 +936,12 @@ func (r *mutationResolver) AssignPermissions(ctx context.Context, input model.As
 	}
 
 	// Check appropriate permissions (need admin level for direct permission assignment)
-	if existingUser.TenantID != nil {
-		if err := requireTenantPermission(ctx, r.DB, "tenant_role.update", *existingUser.TenantID); err != nil {
+	tenantID, err := managedUserTenant(ctx, r.DB, &existingUser)
+	if err != nil {
+		return nil, err
+	}
+	if tenantID != nil {
+		if err := requireTenantPermission(ctx, r.DB, "tenant_role.update", *tenantID); err != nil {
 			return nil, err
 		}
 	} else {
@@ -253,6 +949,10 @@ func (r *mutationResolver) AssignPermissions(ctx context.Context, input model.As
 			return nil, err
 		}
 	}
+	// Directly assigned permissions apply in the user's primary tenant only
+	if tenantID != nil && (existingUser.TenantID == nil || *tenantID != *existingUser.TenantID) {
+		return nil, services.ErrPrimaryTenantOnly
+	}
 
 	userService := services.NewUserService(r.DB)
 	return userService.AssignPermissions(ctx, input)
@@ -269,22 +969,118 @@ func (r *mutationResolver) RevokePermissions(ctx context.Context, input model.As
 	var existingUser models.User
 	err = r.DB.First(&existingUser, "id = ?", userUUID).Error
 	if err != nil {
-		return nil, errors.New("user not found")
+		return nil, errors.New("user not found")
+	}
+
+	// Check appropriate permissions (need admin level for direct permission management)
+	tenantID, err := managedUserTenant(ctx, r.DB, &existingUser)
+	if err != nil {
+		return nil, err
+	}
+	if tenantID != nil {
+		if err := requireTenantPermission(ctx, r.DB, "tenant_role.update", *tenantID); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := requireSystemPermission(ctx, r.DB, "system_role.update"); err != nil {
+			return nil, err
+		}
+	}
+	// Directly assigned permissions apply in the user's primary tenant only
+	if tenantID != nil && (existingUser.TenantID == nil || *tenantID != *existingUser.TenantID) {
+		return nil, services.ErrPrimaryTenantOnly
+	}
+
+	userService := services.NewUserService(r.DB)
+	return userService.RevokePermissions(ctx, input)
+}
+
+// CreateAccessPolicy is the resolver for the createAccessPolicy field.
+func (r *mutationResolver) CreateAccessPolicy(ctx context.Context, input model.CreateAccessPolicyInput) (*model.AccessPolicy, error) {
+	tenantUUID, err := uuid.Parse(input.TenantID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenant ID: %v", err)
+	}
+
+	if err := requireTenantPermission(ctx, r.DB, "tenant_role.create", tenantUUID); err != nil {
+		return nil, err
+	}
+	user, err := middleware.RequireUserSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	policyService := services.NewAccessPolicyService(r.DB)
+	return policyService.CreateAccessPolicy(ctx, user, input)
+}
+
+// UpdateAccessPolicy is the resolver for the updateAccessPolicy field.
+func (r *mutationResolver) UpdateAccessPolicy(ctx context.Context, id string, input model.UpdateAccessPolicyInput) (*model.AccessPolicy, error) {
+	policyUUID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid access policy ID: %v", err)
+	}
+
+	policyService := services.NewAccessPolicyService(r.DB)
+	policy, err := policyService.GetAccessPolicy(policyUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireTenantPermission(ctx, r.DB, "tenant_role.update", policy.TenantID); err != nil {
+		return nil, err
+	}
+	user, err := middleware.RequireUserSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return policyService.UpdateAccessPolicy(ctx, user, policyUUID, input)
+}
+
+// DeleteAccessPolicy is the resolver for the deleteAccessPolicy field.
+func (r *mutationResolver) DeleteAccessPolicy(ctx context.Context, id string) (bool, error) {
+	policyUUID, err := uuid.Parse(id)
+	if err != nil {
+		return false, fmt.Errorf("invalid access policy ID: %v", err)
+	}
+
+	policyService := services.NewAccessPolicyService(r.DB)
+	policy, err := policyService.GetAccessPolicy(policyUUID)
+	if err != nil {
+		return false, err
+	}
+
+	if err := requireTenantPermission(ctx, r.DB, "tenant_role.delete", policy.TenantID); err != nil {
+		return false, err
+	}
+
+	if err := policyService.DeleteAccessPolicy(ctx, policyUUID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// UpdateMemberAttributes is the resolver for the updateMemberAttributes field.
+func (r *mutationResolver) UpdateMemberAttributes(ctx context.Context, tenantID string, userID string, attributes map[string]any) (bool, error) {
+	tenantUUID, err := uuid.Parse(tenantID)
+	if err != nil {
+		return false, fmt.Errorf("invalid tenant ID: %v", err)
+	}
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return false, fmt.Errorf("invalid user ID: %v", err)
 	}
 
-	// Check appropriate permissions (need admin level for direct permission management)
-	if existingUser.TenantID != nil {
-		if err := requireTenantPermission(ctx, r.DB, "tenant_role.update", *existingUser.TenantID); err != nil {
-			return nil, err
-		}
-	} else {
-		if err := requireSystemPermission(ctx, r.DB, "system_role.update"); err != nil {
-			return nil, err
-		}
+	if err := requireTenantPermission(ctx, r.DB, "tenant_user.update", tenantUUID); err != nil {
+		return false, err
 	}
 
-	userService := services.NewUserService(r.DB)
-	return userService.RevokePermissions(ctx, input)
+	policyService := services.NewAccessPolicyService(r.DB)
+	if err := policyService.UpdateMemberAttributes(ctx, tenantUUID, userUUID, attributes); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
 // CreateCustomer is the resolver for the createCustomer field.
@@ -317,8 +1113,15 @@ func (r *mutationResolver) UpdateCustomer(ctx context.Context, id string, input
 		return nil, err
 	}
 
+	// Customers may update their own profile, but not the fields the tenant manages
 	if err := requireTenantPermission(ctx, r.DB, "customer.update", existingCustomer.TenantID); err != nil {
-		return nil, err
+		resource := services.ResourceRef{Type: models.ResourceCustomer, ID: existingCustomer.ID}
+		if ownErr := middleware.RequireResourcePermission(ctx, r.DB, "profile.update", existingCustomer.TenantID, resource); ownErr != nil {
+			return nil, err
+		}
+		if input.IsActive != nil || input.Tags != nil || input.Metadata != nil || input.UserID != nil {
+			return nil, middleware.ErrForbidden
+		}
 	}
 
 	customer, err := customerService.UpdateCustomer(ctx, id, input)
@@ -380,6 +1183,9 @@ func (r *permissionResolver) Scope(ctx context.Context, obj *models.Permission)
 	if obj.IsSystemPermission {
 		return model.PermissionScopeSystem, nil
 	}
+	if obj.Scope == models.ScopeOwn {
+		return model.PermissionScopeOwn, nil
+	}
 	return model.PermissionScopeTenant, nil
 }
 
@@ -435,6 +1241,79 @@ func (r *queryResolver) CheckPermission(ctx context.Context, input model.Permiss
 	panic(fmt.Errorf("not implemented: CheckPermission - checkPermission"))
 }
 
+// MyTenants is the resolver for the myTenants field.
+func (r *queryResolver) MyTenants(ctx context.Context) ([]*model.TenantMembership, error) {
+	user, err := middleware.RequireUserSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	membershipService := services.NewTenantMembershipService(r.DB)
+	return membershipService.ListMemberships(user.ID, user.TenantID)
+}
+
+// MySessions is the resolver for the mySessions field.
+func (r *queryResolver) MySessions(ctx context.Context) ([]*model.UserSession, error) {
+	user, err := middleware.RequireUserSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	session, ok := middleware.GetSessionFromContext(ctx)
+	if !ok || session == nil {
+		return nil, middleware.ErrUnauthorized
+	}
+
+	sessionService := services.NewSessionService(r.DB)
+	return sessionService.ListActiveSessions(user.ID, nil, session.ID)
+}
+
+// MyOAuthConsents is the resolver for the myOAuthConsents field.
+func (r *queryResolver) MyOAuthConsents(ctx context.Context) ([]*model.OAuthConsent, error) {
+	user, err := middleware.RequireUserSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	oauthService := services.NewOAuthService(r.DB)
+	return oauthService.ListConsents(ctx, user.ID)
+}
+
+// OauthAuthorizationRequest is the resolver for the oauthAuthorizationRequest field.
+func (r *queryResolver) OauthAuthorizationRequest(ctx context.Context, input model.OAuthAuthorizeInput) (*model.OAuthAuthorizationRequest, error) {
+	user, err := middleware.RequireUserSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	oauthService := services.NewOAuthService(r.DB)
+	return oauthService.DescribeAuthorizationRequest(ctx, user.ID, input)
+}
+
+// APIKeys is the resolver for the apiKeys field.
+func (r *queryResolver) APIKeys(ctx context.Context, tenantID string) ([]*model.APIKey, error) {
+	user, err := middleware.RequireUserSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tenantUUID, err := uuid.Parse(tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenant ID: %v", err)
+	}
+
+	// Tenant admins see every key in the tenant, other members only their own
+	var ownerID *uuid.UUID
+	if err := requireTenantPermission(ctx, r.DB, "tenant_setting.read", tenantUUID); err != nil {
+		if user.TenantID == nil || *user.TenantID != tenantUUID {
+			return nil, err
+		}
+		ownerID = &user.ID
+	}
+
+	apiKeyService := services.NewAPIKeyService(r.DB)
+	return apiKeyService.ListAPIKeys(ctx, tenantUUID, ownerID)
+}
+
 // Users is the resolver for the users field.
 func (r *queryResolver) Users(ctx context.Context, filter *model.UserFilter, pagination *model.PaginationInput) (*model.PaginatedUsers, error) {
 	// Determine appropriate permission checking based on filter
@@ -472,8 +1351,12 @@ func (r *queryResolver) User(ctx context.Context, id string) (*models.User, erro
 	}
 
 	// Check appropriate permissions
-	if user.TenantID != nil {
-		if err := requireTenantPermission(ctx, r.DB, "tenant_user.read", *user.TenantID); err != nil {
+	tenantID, err := managedUserTenant(ctx, r.DB, &user)
+	if err != nil {
+		return nil, err
+	}
+	if tenantID != nil {
+		if err := requireTenantPermission(ctx, r.DB, "tenant_user.read", *tenantID); err != nil {
 			return nil, err
 		}
 	} else {
@@ -486,6 +1369,58 @@ func (r *queryResolver) User(ctx context.Context, id string) (*models.User, erro
 	return userService.GetUser(ctx, id)
 }
 
+// Invitations is the resolver for the invitations field.
+func (r *queryResolver) Invitations(ctx context.Context, tenantID string) ([]*model.Invitation, error) {
+	tenantUUID, err := uuid.Parse(tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenant ID: %v", err)
+	}
+
+	if err := requireTenantPermission(ctx, r.DB, "tenant_user.list", tenantUUID); err != nil {
+		return nil, err
+	}
+
+	invitationService := services.NewInvitationService(r.DB)
+	return invitationService.ListInvitations(tenantUUID)
+}
+
+// UserSessions is the resolver for the userSessions field.
+func (r *queryResolver) UserSessions(ctx context.Context, userID string) ([]*model.UserSession, error) {
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user ID: %v", err)
+	}
+
+	var existingUser models.User
+	err = r.DB.First(&existingUser, "id = ?", userUUID).Error
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	// Tenant admins see the user's sessions in their tenant only
+	tenantID, err := managedUserTenant(ctx, r.DB, &existingUser)
+	if err != nil {
+		return nil, err
+	}
+	if tenantID != nil {
+		if err := requireTenantPermission(ctx, r.DB, "tenant_user.update", *tenantID); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := requireSystemPermission(ctx, r.DB, "system_user.update"); err != nil {
+			return nil, err
+		}
+	}
+
+	var currentSessionID uuid.UUID
+	if session, ok := middleware.GetSessionFromContext(ctx); ok && session != nil {
+		currentSessionID = session.ID
+	}
+
+	sessionService := services.NewSessionService(r.DB)
+	return sessionService.ListActiveSessions(existingUser.ID, tenantID, currentSessionID)
+}
+
 // Tenants is the resolver for the tenants field.
 func (r *queryResolver) Tenants(ctx context.Context, filter *model.TenantFilter, pagination *model.PaginationInput) (*model.PaginatedTenants, error) {
 	if err := requireSystemPermission(ctx, r.DB, "tenant.list"); err != nil {
@@ -512,29 +1447,213 @@ func (r *queryResolver) TenantBySlug(ctx context.Context, slug string) (*models.
 	return tenantService.GetTenantBySlug(ctx, slug)
 }
 
+// TenantSettings is the resolver for the tenantSettings field.
+func (r *queryResolver) TenantSettings(ctx context.Context, tenantID string) ([]*model.TenantSetting, error) {
+	tenantUUID, err := uuid.Parse(tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenant ID: %v", err)
+	}
+
+	if err := requireTenantPermission(ctx, r.DB, "tenant_setting.read", tenantUUID); err != nil {
+		return nil, err
+	}
+
+	settingsService := services.NewTenantSettingsService(r.DB)
+	return settingsService.ListSettings(ctx, tenantUUID)
+}
+
+// TenantOidcProvider is the resolver for the tenantOidcProvider field.
+func (r *queryResolver) TenantOidcProvider(ctx context.Context, tenantID string) (*model.TenantOidcProvider, error) {
+	tenantUUID, err := uuid.Parse(tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenant ID: %v", err)
+	}
+
+	if err := requireTenantPermission(ctx, r.DB, "tenant_setting.read", tenantUUID); err != nil {
+		return nil, err
+	}
+
+	ssoService := services.NewSSOService(r.DB)
+	return ssoService.GetOIDCProvider(ctx, tenantUUID)
+}
+
+// TenantSamlProvider is the resolver for the tenantSamlProvider field.
+func (r *queryResolver) TenantSamlProvider(ctx context.Context, tenantID string) (*model.TenantSamlProvider, error) {
+	tenantUUID, err := uuid.Parse(tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenant ID: %v", err)
+	}
+
+	if err := requireTenantPermission(ctx, r.DB, "tenant_setting.read", tenantUUID); err != nil {
+		return nil, err
+	}
+
+	ssoService := services.NewSSOService(r.DB)
+	return ssoService.GetSAMLProvider(ctx, tenantUUID)
+}
+
+// ScimTokens is the resolver for the scimTokens field.
+func (r *queryResolver) ScimTokens(ctx context.Context, tenantID string) ([]*model.ScimToken, error) {
+	tenantUUID, err := uuid.Parse(tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenant ID: %v", err)
+	}
+
+	if err := requireTenantPermission(ctx, r.DB, "tenant_setting.read", tenantUUID); err != nil {
+		return nil, err
+	}
+
+	scimTokenService := services.NewSCIMTokenService(r.DB)
+	return scimTokenService.ListSCIMTokens(ctx, tenantUUID)
+}
+
+// OauthClients is the resolver for the oauthClients field.
+func (r *queryResolver) OauthClients(ctx context.Context, tenantID *string) ([]*model.OAuthClient, error) {
+	if tenantID == nil {
+		if err := requireSystemPermission(ctx, r.DB, "system.view"); err != nil {
+			return nil, err
+		}
+
+		oauthClientService := services.NewOAuthClientService(r.DB)
+		return oauthClientService.ListOAuthClients(ctx, nil)
+	}
+
+	tenantUUID, err := uuid.Parse(*tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenant ID: %v", err)
+	}
+
+	if err := requireTenantPermission(ctx, r.DB, "tenant_setting.read", tenantUUID); err != nil {
+		return nil, err
+	}
+
+	oauthClientService := services.NewOAuthClientService(r.DB)
+	return oauthClientService.ListOAuthClients(ctx, &tenantUUID)
+}
+
 // Roles is the resolver for the roles field.
 func (r *queryResolver) Roles(ctx context.Context, tenantID *string, pagination *model.PaginationInput) (*model.PaginatedRoles, error) {
-	panic(fmt.Errorf("not implemented: Roles - roles"))
+	var tenantUUID *uuid.UUID
+	if tenantID != nil {
+		id, err := uuid.Parse(*tenantID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tenant ID: %v", err)
+		}
+		tenantUUID = &id
+	}
+
+	if err := requireRolePermission(ctx, r.DB, "list", tenantUUID); err != nil {
+		return nil, err
+	}
+
+	roleService := services.NewRoleService(r.DB)
+	return roleService.ListRoles(ctx, tenantUUID, pagination)
 }
 
 // Role is the resolver for the role field.
 func (r *queryResolver) Role(ctx context.Context, id string) (*models.Role, error) {
-	panic(fmt.Errorf("not implemented: Role - role"))
+	roleUUID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid role ID: %v", err)
+	}
+
+	roleService := services.NewRoleService(r.DB)
+	role, err := roleService.GetRole(roleUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireRolePermission(ctx, r.DB, "read", role.TenantID); err != nil {
+		return nil, err
+	}
+	return role, nil
 }
 
 // Permissions is the resolver for the permissions field.
 func (r *queryResolver) Permissions(ctx context.Context, isSystem *bool, pagination *model.PaginationInput) (*model.PaginatedPermissions, error) {
-	panic(fmt.Errorf("not implemented: Permissions - permissions"))
+	user, err := middleware.RequireAuth(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tenant administrators only see the permissions their roles can be granted
+	if user.TenantID != nil {
+		if isSystem != nil && *isSystem {
+			return nil, middleware.ErrForbidden
+		}
+		if err := requireTenantPermission(ctx, r.DB, "tenant_role.list", *user.TenantID); err != nil {
+			return nil, err
+		}
+		tenantPermissions := false
+		isSystem = &tenantPermissions
+	} else if err := requireSystemPermission(ctx, r.DB, "system_role.list"); err != nil {
+		return nil, err
+	}
+
+	roleService := services.NewRoleService(r.DB)
+	return roleService.ListPermissions(ctx, isSystem, pagination)
 }
 
 // Permission is the resolver for the permission field.
 func (r *queryResolver) Permission(ctx context.Context, id string) (*models.Permission, error) {
-	panic(fmt.Errorf("not implemented: Permission - permission"))
+	permissionUUID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid permission ID: %v", err)
+	}
+
+	user, err := middleware.RequireAuth(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	roleService := services.NewRoleService(r.DB)
+	permission, err := roleService.GetPermission(permissionUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.TenantID != nil {
+		if permission.IsSystemPermission {
+			return nil, services.ErrPermissionNotFound
+		}
+		if err := requireTenantPermission(ctx, r.DB, "tenant_role.read", *user.TenantID); err != nil {
+			return nil, err
+		}
+	} else if err := requireSystemPermission(ctx, r.DB, "system_role.read"); err != nil {
+		return nil, err
+	}
+	return permission, nil
 }
 
 // RolePermissionMatrix is the resolver for the rolePermissionMatrix field.
 func (r *queryResolver) RolePermissionMatrix(ctx context.Context) ([]*model.RolePermissionMatrix, error) {
-	panic(fmt.Errorf("not implemented: RolePermissionMatrix - rolePermissionMatrix"))
+	user, err := middleware.RequireAuth(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The matrix covers the roles of the caller's current tenant, or the system roles
+	if err := requireRolePermission(ctx, r.DB, "list", user.TenantID); err != nil {
+		return nil, err
+	}
+
+	roleService := services.NewRoleService(r.DB)
+	return roleService.GetRolePermissionMatrix(ctx, user.TenantID)
+}
+
+// AccessPolicies is the resolver for the accessPolicies field.
+func (r *queryResolver) AccessPolicies(ctx context.Context, tenantID string) ([]*model.AccessPolicy, error) {
+	tenantUUID, err := uuid.Parse(tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenant ID: %v", err)
+	}
+
+	if err := requireTenantPermission(ctx, r.DB, "tenant_role.list", tenantUUID); err != nil {
+		return nil, err
+	}
+
+	policyService := services.NewAccessPolicyService(r.DB)
+	return policyService.ListAccessPolicies(ctx, tenantUUID)
 }
 
 // Customers is the resolver for the customers field.
@@ -568,13 +1687,41 @@ func (r *queryResolver) Customer(ctx context.Context, id string) (*model.Custome
 		return nil, err
 	}
 
+	// Customers may read their own profile
 	if err := requireTenantPermission(ctx, r.DB, "customer.read", existingCustomer.TenantID); err != nil {
-		return nil, err
+		resource := services.ResourceRef{Type: models.ResourceCustomer, ID: existingCustomer.ID}
+		if ownErr := middleware.RequireResourcePermission(ctx, r.DB, "profile.read", existingCustomer.TenantID, resource); ownErr != nil {
+			return nil, err
+		}
 	}
 
 	return customerService.ConvertToGraphQLModel(existingCustomer), nil
 }
 
+// MyCustomerProfile is the resolver for the myCustomerProfile field.
+func (r *queryResolver) MyCustomerProfile(ctx context.Context) (*model.CustomerProfile, error) {
+	user, err := middleware.RequireAuth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if user.TenantID == nil {
+		return nil, nil
+	}
+
+	customerService := services.NewCustomerService(r.DB)
+	customer, err := customerService.GetCustomerByUser(ctx, user.ID, *user.TenantID)
+	if err != nil || customer == nil {
+		return nil, err
+	}
+
+	resource := services.ResourceRef{Type: models.ResourceCustomer, ID: customer.ID}
+	if err := middleware.RequireResourcePermission(ctx, r.DB, "profile.read", customer.TenantID, resource); err != nil {
+		return nil, err
+	}
+
+	return customerService.ConvertToGraphQLModel(customer), nil
+}
+
 // Plans is the resolver for the plans field.
 func (r *queryResolver) Plans(ctx context.Context) ([]*models.Plan, error) {
 	var plans []models.Plan
@@ -619,7 +1766,11 @@ func (r *roleResolver) TenantID(ctx context.Context, obj *models.Role) (*string,
 // UsersCount is the resolver for the usersCount field.
 func (r *roleResolver) UsersCount(ctx context.Context, obj *models.Role) (int32, error) {
 	var count int64
-	if err := r.DB.Model(&models.User{}).Where("role_id = ?", obj.ID).Count(&count).Error; err != nil {
+	// Members of other tenants hold the role through their membership
+	err := r.DB.Model(&models.User{}).
+		Where("role_id = ? OR id IN (?)", obj.ID, r.DB.Model(&models.TenantUser{}).Select("user_id").Where("role_id = ?", obj.ID)).
+		Count(&count).Error
+	if err != nil {
 		return 0, err
 	}
 	return int32(count), nil
@@ -674,6 +1825,12 @@ func (r *userResolver) TenantID(ctx context.Context, obj *models.User) (*string,
 	return &tenantIDStr, nil
 }
 
+// TwoFactorEnabled is the resolver for the twoFactorEnabled field.
+func (r *userResolver) TwoFactorEnabled(ctx context.Context, obj *models.User) (bool, error) {
+	twoFactorService := services.NewTwoFactorService(r.DB)
+	return twoFactorService.IsEnabled(obj.ID)
+}
+
 // DirectPermissions is the resolver for the directPermissions field.
 func (r *userResolver) DirectPermissions(ctx context.Context, obj *models.User) ([]*models.Permission, error) {
 	panic(fmt.Errorf("not implemented: DirectPermissions - directPermissions"))
@@ -681,7 +1838,9 @@ func (r *userResolver) DirectPermissions(ctx context.Context, obj *models.User)
 
 // AllPermissions is the resolver for the allPermissions field.
 func (r *userResolver) AllPermissions(ctx context.Context, obj *models.User) ([]string, error) {
-	panic(fmt.Errorf("not implemented: AllPermissions - allPermissions"))
+	// Role and direct permissions in the user's current tenant, with wildcards expanded
+	rbacService := services.NewRBACService(r.DB)
+	return rbacService.GetUserPermissionsInTenant(obj.ID, obj.TenantID)
 }
 
 // Mutation returns MutationResolver implementation.
@@ -738,6 +1897,38 @@ func requireTenantPermission(ctx context.Context, db *gorm.DB, permission string
 	return middleware.RequireTenantPermission(ctx, db, permission, tenantID)
 }
 
+// Helper function to check role management permissions: tenant_role.* for a tenant's
+// roles, system_role.* for the system roles
+func requireRolePermission(ctx context.Context, db *gorm.DB, action string, tenantID *uuid.UUID) error {
+	if tenantID != nil {
+		return requireTenantPermission(ctx, db, "tenant_role."+action, *tenantID)
+	}
+	return requireSystemPermission(ctx, db, "system_role."+action)
+}
+
+// Helper function to find the tenant a user is administered in: the tenant the caller acts
+// in, which the user has to belong to, or the user's own tenant for system admins. Nil
+// means a system user, administered with system permissions.
+func managedUserTenant(ctx context.Context, db *gorm.DB, user *models.User) (*uuid.UUID, error) {
+	caller, err := middleware.RequireAuth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if caller.TenantID == nil {
+		return user.TenantID, nil
+	}
+
+	membershipService := services.NewTenantMembershipService(db)
+	isMember, err := membershipService.IsMember(user, *caller.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	if !isMember {
+		return nil, errors.New("user not found")
+	}
+	return caller.TenantID, nil
+}
+
 // MyPermissions is the resolver for the myPermissions field.
 func (r *queryResolver) MyPermissions(ctx context.Context) ([]string, error) {
 	// Get user from context (set by auth middleware)
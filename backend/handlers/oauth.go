@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang_saas/config"
+	"golang_saas/graph/model"
+	"golang_saas/models"
+	"golang_saas/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// OAuthHandler serves the platform's OAuth2 and OpenID Connect endpoints: tenant apps sign
+// users in through them, and internal services check and revoke platform tokens without
+// sharing the signing keys
+type OAuthHandler struct {
+	db *gorm.DB
+}
+
+func NewOAuthHandler(db *gorm.DB) *OAuthHandler {
+	return &OAuthHandler{db: db}
+}
+
+// oauthClientKey is the gin context key of the authenticated OAuth client
+const oauthClientKey = "oauth_client"
+
+// RegisterRoutes mounts the OAuth routes
+func (h *OAuthHandler) RegisterRoutes(r gin.IRouter) {
+	r.GET("/.well-known/openid-configuration", h.Discovery)
+
+	r.GET("/oauth/authorize", h.Authorize)
+	r.GET("/oauth/userinfo", h.UserInfo)
+	r.POST("/oauth/userinfo", h.UserInfo)
+
+	oauth := r.Group("/oauth", h.authenticateClient)
+	oauth.POST("/token", h.Token)
+	oauth.POST("/introspect", h.Introspect)
+	oauth.POST("/revoke", h.Revoke)
+}
+
+// authenticateClient checks the client credentials, sent with HTTP Basic authentication
+// or as client_id and client_secret form parameters. Public clients only send client_id.
+func (h *OAuthHandler) authenticateClient(c *gin.Context) {
+	clientID, clientSecret, ok := c.Request.BasicAuth()
+	if ok {
+		// Basic credentials are form-encoded before being base64-encoded (RFC 6749 2.3.1)
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID = c.PostForm("client_id")
+		clientSecret = c.PostForm("client_secret")
+	}
+
+	client, err := services.NewOAuthClientService(h.db).Authenticate(clientID, clientSecret)
+	if err != nil {
+		if !errors.Is(err, services.ErrInvalidOAuthClient) {
+			log.Printf("OAuth client authentication failed: %v", err)
+		}
+		c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid_client"})
+		return
+	}
+
+	c.Set(oauthClientKey, client)
+	c.Next()
+}
+
+// Discovery serves the OpenID Connect discovery document
+func (h *OAuthHandler) Discovery(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, services.NewOAuthService(h.db).OpenIDConfiguration())
+}
+
+// Authorize starts the authorization code flow. Valid requests are handed to the frontend,
+// which signs the user in and asks for their consent; invalid ones are reported to the
+// client, unless the client or redirect URI cannot be trusted.
+func (h *OAuthHandler) Authorize(c *gin.Context) {
+	input := model.OAuthAuthorizeInput{
+		ClientID:            c.Query("client_id"),
+		RedirectURI:         optionalQuery(c, "redirect_uri"),
+		ResponseType:        c.Query("response_type"),
+		Scope:               optionalQuery(c, "scope"),
+		State:               optionalQuery(c, "state"),
+		Nonce:               optionalQuery(c, "nonce"),
+		CodeChallenge:       optionalQuery(c, "code_challenge"),
+		CodeChallengeMethod: optionalQuery(c, "code_challenge_method"),
+	}
+
+	redirectURI, err := services.NewOAuthService(h.db).ValidateAuthorizationRequest(input)
+	if err != nil {
+		var oauthErr *services.OAuthError
+		switch {
+		case errors.As(err, &oauthErr):
+			c.Redirect(http.StatusFound, services.OAuthErrorRedirect(redirectURI, oauthErr, input.State))
+		case errors.Is(err, services.ErrInvalidOAuthClient), errors.Is(err, services.ErrInvalidRedirectURI):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": err.Error()})
+		default:
+			log.Printf("OAuth authorization request failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		}
+		return
+	}
+
+	c.Redirect(http.StatusFound, config.AppConfig.FrontendURL+"/oauth/consent?"+c.Request.URL.RawQuery)
+}
+
+// Token exchanges an authorization code, a refresh token or the client's own credentials
+// for tokens (RFC 6749 section 3.2)
+func (h *OAuthHandler) Token(c *gin.Context) {
+	req := services.OAuthTokenRequest{
+		GrantType:    c.PostForm("grant_type"),
+		Code:         c.PostForm("code"),
+		RedirectURI:  c.PostForm("redirect_uri"),
+		CodeVerifier: c.PostForm("code_verifier"),
+		RefreshToken: c.PostForm("refresh_token"),
+		Scope:        c.PostForm("scope"),
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	result, err := services.NewOAuthService(h.db).Token(c.Request.Context(), authenticatedClient(c), req)
+	if err != nil {
+		var oauthErr *services.OAuthError
+		if errors.As(err, &oauthErr) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": oauthErr.Code, "error_description": oauthErr.Description})
+			return
+		}
+		log.Printf("OAuth token request failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// UserInfo returns the claims about the user an access token was issued for
+func (h *OAuthHandler) UserInfo(c *gin.Context) {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if token == "" || token == c.GetHeader("Authorization") {
+		c.Header("WWW-Authenticate", `Bearer realm="oauth"`)
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	result, err := services.NewOAuthService(h.db).UserInfo(c.Request.Context(), token)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidAccessToken):
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.AbortWithStatus(http.StatusUnauthorized)
+		case errors.Is(err, services.ErrInsufficientScope):
+			c.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+			c.AbortWithStatus(http.StatusForbidden)
+		default:
+			log.Printf("OAuth userinfo request failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		}
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, result)
+}
+
+// Introspect reports whether a token is active and who it was issued to (RFC 7662). Only
+// confidential clients may introspect tokens.
+func (h *OAuthHandler) Introspect(c *gin.Context) {
+	client := authenticatedClient(c)
+	if client.IsPublic() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_client"})
+		return
+	}
+
+	token := c.PostForm("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "token is required"})
+		return
+	}
+
+	result, err := services.NewOAuthService(h.db).IntrospectToken(c.Request.Context(), client, token)
+	if err != nil {
+		log.Printf("Token introspection failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, result)
+}
+
+// Revoke revokes an access or refresh token (RFC 7009). Unknown tokens are not an error.
+func (h *OAuthHandler) Revoke(c *gin.Context) {
+	token := c.PostForm("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "token is required"})
+		return
+	}
+
+	if err := services.NewOAuthService(h.db).RevokeToken(c.Request.Context(), authenticatedClient(c), token); err != nil {
+		log.Printf("Token revocation failed: %v", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "server_error"})
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// authenticatedClient returns the client authenticated by authenticateClient
+func authenticatedClient(c *gin.Context) *models.OAuthClient {
+	return c.MustGet(oauthClientKey).(*models.OAuthClient)
+}
+
+// optionalQuery returns a query parameter, or nil when it is absent
+func optionalQuery(c *gin.Context, key string) *string {
+	value, ok := c.GetQuery(key)
+	if !ok {
+		return nil
+	}
+	return &value
+}
@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang_saas/config"
+	"golang_saas/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	scimContentType = "application/scim+json"
+	// scimTenantKey holds the tenant of the presented SCIM token in the gin context
+	scimTenantKey = "scim_tenant_id"
+)
+
+// SCIMHandler serves the SCIM 2.0 provisioning API identity providers use to manage a
+// tenant's users and groups
+type SCIMHandler struct {
+	db *gorm.DB
+}
+
+func NewSCIMHandler(db *gorm.DB) *SCIMHandler {
+	return &SCIMHandler{db: db}
+}
+
+// RegisterRoutes mounts the SCIM routes
+func (h *SCIMHandler) RegisterRoutes(r gin.IRouter) {
+	scim := r.Group("/scim/v2", h.authenticate)
+
+	scim.GET("/ServiceProviderConfig", h.ServiceProviderConfig)
+	scim.GET("/ResourceTypes", h.ResourceTypes)
+
+	scim.GET("/Users", h.ListUsers)
+	scim.POST("/Users", h.CreateUser)
+	scim.GET("/Users/:id", h.GetUser)
+	scim.PUT("/Users/:id", h.ReplaceUser)
+	scim.PATCH("/Users/:id", h.PatchUser)
+	scim.DELETE("/Users/:id", h.DeleteUser)
+
+	scim.GET("/Groups", h.ListGroups)
+	scim.POST("/Groups", h.CreateGroup)
+	scim.GET("/Groups/:id", h.GetGroup)
+	scim.PUT("/Groups/:id", h.ReplaceGroup)
+	scim.PATCH("/Groups/:id", h.PatchGroup)
+	scim.DELETE("/Groups/:id", h.DeleteGroup)
+}
+
+// authenticate resolves the bearer token to the tenant being provisioned
+func (h *SCIMHandler) authenticate(c *gin.Context) {
+	token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !found {
+		h.error(c, &services.SCIMError{Status: http.StatusUnauthorized, Detail: "authorization required"})
+		c.Abort()
+		return
+	}
+
+	scimToken, err := services.NewSCIMTokenService(h.db).Authenticate(strings.TrimSpace(token))
+	if err != nil {
+		if !errors.Is(err, services.ErrInvalidSCIMToken) {
+			log.Printf("SCIM authentication failed: %v", err)
+		}
+		h.error(c, &services.SCIMError{Status: http.StatusUnauthorized, Detail: services.ErrInvalidSCIMToken.Error()})
+		c.Abort()
+		return
+	}
+
+	c.Set(scimTenantKey, scimToken.TenantID)
+	c.Next()
+}
+
+// ServiceProviderConfig describes the SCIM features this endpoint supports
+func (h *SCIMHandler) ServiceProviderConfig(c *gin.Context) {
+	h.respond(c, http.StatusOK, gin.H{
+		"schemas":        []string{services.SCIMSchemaServiceProviderConfig},
+		"patch":          gin.H{"supported": true},
+		"bulk":           gin.H{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         gin.H{"supported": true, "maxResults": services.SCIMMaxResults},
+		"changePassword": gin.H{"supported": false},
+		"sort":           gin.H{"supported": false},
+		"etag":           gin.H{"supported": false},
+		"authenticationSchemes": []gin.H{{
+			"type":        "oauthbearertoken",
+			"name":        "OAuth Bearer Token",
+			"description": "Authentication with a tenant SCIM token",
+			"primary":     true,
+		}},
+	})
+}
+
+// ResourceTypes lists the resources served by this endpoint
+func (h *SCIMHandler) ResourceTypes(c *gin.Context) {
+	resourceTypes := []gin.H{
+		{
+			"schemas":  []string{services.SCIMSchemaResourceType},
+			"id":       "User",
+			"name":     "User",
+			"endpoint": "/Users",
+			"schema":   services.SCIMSchemaUser,
+			"meta":     gin.H{"resourceType": "ResourceType", "location": config.AppConfig.PublicURL + "/scim/v2/ResourceTypes/User"},
+		},
+		{
+			"schemas":  []string{services.SCIMSchemaResourceType},
+			"id":       "Group",
+			"name":     "Group",
+			"endpoint": "/Groups",
+			"schema":   services.SCIMSchemaGroup,
+			"meta":     gin.H{"resourceType": "ResourceType", "location": config.AppConfig.PublicURL + "/scim/v2/ResourceTypes/Group"},
+		},
+	}
+
+	h.respond(c, http.StatusOK, gin.H{
+		"schemas":      []string{services.SCIMSchemaListResponse},
+		"totalResults": len(resourceTypes),
+		"startIndex":   1,
+		"itemsPerPage": len(resourceTypes),
+		"Resources":    resourceTypes,
+	})
+}
+
+// ListUsers returns the tenant's users matching the filter
+func (h *SCIMHandler) ListUsers(c *gin.Context) {
+	response, err := services.NewSCIMService(h.db).ListUsers(c.Request.Context(), h.tenantID(c), h.listParams(c))
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	h.respond(c, http.StatusOK, response)
+}
+
+// CreateUser provisions a user in the tenant
+func (h *SCIMHandler) CreateUser(c *gin.Context) {
+	var input services.SCIMUser
+	if !h.bind(c, &input) {
+		return
+	}
+
+	user, err := services.NewSCIMService(h.db).CreateUser(c.Request.Context(), h.tenantID(c), &input)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	h.respond(c, http.StatusCreated, user)
+}
+
+// GetUser returns one of the tenant's users
+func (h *SCIMHandler) GetUser(c *gin.Context) {
+	user, err := services.NewSCIMService(h.db).GetUser(c.Request.Context(), h.tenantID(c), c.Param("id"))
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	h.respond(c, http.StatusOK, user)
+}
+
+// ReplaceUser replaces a user's attributes
+func (h *SCIMHandler) ReplaceUser(c *gin.Context) {
+	var input services.SCIMUser
+	if !h.bind(c, &input) {
+		return
+	}
+
+	user, err := services.NewSCIMService(h.db).ReplaceUser(c.Request.Context(), h.tenantID(c), c.Param("id"), &input)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	h.respond(c, http.StatusOK, user)
+}
+
+// PatchUser applies PATCH operations to a user
+func (h *SCIMHandler) PatchUser(c *gin.Context) {
+	var patch services.SCIMPatchRequest
+	if !h.bind(c, &patch) {
+		return
+	}
+
+	user, err := services.NewSCIMService(h.db).PatchUser(c.Request.Context(), h.tenantID(c), c.Param("id"), &patch)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	h.respond(c, http.StatusOK, user)
+}
+
+// DeleteUser deactivates a user
+func (h *SCIMHandler) DeleteUser(c *gin.Context) {
+	if err := services.NewSCIMService(h.db).DeactivateUser(c.Request.Context(), h.tenantID(c), c.Param("id")); err != nil {
+		h.error(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ListGroups returns the tenant's roles matching the filter
+func (h *SCIMHandler) ListGroups(c *gin.Context) {
+	response, err := services.NewSCIMService(h.db).ListGroups(c.Request.Context(), h.tenantID(c), h.listParams(c))
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	h.respond(c, http.StatusOK, response)
+}
+
+// CreateGroup creates a tenant role
+func (h *SCIMHandler) CreateGroup(c *gin.Context) {
+	var input services.SCIMGroup
+	if !h.bind(c, &input) {
+		return
+	}
+
+	group, err := services.NewSCIMService(h.db).CreateGroup(c.Request.Context(), h.tenantID(c), &input)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	h.respond(c, http.StatusCreated, group)
+}
+
+// GetGroup returns one of the tenant's roles
+func (h *SCIMHandler) GetGroup(c *gin.Context) {
+	group, err := services.NewSCIMService(h.db).GetGroup(c.Request.Context(), h.tenantID(c), c.Param("id"), !h.listParams(c).ExcludeMembers)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	h.respond(c, http.StatusOK, group)
+}
+
+// ReplaceGroup replaces a group's name and members
+func (h *SCIMHandler) ReplaceGroup(c *gin.Context) {
+	var input services.SCIMGroup
+	if !h.bind(c, &input) {
+		return
+	}
+
+	group, err := services.NewSCIMService(h.db).ReplaceGroup(c.Request.Context(), h.tenantID(c), c.Param("id"), &input)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	h.respond(c, http.StatusOK, group)
+}
+
+// PatchGroup applies PATCH operations to a group
+func (h *SCIMHandler) PatchGroup(c *gin.Context) {
+	var patch services.SCIMPatchRequest
+	if !h.bind(c, &patch) {
+		return
+	}
+
+	group, err := services.NewSCIMService(h.db).PatchGroup(c.Request.Context(), h.tenantID(c), c.Param("id"), &patch)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	h.respond(c, http.StatusOK, group)
+}
+
+// DeleteGroup deletes a tenant role
+func (h *SCIMHandler) DeleteGroup(c *gin.Context) {
+	if err := services.NewSCIMService(h.db).DeleteGroup(c.Request.Context(), h.tenantID(c), c.Param("id")); err != nil {
+		h.error(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *SCIMHandler) tenantID(c *gin.Context) uuid.UUID {
+	return c.MustGet(scimTenantKey).(uuid.UUID)
+}
+
+func (h *SCIMHandler) listParams(c *gin.Context) services.SCIMListParams {
+	startIndex, _ := strconv.Atoi(c.Query("startIndex"))
+	count, _ := strconv.Atoi(c.Query("count"))
+
+	excludeMembers := false
+	for _, attribute := range strings.Split(c.Query("excludedAttributes"), ",") {
+		if strings.EqualFold(strings.TrimSpace(attribute), "members") {
+			excludeMembers = true
+		}
+	}
+
+	return services.SCIMListParams{
+		Filter:         c.Query("filter"),
+		StartIndex:     startIndex,
+		Count:          count,
+		ExcludeMembers: excludeMembers,
+	}
+}
+
+// bind decodes the request body, responding with a SCIM error when it is malformed
+func (h *SCIMHandler) bind(c *gin.Context, target any) bool {
+	if err := json.NewDecoder(c.Request.Body).Decode(target); err != nil {
+		h.error(c, &services.SCIMError{Status: http.StatusBadRequest, ScimType: "invalidSyntax", Detail: "request body is not valid JSON"})
+		return false
+	}
+	return true
+}
+
+func (h *SCIMHandler) respond(c *gin.Context, status int, body any) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		h.error(c, err)
+		return
+	}
+	c.Data(status, scimContentType, data)
+}
+
+// error writes a SCIM error response. Errors that are not SCIM errors are logged and
+// reported as internal errors.
+func (h *SCIMHandler) error(c *gin.Context, err error) {
+	var scimErr *services.SCIMError
+	if !errors.As(err, &scimErr) {
+		log.Printf("SCIM request %s %s failed: %v", c.Request.Method, c.Request.URL.Path, err)
+		scimErr = &services.SCIMError{Status: http.StatusInternalServerError, Detail: "internal server error"}
+	}
+
+	body := gin.H{
+		"schemas": []string{services.SCIMSchemaError},
+		"status":  strconv.Itoa(scimErr.Status),
+		"detail":  scimErr.Detail,
+	}
+	if scimErr.ScimType != "" {
+		body["scimType"] = scimErr.ScimType
+	}
+
+	data, _ := json.Marshal(body)
+	c.Data(scimErr.Status, scimContentType, data)
+}
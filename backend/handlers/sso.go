@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"golang_saas/middleware"
+	"golang_saas/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// SSOHandler serves the browser redirects of tenant single sign-on
+type SSOHandler struct {
+	db *gorm.DB
+}
+
+func NewSSOHandler(db *gorm.DB) *SSOHandler {
+	return &SSOHandler{db: db}
+}
+
+// RegisterRoutes mounts the SSO routes
+func (h *SSOHandler) RegisterRoutes(r gin.IRouter) {
+	r.GET("/auth/sso/:tenant/oidc/login", h.OIDCLogin)
+	r.GET("/auth/sso/:tenant/oidc/callback", h.OIDCCallback)
+	r.POST("/auth/sso/:tenant/oidc/link", h.OIDCLink)
+
+	// SAML is served on the tenant subdomain, which also identifies the tenant
+	r.GET("/api/v1/auth/saml/metadata", h.SAMLMetadata)
+	r.GET("/api/v1/auth/saml/login", h.SAMLLogin)
+	r.POST("/api/v1/auth/saml/acs", h.SAMLACS)
+	r.POST("/api/v1/auth/saml/link", h.SAMLLink)
+}
+
+// OIDCLogin redirects the browser to the tenant's OpenID provider
+func (h *SSOHandler) OIDCLogin(c *gin.Context) {
+	ssoService := services.NewSSOService(h.db)
+	authURL, err := ssoService.BeginOIDCLogin(c.Request.Context(), c.Param("tenant"))
+	if err != nil {
+		log.Printf("OIDC login for tenant %s failed: %v", c.Param("tenant"), err)
+		c.Redirect(http.StatusFound, services.SSOErrorRedirectURL(err))
+		return
+	}
+
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCLink returns the URL the signed-in user's browser follows to link their account to
+// the tenant's OpenID provider
+func (h *SSOHandler) OIDCLink(c *gin.Context) {
+	user, err := middleware.RequireUserSession(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	ssoService := services.NewSSOService(h.db)
+	authURL, err := ssoService.BeginOIDCLink(c.Request.Context(), c.Param("tenant"), user.ID)
+	if err != nil {
+		log.Printf("OIDC link for tenant %s failed: %v", c.Param("tenant"), err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "single sign-on is not available for this tenant"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"url": authURL})
+}
+
+// OIDCCallback completes the login and hands the frontend a one-time ticket
+func (h *SSOHandler) OIDCCallback(c *gin.Context) {
+	if idpError := c.Query("error"); idpError != "" {
+		log.Printf("OIDC provider for tenant %s returned an error: %s %s", c.Param("tenant"), idpError, c.Query("error_description"))
+		c.Redirect(http.StatusFound, services.SSOErrorRedirectURL(nil))
+		return
+	}
+
+	ssoService := services.NewSSOService(h.db)
+	ticket, err := ssoService.CompleteOIDCLogin(c.Request.Context(), c.Param("tenant"), c.Query("code"), c.Query("state"))
+	if err != nil {
+		log.Printf("OIDC callback for tenant %s failed: %v", c.Param("tenant"), err)
+		c.Redirect(http.StatusFound, services.SSOErrorRedirectURL(err))
+		return
+	}
+
+	c.Redirect(http.StatusFound, services.SSOTicketRedirectURL(ticket))
+}
+
+// SAMLMetadata serves the tenant's SP metadata for upload to the IdP
+func (h *SSOHandler) SAMLMetadata(c *gin.Context) {
+	ssoService := services.NewSSOService(h.db)
+	metadata, err := ssoService.SAMLMetadata(c.Request.Context(), c.Request.Host)
+	if err != nil {
+		log.Printf("SAML metadata for host %s failed: %v", c.Request.Host, err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "SAML is not configured for this tenant"})
+		return
+	}
+
+	c.Data(http.StatusOK, "application/samlmetadata+xml", metadata)
+}
+
+// SAMLLogin redirects the browser to the tenant's IdP with an authentication request
+func (h *SSOHandler) SAMLLogin(c *gin.Context) {
+	ssoService := services.NewSSOService(h.db)
+	redirectURL, err := ssoService.BeginSAMLLogin(c.Request.Context(), c.Request.Host)
+	if err != nil {
+		log.Printf("SAML login for host %s failed: %v", c.Request.Host, err)
+		c.Redirect(http.StatusFound, services.SSOErrorRedirectURL(err))
+		return
+	}
+
+	c.Redirect(http.StatusFound, redirectURL)
+}
+
+// SAMLLink returns the URL the signed-in user's browser follows to link their account to
+// the tenant's SAML IdP
+func (h *SSOHandler) SAMLLink(c *gin.Context) {
+	user, err := middleware.RequireUserSession(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	ssoService := services.NewSSOService(h.db)
+	redirectURL, err := ssoService.BeginSAMLLink(c.Request.Context(), c.Request.Host, user.ID)
+	if err != nil {
+		log.Printf("SAML link for host %s failed: %v", c.Request.Host, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "single sign-on is not available for this tenant"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"url": redirectURL})
+}
+
+// SAMLACS consumes the IdP response and hands the frontend a one-time ticket
+func (h *SSOHandler) SAMLACS(c *gin.Context) {
+	ssoService := services.NewSSOService(h.db)
+	ticket, err := ssoService.CompleteSAMLLogin(c.Request.Context(), c.Request.Host, c.PostForm("SAMLResponse"), c.PostForm("RelayState"))
+	if err != nil {
+		log.Printf("SAML response for host %s rejected: %v", c.Request.Host, err)
+		c.Redirect(http.StatusFound, services.SSOErrorRedirectURL(err))
+		return
+	}
+
+	c.Redirect(http.StatusFound, services.SSOTicketRedirectURL(ticket))
+}
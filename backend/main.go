@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 
 	"golang_saas/config"
 	"golang_saas/graph"
+	"golang_saas/handlers"
 	"golang_saas/middleware"
+	"golang_saas/services"
+	"golang_saas/utils"
 
 	"github.com/99designs/gqlgen/graphql/handler"
 	"github.com/99designs/gqlgen/graphql/playground"
@@ -39,9 +43,34 @@ func main() {
 		return
 	}
 
+	// Load JWT signing keys
+	signingKeyService := services.NewSigningKeyService(config.DB)
+	if err := signingKeyService.InitKeyRing(); err != nil {
+		log.Fatal("Failed to initialize JWT signing keys:", err)
+	}
+
+	// Cache permission checks; role changes made by any instance invalidate every instance
+	if err := services.RegisterPermissionCacheCallbacks(config.DB); err != nil {
+		log.Fatal("Failed to set up the permission cache:", err)
+	}
+	listenCtx, stopListening := context.WithCancel(context.Background())
+	defer stopListening()
+	services.ListenForPermissionInvalidations(listenCtx)
+
+	// Give the built-in roles the permissions this version defines for them
+	if err := services.NewRBACService(config.DB).InitializeSystemRoles(); err != nil {
+		log.Fatal("Failed to initialize roles and permissions:", err)
+	}
+
 	// Create Gin router
 	r := gin.Default()
 
+	// Client IPs drive login throttling and session records, so X-Forwarded-For is only
+	// believed when it comes from one of our own proxies
+	if err := r.SetTrustedProxies(config.AppConfig.TrustedProxyList()); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES:", err)
+	}
+
 	// CORS middleware
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:3001", "http://localhost:3000"},
@@ -53,6 +82,7 @@ func main() {
 
 	// Authentication middleware
 	r.Use(middleware.AuthMiddleware(config.DB))
+	r.Use(middleware.AuditImpersonation(config.DB))
 
 	// GraphQL resolver
 	resolver := &graph.Resolver{
@@ -74,6 +104,21 @@ func main() {
 		})
 	}
 
+	// Tenant single sign-on
+	handlers.NewSSOHandler(config.DB).RegisterRoutes(r)
+
+	// SCIM provisioning for tenant identity providers
+	handlers.NewSCIMHandler(config.DB).RegisterRoutes(r)
+
+	// OAuth2 and OpenID Connect provider for tenant apps and internal services
+	handlers.NewOAuthHandler(config.DB).RegisterRoutes(r)
+
+	// Public keys for verifying our JWTs
+	r.GET("/.well-known/jwks.json", func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(200, utils.PublicJWKS())
+	})
+
 	// Health check endpoint
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "ok"})
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"context"
+	"log"
 	"strings"
 
 	"golang_saas/models"
@@ -17,13 +18,21 @@ import (
 type contextKey string
 
 const (
-	UserContextKey   contextKey = "user"
-	ClaimsContextKey contextKey = "claims"
+	UserContextKey    contextKey = "user"
+	ClaimsContextKey  contextKey = "claims"
+	SessionContextKey contextKey = "session"
+	APIKeyContextKey  contextKey = "api_key"
 )
 
 // AuthMiddleware for GraphQL
 func AuthMiddleware(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// Record client info for session tracking
+		c.Request = c.Request.WithContext(services.WithClientInfo(c.Request.Context(), services.ClientInfo{
+			IPAddress: c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+		}))
+
 		// Get token from Authorization header
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -31,8 +40,15 @@ func AuthMiddleware(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		// Extract token from "Bearer <token>"
+		// Machine clients authenticate with "ApiKey <key>"
 		tokenParts := strings.Split(authHeader, " ")
+		if len(tokenParts) == 2 && tokenParts[0] == "ApiKey" {
+			authenticateAPIKey(c, db, tokenParts[1])
+			c.Next()
+			return
+		}
+
+		// Extract token from "Bearer <token>"
 		if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
 			c.Next()
 			return
@@ -47,23 +63,45 @@ func AuthMiddleware(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		// Reject tokens whose session was revoked or has expired
+		sessionService := services.NewSessionService(db)
+		session, err := sessionService.ValidateAccessToken(token)
+		if err != nil {
+			c.Next()
+			return
+		}
+
 		// Get user from database
 		var user models.User
 		userUUID, err := uuid.Parse(claims.UserID)
-		if err != nil {
+		if err != nil || userUUID != session.UserID {
 			c.Next()
 			return
 		}
 
-		err = db.Preload("Role").Preload("Role.Permissions").First(&user, "id = ?", userUUID).Error
+		err = db.Preload("Role").Preload("Role.Permissions").Where("is_active = ?", true).First(&user, "id = ?", userUUID).Error
 		if err != nil {
 			c.Next()
 			return
 		}
 
-		// Store user and claims in context
+		// Tokens issued for another of the user's tenants act with the role held there
+		if session.TenantID != nil {
+			membershipService := services.NewTenantMembershipService(db)
+			if err := membershipService.ActivateTenant(&user, *session.TenantID); err != nil {
+				c.Next()
+				return
+			}
+		}
+
+		if err := sessionService.TouchSession(session); err != nil {
+			log.Printf("Failed to record session activity: %v", err)
+		}
+
+		// Store user, claims and session in context
 		ctx := context.WithValue(c.Request.Context(), UserContextKey, &user)
 		ctx = context.WithValue(ctx, ClaimsContextKey, claims)
+		ctx = context.WithValue(ctx, SessionContextKey, session)
 		ctx = context.WithValue(ctx, "userID", claims.UserID)
 		c.Request = c.Request.WithContext(ctx)
 
@@ -71,6 +109,34 @@ func AuthMiddleware(db *gorm.DB) gin.HandlerFunc {
 	}
 }
 
+// authenticateAPIKey stores the user an API key acts for in the request context. The claims
+// are derived from the key, so they only list the permissions granted to it.
+func authenticateAPIKey(c *gin.Context, db *gorm.DB, key string) {
+	apiKeyService := services.NewAPIKeyService(db)
+	apiKey, user, err := apiKeyService.Authenticate(key)
+	if err != nil {
+		return
+	}
+
+	permissions, err := services.NewRBACService(db).ExpandPermissions(apiKey.Permissions)
+	if err != nil {
+		return
+	}
+
+	claims := &utils.Claims{
+		UserID:      user.ID.String(),
+		TenantID:    apiKey.TenantID.String(),
+		Role:        user.Role.Name,
+		Permissions: permissions,
+	}
+
+	ctx := context.WithValue(c.Request.Context(), UserContextKey, user)
+	ctx = context.WithValue(ctx, ClaimsContextKey, claims)
+	ctx = context.WithValue(ctx, APIKeyContextKey, apiKey)
+	ctx = context.WithValue(ctx, "userID", claims.UserID)
+	c.Request = c.Request.WithContext(ctx)
+}
+
 // GetUserFromContext extracts user from GraphQL context
 func GetUserFromContext(ctx context.Context) (*models.User, bool) {
 	user, ok := ctx.Value(UserContextKey).(*models.User)
@@ -83,6 +149,18 @@ func GetClaimsFromContext(ctx context.Context) (*utils.Claims, bool) {
 	return claims, ok
 }
 
+// GetSessionFromContext extracts the current session from GraphQL context
+func GetSessionFromContext(ctx context.Context) (*models.UserSession, bool) {
+	session, ok := ctx.Value(SessionContextKey).(*models.UserSession)
+	return session, ok
+}
+
+// GetAPIKeyFromContext returns the API key the request was authenticated with, if any
+func GetAPIKeyFromContext(ctx context.Context) (*models.APIKey, bool) {
+	apiKey, ok := ctx.Value(APIKeyContextKey).(*models.APIKey)
+	return apiKey, ok
+}
+
 // RequireAuth ensures user is authenticated
 func RequireAuth(ctx context.Context) (*models.User, error) {
 	user, ok := GetUserFromContext(ctx)
@@ -92,6 +170,34 @@ func RequireAuth(ctx context.Context) (*models.User, error) {
 	return user, nil
 }
 
+// RequireUserSession ensures the request was made by a signed-in user. Account and
+// credential management is not available to API keys or while impersonating.
+func RequireUserSession(ctx context.Context) (*models.User, error) {
+	user, err := RequireAuth(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := GetAPIKeyFromContext(ctx); ok {
+		return nil, ErrUserSessionRequired
+	}
+	if claims, ok := GetClaimsFromContext(ctx); ok && claims.Act != nil {
+		return nil, ErrImpersonationRestricted
+	}
+
+	return user, nil
+}
+
+// checkPermission checks a permission for the current user, within the limits of the
+// API key when the request was authenticated with one
+func checkPermission(ctx context.Context, db *gorm.DB, user *models.User, permission string, tenantID *uuid.UUID, resource *services.ResourceRef) (bool, error) {
+	rbacService := services.NewRBACService(db)
+	if apiKey, ok := GetAPIKeyFromContext(ctx); ok {
+		return rbacService.CheckAPIKeyPermission(apiKey, permission, tenantID, resource)
+	}
+	return rbacService.CheckUserPermission(user.ID, permission, tenantID, resource)
+}
+
 // RequirePermission checks if user has specific permission
 func RequirePermission(ctx context.Context, db *gorm.DB, permission string) error {
 	user, err := RequireAuth(ctx)
@@ -99,8 +205,7 @@ func RequirePermission(ctx context.Context, db *gorm.DB, permission string) erro
 		return err
 	}
 
-	rbacService := services.NewRBACService(db)
-	hasPermission, err := rbacService.CheckUserPermission(user.ID, permission, user.TenantID)
+	hasPermission, err := checkPermission(ctx, db, user, permission, user.TenantID, nil)
 	if err != nil {
 		return &AuthError{Code: "PERMISSION_CHECK_FAILED", Message: "Failed to check permissions"}
 	}
@@ -124,8 +229,7 @@ func RequireSystemPermission(ctx context.Context, db *gorm.DB, permission string
 		return &AuthError{Code: "SYSTEM_ACCESS_REQUIRED", Message: "System access required"}
 	}
 
-	rbacService := services.NewRBACService(db)
-	hasPermission, err := rbacService.CheckUserPermission(user.ID, permission, nil)
+	hasPermission, err := checkPermission(ctx, db, user, permission, nil, nil)
 	if err != nil {
 		return &AuthError{Code: "PERMISSION_CHECK_FAILED", Message: "Failed to check permissions"}
 	}
@@ -139,6 +243,16 @@ func RequireSystemPermission(ctx context.Context, db *gorm.DB, permission string
 
 // RequireTenantPermission checks if user has specific tenant permission
 func RequireTenantPermission(ctx context.Context, db *gorm.DB, permission string, tenantID uuid.UUID) error {
+	return requireTenantPermission(ctx, db, permission, tenantID, nil)
+}
+
+// RequireResourcePermission checks a tenant permission against a resource of the tenant.
+// Permissions with the OWN scope are granted if the user owns the resource.
+func RequireResourcePermission(ctx context.Context, db *gorm.DB, permission string, tenantID uuid.UUID, resource services.ResourceRef) error {
+	return requireTenantPermission(ctx, db, permission, tenantID, &resource)
+}
+
+func requireTenantPermission(ctx context.Context, db *gorm.DB, permission string, tenantID uuid.UUID, resource *services.ResourceRef) error {
 	user, err := RequireAuth(ctx)
 	if err != nil {
 		return err
@@ -147,8 +261,7 @@ func RequireTenantPermission(ctx context.Context, db *gorm.DB, permission string
 	// System users can access any tenant
 	if user.TenantID == nil {
 		// Check if user has system permission to manage tenants
-		rbacService := services.NewRBACService(db)
-		hasSystemAccess, err := rbacService.CheckUserPermission(user.ID, "tenant.manage", nil)
+		hasSystemAccess, err := checkPermission(ctx, db, user, "tenant.manage", nil, nil)
 		if err != nil {
 			return &AuthError{Code: "PERMISSION_CHECK_FAILED", Message: "Failed to check permissions"}
 		}
@@ -163,8 +276,7 @@ func RequireTenantPermission(ctx context.Context, db *gorm.DB, permission string
 		return &AuthError{Code: "TENANT_ACCESS_DENIED", Message: "Access to tenant denied"}
 	}
 
-	rbacService := services.NewRBACService(db)
-	hasPermission, err := rbacService.CheckUserPermission(user.ID, permission, &tenantID)
+	hasPermission, err := checkPermission(ctx, db, user, permission, &tenantID, resource)
 	if err != nil {
 		return &AuthError{Code: "PERMISSION_CHECK_FAILED", Message: "Failed to check permissions"}
 	}
@@ -237,11 +349,31 @@ func GetUserPermissions(ctx context.Context, db *gorm.DB) ([]string, error) {
 	}
 
 	rbacService := services.NewRBACService(db)
-	permissions, err := rbacService.GetUserPermissions(user.ID)
+	permissions, err := rbacService.GetUserPermissionsInTenant(user.ID, user.TenantID)
 	if err != nil {
 		return nil, &AuthError{Code: "PERMISSION_FETCH_FAILED", Message: "Failed to fetch permissions"}
 	}
 
+	// An API key only carries the permissions it was granted
+	if apiKey, ok := GetAPIKeyFromContext(ctx); ok {
+		keyPermissions, err := rbacService.ExpandPermissions(apiKey.Permissions)
+		if err != nil {
+			return nil, &AuthError{Code: "PERMISSION_FETCH_FAILED", Message: "Failed to fetch permissions"}
+		}
+		grantedByKey := make(map[string]bool, len(keyPermissions))
+		for _, permission := range keyPermissions {
+			grantedByKey[permission] = true
+		}
+
+		granted := permissions[:0]
+		for _, permission := range permissions {
+			if grantedByKey[permission] {
+				granted = append(granted, permission)
+			}
+		}
+		permissions = granted
+	}
+
 	return permissions, nil
 }
 
@@ -290,6 +422,9 @@ func RequireTenantAccess(ctx context.Context, tenantID uuid.UUID) error {
 var (
 	ErrUnauthorized = &AuthError{Code: "UNAUTHORIZED", Message: "Authentication required"}
 	ErrForbidden    = &AuthError{Code: "FORBIDDEN", Message: "Access denied"}
+
+	ErrUserSessionRequired     = &AuthError{Code: "USER_SESSION_REQUIRED", Message: "Not available to API keys"}
+	ErrImpersonationRestricted = &AuthError{Code: "IMPERSONATION_RESTRICTED", Message: "Not available while impersonating"}
 )
 
 type AuthError struct {
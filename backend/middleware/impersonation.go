@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+
+	"golang_saas/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// maxAuditedBodySize limits how much of a GraphQL request body is read to find the operation
+const maxAuditedBodySize = 1 << 20
+
+// AuditImpersonation writes every request made with an impersonation token to the system
+// audit log before it is handled. Requests that cannot be audited are refused.
+// It must run after AuthMiddleware.
+func AuditImpersonation(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		claims, ok := GetClaimsFromContext(ctx)
+		if !ok || claims.Act == nil {
+			c.Next()
+			return
+		}
+
+		user, _ := GetUserFromContext(ctx)
+		impersonatorID, err := uuid.Parse(claims.Act.UserID)
+		if err != nil || user == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": ErrUnauthorized.Message})
+			return
+		}
+
+		request := map[string]any{
+			"method": c.Request.Method,
+			"path":   c.Request.URL.Path,
+		}
+		if operationName, query, ok := graphQLOperation(c); ok {
+			request["operation_name"] = operationName
+			request["query"] = query
+		}
+
+		impersonationService := services.NewImpersonationService(db)
+		if err := impersonationService.RecordRequest(ctx, impersonatorID, user.ID, user.TenantID, request); err != nil {
+			log.Printf("Failed to audit impersonated request: %v", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to audit request"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// graphQLOperation reads the operation name and query from a GraphQL POST body and restores
+// the body for the handler. Variables are left out since they may carry secrets.
+func graphQLOperation(c *gin.Context) (string, string, bool) {
+	if c.Request.Method != http.MethodPost || c.Request.Body == nil {
+		return "", "", false
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxAuditedBodySize))
+	if err != nil {
+		return "", "", false
+	}
+	c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), c.Request.Body))
+
+	var params struct {
+		OperationName string `json:"operationName"`
+		Query         string `json:"query"`
+	}
+	if err := json.Unmarshal(body, &params); err != nil || params.Query == "" {
+		return "", "", false
+	}
+
+	return params.OperationName, params.Query, true
+}
@@ -0,0 +1,129 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PasswordResetToken represents a single-use password reset request
+type PasswordResetToken struct {
+	BaseModel
+	UserID    uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index"`
+	TenantID  *uuid.UUID `json:"tenant_id" gorm:"type:uuid;index"`
+	TokenHash string     `json:"-" gorm:"not null;uniqueIndex"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null"`
+	UsedAt    *time.Time `json:"used_at"`
+
+	// Relations
+	User User `json:"user" gorm:"foreignKey:UserID"`
+}
+
+// MagicLinkToken records a signed sign-in link emailed to a tenant user so it can only be
+// used once. Only a hash of the link's token is stored.
+type MagicLinkToken struct {
+	BaseModel
+	UserID    uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index"`
+	TenantID  uuid.UUID  `json:"tenant_id" gorm:"type:uuid;not null"`
+	TokenHash string     `json:"-" gorm:"not null;uniqueIndex"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null"`
+	UsedAt    *time.Time `json:"used_at"`
+
+	// Relations
+	User User `json:"user" gorm:"foreignKey:UserID"`
+}
+
+// PasswordHistory keeps hashes of a user's previous passwords so policies can prevent reuse
+type PasswordHistory struct {
+	BaseModel
+	UserID       uuid.UUID `json:"user_id" gorm:"type:uuid;not null;index"`
+	PasswordHash string    `json:"-" gorm:"not null"`
+
+	// Relations
+	User User `json:"user" gorm:"foreignKey:UserID"`
+}
+
+// UserTwoFactor holds a user's TOTP enrollment. The secret is encrypted at rest.
+type UserTwoFactor struct {
+	BaseModel
+	UserID       uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;uniqueIndex"`
+	Secret       string     `json:"-" gorm:"not null"`
+	Enabled      bool       `json:"enabled" gorm:"default:false"`
+	EnabledAt    *time.Time `json:"enabled_at"`
+	LastUsedStep int64      `json:"-" gorm:"default:0"`
+
+	// Relations
+	User User `json:"user" gorm:"foreignKey:UserID"`
+}
+
+// RecoveryCode is a hashed single-use two-factor recovery code
+type RecoveryCode struct {
+	BaseModel
+	UserID   uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index"`
+	CodeHash string     `json:"-" gorm:"not null"`
+	UsedAt   *time.Time `json:"used_at"`
+
+	// Relations
+	User User `json:"user" gorm:"foreignKey:UserID"`
+}
+
+// Signing key statuses
+const (
+	SigningKeyStatusPending = "pending" // published in the JWKS, not yet used for signing
+	SigningKeyStatusActive  = "active"  // current signing key
+	SigningKeyStatusRetired = "retired" // kept in the JWKS until tokens it signed expire
+)
+
+// SigningKey is an asymmetric key used to sign JWTs. The private key is encrypted at rest.
+type SigningKey struct {
+	BaseModel
+	KID         string     `json:"kid" gorm:"not null;uniqueIndex"`
+	Algorithm   string     `json:"algorithm" gorm:"not null"`
+	PrivateKey  string     `json:"-" gorm:"type:text;not null"`
+	PublicKey   string     `json:"public_key" gorm:"type:text;not null"`
+	Status      string     `json:"status" gorm:"not null;index"`
+	ActivatedAt *time.Time `json:"activated_at"`
+	RetiredAt   *time.Time `json:"retired_at"`
+}
+
+// APIKey lets a machine client act for the user who created it within one tenant,
+// limited to a subset of that user's permissions. Only a hash of the secret is stored;
+// the prefix identifies the key in listings and logs.
+type APIKey struct {
+	BaseModel
+	TenantID   uuid.UUID  `json:"tenant_id" gorm:"type:uuid;not null;index"`
+	UserID     uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index"`
+	Name       string     `json:"name" gorm:"not null"`
+	Prefix     string     `json:"prefix" gorm:"not null;uniqueIndex"`
+	SecretHash string     `json:"-" gorm:"not null"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+
+	// Relations
+	User        User         `json:"user" gorm:"foreignKey:UserID"`
+	Permissions []Permission `json:"permissions,omitempty" gorm:"many2many:api_key_permissions;"`
+}
+
+// SCIMToken authenticates a tenant's identity provider on the SCIM provisioning endpoint.
+// Like API keys, only a hash of the secret is stored and the prefix identifies the token.
+type SCIMToken struct {
+	BaseModel
+	TenantID    uuid.UUID  `json:"tenant_id" gorm:"type:uuid;not null;index"`
+	Name        string     `json:"name" gorm:"not null"`
+	Prefix      string     `json:"prefix" gorm:"not null;uniqueIndex"`
+	SecretHash  string     `json:"-" gorm:"not null"`
+	CreatedByID *uuid.UUID `json:"created_by_id" gorm:"type:uuid"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+}
+
+// HasPermission reports whether the key was granted the permission
+func (k *APIKey) HasPermission(permission string) bool {
+	for _, perm := range k.Permissions {
+		if perm.Name == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OAuth grant types clients can be registered for
+const (
+	OAuthGrantAuthorizationCode = "authorization_code"
+	OAuthGrantRefreshToken      = "refresh_token"
+	OAuthGrantClientCredentials = "client_credentials"
+)
+
+// OAuthClient is an application registered to use the platform's OAuth endpoints. Platform
+// clients (no tenant) are internal services that introspect and revoke platform tokens;
+// tenant clients are the tenant's own apps signing users in with their platform accounts.
+// Only a hash of the client secret is stored; public clients have none.
+type OAuthClient struct {
+	BaseModel
+	TenantID     *uuid.UUID `json:"tenant_id" gorm:"type:uuid;index"`
+	Name         string     `json:"name" gorm:"not null"`
+	ClientID     string     `json:"client_id" gorm:"not null;uniqueIndex"`
+	SecretHash   string     `json:"-"`
+	RedirectURIs string     `json:"redirect_uris" gorm:"type:text"` // space separated
+	Scopes       string     `json:"scopes" gorm:"type:text"`        // space separated scopes the client may request
+	GrantTypes   string     `json:"grant_types"`                    // space separated
+	CreatedByID  *uuid.UUID `json:"created_by_id" gorm:"type:uuid"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+	RevokedAt    *time.Time `json:"revoked_at"`
+
+	// Relations
+	Tenant *Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+}
+
+// TableName keeps gorm from splitting the OAuth acronym into "o_auth"
+func (OAuthClient) TableName() string {
+	return "oauth_clients"
+}
+
+// IsPublic reports whether the client cannot keep a secret, like a single-page or mobile app
+func (c *OAuthClient) IsPublic() bool {
+	return c.SecretHash == ""
+}
+
+// OAuthAuthorizationCode is a single-use code handed to a client's redirect URI after the
+// user approved its request. Only a hash of the code is stored.
+type OAuthAuthorizationCode struct {
+	BaseModel
+	ClientID      uuid.UUID  `json:"client_id" gorm:"type:uuid;not null;index"`
+	UserID        uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index"`
+	TenantID      uuid.UUID  `json:"tenant_id" gorm:"type:uuid;not null"`
+	CodeHash      string     `json:"-" gorm:"not null;uniqueIndex"`
+	RedirectURI   string     `json:"redirect_uri" gorm:"not null"`
+	Scope         string     `json:"scope"`
+	Nonce         string     `json:"-"`
+	CodeChallenge string     `json:"-" gorm:"not null"` // S256 PKCE challenge
+	ExpiresAt     time.Time  `json:"expires_at" gorm:"not null"`
+	UsedAt        *time.Time `json:"used_at"`
+}
+
+// TableName keeps gorm from splitting the OAuth acronym
+func (OAuthAuthorizationCode) TableName() string {
+	return "oauth_authorization_codes"
+}
+
+// OAuthRefreshToken lets a client obtain new access tokens for a user. Refresh tokens are
+// single-use; all tokens rotated from the same authorization share a family.
+type OAuthRefreshToken struct {
+	BaseModel
+	ClientID  uuid.UUID  `json:"client_id" gorm:"type:uuid;not null;index"`
+	UserID    uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index"`
+	TenantID  uuid.UUID  `json:"tenant_id" gorm:"type:uuid;not null"`
+	FamilyID  uuid.UUID  `json:"family_id" gorm:"type:uuid;not null;index"`
+	TokenHash string     `json:"-" gorm:"not null;uniqueIndex"`
+	Scope     string     `json:"scope"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null"`
+	RotatedAt *time.Time `json:"rotated_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+// TableName keeps gorm from splitting the OAuth acronym
+func (OAuthRefreshToken) TableName() string {
+	return "oauth_refresh_tokens"
+}
+
+// OAuthConsent records the scopes a user has granted a client, so they are not asked again
+type OAuthConsent struct {
+	BaseModel
+	ClientID uuid.UUID `json:"client_id" gorm:"type:uuid;not null;uniqueIndex:idx_oauth_consents_client_user"`
+	UserID   uuid.UUID `json:"user_id" gorm:"type:uuid;not null;uniqueIndex:idx_oauth_consents_client_user"`
+	Scope    string    `json:"scope"` // space separated
+}
+
+// TableName keeps gorm from splitting the OAuth acronym
+func (OAuthConsent) TableName() string {
+	return "oauth_consents"
+}
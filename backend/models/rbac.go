@@ -1,6 +1,8 @@
 package models
 
 import (
+	"strings"
+
 	"github.com/google/uuid"
 	"gorm.io/datatypes"
 )
@@ -62,8 +64,17 @@ const (
 	ActionView   ActionType = "view"
 	ActionExport ActionType = "export"
 	ActionImport ActionType = "import"
+
+	ActionImpersonate ActionType = "impersonate"
+
+	// ActionAll is the action of wildcard permissions such as tenant_user.*
+	ActionAll ActionType = "*"
 )
 
+// PermissionWildcard on its own grants every permission of its kind; as the action of a
+// permission name ("tenant_user.*") it grants every action on the resource
+const PermissionWildcard = "*"
+
 // PermissionScope represents the scope of a permission
 type PermissionScope string
 
@@ -83,9 +94,10 @@ type SystemPermission struct {
 	IsSystem    bool
 }
 
-// GetSystemPermissions returns all predefined system permissions
+// GetSystemPermissions returns all predefined system permissions, followed by the wildcard
+// permissions that grant them in bulk
 func GetSystemPermissions() []SystemPermission {
-	return []SystemPermission{
+	permissions := []SystemPermission{
 		// System Administration Permissions
 		{Name: "system.manage", Resource: ResourceSystem, Action: ActionManage, Scope: ScopeSystem, Description: "Full system management", IsSystem: true},
 		{Name: "system.view", Resource: ResourceSystem, Action: ActionView, Scope: ScopeSystem, Description: "View system information", IsSystem: true},
@@ -142,6 +154,9 @@ func GetSystemPermissions() []SystemPermission {
 		{Name: "audit_log.read", Resource: ResourceAuditLog, Action: ActionRead, Scope: ScopeSystem, Description: "View audit logs", IsSystem: true},
 		{Name: "audit_log.list", Resource: ResourceAuditLog, Action: ActionList, Scope: ScopeSystem, Description: "List audit logs", IsSystem: true},
 
+		// Impersonation Permissions
+		{Name: "user.impersonate", Resource: ResourceUser, Action: ActionImpersonate, Scope: ScopeSystem, Description: "Sign in as tenant users for support", IsSystem: true},
+
 		// ===========================================
 		// TENANT LEVEL PERMISSIONS
 		// ===========================================
@@ -207,6 +222,42 @@ func GetSystemPermissions() []SystemPermission {
 		{Name: "profile.read", Resource: ResourceUser, Action: ActionRead, Scope: ScopeOwn, Description: "View own profile", IsSystem: false},
 		{Name: "profile.update", Resource: ResourceUser, Action: ActionUpdate, Scope: ScopeOwn, Description: "Update own profile", IsSystem: false},
 	}
+
+	return append(permissions, wildcardPermissions(permissions)...)
+}
+
+// wildcardPermissions returns a resource.* permission for every resource of the given
+// permissions, and the * permission granting all system permissions
+func wildcardPermissions(permissions []SystemPermission) []SystemPermission {
+	wildcards := []SystemPermission{
+		{Name: PermissionWildcard, Resource: ResourceSystem, Action: ActionAll, Scope: ScopeSystem, Description: "All system permissions", IsSystem: true},
+	}
+
+	seen := make(map[string]bool)
+	for _, perm := range permissions {
+		resource := strings.SplitN(perm.Name, ".", 2)[0]
+		if seen[resource] {
+			continue
+		}
+		seen[resource] = true
+		wildcards = append(wildcards, SystemPermission{
+			Name:        resource + "." + PermissionWildcard,
+			Resource:    perm.Resource,
+			Action:      ActionAll,
+			Scope:       perm.Scope,
+			Description: "All " + resource + " permissions",
+			IsSystem:    perm.IsSystem,
+		})
+	}
+	return wildcards
+}
+
+// GetPermissionImplications returns the permissions that grant others besides themselves.
+// Implied permissions may be wildcards; implications are transitive.
+func GetPermissionImplications() map[string][]string {
+	return map[string][]string{
+		"system.manage": {"system.*", "system_user.*", "system_role.*", "system_setting.*"},
+	}
 }
 
 // RolePermissionMatrix defines which permissions each role should have
@@ -222,15 +273,7 @@ func GetRolePermissions() []RolePermissionMatrix {
 		{
 			Role: SystemRoleSuperAdmin,
 			Permissions: []string{
-				"system.manage",
-				"tenant.create", "tenant.read", "tenant.update", "tenant.delete", "tenant.list",
-				"plan.create", "plan.read", "plan.update", "plan.delete", "plan.list",
-				"module.create", "module.read", "module.update", "module.delete", "module.list",
-				"system_user.create", "system_user.read", "system_user.update", "system_user.delete", "system_user.list",
-				"system_role.create", "system_role.read", "system_role.update", "system_role.delete", "system_role.list",
-				"subscription.create", "subscription.read", "subscription.update", "subscription.delete", "subscription.list",
-				"system_setting.create", "system_setting.read", "system_setting.update", "system_setting.delete",
-				"audit_log.read", "audit_log.list",
+				"*",
 			},
 		},
 		{
@@ -266,6 +309,7 @@ func GetRolePermissions() []RolePermissionMatrix {
 				"system_user.read", "system_user.list",
 				"subscription.read", "subscription.list",
 				"audit_log.read", "audit_log.list",
+				"user.impersonate",
 			},
 		},
 
@@ -273,16 +317,8 @@ func GetRolePermissions() []RolePermissionMatrix {
 		{
 			Role: TenantRoleAdmin,
 			Permissions: []string{
-				"tenant_user.create", "tenant_user.read", "tenant_user.update", "tenant_user.delete", "tenant_user.list",
-				"tenant_role.create", "tenant_role.read", "tenant_role.update", "tenant_role.delete", "tenant_role.list",
-				"tenant_setting.create", "tenant_setting.read", "tenant_setting.update", "tenant_setting.delete",
-				"tenant_module.read", "tenant_module.update", "tenant_module.list",
-				"domain_mapping.create", "domain_mapping.read", "domain_mapping.update", "domain_mapping.delete",
-				"customer.create", "customer.read", "customer.update", "customer.delete", "customer.list",
-				"tenant_data.create", "tenant_data.read", "tenant_data.update", "tenant_data.delete", "tenant_data.export", "tenant_data.import",
-				"report.create", "report.read", "report.update", "report.delete", "report.export",
-				"dashboard.read", "dashboard.update",
-				"profile.read", "profile.update",
+				"tenant_user.*", "tenant_role.*", "tenant_setting.*", "tenant_module.*", "domain_mapping.*",
+				"customer.*", "tenant_data.*", "report.*", "dashboard.*", "profile.*",
 			},
 		},
 		{
@@ -334,6 +370,35 @@ type CustomerProfile struct {
 	IsActive    bool           `json:"is_active" gorm:"default:true"`
 	Tags        datatypes.JSON `json:"tags" gorm:"type:jsonb"`
 	Metadata    datatypes.JSON `json:"metadata" gorm:"type:jsonb"`
+	UserID      *uuid.UUID     `json:"user_id" gorm:"type:uuid;index"` // the customer's own account, which owns the profile
+
+	// Relations
+	Tenant Tenant `json:"tenant" gorm:"foreignKey:TenantID"`
+}
+
+// PolicyEffect is what an access policy does to the permissions it matches when its
+// condition holds
+type PolicyEffect string
+
+const (
+	PolicyEffectAllow PolicyEffect = "allow"
+	PolicyEffectDeny  PolicyEffect = "deny"
+)
+
+// AccessPolicy refines a tenant's role permissions with a condition on attributes of the
+// subject, the resource and the environment. Deny policies override every grant; allow
+// policies grant tenant permissions roles do not.
+type AccessPolicy struct {
+	BaseModel
+	TenantID    uuid.UUID    `json:"tenant_id" gorm:"type:uuid;not null;index"`
+	Name        string       `json:"name" gorm:"not null"`
+	Description *string      `json:"description"`
+	Effect      PolicyEffect `json:"effect" gorm:"not null"`
+	Permissions string       `json:"permissions" gorm:"not null"` // space separated, wildcards allowed
+	Condition   string       `json:"condition" gorm:"not null"`
+	Timezone    string       `json:"timezone" gorm:"not null;default:'UTC'"` // environment times are local to it
+	IsEnabled   bool         `json:"is_enabled" gorm:"default:true"`
+	CreatedByID *uuid.UUID   `json:"created_by_id" gorm:"type:uuid"`
 
 	// Relations
 	Tenant Tenant `json:"tenant" gorm:"foreignKey:TenantID"`
@@ -0,0 +1,105 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+// SSO protocols
+const (
+	SSOProtocolOIDC = "oidc"
+	SSOProtocolSAML = "saml"
+)
+
+// TenantOIDCProvider is a tenant's OpenID Connect identity provider. The client secret is encrypted at rest.
+type TenantOIDCProvider struct {
+	BaseModel
+	TenantID          uuid.UUID      `json:"tenant_id" gorm:"type:uuid;not null;uniqueIndex"`
+	Issuer            string         `json:"issuer" gorm:"not null"`
+	ClientID          string         `json:"client_id" gorm:"not null"`
+	ClientSecret      string         `json:"-" gorm:"type:text"`
+	Scopes            string         `json:"scopes" gorm:"default:'openid profile email'"` // space separated
+	ClaimMappings     datatypes.JSON `json:"claim_mappings" gorm:"type:jsonb"`             // SSOClaimMappings
+	GroupRoleMappings datatypes.JSON `json:"group_role_mappings" gorm:"type:jsonb"`        // []SSOGroupRoleMapping
+	DefaultRoleID     *uuid.UUID     `json:"default_role_id" gorm:"type:uuid"`
+	IsEnabled         bool           `json:"is_enabled"`
+
+	// Relations
+	Tenant      Tenant `json:"tenant" gorm:"foreignKey:TenantID"`
+	DefaultRole *Role  `json:"default_role,omitempty" gorm:"foreignKey:DefaultRoleID"`
+}
+
+// TableName keeps gorm from splitting the OIDC acronym into "o_id_c"
+func (TenantOIDCProvider) TableName() string {
+	return "tenant_oidc_providers"
+}
+
+// TenantSAMLProvider is a tenant's SAML 2.0 identity provider together with the key pair
+// of the tenant's service provider. The IdP metadata may be uploaded after the SP metadata
+// has been handed to the IdP. The SP private key is encrypted at rest.
+type TenantSAMLProvider struct {
+	BaseModel
+	TenantID          uuid.UUID      `json:"tenant_id" gorm:"type:uuid;not null;uniqueIndex"`
+	IdPMetadata       string         `json:"idp_metadata" gorm:"type:text"`
+	IdPEntityID       string         `json:"idp_entity_id"`
+	SPCertificate     string         `json:"sp_certificate" gorm:"type:text;not null"`
+	SPPrivateKey      string         `json:"-" gorm:"type:text;not null"`
+	AttributeMappings datatypes.JSON `json:"attribute_mappings" gorm:"type:jsonb"`  // SSOClaimMappings
+	GroupRoleMappings datatypes.JSON `json:"group_role_mappings" gorm:"type:jsonb"` // []SSOGroupRoleMapping
+	DefaultRoleID     *uuid.UUID     `json:"default_role_id" gorm:"type:uuid"`
+	IsEnabled         bool           `json:"is_enabled"`
+
+	// Relations
+	Tenant      Tenant `json:"tenant" gorm:"foreignKey:TenantID"`
+	DefaultRole *Role  `json:"default_role,omitempty" gorm:"foreignKey:DefaultRoleID"`
+}
+
+// SSOClaimMappings names the IdP claims (or attributes) user fields are read from
+type SSOClaimMappings struct {
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Groups    string `json:"groups"`
+}
+
+// SSOGroupRoleMapping maps an IdP group to a tenant role by name. Mappings are
+// evaluated in order and the first group the user belongs to wins.
+type SSOGroupRoleMapping struct {
+	Group string `json:"group"`
+	Role  string `json:"role"`
+}
+
+// UserIdentity links a user to an account at an external identity provider
+type UserIdentity struct {
+	BaseModel
+	UserID      uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index"`
+	TenantID    uuid.UUID  `json:"tenant_id" gorm:"type:uuid;not null;index"`
+	Protocol    string     `json:"protocol" gorm:"not null"`
+	ProviderID  uuid.UUID  `json:"provider_id" gorm:"type:uuid;not null;uniqueIndex:idx_user_identities_provider_subject"`
+	Subject     string     `json:"subject" gorm:"not null;uniqueIndex:idx_user_identities_provider_subject"`
+	Email       string     `json:"email"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+
+	// Relations
+	User User `json:"user" gorm:"foreignKey:UserID"`
+}
+
+// SSOLoginState tracks an SSO login from the redirect to the IdP until the
+// frontend exchanges the resulting one-time ticket for tokens
+type SSOLoginState struct {
+	BaseModel
+	TenantID     uuid.UUID  `json:"tenant_id" gorm:"type:uuid;not null;index"`
+	ProviderID   uuid.UUID  `json:"provider_id" gorm:"type:uuid;not null"`
+	Protocol     string     `json:"protocol" gorm:"not null"`
+	StateHash    string     `json:"-" gorm:"not null;uniqueIndex"`
+	Nonce        string     `json:"-"`
+	CodeVerifier string     `json:"-" gorm:"type:text"` // encrypted
+	ExpiresAt    time.Time  `json:"expires_at" gorm:"not null"`
+	UsedAt       *time.Time `json:"used_at"`
+	UserID       *uuid.UUID `json:"user_id" gorm:"type:uuid"`
+	LinkUserID   *uuid.UUID `json:"link_user_id" gorm:"type:uuid"` // set when a signed-in user links the identity to their account
+	TicketHash   *string    `json:"-" gorm:"uniqueIndex"`
+	TicketUsedAt *time.Time `json:"ticket_used_at"`
+}
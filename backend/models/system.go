@@ -36,6 +36,15 @@ type User struct {
 	TenantID  *uuid.UUID `json:"tenant_id" gorm:"type:uuid;index"`
 	RoleID    uuid.UUID  `json:"role_id" gorm:"type:uuid;not null"`
 
+	EmailVerified   bool       `json:"email_verified" gorm:"default:false"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	// SelfRegistered is set for users who signed up on their own, through registration or
+	// SSO just-in-time provisioning, rather than being added by an administrator. Only
+	// their addresses are subject to the tenant's email verification requirement.
+	SelfRegistered bool `json:"self_registered" gorm:"not null;default:false"`
+
+	PasswordChangedAt *time.Time `json:"password_changed_at"`
+
 	// Relations
 	Tenant      *Tenant      `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
 	Role        Role         `json:"role" gorm:"foreignKey:RoleID"`
@@ -64,6 +73,9 @@ type Permission struct {
 	Action             string  `json:"action" gorm:"not null"`
 	Description        *string `json:"description"`
 	IsSystemPermission bool    `json:"is_system_permission" gorm:"default:false"`
+	// Scope limits where the permission applies; OWN permissions only cover resources
+	// the user owns
+	Scope PermissionScope `json:"scope" gorm:"default:'tenant'"`
 
 	// Relations
 	Roles []Role `json:"roles,omitempty" gorm:"many2many:role_permissions;"`
@@ -187,10 +199,16 @@ type SystemAuditLog struct {
 	UserAgent    *string        `json:"user_agent"`
 
 	// Relations
-	SystemUser *SystemUser `json:"system_user,omitempty" gorm:"foreignKey:SystemUserID"`
-	Tenant     *Tenant     `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+	SystemUser *User   `json:"system_user,omitempty" gorm:"foreignKey:SystemUserID"` // system users are users without a tenant
+	Tenant     *Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
 }
 
+// System audit log actions
+const (
+	SystemAuditImpersonationStarted = "impersonation.started"
+	SystemAuditImpersonatedRequest  = "impersonation.request"
+)
+
 // UserRole enum for backward compatibility
 type UserRole string
 
@@ -9,33 +9,93 @@ import (
 
 // Tenant-specific models and data structures
 
-// TenantUser represents tenant-specific user data
+// TenantUser is a user's membership in a tenant, with the role they hold there. A user
+// can belong to several tenants; User.TenantID and User.RoleID mirror the primary membership.
 type TenantUser struct {
 	BaseModel
-	UserID           uuid.UUID      `json:"user_id" gorm:"type:uuid;not null;index"`
-	TenantID         uuid.UUID      `json:"tenant_id" gorm:"type:uuid;not null;index"`
+	UserID           uuid.UUID      `json:"user_id" gorm:"type:uuid;not null;index;uniqueIndex:idx_tenant_users_tenant_user"`
+	TenantID         uuid.UUID      `json:"tenant_id" gorm:"type:uuid;not null;index;uniqueIndex:idx_tenant_users_tenant_user"`
+	RoleID           uuid.UUID      `json:"role_id" gorm:"type:uuid;index"`
+	ExternalID       *string        `json:"external_id" gorm:"index"` // identity provider ID, set by SCIM provisioning
 	AvatarURL        *string        `json:"avatar_url"`
 	Phone            *string        `json:"phone"`
 	Preferences      datatypes.JSON `json:"preferences" gorm:"type:jsonb"`
 	LastLoginAt      *time.Time     `json:"last_login_at"`
+	IsActive         bool           `json:"is_active" gorm:"default:true"` // a deactivated member cannot act in the tenant
 	TwoFactorEnabled bool           `json:"two_factor_enabled" gorm:"default:false"`
+	Attributes       datatypes.JSON `json:"attributes" gorm:"type:jsonb"` // read by access policy conditions, e.g. region
 
 	// Relations
 	User   User   `json:"user" gorm:"foreignKey:UserID"`
 	Tenant Tenant `json:"tenant" gorm:"foreignKey:TenantID"`
+	Role   Role   `json:"role" gorm:"foreignKey:RoleID"`
+}
+
+// Invitation asks someone to join a tenant with a role. Only a hash of the emailed token
+// is stored; resending an invitation replaces the token.
+type Invitation struct {
+	BaseModel
+	TenantID    uuid.UUID  `json:"tenant_id" gorm:"type:uuid;not null;index"`
+	Email       string     `json:"email" gorm:"not null;index"`
+	RoleID      uuid.UUID  `json:"role_id" gorm:"type:uuid;not null"`
+	InvitedByID *uuid.UUID `json:"invited_by_id" gorm:"type:uuid"`
+	TokenHash   string     `json:"-" gorm:"not null;uniqueIndex"`
+	ExpiresAt   time.Time  `json:"expires_at" gorm:"not null"`
+	AcceptedAt  *time.Time `json:"accepted_at"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+
+	// Relations
+	Tenant    Tenant `json:"tenant" gorm:"foreignKey:TenantID"`
+	Role      Role   `json:"role" gorm:"foreignKey:RoleID"`
+	InvitedBy *User  `json:"invited_by,omitempty" gorm:"foreignKey:InvitedByID"`
 }
 
 // TenantSettings represents tenant-specific configuration
 type TenantSettings struct {
 	BaseModel
-	TenantID uuid.UUID      `json:"tenant_id" gorm:"type:uuid;not null;uniqueIndex"`
-	Key      string         `json:"key" gorm:"not null"`
+	TenantID uuid.UUID      `json:"tenant_id" gorm:"type:uuid;not null;uniqueIndex:idx_tenant_settings_tenant_key"`
+	Key      string         `json:"key" gorm:"not null;uniqueIndex:idx_tenant_settings_tenant_key"`
 	Value    datatypes.JSON `json:"value" gorm:"type:jsonb"`
 
 	// Relations
 	Tenant Tenant `json:"tenant" gorm:"foreignKey:TenantID"`
 }
 
+// Tenant setting keys
+const (
+	TenantSettingAuth           = "auth"
+	TenantSettingPasswordPolicy = "password_policy"
+)
+
+// TenantAuthSettings holds the tenant's authentication settings, stored under TenantSettingAuth
+type TenantAuthSettings struct {
+	RequireEmailVerification bool `json:"require_email_verification"`
+	RequireTwoFactor         bool `json:"require_two_factor"`
+	DisableImpersonation     bool `json:"disable_impersonation"` // forbid platform support from signing in as tenant users
+	EnableMagicLink          bool `json:"enable_magic_link"`     // let users sign in with a link emailed to them
+}
+
+// TenantPasswordPolicy holds the tenant's password rules, stored under TenantSettingPasswordPolicy.
+// A zero HistoryCount or MaxAgeDays disables that rule.
+type TenantPasswordPolicy struct {
+	MinLength        int  `json:"min_length"`
+	RequireUppercase bool `json:"require_uppercase"`
+	RequireLowercase bool `json:"require_lowercase"`
+	RequireDigit     bool `json:"require_digit"`
+	RequireSymbol    bool `json:"require_symbol"`
+	BlockBreached    bool `json:"block_breached"` // reject common and breached passwords
+	HistoryCount     int  `json:"history_count"`  // number of previous passwords that may not be reused
+	MaxAgeDays       int  `json:"max_age_days"`
+}
+
+// DefaultPasswordPolicy applies to system users and to tenants that have not configured a policy
+func DefaultPasswordPolicy() TenantPasswordPolicy {
+	return TenantPasswordPolicy{
+		MinLength:     8,
+		BlockBreached: true,
+	}
+}
+
 // TenantModule represents modules enabled for a tenant
 type TenantModule struct {
 	TenantID      uuid.UUID      `json:"tenant_id" gorm:"type:uuid;primary_key"`
@@ -97,21 +157,45 @@ type AuditLog struct {
 // UserSession represents active user sessions
 type UserSession struct {
 	BaseModel
-	UserID             uuid.UUID  `json:"user_id" gorm:"type:char(36);not null;index"`
-	TenantID           uuid.UUID  `json:"tenant_id" gorm:"type:char(36);not null;index"`
-	TokenHash          string     `json:"token_hash" gorm:"not null;index"`
-	RefreshTokenHash   *string    `json:"refresh_token_hash"`
-	IPAddress          *string    `json:"ip_address"`
-	UserAgent          *string    `json:"user_agent"`
-	ExpiresAt          time.Time  `json:"expires_at" gorm:"not null;index"`
-	LastActivity       time.Time  `json:"last_activity" gorm:"default:CURRENT_TIMESTAMP"`
-	IsRevoked          bool       `json:"is_revoked" gorm:"default:false"`
+	UserID           uuid.UUID  `json:"user_id" gorm:"type:char(36);not null;index"`
+	TenantID         *uuid.UUID `json:"tenant_id" gorm:"type:char(36);index"` // nil for system users
+	TokenHash        string     `json:"token_hash" gorm:"not null;index"`
+	RefreshTokenHash *string    `json:"refresh_token_hash" gorm:"index"`
+	FamilyID         uuid.UUID  `json:"family_id" gorm:"type:char(36);index"` // shared by all sessions rotated from the same login
+	IPAddress        *string    `json:"ip_address"`
+	UserAgent        *string    `json:"user_agent"`
+	ExpiresAt        time.Time  `json:"expires_at" gorm:"not null;index"`
+	LastActivity     time.Time  `json:"last_activity" gorm:"default:CURRENT_TIMESTAMP"`
+	IsRevoked        bool       `json:"is_revoked" gorm:"default:false"`
+	RotatedAt        *time.Time `json:"rotated_at"` // set once the refresh token has been exchanged
+	ImpersonatorID   *uuid.UUID `json:"impersonator_id" gorm:"type:char(36);index"` // system user acting as UserID
 
 	// Relations
-	User   User   `json:"user" gorm:"foreignKey:UserID"`
-	Tenant Tenant `json:"tenant" gorm:"foreignKey:TenantID"`
+	User   User    `json:"user" gorm:"foreignKey:UserID"`
+	Tenant *Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
 }
 
+// SecurityEvent represents a security-relevant event for a user
+type SecurityEvent struct {
+	BaseModel
+	UserID    *uuid.UUID     `json:"user_id" gorm:"type:char(36);index"`
+	TenantID  *uuid.UUID     `json:"tenant_id" gorm:"type:char(36);index"`
+	Type      string         `json:"type" gorm:"not null;index"`
+	Details   datatypes.JSON `json:"details" gorm:"type:jsonb"`
+	IPAddress *string        `json:"ip_address"`
+	UserAgent *string        `json:"user_agent"`
+}
+
+// Security event types
+const (
+	SecurityEventRefreshTokenReuse = "refresh_token_reuse"
+	SecurityEventTwoFactorEnabled  = "two_factor_enabled"
+	SecurityEventTwoFactorDisabled = "two_factor_disabled"
+	SecurityEventRecoveryCodeUsed  = "recovery_code_used"
+	SecurityEventAccountLocked     = "account_locked"
+	SecurityEventAccountUnlocked   = "account_unlocked"
+)
+
 // Notification represents notifications within the tenant
 type Notification struct {
 	BaseModel
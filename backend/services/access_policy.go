@@ -0,0 +1,476 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"golang_saas/graph/model"
+	"golang_saas/models"
+	"golang_saas/utils"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrAccessPolicyNotFound = errors.New("access policy not found")
+	ErrInvalidPolicyEffect  = errors.New("effect must be ALLOW or DENY")
+)
+
+// AccessPolicyService manages the attribute-based policies tenants layer on top of their
+// roles. RBACService.CheckUserPermission applies them.
+type AccessPolicyService struct {
+	db *gorm.DB
+}
+
+func NewAccessPolicyService(db *gorm.DB) *AccessPolicyService {
+	return &AccessPolicyService{db: db}
+}
+
+// CreateAccessPolicy creates a policy for a tenant. Policies only cover tenant permissions,
+// and tenant administrators cannot write allow policies for permissions they do not hold.
+func (s *AccessPolicyService) CreateAccessPolicy(ctx context.Context, actor *models.User, input model.CreateAccessPolicyInput) (*model.AccessPolicy, error) {
+	tenantID, err := uuid.Parse(input.TenantID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenant ID: %v", err)
+	}
+
+	policy := models.AccessPolicy{
+		TenantID:    tenantID,
+		Name:        strings.TrimSpace(input.Name),
+		Description: input.Description,
+		Condition:   input.Condition,
+		Timezone:    "UTC",
+		IsEnabled:   true,
+		CreatedByID: &actor.ID,
+	}
+	if policy.Effect, err = policyEffectFromGraphQL(input.Effect); err != nil {
+		return nil, err
+	}
+	if input.Timezone != nil {
+		policy.Timezone = *input.Timezone
+	}
+	if input.IsEnabled != nil {
+		policy.IsEnabled = *input.IsEnabled
+	}
+	if policy.Permissions, err = s.resolvePermissions(actor, tenantID, policy.Effect, input.Permissions); err != nil {
+		return nil, err
+	}
+	if err := validateAccessPolicy(&policy); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Create(&policy).Error; err != nil {
+		return nil, fmt.Errorf("failed to create access policy: %v", err)
+	}
+	return s.convertToGraphQLModel(&policy), nil
+}
+
+// UpdateAccessPolicy changes a policy. The result is validated as a whole, so switching a
+// deny policy to allow checks its permissions again.
+func (s *AccessPolicyService) UpdateAccessPolicy(ctx context.Context, actor *models.User, id uuid.UUID, input model.UpdateAccessPolicyInput) (*model.AccessPolicy, error) {
+	policy, err := s.GetAccessPolicy(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.Name != nil {
+		policy.Name = strings.TrimSpace(*input.Name)
+	}
+	if input.Description != nil {
+		policy.Description = input.Description
+	}
+	if input.Effect != nil {
+		if policy.Effect, err = policyEffectFromGraphQL(*input.Effect); err != nil {
+			return nil, err
+		}
+	}
+	if input.Condition != nil {
+		policy.Condition = *input.Condition
+	}
+	if input.Timezone != nil {
+		policy.Timezone = *input.Timezone
+	}
+	if input.IsEnabled != nil {
+		policy.IsEnabled = *input.IsEnabled
+	}
+
+	permissions := strings.Fields(policy.Permissions)
+	if input.Permissions != nil {
+		permissions = input.Permissions
+	}
+	if policy.Permissions, err = s.resolvePermissions(actor, policy.TenantID, policy.Effect, permissions); err != nil {
+		return nil, err
+	}
+	if err := validateAccessPolicy(policy); err != nil {
+		return nil, err
+	}
+
+	err = s.db.Model(policy).Select("name", "description", "effect", "permissions", "condition", "timezone", "is_enabled").
+		Updates(policy).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to update access policy: %v", err)
+	}
+	return s.convertToGraphQLModel(policy), nil
+}
+
+// DeleteAccessPolicy deletes a policy
+func (s *AccessPolicyService) DeleteAccessPolicy(ctx context.Context, id uuid.UUID) error {
+	result := s.db.Delete(&models.AccessPolicy{}, "id = ?", id)
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete access policy: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrAccessPolicyNotFound
+	}
+	return nil
+}
+
+// GetAccessPolicy returns a policy by ID
+func (s *AccessPolicyService) GetAccessPolicy(id uuid.UUID) (*models.AccessPolicy, error) {
+	var policy models.AccessPolicy
+	if err := s.db.First(&policy, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAccessPolicyNotFound
+		}
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// ListAccessPolicies returns the tenant's policies
+func (s *AccessPolicyService) ListAccessPolicies(ctx context.Context, tenantID uuid.UUID) ([]*model.AccessPolicy, error) {
+	var policies []models.AccessPolicy
+	if err := s.db.Where("tenant_id = ?", tenantID).Order("created_at").Find(&policies).Error; err != nil {
+		return nil, fmt.Errorf("failed to list access policies: %v", err)
+	}
+
+	result := make([]*model.AccessPolicy, len(policies))
+	for i := range policies {
+		result[i] = s.convertToGraphQLModel(&policies[i])
+	}
+	return result, nil
+}
+
+// UpdateMemberAttributes replaces the attributes of a tenant member that policy conditions
+// read as subject.attributes
+func (s *AccessPolicyService) UpdateMemberAttributes(ctx context.Context, tenantID, userID uuid.UUID, attributes map[string]any) error {
+	if attributes == nil {
+		attributes = map[string]any{}
+	}
+	attributesBytes, err := json.Marshal(attributes)
+	if err != nil {
+		return fmt.Errorf("invalid attributes: %v", err)
+	}
+
+	result := s.db.Model(&models.TenantUser{}).
+		Where("user_id = ? AND tenant_id = ?", userID, tenantID).
+		Update("attributes", datatypes.JSON(attributesBytes))
+	if result.Error != nil {
+		return fmt.Errorf("failed to update member attributes: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// resolvePermissions checks the permission patterns of a policy and joins them for storage.
+// Each pattern must cover at least one tenant permission.
+func (s *AccessPolicyService) resolvePermissions(actor *models.User, tenantID uuid.UUID, effect models.PolicyEffect, patterns []string) (string, error) {
+	patterns = uniqueStrings(patterns)
+	if len(patterns) == 0 {
+		return "", errors.New("at least one permission is required")
+	}
+
+	rbacService := NewRBACService(s.db)
+	catalog, err := rbacService.permissionCatalog()
+	if err != nil {
+		return "", err
+	}
+
+	for _, pattern := range patterns {
+		var covered []string
+		for _, perm := range catalog {
+			if !perm.IsSystemPermission && permissionMatches(pattern, perm.Name) {
+				covered = append(covered, perm.Name)
+			}
+		}
+		if len(covered) == 0 {
+			return "", fmt.Errorf("%w: %s", ErrPermissionNotFound, pattern)
+		}
+
+		// Denying is always safe, but allowing must not let administrators grant more than
+		// they hold, as with custom roles
+		if effect != models.PolicyEffectAllow || !sameTenant(actor.TenantID, &tenantID) {
+			continue
+		}
+		for _, name := range covered {
+			hasPermission, err := rbacService.HoldsPermission(actor.ID, name, &tenantID)
+			if err != nil {
+				return "", err
+			}
+			if !hasPermission {
+				return "", fmt.Errorf("you do not have the %s permission", name)
+			}
+		}
+	}
+	return strings.Join(patterns, " "), nil
+}
+
+// validateAccessPolicy checks the fields of a policy that do not depend on the catalog
+func validateAccessPolicy(policy *models.AccessPolicy) error {
+	if policy.Name == "" {
+		return errors.New("name is required")
+	}
+	if _, err := utils.CompileCondition(policy.Condition); err != nil {
+		return err
+	}
+	if _, err := time.LoadLocation(policy.Timezone); err != nil || policy.Timezone == "" {
+		return fmt.Errorf("invalid timezone: %s", policy.Timezone)
+	}
+	return nil
+}
+
+func policyEffectFromGraphQL(effect model.PolicyEffect) (models.PolicyEffect, error) {
+	switch effect {
+	case model.PolicyEffectAllow:
+		return models.PolicyEffectAllow, nil
+	case model.PolicyEffectDeny:
+		return models.PolicyEffectDeny, nil
+	}
+	return "", ErrInvalidPolicyEffect
+}
+
+func (s *AccessPolicyService) convertToGraphQLModel(policy *models.AccessPolicy) *model.AccessPolicy {
+	effect := model.PolicyEffectAllow
+	if policy.Effect == models.PolicyEffectDeny {
+		effect = model.PolicyEffectDeny
+	}
+
+	return &model.AccessPolicy{
+		ID:          policy.ID.String(),
+		TenantID:    policy.TenantID.String(),
+		Name:        policy.Name,
+		Description: policy.Description,
+		Effect:      effect,
+		Permissions: strings.Fields(policy.Permissions),
+		Condition:   policy.Condition,
+		Timezone:    policy.Timezone,
+		IsEnabled:   policy.IsEnabled,
+		CreatedAt:   policy.CreatedAt,
+		UpdatedAt:   policy.UpdatedAt,
+	}
+}
+
+// ResourceAttributeLoader returns the attributes of a resource conditions can read as
+// resource, or nil when it does not exist
+type ResourceAttributeLoader func(db *gorm.DB, id uuid.UUID) (map[string]any, error)
+
+// resourceAttributeLoaders declares the attributes of each resource type. Other resources
+// only expose their type and ID.
+var resourceAttributeLoaders = map[models.ResourceType]ResourceAttributeLoader{
+	models.ResourceUser:     userAttributes,
+	models.ResourceCustomer: customerProfileAttributes,
+}
+
+// applyAccessPolicies combines the decision of the roles with the tenant's enabled policies
+// for the permission. Deny overrides: a deny policy whose condition holds refuses the
+// permission whatever grants it, and so does one whose condition fails to evaluate. An allow
+// policy whose condition holds grants a tenant permission the roles do not.
+func (s *RBACService) applyAccessPolicies(set *permissionSet, permission string, tenantID uuid.UUID, resource *ResourceRef, granted bool) (bool, error) {
+	var policies []models.AccessPolicy
+	if err := s.db.Where("tenant_id = ? AND is_enabled = ?", tenantID, true).Find(&policies).Error; err != nil {
+		return false, fmt.Errorf("failed to load access policies: %v", err)
+	}
+
+	var deny, allow []models.AccessPolicy
+	for _, policy := range policies {
+		if !policyCovers(&policy, permission) {
+			continue
+		}
+		if policy.Effect == models.PolicyEffectDeny {
+			deny = append(deny, policy)
+		} else if !granted {
+			allow = append(allow, policy)
+		}
+	}
+	if len(deny) == 0 && len(allow) == 0 {
+		return granted, nil
+	}
+
+	vars, err := s.policyVariables(set, permission, tenantID, resource)
+	if err != nil {
+		return false, err
+	}
+
+	for _, policy := range deny {
+		matched, err := evaluatePolicy(&policy, vars)
+		if err != nil {
+			log.Printf("Access policy %s failed, denying %s: %v", policy.ID, permission, err)
+			return false, nil
+		}
+		if matched {
+			return false, nil
+		}
+	}
+	if granted {
+		return true, nil
+	}
+
+	// Policies are validated to cover tenant permissions, but a wildcard pattern also matches
+	// system permission names
+	if !s.ValidateTenantPermission(permission) {
+		return false, nil
+	}
+	for _, policy := range allow {
+		matched, err := evaluatePolicy(&policy, vars)
+		if err != nil {
+			log.Printf("Access policy %s failed, ignoring it for %s: %v", policy.ID, permission, err)
+			continue
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// policyVariables builds the subject, resource and permission variables of a check. The
+// environment depends on each policy's timezone and is added by evaluatePolicy.
+func (s *RBACService) policyVariables(set *permissionSet, permission string, tenantID uuid.UUID, resource *ResourceRef) (map[string]any, error) {
+	var membership models.TenantUser
+	err := s.db.Select("attributes").Where("user_id = ? AND tenant_id = ?", set.UserID, tenantID).First(&membership).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to load member attributes: %v", err)
+	}
+
+	subject := map[string]any{
+		"id":         set.UserID.String(),
+		"email":      set.Email,
+		"role":       set.RoleName,
+		"tenant_id":  tenantID.String(),
+		"attributes": jsonAttribute(membership.Attributes, map[string]any{}),
+	}
+
+	var resourceVar any
+	if resource != nil {
+		attributes := map[string]any{}
+		if load, ok := resourceAttributeLoaders[resource.Type]; ok {
+			loaded, err := load(s.db, resource.ID)
+			if err != nil {
+				return nil, err
+			}
+			if loaded != nil {
+				attributes = loaded
+			}
+		}
+		attributes["type"] = string(resource.Type)
+		attributes["id"] = resource.ID.String()
+		resourceVar = attributes
+	}
+
+	return map[string]any{
+		"subject":    subject,
+		"resource":   resourceVar,
+		"permission": permission,
+	}, nil
+}
+
+// evaluatePolicy evaluates a policy's condition at the current time in its timezone
+func evaluatePolicy(policy *models.AccessPolicy, vars map[string]any) (bool, error) {
+	condition, err := utils.CompileCondition(policy.Condition)
+	if err != nil {
+		return false, err
+	}
+	location, err := time.LoadLocation(policy.Timezone)
+	if err != nil {
+		return false, fmt.Errorf("invalid timezone: %v", err)
+	}
+
+	now := time.Now().In(location)
+	policyVars := make(map[string]any, len(vars)+1)
+	for name, value := range vars {
+		policyVars[name] = value
+	}
+	policyVars["environment"] = map[string]any{
+		"time":    now.Format(time.RFC3339),
+		"date":    now.Format("2006-01-02"),
+		"hour":    float64(now.Hour()),
+		"minute":  float64(now.Minute()),
+		"weekday": float64(now.Weekday()), // 0 is Sunday
+		"day":     strings.ToLower(now.Weekday().String()),
+	}
+
+	return condition.Evaluate(policyVars)
+}
+
+// policyCovers reports whether one of the policy's permission patterns matches the permission
+func policyCovers(policy *models.AccessPolicy, permission string) bool {
+	for _, pattern := range strings.Fields(policy.Permissions) {
+		if permissionMatches(pattern, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// userAttributes exposes a user account as a resource
+func userAttributes(db *gorm.DB, id uuid.UUID) (map[string]any, error) {
+	var user models.User
+	if err := db.First(&user, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to find user: %v", err)
+	}
+
+	return map[string]any{
+		"email":     user.Email,
+		"is_active": user.IsActive,
+	}, nil
+}
+
+// customerProfileAttributes exposes a customer profile as a resource, including its tags
+// and metadata
+func customerProfileAttributes(db *gorm.DB, id uuid.UUID) (map[string]any, error) {
+	var customer models.CustomerProfile
+	if err := db.First(&customer, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to find customer: %v", err)
+	}
+
+	var userID any
+	if customer.UserID != nil {
+		userID = customer.UserID.String()
+	}
+	return map[string]any{
+		"tenant_id": customer.TenantID.String(),
+		"user_id":   userID,
+		"email":     customer.Email,
+		"is_active": customer.IsActive,
+		"tags":      jsonAttribute(customer.Tags, []any{}),
+		"metadata":  jsonAttribute(customer.Metadata, map[string]any{}),
+	}, nil
+}
+
+// jsonAttribute decodes a JSON column for conditions, falling back to an empty value so
+// conditions can test membership without checking for the column first
+func jsonAttribute(raw datatypes.JSON, fallback any) any {
+	if len(raw) == 0 {
+		return fallback
+	}
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil || value == nil {
+		return fallback
+	}
+	return value
+}
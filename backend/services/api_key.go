@@ -0,0 +1,255 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang_saas/graph/model"
+	"golang_saas/models"
+	"golang_saas/utils"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// apiKeyPrefix marks secrets as API keys of this platform, which also helps secret scanners
+	apiKeyPrefix = "zpk_"
+	// apiKeyIDLength is the number of hex characters identifying a key after apiKeyPrefix
+	apiKeyIDLength = 12
+	// apiKeyLastUsedInterval throttles last-used updates so busy keys don't write on every request
+	apiKeyLastUsedInterval = time.Minute
+)
+
+var (
+	ErrInvalidAPIKey  = errors.New("invalid or expired API key")
+	ErrAPIKeyNotFound = errors.New("API key not found")
+)
+
+type APIKeyService struct {
+	db *gorm.DB
+}
+
+func NewAPIKeyService(db *gorm.DB) *APIKeyService {
+	return &APIKeyService{db: db}
+}
+
+// CreateAPIKey issues a key for the user in their tenant. The key may only carry permissions
+// the user holds. The full key is returned once and cannot be retrieved later.
+func (s *APIKeyService) CreateAPIKey(ctx context.Context, user *models.User, input model.CreateAPIKeyInput) (*model.CreatedAPIKey, error) {
+	tenantID, err := uuid.Parse(input.TenantID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenant ID: %v", err)
+	}
+	if user.TenantID == nil || *user.TenantID != tenantID {
+		return nil, errors.New("API keys can only be created for your own tenant")
+	}
+
+	name := strings.TrimSpace(input.Name)
+	if name == "" {
+		return nil, errors.New("name is required")
+	}
+	if len(input.Permissions) == 0 {
+		return nil, errors.New("at least one permission is required")
+	}
+	if input.ExpiresAt != nil && !input.ExpiresAt.After(time.Now()) {
+		return nil, errors.New("expiry must be in the future")
+	}
+
+	var permissions []models.Permission
+	if err := s.db.Where("name IN ?", input.Permissions).Find(&permissions).Error; err != nil {
+		return nil, fmt.Errorf("failed to find permissions: %v", err)
+	}
+	if len(permissions) != len(uniqueStrings(input.Permissions)) {
+		return nil, errors.New("one or more permissions do not exist")
+	}
+
+	rbacService := NewRBACService(s.db)
+	for _, permission := range permissions {
+		hasPermission, err := rbacService.HoldsPermission(user.ID, permission.Name, &tenantID)
+		if err != nil {
+			return nil, err
+		}
+		if !hasPermission {
+			return nil, fmt.Errorf("you do not have the %s permission", permission.Name)
+		}
+	}
+
+	prefix, secret, err := generateAPIKey(apiKeyPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate API key: %v", err)
+	}
+	key := prefix + "_" + secret
+
+	apiKey := models.APIKey{
+		TenantID:    tenantID,
+		UserID:      user.ID,
+		Name:        name,
+		Prefix:      prefix,
+		SecretHash:  utils.HashToken(key),
+		ExpiresAt:   input.ExpiresAt,
+		Permissions: permissions,
+	}
+	if err := s.db.Create(&apiKey).Error; err != nil {
+		return nil, fmt.Errorf("failed to create API key: %v", err)
+	}
+
+	return &model.CreatedAPIKey{
+		APIKey: s.convertToGraphQLModel(&apiKey),
+		Key:    key,
+	}, nil
+}
+
+// ListAPIKeys returns the tenant's keys, limited to one user's keys when userID is set
+func (s *APIKeyService) ListAPIKeys(ctx context.Context, tenantID uuid.UUID, userID *uuid.UUID) ([]*model.APIKey, error) {
+	query := s.db.Preload("Permissions").Where("tenant_id = ?", tenantID)
+	if userID != nil {
+		query = query.Where("user_id = ?", *userID)
+	}
+
+	var apiKeys []models.APIKey
+	if err := query.Order("created_at DESC").Find(&apiKeys).Error; err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %v", err)
+	}
+
+	result := make([]*model.APIKey, len(apiKeys))
+	for i := range apiKeys {
+		result[i] = s.convertToGraphQLModel(&apiKeys[i])
+	}
+	return result, nil
+}
+
+// GetAPIKey returns a key by ID
+func (s *APIKeyService) GetAPIKey(id uuid.UUID) (*models.APIKey, error) {
+	var apiKey models.APIKey
+	if err := s.db.First(&apiKey, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, err
+	}
+	return &apiKey, nil
+}
+
+// RevokeAPIKey stops a key from being accepted. Revoked keys stay listed for auditing.
+func (s *APIKeyService) RevokeAPIKey(ctx context.Context, id uuid.UUID) error {
+	result := s.db.Model(&models.APIKey{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke API key: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// Authenticate resolves a presented key to the key record (with its permissions) and the
+// active user it acts for
+func (s *APIKeyService) Authenticate(key string) (*models.APIKey, *models.User, error) {
+	prefixLength := len(apiKeyPrefix) + apiKeyIDLength
+	if len(key) <= prefixLength+1 || !strings.HasPrefix(key, apiKeyPrefix) || key[prefixLength] != '_' {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	var apiKey models.APIKey
+	err := s.db.Preload("Permissions").Where("prefix = ?", key[:prefixLength]).First(&apiKey).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrInvalidAPIKey
+		}
+		return nil, nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(utils.HashToken(key)), []byte(apiKey.SecretHash)) != 1 {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	now := time.Now()
+	if apiKey.RevokedAt != nil || (apiKey.ExpiresAt != nil && !apiKey.ExpiresAt.After(now)) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	var user models.User
+	err = s.db.Preload("Role").Preload("Role.Permissions").
+		Where("id = ? AND is_active = ?", apiKey.UserID, true).
+		First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrInvalidAPIKey
+		}
+		return nil, nil, err
+	}
+
+	// The key acts with the role the user holds in the key's tenant
+	if err := NewTenantMembershipService(s.db).ActivateTenant(&user, apiKey.TenantID); err != nil {
+		if errors.Is(err, ErrNotTenantMember) {
+			return nil, nil, ErrInvalidAPIKey
+		}
+		return nil, nil, err
+	}
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > apiKeyLastUsedInterval {
+		if err := s.db.Model(&apiKey).UpdateColumn("last_used_at", now).Error; err != nil {
+			return nil, nil, fmt.Errorf("failed to record API key use: %v", err)
+		}
+		apiKey.LastUsedAt = &now
+	}
+
+	return &apiKey, &user, nil
+}
+
+// generateAPIKey returns a new key's public prefix and secret part. The prefix is the kind
+// of key followed by apiKeyIDLength hex characters.
+func generateAPIKey(kind string) (string, string, error) {
+	id := make([]byte, apiKeyIDLength/2)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", err
+	}
+
+	secret, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	return kind + hex.EncodeToString(id), secret, nil
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	unique := make([]string, 0, len(values))
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
+
+func (s *APIKeyService) convertToGraphQLModel(apiKey *models.APIKey) *model.APIKey {
+	permissions := make([]string, len(apiKey.Permissions))
+	for i, perm := range apiKey.Permissions {
+		permissions[i] = perm.Name
+	}
+
+	return &model.APIKey{
+		ID:          apiKey.ID.String(),
+		TenantID:    apiKey.TenantID.String(),
+		UserID:      apiKey.UserID.String(),
+		Name:        apiKey.Name,
+		Prefix:      apiKey.Prefix,
+		Permissions: permissions,
+		ExpiresAt:   apiKey.ExpiresAt,
+		LastUsedAt:  apiKey.LastUsedAt,
+		RevokedAt:   apiKey.RevokedAt,
+		CreatedAt:   apiKey.CreatedAt,
+	}
+}
@@ -3,6 +3,7 @@ package services
 import (
 	"context"
 	"errors"
+	"log"
 
 	"golang_saas/graph/model"
 	"golang_saas/models"
@@ -36,19 +37,64 @@ type RegisterRequest struct {
 }
 
 type AuthResponse struct {
-	Token        string       `json:"token"`
-	RefreshToken string       `json:"refresh_token"`
-	User         *models.User `json:"user"`
-	Permissions  []string     `json:"permissions"`
+	Token        string        `json:"token"`
+	RefreshToken string        `json:"refresh_token"`
+	User         *models.User  `json:"user"`
+	Permissions  []string      `json:"permissions"`
+	Challenge    *MFAChallenge `json:"challenge,omitempty"`
+
+	PasswordChange *PasswordChangeChallenge `json:"password_change,omitempty"`
 }
 
-func (s *AuthService) login(req LoginRequest, tenantID *uuid.UUID) (*AuthResponse, error) {
+// toGraphQL converts an issued token pair to the GraphQL auth payload
+func (r *AuthResponse) toGraphQL() *model.AuthPayload {
+	var tenant *models.Tenant
+	if r.User.TenantID != nil {
+		tenant = r.User.Tenant
+	}
+
+	return &model.AuthPayload{
+		Token:        r.Token,
+		RefreshToken: r.RefreshToken,
+		User:         r.User,
+		Tenant:       tenant,
+		Permissions:  r.Permissions,
+	}
+}
+
+// toLoginResult converts a login outcome to tokens or the challenge the user has to complete first
+func (r *AuthResponse) toLoginResult() model.LoginResult {
+	switch {
+	case r.PasswordChange != nil:
+		return &model.PasswordChangeRequired{
+			ChangeToken: r.PasswordChange.ChangeToken,
+			ExpiresAt:   r.PasswordChange.ExpiresAt,
+		}
+	case r.Challenge != nil:
+		return &model.MfaChallenge{
+			ChallengeToken:     r.Challenge.ChallengeToken,
+			ExpiresAt:          r.Challenge.ExpiresAt,
+			EnrollmentRequired: r.Challenge.EnrollmentRequired,
+		}
+	default:
+		return r.toGraphQL()
+	}
+}
+
+func (s *AuthService) login(ctx context.Context, req LoginRequest, tenantID *uuid.UUID) (*AuthResponse, error) {
+	throttle := NewLoginThrottleService(s.db)
+	if err := throttle.Check(ctx, tenantID, req.Email); err != nil {
+		return nil, err
+	}
+
 	var user models.User
 
 	query := s.db.Preload("Role").Preload("Role.Permissions").Preload("Permissions").Where("email = ? AND is_active = ?", req.Email, true)
 
 	if tenantID != nil {
-		query = query.Where("tenant_id = ?", *tenantID)
+		// Users of other tenants can sign in to tenants they are a member of
+		query = query.Where("tenant_id = ? OR id IN (?)", *tenantID,
+			s.db.Model(&models.TenantUser{}).Select("user_id").Where("tenant_id = ?", *tenantID))
 	} else {
 		// For system login, look for system users (no tenant)
 		query = query.Where("tenant_id IS NULL")
@@ -57,6 +103,7 @@ func (s *AuthService) login(req LoginRequest, tenantID *uuid.UUID) (*AuthRespons
 	err := query.First(&user).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
+			throttle.RecordFailure(ctx, tenantID, req.Email, nil)
 			return nil, errors.New("invalid credentials")
 		}
 		return nil, err
@@ -64,44 +111,76 @@ func (s *AuthService) login(req LoginRequest, tenantID *uuid.UUID) (*AuthRespons
 
 	// Check password
 	if !utils.CheckPasswordHash(req.Password, user.Password) {
+		throttle.RecordFailure(ctx, tenantID, req.Email, &user.ID)
 		return nil, errors.New("invalid credentials")
 	}
+	throttle.RecordSuccess(ctx, tenantID, req.Email)
 
-	// Get all permissions (role + direct permissions)
-	rbacService := NewRBACService(s.db)
-	permissions, err := rbacService.GetUserPermissions(user.ID)
-	if err != nil {
-		return nil, err
+	if tenantID != nil {
+		if err := NewTenantMembershipService(s.db).ActivateTenant(&user, *tenantID); err != nil {
+			return nil, err
+		}
 	}
 
-	// Generate JWT tokens
-	var tenantUUID uuid.UUID
-	if user.TenantID != nil {
-		tenantUUID = *user.TenantID
+	// Upgrade hashes made with an older algorithm or weaker costs while the password is at hand
+	if utils.PasswordNeedsRehash(user.Password) {
+		s.rehashPassword(&user, req.Password)
 	}
 
-	// Determine if system user (no tenant)
-	isSystem := user.TenantID == nil
+	return s.continueLogin(ctx, &user)
+}
 
-	token, err := utils.GenerateJWT(user.ID, tenantUUID, user.Role.Name, permissions, isSystem)
+// continueLogin takes a user whose credentials were checked through the remaining login
+// steps: the result is tokens or the challenge the user has to complete first
+func (s *AuthService) continueLogin(ctx context.Context, user *models.User) (*AuthResponse, error) {
+	// Tenants may block logins until the email address is verified
+	verificationRequired, err := s.requiresEmailVerification(user)
 	if err != nil {
 		return nil, err
 	}
+	if verificationRequired {
+		return nil, ErrEmailNotVerified
+	}
 
-	refreshToken, err := utils.GenerateRefreshJWT(user.ID, tenantUUID)
+	// Expired passwords have to be replaced before anything else
+	passwordChange, err := s.passwordChangeFor(user)
 	if err != nil {
 		return nil, err
 	}
+	if passwordChange != nil {
+		return &AuthResponse{User: user, PasswordChange: passwordChange}, nil
+	}
 
-	return &AuthResponse{
-		Token:        token,
-		RefreshToken: refreshToken,
-		User:         &user,
-		Permissions:  permissions,
-	}, nil
+	// Users with two-factor authentication (or whose tenant requires it) get a challenge instead of tokens
+	challenge, err := s.mfaChallengeFor(user)
+	if err != nil {
+		return nil, err
+	}
+	if challenge != nil {
+		return &AuthResponse{User: user, Challenge: challenge}, nil
+	}
+
+	return s.issueTokens(ctx, user, uuid.Nil)
 }
 
-func (s *AuthService) register(req RegisterRequest) (*AuthResponse, error) {
+// rehashPassword stores a fresh hash of the user's current password. Failures are only
+// logged since the existing hash remains valid.
+func (s *AuthService) rehashPassword(user *models.User, password string) {
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		log.Printf("Failed to rehash password for user %s: %v", user.ID, err)
+		return
+	}
+
+	err = s.db.Model(&models.User{}).Where("id = ?", user.ID).UpdateColumn("password", hashedPassword).Error
+	if err != nil {
+		log.Printf("Failed to rehash password for user %s: %v", user.ID, err)
+		return
+	}
+	user.Password = hashedPassword
+}
+
+func (s *AuthService) register(ctx context.Context, req RegisterRequest) (*AuthResponse, error) {
 	// Check if user exists
 	var existingUser models.User
 	err := s.db.Where("email = ?", req.Email).First(&existingUser).Error
@@ -113,7 +192,8 @@ func (s *AuthService) register(req RegisterRequest) (*AuthResponse, error) {
 	}
 
 	// Hash password
-	hashedPassword, err := utils.HashPassword(req.Password)
+	passwordPolicy := NewPasswordPolicyService(s.db)
+	hashedPassword, err := passwordPolicy.HashPassword(req.TenantID, nil, req.Password)
 	if err != nil {
 		return nil, err
 	}
@@ -144,22 +224,65 @@ func (s *AuthService) register(req RegisterRequest) (*AuthResponse, error) {
 		IsActive:  true,
 		TenantID:  req.TenantID,
 		RoleID:    defaultRole.ID,
+
+		SelfRegistered: true,
 	}
 
-	err = s.db.Create(&user).Error
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		if user.TenantID != nil {
+			return saveMembership(tx, user.ID, *user.TenantID, user.RoleID)
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	if err := passwordPolicy.RecordPasswordChange(user.ID, hashedPassword); err != nil {
+		return nil, err
+	}
+
 	// Load relationships
 	err = s.db.Preload("Role").Preload("Role.Permissions").Preload("Permissions").First(&user, user.ID).Error
 	if err != nil {
 		return nil, err
 	}
 
-	// Get all permissions
+	// A failed delivery should not fail the registration; the user can request a new link
+	if err := s.sendVerificationEmail(&user); err != nil {
+		log.Printf("Failed to send verification email to %s: %v", user.Email, err)
+	}
+
+	verificationRequired, err := s.requiresEmailVerification(&user)
+	if err != nil {
+		return nil, err
+	}
+	if verificationRequired {
+		return nil, ErrEmailVerificationRequired
+	}
+
+	// New users of tenants that require two-factor authentication enroll on first sign-in
+	settingsService := NewTenantSettingsService(s.db)
+	authSettings, err := settingsService.GetAuthSettings(user.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	if authSettings.RequireTwoFactor {
+		return nil, ErrTwoFactorEnrollmentRequired
+	}
+
+	return s.issueTokens(ctx, &user, uuid.Nil)
+}
+
+// issueTokens generates a JWT pair for the user and records it as a new session
+// in the given refresh token family (uuid.Nil starts a new family)
+func (s *AuthService) issueTokens(ctx context.Context, user *models.User, familyID uuid.UUID) (*AuthResponse, error) {
+	// Get all permissions (role + direct permissions)
 	rbacService := NewRBACService(s.db)
-	permissions, err := rbacService.GetUserPermissions(user.ID)
+	permissions, err := rbacService.GetUserPermissionsInTenant(user.ID, user.TenantID)
 	if err != nil {
 		return nil, err
 	}
@@ -170,6 +293,7 @@ func (s *AuthService) register(req RegisterRequest) (*AuthResponse, error) {
 		tenantUUID = *user.TenantID
 	}
 
+	// Determine if system user (no tenant)
 	isSystem := user.TenantID == nil
 
 	token, err := utils.GenerateJWT(user.ID, tenantUUID, user.Role.Name, permissions, isSystem)
@@ -182,57 +306,100 @@ func (s *AuthService) register(req RegisterRequest) (*AuthResponse, error) {
 		return nil, err
 	}
 
+	sessionService := NewSessionService(s.db)
+	if _, err := sessionService.CreateSession(ctx, user.ID, user.TenantID, familyID, token, refreshToken); err != nil {
+		return nil, err
+	}
+
 	return &AuthResponse{
 		Token:        token,
 		RefreshToken: refreshToken,
-		User:         &user,
+		User:         user,
 		Permissions:  permissions,
 	}, nil
 }
 
-func (s *AuthService) RefreshToken(refreshToken string) (*AuthResponse, error) {
-	accessToken, newRefreshToken, err := utils.RefreshJWT(refreshToken)
+func (s *AuthService) RefreshToken(ctx context.Context, refreshToken string) (*AuthResponse, error) {
+	claims, err := utils.ValidateJWT(refreshToken)
 	if err != nil {
 		return nil, err
 	}
 
-	// Validate the new token to get user info
-	claims, err := utils.ValidateJWT(accessToken)
+	// Refresh tokens are single-use; a reused token revokes the whole family
+	sessionService := NewSessionService(s.db)
+	session, err := sessionService.RotateRefreshToken(refreshToken)
+	if errors.Is(err, ErrRefreshTokenReused) {
+		securityEventService := NewSecurityEventService(s.db)
+		if recordErr := securityEventService.RecordEvent(ctx, models.SecurityEventRefreshTokenReuse, &session.UserID, session.TenantID, map[string]any{
+			"session_id": session.ID.String(),
+			"family_id":  session.FamilyID.String(),
+		}); recordErr != nil {
+			return nil, recordErr
+		}
+		return nil, err
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	userUUID, err := uuid.Parse(claims.UserID)
+	if err != nil || userUUID != session.UserID {
+		return nil, ErrSessionInvalid
+	}
+
 	// Get user from database
 	var user models.User
-	userUUID, err := uuid.Parse(claims.UserID)
+	err = s.db.Preload("Role").Preload("Role.Permissions").Preload("Permissions").
+		Where("is_active = ?", true).
+		First(&user, "id = ?", userUUID).Error
 	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrSessionInvalid
+		}
 		return nil, err
 	}
 
-	err = s.db.Preload("Role").Preload("Role.Permissions").First(&user, "id = ?", userUUID).Error
-	if err != nil {
-		return nil, err
+	// Keep the tenant the session was started in
+	if session.TenantID != nil {
+		if err := NewTenantMembershipService(s.db).ActivateTenant(&user, *session.TenantID); err != nil {
+			return nil, ErrSessionInvalid
+		}
 	}
 
-	return &AuthResponse{
-		Token:        accessToken,
-		RefreshToken: newRefreshToken,
-		User:         &user,
-		Permissions:  claims.Permissions,
-	}, nil
+	return s.issueTokens(ctx, &user, session.FamilyID)
+}
+
+// Logout revokes the given session
+func (s *AuthService) Logout(ctx context.Context, sessionID uuid.UUID) error {
+	sessionService := NewSessionService(s.db)
+	return sessionService.RevokeSession(sessionID)
+}
+
+// LogoutAllDevices revokes every session of the given user
+func (s *AuthService) LogoutAllDevices(ctx context.Context, userID uuid.UUID) error {
+	sessionService := NewSessionService(s.db)
+	return sessionService.RevokeAllUserSessions(userID)
+}
+
+// resolveTenantID looks up a tenant by slug; a nil slug scopes to system users
+func (s *AuthService) resolveTenantID(tenantSlug *string) (*uuid.UUID, error) {
+	if tenantSlug == nil {
+		return nil, nil
+	}
+
+	var tenant models.Tenant
+	err := s.db.Where("slug = ?", *tenantSlug).First(&tenant).Error
+	if err != nil {
+		return nil, errors.New("tenant not found")
+	}
+	return &tenant.ID, nil
 }
 
 // GraphQL wrapper methods
-func (s *AuthService) Login(ctx context.Context, input model.LoginInput) (*model.AuthPayload, error) {
-	var tenantID *uuid.UUID
-	if input.TenantSlug != nil {
-		// Look up tenant by slug
-		var tenant models.Tenant
-		err := s.db.Where("slug = ?", *input.TenantSlug).First(&tenant).Error
-		if err != nil {
-			return nil, errors.New("tenant not found")
-		}
-		tenantID = &tenant.ID
+func (s *AuthService) Login(ctx context.Context, input model.LoginInput) (model.LoginResult, error) {
+	tenantID, err := s.resolveTenantID(input.TenantSlug)
+	if err != nil {
+		return nil, err
 	}
 
 	loginReq := LoginRequest{
@@ -240,35 +407,18 @@ func (s *AuthService) Login(ctx context.Context, input model.LoginInput) (*model
 		Password: input.Password,
 	}
 
-	authResp, err := s.login(loginReq, tenantID)
+	authResp, err := s.login(ctx, loginReq, tenantID)
 	if err != nil {
 		return nil, err
 	}
 
-	var tenant *models.Tenant
-	if authResp.User.TenantID != nil {
-		tenant = authResp.User.Tenant
-	}
-
-	return &model.AuthPayload{
-		Token:        authResp.Token,
-		RefreshToken: authResp.RefreshToken,
-		User:         authResp.User,
-		Tenant:       tenant,
-		Permissions:  authResp.Permissions,
-	}, nil
+	return authResp.toLoginResult(), nil
 }
 
 func (s *AuthService) Register(ctx context.Context, input model.RegisterInput) (*model.AuthPayload, error) {
-	var tenantID *uuid.UUID
-	if input.TenantSlug != nil {
-		// Look up tenant by slug
-		var tenant models.Tenant
-		err := s.db.Where("slug = ?", *input.TenantSlug).First(&tenant).Error
-		if err != nil {
-			return nil, errors.New("tenant not found")
-		}
-		tenantID = &tenant.ID
+	tenantID, err := s.resolveTenantID(input.TenantSlug)
+	if err != nil {
+		return nil, err
 	}
 
 	registerReq := RegisterRequest{
@@ -279,21 +429,10 @@ func (s *AuthService) Register(ctx context.Context, input model.RegisterInput) (
 		TenantID:  tenantID,
 	}
 
-	authResp, err := s.register(registerReq)
+	authResp, err := s.register(ctx, registerReq)
 	if err != nil {
 		return nil, err
 	}
 
-	var tenant *models.Tenant
-	if authResp.User.TenantID != nil {
-		tenant = authResp.User.Tenant
-	}
-
-	return &model.AuthPayload{
-		Token:        authResp.Token,
-		RefreshToken: authResp.RefreshToken,
-		User:         authResp.User,
-		Tenant:       tenant,
-		Permissions:  authResp.Permissions,
-	}, nil
+	return authResp.toGraphQL(), nil
 }
@@ -91,6 +91,12 @@ func (s *CustomerService) CreateCustomer(ctx context.Context, input model.Create
 		customer.Metadata = datatypes.JSON(metadataBytes)
 	}
 
+	if input.UserID != nil {
+		if err := s.linkAccount(&customer, *input.UserID); err != nil {
+			return nil, err
+		}
+	}
+
 	err = s.db.Create(&customer).Error
 	if err != nil {
 		return nil, fmt.Errorf("failed to create customer: %v", err)
@@ -164,6 +170,12 @@ func (s *CustomerService) UpdateCustomer(ctx context.Context, id string, input m
 		customer.Metadata = datatypes.JSON(metadataBytes)
 	}
 
+	if input.UserID != nil {
+		if err := s.linkAccount(&customer, *input.UserID); err != nil {
+			return nil, err
+		}
+	}
+
 	err = s.db.Save(&customer).Error
 	if err != nil {
 		return nil, fmt.Errorf("failed to update customer: %v", err)
@@ -209,6 +221,56 @@ func (s *CustomerService) GetCustomer(ctx context.Context, id string) (*models.C
 	return &customer, nil
 }
 
+// GetCustomerByUser gets the profile linked to a user's account in a tenant
+func (s *CustomerService) GetCustomerByUser(ctx context.Context, userID uuid.UUID, tenantID uuid.UUID) (*models.CustomerProfile, error) {
+	var customer models.CustomerProfile
+	err := s.db.Preload("Tenant").Where("user_id = ? AND tenant_id = ?", userID, tenantID).First(&customer).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to find customer: %v", err)
+	}
+
+	return &customer, nil
+}
+
+// linkAccount links a profile to the account of its customer, who then owns the profile.
+// The account must belong to the profile's tenant and have no other profile there.
+func (s *CustomerService) linkAccount(customer *models.CustomerProfile, userID string) error {
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return fmt.Errorf("invalid user ID: %v", err)
+	}
+
+	var members int64
+	err = s.db.Model(&models.User{}).
+		Where("id = ?", userUUID).
+		Where("tenant_id = ? OR id IN (?)", customer.TenantID,
+			s.db.Model(&models.TenantUser{}).Select("user_id").Where("tenant_id = ?", customer.TenantID)).
+		Count(&members).Error
+	if err != nil {
+		return fmt.Errorf("failed to find user: %v", err)
+	}
+	if members == 0 {
+		return errors.New("user is not a member of the customer's tenant")
+	}
+
+	var linked int64
+	err = s.db.Model(&models.CustomerProfile{}).
+		Where("tenant_id = ? AND user_id = ? AND id <> ?", customer.TenantID, userUUID, customer.ID).
+		Count(&linked).Error
+	if err != nil {
+		return fmt.Errorf("failed to check existing customer: %v", err)
+	}
+	if linked > 0 {
+		return errors.New("user already has a customer profile in this tenant")
+	}
+
+	customer.UserID = &userUUID
+	return nil
+}
+
 // ListCustomers lists customers with filtering and pagination
 func (s *CustomerService) ListCustomers(ctx context.Context, filter *model.UserFilter, pagination *model.PaginationInput) (*model.PaginatedCustomers, error) {
 	query := s.db.Model(&models.CustomerProfile{})
@@ -319,6 +381,11 @@ func (s *CustomerService) convertToGraphQLModel(customer *models.CustomerProfile
 		}
 	}
 
+	if customer.UserID != nil {
+		userID := customer.UserID.String()
+		result.UserID = &userID
+	}
+
 	if customer.Tenant.ID != uuid.Nil {
 		result.Tenant = &customer.Tenant
 	}
@@ -0,0 +1,51 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"strings"
+
+	"golang_saas/config"
+)
+
+type EmailService struct{}
+
+func NewEmailService() *EmailService {
+	return &EmailService{}
+}
+
+// Send delivers a plain text email through the configured SMTP server
+func (s *EmailService) Send(to, subject, body string) error {
+	cfg := config.AppConfig
+
+	if cfg.SMTPHost == "" {
+		// Without SMTP settings emails are only logged in development
+		if cfg.AppEnv == "development" {
+			log.Printf("Email to %s: %s\n%s", to, subject, body)
+			return nil
+		}
+		return fmt.Errorf("email delivery is not configured")
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s <%s>\r\n", cfg.FromName, cfg.FromEmail)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(body)
+
+	var auth smtp.Auth
+	if cfg.SMTPUser != "" {
+		auth = smtp.PlainAuth("", cfg.SMTPUser, cfg.SMTPPassword, cfg.SMTPHost)
+	}
+
+	addr := fmt.Sprintf("%s:%s", cfg.SMTPHost, cfg.SMTPPort)
+	if err := smtp.SendMail(addr, auth, cfg.FromEmail, []string{to}, []byte(msg.String())); err != nil {
+		return fmt.Errorf("failed to send email: %v", err)
+	}
+
+	return nil
+}
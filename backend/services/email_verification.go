@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"golang_saas/config"
+	"golang_saas/models"
+	"golang_saas/utils"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	emailVerificationPurpose  = "email_verification"
+	emailVerificationTokenTTL = 24 * time.Hour
+)
+
+var (
+	ErrInvalidVerificationToken  = errors.New("invalid or expired email verification token")
+	ErrEmailNotVerified          = errors.New("email address has not been verified")
+	ErrEmailVerificationRequired = errors.New("registration successful; check your email to verify your address before signing in")
+)
+
+// sendVerificationEmail emails a signed verification link to the user
+func (s *AuthService) sendVerificationEmail(user *models.User) error {
+	token, err := utils.GenerateActionToken(emailVerificationPurpose, user.ID, user.Email, emailVerificationTokenTTL)
+	if err != nil {
+		return fmt.Errorf("failed to generate verification token: %v", err)
+	}
+
+	link := fmt.Sprintf("%s/auth/verify-email?token=%s", config.AppConfig.FrontendURL, url.QueryEscape(token))
+	body := fmt.Sprintf("Hello %s,\n\nPlease confirm your email address for %s by opening the link below:\n\n%s\n\nThe link expires in %d hours. If you did not create an account, you can ignore this email.\n",
+		user.FirstName, config.AppConfig.AppName, link, int(emailVerificationTokenTTL.Hours()))
+
+	emailService := NewEmailService()
+	return emailService.Send(user.Email, "Verify your email address", body)
+}
+
+// VerifyEmail marks the user's email address as verified using a token from a verification link
+func (s *AuthService) VerifyEmail(ctx context.Context, token string) error {
+	claims, err := utils.ValidateActionToken(token, emailVerificationPurpose)
+	if err != nil {
+		return ErrInvalidVerificationToken
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return ErrInvalidVerificationToken
+	}
+
+	// The token is only valid for the address it was issued for
+	var user models.User
+	err = s.db.Where("id = ? AND email = ?", userID, claims.Email).First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidVerificationToken
+		}
+		return err
+	}
+
+	if user.EmailVerified {
+		return nil
+	}
+
+	now := time.Now()
+	err = s.db.Model(&user).Updates(map[string]any{
+		"email_verified":    true,
+		"email_verified_at": now,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to verify email: %v", err)
+	}
+
+	return nil
+}
+
+// ResendVerification sends a new verification link if the account exists and is unverified.
+// It does not reveal whether the email is registered.
+func (s *AuthService) ResendVerification(ctx context.Context, email string, tenantSlug *string) error {
+	tenantID, err := s.resolveTenantID(tenantSlug)
+	if err != nil {
+		return err
+	}
+
+	var user models.User
+	query := s.db.Where("email = ? AND is_active = ? AND email_verified = ?", email, true, false)
+	if tenantID != nil {
+		// Users of other tenants belong to the tenants they are a member of
+		query = query.Where("tenant_id = ? OR id IN (?)", *tenantID,
+			s.db.Model(&models.TenantUser{}).Select("user_id").Where("tenant_id = ?", *tenantID))
+	} else {
+		query = query.Where("tenant_id IS NULL")
+	}
+
+	err = query.First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	// A failure is only logged: reporting it would tell that the email is registered
+	if err := s.sendVerificationEmail(&user); err != nil {
+		log.Printf("Failed to send verification email to %s: %v", user.Email, err)
+	}
+	return nil
+}
+
+// requiresEmailVerification reports whether the user must verify their email before logging in.
+// Users added by an administrator, and those who predate the requirement, are not held back.
+func (s *AuthService) requiresEmailVerification(user *models.User) (bool, error) {
+	if user.EmailVerified || !user.SelfRegistered {
+		return false, nil
+	}
+
+	settingsService := NewTenantSettingsService(s.db)
+	authSettings, err := settingsService.GetAuthSettings(user.TenantID)
+	if err != nil {
+		return false, err
+	}
+
+	return authSettings.RequireEmailVerification, nil
+}
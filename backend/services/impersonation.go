@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang_saas/config"
+	"golang_saas/graph/model"
+	"golang_saas/models"
+	"golang_saas/utils"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrImpersonationDisabled = errors.New("this tenant does not allow impersonation")
+	ErrImpersonationTarget   = errors.New("only active tenant users can be impersonated")
+)
+
+// ImpersonationService lets platform support sign in as tenant users. Every impersonation
+// and every request made with an impersonation token is written to the system audit log.
+type ImpersonationService struct {
+	db *gorm.DB
+}
+
+func NewImpersonationService(db *gorm.DB) *ImpersonationService {
+	return &ImpersonationService{db: db}
+}
+
+// ImpersonateUser issues a short-lived, non-refreshable access token for a tenant user
+// on behalf of a system user
+func (s *ImpersonationService) ImpersonateUser(ctx context.Context, impersonator *models.User, userID uuid.UUID, reason string) (*model.ImpersonationPayload, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, errors.New("a reason is required")
+	}
+	if impersonator.ID == userID {
+		return nil, errors.New("you cannot impersonate yourself")
+	}
+
+	var user models.User
+	err := s.db.Preload("Role").Preload("Role.Permissions").Preload("Permissions").Preload("Tenant").
+		Where("id = ? AND is_active = ?", userID, true).
+		First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrImpersonationTarget
+		}
+		return nil, err
+	}
+	if user.TenantID == nil {
+		return nil, ErrImpersonationTarget
+	}
+
+	settingsService := NewTenantSettingsService(s.db)
+	authSettings, err := settingsService.GetAuthSettings(user.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	if authSettings.DisableImpersonation {
+		return nil, ErrImpersonationDisabled
+	}
+
+	rbacService := NewRBACService(s.db)
+	permissions, err := rbacService.GetUserPermissions(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := time.Duration(config.AppConfig.ImpersonationTokenMinutes) * time.Minute
+	expiresAt := time.Now().Add(ttl)
+	token, err := utils.GenerateImpersonationJWT(user.ID, *user.TenantID, user.Role.Name, permissions, impersonator.ID, ttl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %v", err)
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		session, err := NewSessionService(tx).CreateImpersonationSession(ctx, &user, impersonator.ID, token, expiresAt)
+		if err != nil {
+			return err
+		}
+
+		userIDString := user.ID.String()
+		return recordSystemAudit(ctx, tx, models.SystemAuditLog{
+			SystemUserID: &impersonator.ID,
+			TenantID:     user.TenantID,
+			Action:       models.SystemAuditImpersonationStarted,
+			Resource:     string(models.ResourceUser),
+			ResourceID:   &userIDString,
+		}, map[string]any{
+			"reason":     reason,
+			"email":      user.Email,
+			"session_id": session.ID.String(),
+			"expires_at": expiresAt,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.ImpersonationPayload{
+		Token:       token,
+		ExpiresAt:   expiresAt,
+		User:        &user,
+		Tenant:      user.Tenant,
+		Permissions: permissions,
+	}, nil
+}
+
+// RecordRequest audits a request made with an impersonation token
+func (s *ImpersonationService) RecordRequest(ctx context.Context, impersonatorID, userID uuid.UUID, tenantID *uuid.UUID, request map[string]any) error {
+	userIDString := userID.String()
+	return recordSystemAudit(ctx, s.db, models.SystemAuditLog{
+		SystemUserID: &impersonatorID,
+		TenantID:     tenantID,
+		Action:       models.SystemAuditImpersonatedRequest,
+		Resource:     string(models.ResourceUser),
+		ResourceID:   &userIDString,
+	}, request)
+}
+
+// recordSystemAudit writes a system audit log entry with the request's client info
+func recordSystemAudit(ctx context.Context, db *gorm.DB, entry models.SystemAuditLog, values map[string]any) error {
+	if values != nil {
+		valuesJSON, err := json.Marshal(values)
+		if err != nil {
+			return fmt.Errorf("failed to marshal audit values: %v", err)
+		}
+		entry.NewValues = datatypes.JSON(valuesJSON)
+	}
+
+	info := ClientInfoFromContext(ctx)
+	if info.IPAddress != "" {
+		entry.IPAddress = &info.IPAddress
+	}
+	if info.UserAgent != "" {
+		entry.UserAgent = &info.UserAgent
+	}
+
+	if err := db.Create(&entry).Error; err != nil {
+		return fmt.Errorf("failed to write audit log: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,396 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang_saas/config"
+	"golang_saas/graph/model"
+	"golang_saas/models"
+	"golang_saas/utils"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	invitationPurpose = "invitation"
+	invitationTTL     = 7 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidInvitation       = errors.New("invalid or expired invitation")
+	ErrInvitationPending       = errors.New("an invitation to this email is already pending")
+	ErrAlreadyTenantMember     = errors.New("this user is already a member of the tenant")
+	ErrInvitationAccountExists = errors.New("an account with this email already exists; enter its password to accept the invitation")
+)
+
+// InvitationService invites people to join a tenant by email
+type InvitationService struct {
+	db *gorm.DB
+}
+
+func NewInvitationService(db *gorm.DB) *InvitationService {
+	return &InvitationService{db: db}
+}
+
+// InviteUser creates a pending invitation to the role's tenant and emails it
+func (s *InvitationService) InviteUser(ctx context.Context, inviter *models.User, email string, role *models.Role) (*model.Invitation, error) {
+	if role.TenantID == nil {
+		return nil, errors.New("invitations can only grant tenant roles")
+	}
+	if err := s.checkCanGrantRole(inviter, role); err != nil {
+		return nil, err
+	}
+
+	address, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil {
+		return nil, errors.New("invalid email address")
+	}
+	email = strings.ToLower(address.Address)
+
+	var members int64
+	err = s.db.Model(&models.User{}).
+		Where("email = ?", email).
+		Where("tenant_id = ? OR id IN (?)", *role.TenantID,
+			s.db.Model(&models.TenantUser{}).Select("user_id").Where("tenant_id = ?", *role.TenantID)).
+		Count(&members).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to check existing members: %v", err)
+	}
+	if members > 0 {
+		return nil, ErrAlreadyTenantMember
+	}
+
+	var pending int64
+	err = s.db.Model(&models.Invitation{}).
+		Where("tenant_id = ? AND email = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", *role.TenantID, email, time.Now()).
+		Count(&pending).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to check pending invitations: %v", err)
+	}
+	if pending > 0 {
+		return nil, ErrInvitationPending
+	}
+
+	invitation := models.Invitation{
+		TenantID:    *role.TenantID,
+		Email:       email,
+		RoleID:      role.ID,
+		InvitedByID: &inviter.ID,
+	}
+	invitation.ID = uuid.New()
+	token, err := issueInvitationToken(&invitation)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Create(&invitation).Error; err != nil {
+		return nil, fmt.Errorf("failed to create invitation: %v", err)
+	}
+
+	if err := s.sendInvitationEmail(&invitation, inviter, token); err != nil {
+		return nil, err
+	}
+
+	return s.GetInvitation(invitation.ID)
+}
+
+// ListInvitations returns the tenant's invitations that have not been accepted or revoked
+func (s *InvitationService) ListInvitations(tenantID uuid.UUID) ([]*model.Invitation, error) {
+	var invitations []models.Invitation
+	err := s.db.Preload("Role").Preload("InvitedBy").
+		Where("tenant_id = ? AND accepted_at IS NULL AND revoked_at IS NULL", tenantID).
+		Order("created_at DESC").
+		Find(&invitations).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list invitations: %v", err)
+	}
+
+	result := make([]*model.Invitation, len(invitations))
+	for i := range invitations {
+		result[i] = convertInvitationToGraphQL(&invitations[i])
+	}
+	return result, nil
+}
+
+// GetInvitation returns a pending invitation
+func (s *InvitationService) GetInvitation(id uuid.UUID) (*model.Invitation, error) {
+	invitation, err := s.findPending(id)
+	if err != nil {
+		return nil, err
+	}
+	return convertInvitationToGraphQL(invitation), nil
+}
+
+// GetInvitationTenant returns the tenant a pending invitation belongs to
+func (s *InvitationService) GetInvitationTenant(id uuid.UUID) (uuid.UUID, error) {
+	invitation, err := s.findPending(id)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return invitation.TenantID, nil
+}
+
+// ResendInvitation emails a new token for a pending invitation. Earlier tokens stop working
+// and the expiry starts over.
+func (s *InvitationService) ResendInvitation(ctx context.Context, sender *models.User, id uuid.UUID) (*model.Invitation, error) {
+	invitation, err := s.findPending(id)
+	if err != nil {
+		return nil, err
+	}
+	// A new token grants the role again, so the sender must be able to grant it
+	if err := s.checkCanGrantRole(sender, &invitation.Role); err != nil {
+		return nil, err
+	}
+
+	token, err := issueInvitationToken(invitation)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Model(&models.Invitation{}).Where("id = ?", invitation.ID).Updates(map[string]any{
+		"token_hash": invitation.TokenHash,
+		"expires_at": invitation.ExpiresAt,
+	}).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to update invitation: %v", err)
+	}
+
+	if err := s.sendInvitationEmail(invitation, sender, token); err != nil {
+		return nil, err
+	}
+
+	return convertInvitationToGraphQL(invitation), nil
+}
+
+// RevokeInvitation cancels a pending invitation
+func (s *InvitationService) RevokeInvitation(ctx context.Context, id uuid.UUID) error {
+	result := s.db.Model(&models.Invitation{}).
+		Where("id = ? AND accepted_at IS NULL AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke invitation: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("invitation not found")
+	}
+	return nil
+}
+
+// checkCanGrantRole makes sure the user holds every permission of the role in its tenant, so
+// nobody can invite someone with more access than they have. As with roles, system users
+// were already allowed to by their tenant permission.
+func (s *InvitationService) checkCanGrantRole(user *models.User, role *models.Role) error {
+	if user.TenantID == nil {
+		return nil
+	}
+
+	var permissions []models.Permission
+	if err := s.db.Model(role).Association("Permissions").Find(&permissions); err != nil {
+		return fmt.Errorf("failed to load role permissions: %v", err)
+	}
+
+	rbacService := NewRBACService(s.db)
+	for _, permission := range permissions {
+		hasPermission, err := rbacService.HoldsPermission(user.ID, permission.Name, role.TenantID)
+		if err != nil {
+			return err
+		}
+		if !hasPermission {
+			return fmt.Errorf("you do not have the %s permission", permission.Name)
+		}
+	}
+	return nil
+}
+
+// findPending loads an invitation that has not been accepted or revoked
+func (s *InvitationService) findPending(id uuid.UUID) (*models.Invitation, error) {
+	var invitation models.Invitation
+	err := s.db.Preload("Role").Preload("InvitedBy").Preload("Tenant").
+		Where("id = ? AND accepted_at IS NULL AND revoked_at IS NULL", id).
+		First(&invitation).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("invitation not found")
+		}
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+// sendInvitationEmail emails the link to accept an invitation
+func (s *InvitationService) sendInvitationEmail(invitation *models.Invitation, inviter *models.User, token string) error {
+	var tenant models.Tenant
+	if err := s.db.First(&tenant, "id = ?", invitation.TenantID).Error; err != nil {
+		return fmt.Errorf("failed to find tenant: %v", err)
+	}
+
+	link := fmt.Sprintf("%s/auth/accept-invitation?token=%s", config.AppConfig.FrontendURL, url.QueryEscape(token))
+	body := fmt.Sprintf("Hello,\n\n%s %s invited you to join %s on %s. Use the link below to accept the invitation:\n\n%s\n\nThe link expires in %d days. If you did not expect this invitation, you can ignore this email.\n",
+		inviter.FirstName, inviter.LastName, tenant.Name, config.AppConfig.AppName, link, int(invitationTTL.Hours()/24))
+
+	emailService := NewEmailService()
+	if err := emailService.Send(invitation.Email, fmt.Sprintf("You have been invited to %s", tenant.Name), body); err != nil {
+		return fmt.Errorf("failed to send invitation email: %v", err)
+	}
+	return nil
+}
+
+// issueInvitationToken signs a new token for an invitation and stores its hash and expiry
+// on the invitation
+func issueInvitationToken(invitation *models.Invitation) (string, error) {
+	token, err := utils.GenerateTenantActionToken(invitationPurpose, invitation.ID, &invitation.TenantID, invitation.Email, invitationTTL)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate invitation token: %v", err)
+	}
+
+	invitation.TokenHash = utils.HashToken(token)
+	invitation.ExpiresAt = time.Now().Add(invitationTTL)
+	return token, nil
+}
+
+// convertInvitationToGraphQL converts an invitation to its GraphQL representation
+func convertInvitationToGraphQL(invitation *models.Invitation) *model.Invitation {
+	return &model.Invitation{
+		ID:        invitation.ID.String(),
+		TenantID:  invitation.TenantID.String(),
+		Email:     invitation.Email,
+		Role:      &invitation.Role,
+		InvitedBy: invitation.InvitedBy,
+		ExpiresAt: invitation.ExpiresAt,
+		CreatedAt: invitation.CreatedAt,
+	}
+}
+
+// AcceptInvitation joins the invited tenant with the invitation's role and signs the user in.
+// New users choose their password and name here; existing users confirm their password.
+func (s *AuthService) AcceptInvitation(ctx context.Context, token, password string, firstName, lastName *string) (model.LoginResult, error) {
+	claims, err := utils.ValidateActionToken(token, invitationPurpose)
+	if err != nil {
+		return nil, ErrInvalidInvitation
+	}
+
+	var invitation models.Invitation
+	err = s.db.Preload("Role").Preload("Tenant").
+		Where("id = ? AND token_hash = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", claims.Subject, utils.HashToken(token), time.Now()).
+		First(&invitation).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidInvitation
+		}
+		return nil, err
+	}
+	if invitation.Tenant.Status != models.TenantStatusActive || invitation.Role.TenantID == nil || *invitation.Role.TenantID != invitation.TenantID {
+		return nil, ErrInvalidInvitation
+	}
+
+	var user models.User
+	err = s.db.Where("email = ?", invitation.Email).First(&user).Error
+	existing := err == nil
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if existing {
+		if !user.IsActive || user.TenantID == nil {
+			return nil, ErrInvalidInvitation
+		}
+
+		throttle := NewLoginThrottleService(s.db)
+		if err := throttle.Check(ctx, &invitation.TenantID, user.Email); err != nil {
+			return nil, err
+		}
+		if !utils.CheckPasswordHash(password, user.Password) {
+			throttle.RecordFailure(ctx, &invitation.TenantID, user.Email, &user.ID)
+			return nil, ErrInvitationAccountExists
+		}
+		throttle.RecordSuccess(ctx, &invitation.TenantID, user.Email)
+	} else if firstName == nil || strings.TrimSpace(*firstName) == "" || lastName == nil || strings.TrimSpace(*lastName) == "" {
+		return nil, errors.New("first and last name are required")
+	}
+
+	var hashedPassword string
+	if !existing {
+		hashedPassword, err = NewPasswordPolicyService(s.db).HashPassword(&invitation.TenantID, nil, password)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Invitation{}).
+			Where("id = ? AND accepted_at IS NULL", invitation.ID).
+			Update("accepted_at", time.Now())
+		if result.Error != nil {
+			return fmt.Errorf("failed to accept invitation: %v", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidInvitation
+		}
+
+		// The emailed link proves the address belongs to the user
+		now := time.Now()
+		if existing {
+			var memberships int64
+			err := tx.Model(&models.TenantUser{}).Where("user_id = ? AND tenant_id = ?", user.ID, invitation.TenantID).Count(&memberships).Error
+			if err != nil {
+				return fmt.Errorf("failed to load tenant membership: %v", err)
+			}
+			if memberships > 0 || *user.TenantID == invitation.TenantID {
+				return ErrAlreadyTenantMember
+			}
+			if !user.EmailVerified {
+				err := tx.Model(&models.User{}).Where("id = ?", user.ID).
+					Updates(map[string]any{"email_verified": true, "email_verified_at": now}).Error
+				if err != nil {
+					return fmt.Errorf("failed to update user: %v", err)
+				}
+			}
+			return saveMembership(tx, user.ID, invitation.TenantID, invitation.RoleID)
+		}
+
+		tenantID := invitation.TenantID
+		user = models.User{
+			Email:           invitation.Email,
+			Password:        hashedPassword,
+			FirstName:       strings.TrimSpace(*firstName),
+			LastName:        strings.TrimSpace(*lastName),
+			IsActive:        true,
+			TenantID:        &tenantID,
+			RoleID:          invitation.RoleID,
+			EmailVerified:   true,
+			EmailVerifiedAt: &now,
+		}
+		if err := tx.Create(&user).Error; err != nil {
+			return fmt.Errorf("failed to create user: %v", err)
+		}
+		if err := saveMembership(tx, user.ID, tenantID, invitation.RoleID); err != nil {
+			return err
+		}
+		return NewPasswordPolicyService(tx).RecordPasswordChange(user.ID, hashedPassword)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Preload("Role").Preload("Role.Permissions").Preload("Permissions").Preload("Tenant").
+		First(&user, "id = ?", user.ID).Error
+	if err != nil {
+		return nil, err
+	}
+	if err := NewTenantMembershipService(s.db).ActivateTenant(&user, invitation.TenantID); err != nil {
+		return nil, err
+	}
+
+	authResp, err := s.continueLogin(ctx, &user)
+	if err != nil {
+		return nil, err
+	}
+	return authResp.toLoginResult(), nil
+}
@@ -0,0 +1,325 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"golang_saas/config"
+	"golang_saas/models"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// loginDelayAfter is the number of failures after which every further attempt has to wait
+	loginDelayAfter = 2
+	// loginMaxDelay caps the progressive delay between attempts
+	loginMaxDelay = 30 * time.Second
+	// twoFactorMaxFailures is the number of wrong two-factor codes after which the user's
+	// second factor is locked for the lockout window
+	twoFactorMaxFailures = 5
+)
+
+// LoginThrottledError is returned while an account or client must wait before trying to log in again
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, try again in %d seconds", int(math.Ceil(e.RetryAfter.Seconds())))
+}
+
+// attemptStore keeps the expiring counters and markers used for login throttling
+type attemptStore interface {
+	// increment adds one to a counter, starting its expiry when the counter is new
+	increment(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	// mark stores a marker that expires after ttl
+	mark(ctx context.Context, key string, ttl time.Duration) error
+	// remaining returns how long a key still lives, or 0 if it does not exist
+	remaining(ctx context.Context, key string) (time.Duration, error)
+	delete(ctx context.Context, keys ...string) error
+}
+
+// LoginThrottleService counts failed logins per (tenant, email) and per client IP. Repeated
+// failures first delay further attempts, then lock the account or IP for the lockout window.
+// Counters live in Redis, or in process memory when Redis is unavailable.
+type LoginThrottleService struct {
+	db    *gorm.DB
+	store attemptStore
+}
+
+func NewLoginThrottleService(db *gorm.DB) *LoginThrottleService {
+	var store attemptStore = localAttempts
+	if config.RedisClient != nil {
+		store = &redisAttemptStore{client: config.RedisClient}
+	}
+	return &LoginThrottleService{db: db, store: store}
+}
+
+// Check returns a LoginThrottledError if the account or client IP may not attempt a login yet.
+// Counter store failures are logged and do not block logins.
+func (s *LoginThrottleService) Check(ctx context.Context, tenantID *uuid.UUID, email string) error {
+	account := loginAccountKey(tenantID, email)
+	keys := []string{"login:lock:" + account, "login:delay:" + account}
+	if ip := ClientInfoFromContext(ctx).IPAddress; ip != "" {
+		keys = append(keys, "login:lock:ip:"+ip)
+	}
+
+	for _, key := range keys {
+		wait, err := s.store.remaining(ctx, key)
+		if err != nil {
+			log.Printf("Login throttle check failed: %v", err)
+			return nil
+		}
+		if wait > 0 {
+			return &LoginThrottledError{RetryAfter: wait}
+		}
+	}
+
+	return nil
+}
+
+// RecordFailure counts a failed login. userID is nil when no account matched the email;
+// those failures count all the same so lockouts don't reveal which accounts exist.
+func (s *LoginThrottleService) RecordFailure(ctx context.Context, tenantID *uuid.UUID, email string, userID *uuid.UUID) {
+	window := time.Duration(config.AppConfig.LoginLockoutMinutes) * time.Minute
+	account := loginAccountKey(tenantID, email)
+
+	failures, err := s.store.increment(ctx, "login:failures:"+account, window)
+	if err != nil {
+		log.Printf("Failed to record login failure: %v", err)
+		return
+	}
+
+	switch {
+	case failures >= int64(config.AppConfig.LoginMaxFailures):
+		if err := s.store.mark(ctx, "login:lock:"+account, window); err != nil {
+			log.Printf("Failed to lock account: %v", err)
+		}
+		if userID != nil && failures == int64(config.AppConfig.LoginMaxFailures) {
+			securityEvents := NewSecurityEventService(s.db)
+			details := map[string]any{"failures": failures, "locked_until": time.Now().Add(window)}
+			if err := securityEvents.RecordEvent(ctx, models.SecurityEventAccountLocked, userID, tenantID, details); err != nil {
+				log.Printf("Failed to record account lockout: %v", err)
+			}
+		}
+	case failures > loginDelayAfter:
+		// Wait 2s, 4s, 8s... between attempts
+		delay := time.Duration(1<<min(failures-loginDelayAfter, 5)) * time.Second
+		if err := s.store.mark(ctx, "login:delay:"+account, min(delay, loginMaxDelay)); err != nil {
+			log.Printf("Failed to delay login attempts: %v", err)
+		}
+	}
+
+	ip := ClientInfoFromContext(ctx).IPAddress
+	if ip == "" {
+		return
+	}
+
+	ipFailures, err := s.store.increment(ctx, "login:failures:ip:"+ip, window)
+	if err != nil {
+		log.Printf("Failed to record login failure: %v", err)
+		return
+	}
+	if ipFailures >= int64(config.AppConfig.LoginIPMaxFailures) {
+		if err := s.store.mark(ctx, "login:lock:ip:"+ip, window); err != nil {
+			log.Printf("Failed to block client IP: %v", err)
+		}
+	}
+}
+
+// RecordSuccess clears the account's failure count after a correct password
+func (s *LoginThrottleService) RecordSuccess(ctx context.Context, tenantID *uuid.UUID, email string) {
+	account := loginAccountKey(tenantID, email)
+	if err := s.store.delete(ctx, "login:failures:"+account, "login:delay:"+account); err != nil {
+		log.Printf("Failed to reset login failures: %v", err)
+	}
+}
+
+// Unlock lifts a lockout on the user's sign-in to the given tenant and resets its failure
+// count. Nil is the tenant the user signs in to without one: the system, for system users.
+func (s *LoginThrottleService) Unlock(ctx context.Context, user *models.User, tenantID *uuid.UUID) error {
+	account := loginAccountKey(tenantID, user.Email)
+	userKey := user.ID.String()
+	if err := s.store.delete(ctx, "login:failures:"+account, "login:delay:"+account, "login:lock:"+account, "2fa:failures:"+userKey, "2fa:lock:"+userKey); err != nil {
+		return fmt.Errorf("failed to unlock account: %v", err)
+	}
+
+	securityEvents := NewSecurityEventService(s.db)
+	return securityEvents.RecordEvent(ctx, models.SecurityEventAccountUnlocked, &user.ID, tenantID, nil)
+}
+
+// CheckTwoFactor returns a LoginThrottledError while the user may not try another two-factor code
+func (s *LoginThrottleService) CheckTwoFactor(ctx context.Context, userID uuid.UUID) error {
+	wait, err := s.store.remaining(ctx, "2fa:lock:"+userID.String())
+	if err != nil {
+		log.Printf("Two-factor throttle check failed: %v", err)
+		return nil
+	}
+	if wait > 0 {
+		return &LoginThrottledError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// RecordTwoFactorFailure counts a wrong two-factor code. The failure also counts towards
+// the account's and client IP's login failures.
+func (s *LoginThrottleService) RecordTwoFactorFailure(ctx context.Context, user *models.User) {
+	window := time.Duration(config.AppConfig.LoginLockoutMinutes) * time.Minute
+	key := user.ID.String()
+
+	failures, err := s.store.increment(ctx, "2fa:failures:"+key, window)
+	if err != nil {
+		log.Printf("Failed to record two-factor failure: %v", err)
+	} else if failures >= twoFactorMaxFailures {
+		if err := s.store.mark(ctx, "2fa:lock:"+key, window); err != nil {
+			log.Printf("Failed to lock two-factor authentication: %v", err)
+		}
+	}
+
+	s.RecordFailure(ctx, user.TenantID, user.Email, &user.ID)
+}
+
+// RecordTwoFactorSuccess clears the user's two-factor failure count after a correct code
+func (s *LoginThrottleService) RecordTwoFactorSuccess(ctx context.Context, userID uuid.UUID) {
+	if err := s.store.delete(ctx, "2fa:failures:"+userID.String()); err != nil {
+		log.Printf("Failed to reset two-factor failures: %v", err)
+	}
+}
+
+// loginAccountKey identifies an account by tenant and normalized email, whether or not it exists
+func loginAccountKey(tenantID *uuid.UUID, email string) string {
+	tenant := "system"
+	if tenantID != nil {
+		tenant = tenantID.String()
+	}
+	return fmt.Sprintf("account:%s:%s", tenant, strings.ToLower(strings.TrimSpace(email)))
+}
+
+// redisAttemptStore shares counters between all API instances
+type redisAttemptStore struct {
+	client *redis.Client
+}
+
+// incrementScript starts the expiry in the same step as creating the counter, so a counter
+// can never be left without one
+var incrementScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if count == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return count
+`)
+
+func (r *redisAttemptStore) increment(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return incrementScript.Run(ctx, r.client, []string{key}, ttl.Milliseconds()).Int64()
+}
+
+func (r *redisAttemptStore) mark(ctx context.Context, key string, ttl time.Duration) error {
+	return r.client.Set(ctx, key, 1, ttl).Err()
+}
+
+func (r *redisAttemptStore) remaining(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := r.client.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	// Missing keys report a negative TTL
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+func (r *redisAttemptStore) delete(ctx context.Context, keys ...string) error {
+	return r.client.Del(ctx, keys...).Err()
+}
+
+// localAttempts is the in-process fallback used without Redis. Counters are per instance.
+var localAttempts = &memoryAttemptStore{entries: map[string]*memoryAttempt{}}
+
+type memoryAttempt struct {
+	count     int64
+	expiresAt time.Time
+}
+
+type memoryAttemptStore struct {
+	mu      sync.Mutex
+	entries map[string]*memoryAttempt
+}
+
+// live returns the unexpired entry for key. Callers must hold the lock.
+func (m *memoryAttemptStore) live(key string, now time.Time) *memoryAttempt {
+	entry, ok := m.entries[key]
+	if !ok {
+		return nil
+	}
+	if !now.Before(entry.expiresAt) {
+		delete(m.entries, key)
+		return nil
+	}
+	return entry
+}
+
+func (m *memoryAttemptStore) increment(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	entry := m.live(key, now)
+	if entry == nil {
+		m.sweep(now)
+		entry = &memoryAttempt{expiresAt: now.Add(ttl)}
+		m.entries[key] = entry
+	}
+	entry.count++
+	return entry.count, nil
+}
+
+func (m *memoryAttemptStore) mark(ctx context.Context, key string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[key] = &memoryAttempt{count: 1, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (m *memoryAttemptStore) remaining(ctx context.Context, key string) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if entry := m.live(key, now); entry != nil {
+		return entry.expiresAt.Sub(now), nil
+	}
+	return 0, nil
+}
+
+func (m *memoryAttemptStore) delete(ctx context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range keys {
+		delete(m.entries, key)
+	}
+	return nil
+}
+
+// sweep drops expired entries once the map has grown, so abandoned keys don't pile up
+func (m *memoryAttemptStore) sweep(now time.Time) {
+	if len(m.entries) < 10000 {
+		return
+	}
+	for key, entry := range m.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(m.entries, key)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"golang_saas/config"
+	"golang_saas/graph/model"
+	"golang_saas/models"
+	"golang_saas/utils"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	magicLinkPurpose  = "magic_link"
+	magicLinkTokenTTL = 15 * time.Minute
+
+	// magicLinkResendInterval stops the same mailbox from being flooded with links
+	magicLinkResendInterval = time.Minute
+)
+
+var (
+	ErrMagicLinkDisabled  = errors.New("sign-in links are not enabled for this tenant")
+	ErrInvalidMagicLink   = errors.New("invalid or expired sign-in link")
+	ErrMagicLinkTwoFactor = errors.New("this account requires two-factor authentication; sign in with your password")
+)
+
+// RequestMagicLink emails a single-use sign-in link to a member of the tenant if the tenant
+// allows it. Like password resets, it does not reveal whether the email is registered.
+// Accounts that need a second factor do not get links.
+func (s *AuthService) RequestMagicLink(ctx context.Context, email string, tenantSlug string) error {
+	tenantID, err := s.resolveTenantID(&tenantSlug)
+	if err != nil {
+		return err
+	}
+
+	enabled, err := s.magicLinkEnabled(*tenantID)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return ErrMagicLinkDisabled
+	}
+
+	// Scope the lookup the same way login does
+	var user models.User
+	err = s.db.Where("email = ? AND is_active = ?", email, true).
+		Where("tenant_id = ? OR id IN (?)", *tenantID,
+			s.db.Model(&models.TenantUser{}).Select("user_id").Where("tenant_id = ?", *tenantID)).
+		First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if err := NewTenantMembershipService(s.db).ActivateTenant(&user, *tenantID); err != nil {
+		if errors.Is(err, ErrNotTenantMember) {
+			return nil
+		}
+		return err
+	}
+
+	twoFactorRequired, err := s.requiresTwoFactor(&user)
+	if err != nil {
+		return err
+	}
+	if twoFactorRequired {
+		return nil
+	}
+
+	var recent int64
+	err = s.db.Model(&models.MagicLinkToken{}).
+		Where("user_id = ? AND tenant_id = ? AND created_at > ?", user.ID, *tenantID, time.Now().Add(-magicLinkResendInterval)).
+		Count(&recent).Error
+	if err != nil {
+		return fmt.Errorf("failed to check sign-in links: %v", err)
+	}
+	if recent > 0 {
+		return nil
+	}
+
+	token, err := utils.GenerateTenantActionToken(magicLinkPurpose, user.ID, tenantID, user.Email, magicLinkTokenTTL)
+	if err != nil {
+		return fmt.Errorf("failed to generate sign-in link: %v", err)
+	}
+
+	magicLink := models.MagicLinkToken{
+		UserID:    user.ID,
+		TenantID:  *tenantID,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(magicLinkTokenTTL),
+	}
+	if err := s.db.Create(&magicLink).Error; err != nil {
+		return fmt.Errorf("failed to create sign-in link: %v", err)
+	}
+
+	link := fmt.Sprintf("%s/auth/magic-link?token=%s", config.AppConfig.FrontendURL, url.QueryEscape(token))
+	body := fmt.Sprintf("Hello %s,\n\nUse the link below to sign in to %s:\n\n%s\n\nThe link expires in %d minutes and can only be used once. If you did not request it, you can ignore this email.\n",
+		user.FirstName, config.AppConfig.AppName, link, int(magicLinkTokenTTL.Minutes()))
+
+	// A failure is only logged: reporting it would tell that the email is registered
+	emailService := NewEmailService()
+	if err := emailService.Send(user.Email, "Your sign-in link", body); err != nil {
+		log.Printf("Failed to send sign-in link to %s: %v", user.Email, err)
+	}
+	return nil
+}
+
+// ConsumeMagicLink signs a user in with a link from RequestMagicLink. Opening the link proves
+// ownership of the mailbox, so it also verifies the address.
+func (s *AuthService) ConsumeMagicLink(ctx context.Context, token string) (*model.AuthPayload, error) {
+	claims, err := utils.ValidateActionToken(token, magicLinkPurpose)
+	if err != nil {
+		return nil, ErrInvalidMagicLink
+	}
+
+	// Links are only issued for tenants
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil || claims.TenantID == "" {
+		return nil, ErrInvalidMagicLink
+	}
+
+	// Consume the link first; the used_at guard prevents concurrent reuse
+	now := time.Now()
+	result := s.db.Model(&models.MagicLinkToken{}).
+		Where("token_hash = ? AND user_id = ? AND used_at IS NULL AND expires_at > ?", utils.HashToken(token), userID, now).
+		Update("used_at", now)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to consume sign-in link: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvalidMagicLink
+	}
+
+	// The link is only valid for the address it was sent to
+	var user models.User
+	err = s.db.Preload("Role").Preload("Role.Permissions").Preload("Permissions").
+		Where("id = ? AND email = ? AND is_active = ?", userID, claims.Email, true).
+		First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidMagicLink
+		}
+		return nil, err
+	}
+	if err := activateTokenTenant(s.db, &user, claims); err != nil {
+		return nil, ErrInvalidMagicLink
+	}
+
+	// The tenant may have turned links off, or started requiring a second factor, since
+	// the link was sent
+	enabled, err := s.magicLinkEnabled(*user.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return nil, ErrMagicLinkDisabled
+	}
+	twoFactorRequired, err := s.requiresTwoFactor(&user)
+	if err != nil {
+		return nil, err
+	}
+	if twoFactorRequired {
+		return nil, ErrMagicLinkTwoFactor
+	}
+
+	if !user.EmailVerified {
+		err = s.db.Model(&user).Updates(map[string]any{
+			"email_verified":    true,
+			"email_verified_at": now,
+		}).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify email: %v", err)
+		}
+	}
+
+	authResp, err := s.issueTokens(ctx, &user, uuid.Nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return authResp.toGraphQL(), nil
+}
+
+// magicLinkEnabled reports whether the tenant lets its users sign in with emailed links
+func (s *AuthService) magicLinkEnabled(tenantID uuid.UUID) (bool, error) {
+	authSettings, err := NewTenantSettingsService(s.db).GetAuthSettings(&tenantID)
+	if err != nil {
+		return false, err
+	}
+	return authSettings.EnableMagicLink, nil
+}
+
+// requiresTwoFactor reports whether the user has to pass a second factor to sign in, which
+// a link alone cannot provide
+func (s *AuthService) requiresTwoFactor(user *models.User) (bool, error) {
+	enabled, err := NewTwoFactorService(s.db).IsEnabled(user.ID)
+	if err != nil || enabled {
+		return enabled, err
+	}
+
+	authSettings, err := NewTenantSettingsService(s.db).GetAuthSettings(user.TenantID)
+	if err != nil {
+		return false, err
+	}
+	return authSettings.RequireTwoFactor, nil
+}
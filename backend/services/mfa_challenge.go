@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"golang_saas/graph/model"
+	"golang_saas/models"
+	"golang_saas/utils"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	mfaChallengePurpose = "mfa_challenge"
+	mfaChallengeTTL     = 5 * time.Minute
+	// mfaChallengeMaxAttempts is the number of wrong codes after which a challenge is spent
+	// and the user has to sign in again
+	mfaChallengeMaxAttempts = 5
+)
+
+var (
+	ErrInvalidMFAChallenge         = errors.New("invalid or expired two-factor challenge")
+	ErrTwoFactorEnrollmentRequired = errors.New("registration successful; sign in to set up two-factor authentication")
+)
+
+// MFAChallenge is returned by login instead of tokens when a second factor is needed
+type MFAChallenge struct {
+	ChallengeToken     string
+	ExpiresAt          time.Time
+	EnrollmentRequired bool
+}
+
+// mfaChallengeFor returns a challenge if the user must pass (or set up) a second factor, or nil otherwise
+func (s *AuthService) mfaChallengeFor(user *models.User) (*MFAChallenge, error) {
+	twoFactorService := NewTwoFactorService(s.db)
+	enabled, err := twoFactorService.IsEnabled(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	enrollmentRequired := false
+	if !enabled {
+		settingsService := NewTenantSettingsService(s.db)
+		authSettings, err := settingsService.GetAuthSettings(user.TenantID)
+		if err != nil {
+			return nil, err
+		}
+		if !authSettings.RequireTwoFactor {
+			return nil, nil
+		}
+		enrollmentRequired = true
+	}
+
+	token, err := utils.GenerateTenantActionToken(mfaChallengePurpose, user.ID, user.TenantID, user.Email, mfaChallengeTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MFAChallenge{
+		ChallengeToken:     token,
+		ExpiresAt:          time.Now().Add(mfaChallengeTTL),
+		EnrollmentRequired: enrollmentRequired,
+	}, nil
+}
+
+// userFromMFAChallenge loads the active user an unspent challenge token was issued for
+func (s *AuthService) userFromMFAChallenge(ctx context.Context, challengeToken string) (*models.User, *utils.ActionClaims, error) {
+	claims, err := utils.ValidateActionToken(challengeToken, mfaChallengePurpose)
+	if err != nil || claims.ID == "" {
+		return nil, nil, ErrInvalidMFAChallenge
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, nil, ErrInvalidMFAChallenge
+	}
+
+	spent, err := NewLoginThrottleService(s.db).store.remaining(ctx, mfaChallengeSpentKey(claims))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to check two-factor challenge: %v", err)
+	}
+	if spent > 0 {
+		return nil, nil, ErrInvalidMFAChallenge
+	}
+
+	var user models.User
+	err = s.db.Preload("Role").Preload("Role.Permissions").Preload("Permissions").
+		Where("id = ? AND email = ? AND is_active = ?", userID, claims.Email, true).
+		First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrInvalidMFAChallenge
+		}
+		return nil, nil, err
+	}
+
+	if err := activateTokenTenant(s.db, &user, claims); err != nil {
+		return nil, nil, ErrInvalidMFAChallenge
+	}
+
+	return &user, claims, nil
+}
+
+// spendMFAChallenge uses up a challenge once it has been answered. Only the first caller
+// succeeds, so a challenge cannot complete two logins.
+func (s *AuthService) spendMFAChallenge(ctx context.Context, claims *utils.ActionClaims) error {
+	uses, err := NewLoginThrottleService(s.db).store.increment(ctx, mfaChallengeSpentKey(claims), mfaChallengeTTL)
+	if err != nil {
+		return fmt.Errorf("failed to complete two-factor challenge: %v", err)
+	}
+	if uses > 1 {
+		return ErrInvalidMFAChallenge
+	}
+	return nil
+}
+
+// recordMFAChallengeFailure counts a wrong code against the challenge and spends it once
+// the attempts run out
+func (s *AuthService) recordMFAChallengeFailure(ctx context.Context, claims *utils.ActionClaims) {
+	store := NewLoginThrottleService(s.db).store
+	failures, err := store.increment(ctx, "mfa:failures:"+claims.ID, mfaChallengeTTL)
+	if err != nil {
+		log.Printf("Failed to record two-factor challenge failure: %v", err)
+		return
+	}
+	if failures >= mfaChallengeMaxAttempts {
+		if err := store.mark(ctx, mfaChallengeSpentKey(claims), mfaChallengeTTL); err != nil {
+			log.Printf("Failed to expire two-factor challenge: %v", err)
+		}
+	}
+}
+
+func mfaChallengeSpentKey(claims *utils.ActionClaims) string {
+	return "mfa:spent:" + claims.ID
+}
+
+// EnrollTwoFactorWithChallenge starts two-factor enrollment for a user whose
+// tenant requires it and who has not signed in yet
+func (s *AuthService) EnrollTwoFactorWithChallenge(ctx context.Context, challengeToken string) (*model.TwoFactorEnrollment, error) {
+	user, _, err := s.userFromMFAChallenge(ctx, challengeToken)
+	if err != nil {
+		return nil, err
+	}
+
+	twoFactorService := NewTwoFactorService(s.db)
+	return twoFactorService.Enroll(ctx, user)
+}
+
+// VerifyMFAChallenge completes a two-step login with a TOTP or recovery code.
+// Users who were asked to enroll confirm their new secret here and receive
+// their recovery codes in the response. A challenge completes one login and
+// allows a few wrong codes.
+func (s *AuthService) VerifyMFAChallenge(ctx context.Context, challengeToken, code string) (*model.AuthPayload, error) {
+	user, claims, err := s.userFromMFAChallenge(ctx, challengeToken)
+	if err != nil {
+		return nil, err
+	}
+
+	twoFactorService := NewTwoFactorService(s.db)
+	enabled, err := twoFactorService.IsEnabled(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var recoveryCodes []string
+	if enabled {
+		valid, err := twoFactorService.VerifyCode(ctx, user, code)
+		if err != nil {
+			return nil, err
+		}
+		if !valid {
+			s.recordMFAChallengeFailure(ctx, claims)
+			return nil, ErrInvalidTwoFactorCode
+		}
+	} else {
+		if recoveryCodes, err = twoFactorService.Enable(ctx, user, code); err != nil {
+			if errors.Is(err, ErrInvalidTwoFactorCode) {
+				s.recordMFAChallengeFailure(ctx, claims)
+			}
+			return nil, err
+		}
+	}
+
+	if err := s.spendMFAChallenge(ctx, claims); err != nil {
+		return nil, err
+	}
+
+	authResp, err := s.issueTokens(ctx, user, uuid.Nil)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := authResp.toGraphQL()
+	payload.RecoveryCodes = recoveryCodes
+	return payload, nil
+}
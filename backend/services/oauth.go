@@ -0,0 +1,297 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang_saas/models"
+	"golang_saas/utils"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Token types reported by introspection (RFC 7662)
+const (
+	tokenTypeAccess  = "access_token"
+	tokenTypeRefresh = "refresh_token"
+)
+
+// TokenIntrospection is the RFC 7662 introspection response. Inactive tokens only carry
+// Active; the tenant, role and permission fields extend the standard response.
+type TokenIntrospection struct {
+	Active      bool         `json:"active"`
+	TokenType   string       `json:"token_type,omitempty"`
+	Subject     string       `json:"sub,omitempty"`
+	Username    string       `json:"username,omitempty"`
+	ClientID    string       `json:"client_id,omitempty"`
+	Scope       string       `json:"scope,omitempty"`
+	TenantID    string       `json:"tenant_id,omitempty"`
+	Role        string       `json:"role,omitempty"`
+	Permissions []string     `json:"permissions,omitempty"`
+	IsSystem    bool         `json:"is_system,omitempty"`
+	SessionID   string       `json:"sid,omitempty"`
+	Act         *utils.Actor `json:"act,omitempty"`
+	Issuer      string       `json:"iss,omitempty"`
+	JWTID       string       `json:"jti,omitempty"`
+	IssuedAt    int64        `json:"iat,omitempty"`
+	ExpiresAt   int64        `json:"exp,omitempty"`
+}
+
+// OAuthService implements the platform's OAuth2 and OpenID Connect provider: the endpoints
+// internal services use to check platform tokens and those tenant apps sign users in with
+type OAuthService struct {
+	db *gorm.DB
+}
+
+func NewOAuthService(db *gorm.DB) *OAuthService {
+	return &OAuthService{db: db}
+}
+
+// IntrospectToken reports whether an access or refresh token is currently usable and, if
+// so, who it was issued to. Platform tokens are only described to platform clients; tokens
+// issued to tenant apps are also described to the app they were issued to.
+func (s *OAuthService) IntrospectToken(ctx context.Context, client *models.OAuthClient, token string) (*TokenIntrospection, error) {
+	inactive := &TokenIntrospection{Active: false}
+
+	if _, err := utils.ValidateOAuthAccessToken(token); err == nil {
+		return s.introspectOAuthAccessToken(client, token)
+	}
+	if refreshToken, err := s.findOAuthRefreshToken(token, false); err == nil {
+		return s.introspectOAuthRefreshToken(client, refreshToken)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if client.TenantID != nil {
+		return inactive, nil
+	}
+
+	return s.introspectPlatformToken(token)
+}
+
+// introspectPlatformToken checks a token issued by the GraphQL API. Besides the signature and
+// expiry, the token's session has to be live and its user active, the same checks the API
+// applies.
+func (s *OAuthService) introspectPlatformToken(token string) (*TokenIntrospection, error) {
+	inactive := &TokenIntrospection{Active: false}
+
+	claims, err := utils.ValidateJWT(token)
+	if err != nil {
+		return inactive, nil
+	}
+
+	session, err := s.findSession(token, false)
+	if err != nil {
+		if errors.Is(err, ErrSessionInvalid) {
+			return inactive, nil
+		}
+		return nil, err
+	}
+
+	var user models.User
+	err = s.db.Where("id = ? AND is_active = ?", session.UserID, true).First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return inactive, nil
+		}
+		return nil, fmt.Errorf("failed to load user: %v", err)
+	}
+	if claims.UserID != user.ID.String() {
+		return inactive, nil
+	}
+
+	result := &TokenIntrospection{
+		Active:    true,
+		TokenType: tokenTypeAccess,
+		Subject:   claims.UserID,
+		Username:  user.Email,
+		TenantID:  claims.TenantID,
+		SessionID: session.ID.String(),
+		Issuer:    claims.Issuer,
+		JWTID:     claims.ID,
+	}
+	if claims.IssuedAt != nil {
+		result.IssuedAt = claims.IssuedAt.Unix()
+	}
+	if claims.ExpiresAt != nil {
+		result.ExpiresAt = claims.ExpiresAt.Unix()
+	}
+
+	if session.TokenHash == utils.HashToken(token) {
+		result.Role = claims.Role
+		result.Permissions = claims.Permissions
+		result.IsSystem = claims.IsSystem
+		result.Act = claims.Act
+	} else {
+		// Refresh tokens only grant new access tokens
+		result.TokenType = tokenTypeRefresh
+	}
+
+	return result, nil
+}
+
+// introspectOAuthAccessToken checks an access token issued to a tenant app. The app must not
+// have been revoked and, for user tokens, the user must still be an active tenant member.
+func (s *OAuthService) introspectOAuthAccessToken(client *models.OAuthClient, token string) (*TokenIntrospection, error) {
+	inactive := &TokenIntrospection{Active: false}
+
+	claims, err := utils.ValidateOAuthAccessToken(token)
+	if err != nil || (client.TenantID != nil && client.ClientID != claims.ClientID) {
+		return inactive, nil
+	}
+
+	var owner models.OAuthClient
+	err = s.db.Where("client_id = ? AND revoked_at IS NULL", claims.ClientID).First(&owner).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return inactive, nil
+		}
+		return nil, fmt.Errorf("failed to load OAuth client: %v", err)
+	}
+
+	result := &TokenIntrospection{
+		Active:      true,
+		TokenType:   tokenTypeAccess,
+		Subject:     claims.Subject,
+		ClientID:    claims.ClientID,
+		Scope:       claims.Scope,
+		TenantID:    claims.TenantID,
+		Role:        claims.Role,
+		Permissions: claims.Permissions,
+		Issuer:      claims.Issuer,
+		JWTID:       claims.ID,
+	}
+	if claims.IssuedAt != nil {
+		result.IssuedAt = claims.IssuedAt.Unix()
+	}
+	if claims.ExpiresAt != nil {
+		result.ExpiresAt = claims.ExpiresAt.Unix()
+	}
+
+	// Client credentials tokens are issued to the client itself
+	if claims.Subject == owner.ClientID {
+		return result, nil
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil || owner.TenantID == nil {
+		return inactive, nil
+	}
+	user, err := s.loadTenantUser(userID, *owner.TenantID)
+	if err != nil {
+		if errors.Is(err, ErrNotTenantMember) || errors.Is(err, ErrTenantInactive) || errors.Is(err, ErrUserNotFound) {
+			return inactive, nil
+		}
+		return nil, err
+	}
+	result.Username = user.Email
+
+	return result, nil
+}
+
+// introspectOAuthRefreshToken checks a refresh token issued to a tenant app
+func (s *OAuthService) introspectOAuthRefreshToken(client *models.OAuthClient, refreshToken *models.OAuthRefreshToken) (*TokenIntrospection, error) {
+	inactive := &TokenIntrospection{Active: false}
+
+	if client.TenantID != nil && client.ID != refreshToken.ClientID {
+		return inactive, nil
+	}
+
+	var owner models.OAuthClient
+	err := s.db.Where("id = ? AND revoked_at IS NULL", refreshToken.ClientID).First(&owner).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return inactive, nil
+		}
+		return nil, fmt.Errorf("failed to load OAuth client: %v", err)
+	}
+
+	user, err := s.loadTenantUser(refreshToken.UserID, refreshToken.TenantID)
+	if err != nil {
+		if errors.Is(err, ErrNotTenantMember) || errors.Is(err, ErrTenantInactive) || errors.Is(err, ErrUserNotFound) {
+			return inactive, nil
+		}
+		return nil, err
+	}
+
+	return &TokenIntrospection{
+		Active:    true,
+		TokenType: tokenTypeRefresh,
+		Subject:   refreshToken.UserID.String(),
+		Username:  user.Email,
+		ClientID:  owner.ClientID,
+		Scope:     refreshToken.Scope,
+		TenantID:  refreshToken.TenantID.String(),
+		IssuedAt:  refreshToken.CreatedAt.Unix(),
+		ExpiresAt: refreshToken.ExpiresAt.Unix(),
+	}, nil
+}
+
+// RevokeToken revokes a refresh token together with the tokens rotated from it, or the
+// session a platform token belongs to (RFC 7009). Platform tokens can only be revoked by
+// platform clients and an app's refresh tokens by the app itself. Unknown and already
+// revoked tokens are ignored, as are the short-lived access tokens issued to apps.
+func (s *OAuthService) RevokeToken(ctx context.Context, client *models.OAuthClient, token string) error {
+	refreshToken, err := s.findOAuthRefreshToken(token, true)
+	if err == nil {
+		if client.TenantID != nil && client.ID != refreshToken.ClientID {
+			return nil
+		}
+		return s.revokeRefreshTokens("family_id = ?", refreshToken.FamilyID)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if client.TenantID != nil {
+		return nil
+	}
+
+	session, err := s.findSession(token, true)
+	if err != nil {
+		if errors.Is(err, ErrSessionInvalid) {
+			return nil
+		}
+		return err
+	}
+
+	return NewSessionService(s.db).RevokeFamily(session.FamilyID)
+}
+
+// findOAuthRefreshToken loads a refresh token issued to a tenant app. Unless includeRevoked
+// is set, only tokens that can still be used are returned.
+func (s *OAuthService) findOAuthRefreshToken(token string, includeRevoked bool) (*models.OAuthRefreshToken, error) {
+	query := s.db.Where("token_hash = ?", utils.HashToken(token))
+	if !includeRevoked {
+		query = query.Where("revoked_at IS NULL AND rotated_at IS NULL AND expires_at > ?", time.Now())
+	}
+
+	var refreshToken models.OAuthRefreshToken
+	if err := query.First(&refreshToken).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to load refresh token: %v", err)
+	}
+	return &refreshToken, nil
+}
+
+// findSession loads the session a token was issued for. Unless includeRevoked is set, only
+// live sessions are returned.
+func (s *OAuthService) findSession(token string, includeRevoked bool) (*models.UserSession, error) {
+	tokenHash := utils.HashToken(token)
+	query := s.db.Where("(token_hash = ? OR refresh_token_hash = ?)", tokenHash, tokenHash)
+	if !includeRevoked {
+		query = query.Where("is_revoked = ? AND expires_at > ?", false, time.Now())
+	}
+
+	var session models.UserSession
+	if err := query.First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionInvalid
+		}
+		return nil, fmt.Errorf("failed to load session: %v", err)
+	}
+	return &session, nil
+}
@@ -0,0 +1,282 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang_saas/graph/model"
+	"golang_saas/models"
+	"golang_saas/utils"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// oauthClientIDPrefix marks client IDs as OAuth clients of this platform
+const oauthClientIDPrefix = "zoc_"
+
+var (
+	ErrInvalidOAuthClient  = errors.New("invalid client credentials")
+	ErrOAuthClientNotFound = errors.New("OAuth client not found")
+)
+
+// OAuthClientService manages the clients allowed to call the OAuth endpoints
+type OAuthClientService struct {
+	db *gorm.DB
+}
+
+func NewOAuthClientService(db *gorm.DB) *OAuthClientService {
+	return &OAuthClientService{db: db}
+}
+
+// CreateOAuthClient registers a client. Without a tenant the client is a platform client,
+// which can only introspect and revoke tokens. Tenant clients may request the OpenID scopes
+// and permissions the creator holds in the tenant. The client secret is returned once and
+// cannot be retrieved later; public clients get none.
+func (s *OAuthClientService) CreateOAuthClient(ctx context.Context, creator *models.User, input model.CreateOAuthClientInput) (*model.CreatedOAuthClient, error) {
+	name := strings.TrimSpace(input.Name)
+	if name == "" {
+		return nil, errors.New("name is required")
+	}
+
+	confidential := input.IsConfidential == nil || *input.IsConfidential
+	client := models.OAuthClient{
+		Name:        name,
+		CreatedByID: &creator.ID,
+	}
+
+	if input.TenantID == nil {
+		if len(input.RedirectUris) > 0 || len(input.Scopes) > 0 || len(input.GrantTypes) > 0 || !confidential {
+			return nil, errors.New("platform clients cannot use grants; register the client for a tenant")
+		}
+	} else {
+		tenantID, err := uuid.Parse(*input.TenantID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tenant ID: %v", err)
+		}
+		client.TenantID = &tenantID
+
+		if err := s.configureTenantClient(&client, creator, input, confidential); err != nil {
+			return nil, err
+		}
+	}
+
+	clientID, secret, err := generateAPIKey(oauthClientIDPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate client credentials: %v", err)
+	}
+	client.ClientID = clientID
+
+	var clientSecret *string
+	if confidential {
+		client.SecretHash = utils.HashToken(secret)
+		clientSecret = &secret
+	}
+
+	if err := s.db.Create(&client).Error; err != nil {
+		return nil, fmt.Errorf("failed to create OAuth client: %v", err)
+	}
+
+	return &model.CreatedOAuthClient{
+		Client:       s.convertToGraphQLModel(&client),
+		ClientSecret: clientSecret,
+	}, nil
+}
+
+// configureTenantClient validates the grants, redirect URIs and scopes of a tenant client
+func (s *OAuthClientService) configureTenantClient(client *models.OAuthClient, creator *models.User, input model.CreateOAuthClientInput, confidential bool) error {
+	grantTypes := uniqueStrings(input.GrantTypes)
+	if len(grantTypes) == 0 {
+		grantTypes = []string{models.OAuthGrantAuthorizationCode, models.OAuthGrantRefreshToken}
+	}
+	for _, grantType := range grantTypes {
+		switch grantType {
+		case models.OAuthGrantAuthorizationCode, models.OAuthGrantRefreshToken:
+		case models.OAuthGrantClientCredentials:
+			if !confidential {
+				return errors.New("public clients cannot use the client credentials grant")
+			}
+		default:
+			return fmt.Errorf("unsupported grant type %s", grantType)
+		}
+	}
+
+	redirectURIs := uniqueStrings(input.RedirectUris)
+	for _, redirectURI := range redirectURIs {
+		if err := validateRedirectURI(redirectURI); err != nil {
+			return err
+		}
+	}
+	if containsString(grantTypes, models.OAuthGrantAuthorizationCode) && len(redirectURIs) == 0 {
+		return errors.New("at least one redirect URI is required for the authorization code grant")
+	}
+
+	scopes := uniqueStrings(input.Scopes)
+	if len(scopes) == 0 {
+		scopes = []string{oauthScopeOpenID, oauthScopeProfile, oauthScopeEmail}
+		if containsString(grantTypes, models.OAuthGrantRefreshToken) {
+			scopes = append(scopes, oauthScopeOfflineAccess)
+		}
+	}
+
+	// Clients may only be granted permissions their creator holds
+	rbacService := NewRBACService(s.db)
+	for _, scope := range scopes {
+		if oidcScopes[scope] {
+			continue
+		}
+
+		var permission models.Permission
+		err := s.db.Where("name = ? AND is_system_permission = ?", scope, false).First(&permission).Error
+		if err != nil {
+			return fmt.Errorf("unknown scope %s", scope)
+		}
+
+		hasPermission, err := rbacService.HoldsPermission(creator.ID, permission.Name, client.TenantID)
+		if err != nil {
+			return err
+		}
+		if !hasPermission {
+			return fmt.Errorf("you do not have the %s permission", permission.Name)
+		}
+	}
+
+	client.GrantTypes = strings.Join(grantTypes, " ")
+	client.RedirectURIs = strings.Join(redirectURIs, " ")
+	client.Scopes = strings.Join(scopes, " ")
+	return nil
+}
+
+// ListOAuthClients returns a tenant's clients, or the platform clients when tenantID is nil
+func (s *OAuthClientService) ListOAuthClients(ctx context.Context, tenantID *uuid.UUID) ([]*model.OAuthClient, error) {
+	query := s.db.Where("tenant_id IS NULL")
+	if tenantID != nil {
+		query = s.db.Where("tenant_id = ?", *tenantID)
+	}
+
+	var clients []models.OAuthClient
+	if err := query.Order("created_at DESC").Find(&clients).Error; err != nil {
+		return nil, fmt.Errorf("failed to list OAuth clients: %v", err)
+	}
+
+	result := make([]*model.OAuthClient, len(clients))
+	for i := range clients {
+		result[i] = s.convertToGraphQLModel(&clients[i])
+	}
+	return result, nil
+}
+
+// GetOAuthClient returns a client by ID
+func (s *OAuthClientService) GetOAuthClient(id uuid.UUID) (*models.OAuthClient, error) {
+	var client models.OAuthClient
+	if err := s.db.First(&client, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOAuthClientNotFound
+		}
+		return nil, err
+	}
+	return &client, nil
+}
+
+// RevokeOAuthClient stops a client from authenticating. Revoked clients stay listed for auditing.
+func (s *OAuthClientService) RevokeOAuthClient(ctx context.Context, id uuid.UUID) error {
+	result := s.db.Model(&models.OAuthClient{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke OAuth client: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrOAuthClientNotFound
+	}
+	return nil
+}
+
+// Authenticate checks a client's credentials. Public clients identify themselves with their
+// client ID alone.
+func (s *OAuthClientService) Authenticate(clientID, clientSecret string) (*models.OAuthClient, error) {
+	if clientID == "" {
+		return nil, ErrInvalidOAuthClient
+	}
+
+	var client models.OAuthClient
+	err := s.db.Where("client_id = ? AND revoked_at IS NULL", clientID).First(&client).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidOAuthClient
+		}
+		return nil, err
+	}
+
+	if client.IsPublic() {
+		if clientSecret != "" {
+			return nil, ErrInvalidOAuthClient
+		}
+	} else if subtle.ConstantTimeCompare([]byte(utils.HashToken(clientSecret)), []byte(client.SecretHash)) != 1 {
+		return nil, ErrInvalidOAuthClient
+	}
+
+	now := time.Now()
+	if client.LastUsedAt == nil || now.Sub(*client.LastUsedAt) > apiKeyLastUsedInterval {
+		if err := s.db.Model(&client).UpdateColumn("last_used_at", now).Error; err != nil {
+			return nil, fmt.Errorf("failed to record OAuth client use: %v", err)
+		}
+		client.LastUsedAt = &now
+	}
+
+	return &client, nil
+}
+
+// validateRedirectURI accepts HTTPS URIs, HTTP on the loopback interface and the private-use
+// schemes of native apps (RFC 8252)
+func validateRedirectURI(redirectURI string) error {
+	parsed, err := url.Parse(redirectURI)
+	if err != nil || parsed.Scheme == "" || parsed.Fragment != "" {
+		return fmt.Errorf("invalid redirect URI %s", redirectURI)
+	}
+
+	switch parsed.Scheme {
+	case "https":
+		if parsed.Host == "" {
+			return fmt.Errorf("invalid redirect URI %s", redirectURI)
+		}
+	case "http":
+		switch parsed.Hostname() {
+		case "localhost", "127.0.0.1", "::1":
+		default:
+			return fmt.Errorf("redirect URI %s must use HTTPS", redirectURI)
+		}
+	default:
+		if !strings.Contains(parsed.Scheme, ".") {
+			return fmt.Errorf("redirect URI %s must use HTTPS or a reverse domain scheme", redirectURI)
+		}
+	}
+	return nil
+}
+
+func (s *OAuthClientService) convertToGraphQLModel(client *models.OAuthClient) *model.OAuthClient {
+	var tenantID *string
+	if client.TenantID != nil {
+		id := client.TenantID.String()
+		tenantID = &id
+	}
+
+	return &model.OAuthClient{
+		ID:             client.ID.String(),
+		TenantID:       tenantID,
+		ClientID:       client.ClientID,
+		Name:           client.Name,
+		RedirectUris:   strings.Fields(client.RedirectURIs),
+		Scopes:         strings.Fields(client.Scopes),
+		GrantTypes:     strings.Fields(client.GrantTypes),
+		IsConfidential: !client.IsPublic(),
+		LastUsedAt:     client.LastUsedAt,
+		RevokedAt:      client.RevokedAt,
+		CreatedAt:      client.CreatedAt,
+	}
+}
@@ -0,0 +1,795 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang_saas/config"
+	"golang_saas/graph/model"
+	"golang_saas/models"
+	"golang_saas/utils"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// OpenID Connect scopes. Any other scope a client requests is a tenant permission.
+const (
+	oauthScopeOpenID        = "openid"
+	oauthScopeProfile       = "profile"
+	oauthScopeEmail         = "email"
+	oauthScopeOfflineAccess = "offline_access"
+)
+
+var oidcScopes = map[string]bool{
+	oauthScopeOpenID:        true,
+	oauthScopeProfile:       true,
+	oauthScopeEmail:         true,
+	oauthScopeOfflineAccess: true,
+}
+
+const (
+	oauthCodeTTL        = 5 * time.Minute
+	oauthAccessTokenTTL = time.Hour
+	oauthIDTokenTTL     = time.Hour
+
+	// pkceMethodS256 is the only PKCE method supported; plain challenges offer no protection
+	pkceMethodS256 = "S256"
+)
+
+var (
+	ErrUserNotFound         = errors.New("user not found")
+	ErrInvalidRedirectURI   = errors.New("invalid redirect URI")
+	ErrInvalidAccessToken   = errors.New("invalid access token")
+	ErrInsufficientScope    = errors.New("the access token does not grant the openid scope")
+	ErrOAuthConsentNotFound = errors.New("OAuth consent not found")
+)
+
+// OAuthError is an error response of the authorization and token endpoints (RFC 6749 4.1.2.1
+// and 5.2). Authorization errors are sent back to the client's redirect URI.
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+func (e *OAuthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func oauthError(code, description string) *OAuthError {
+	return &OAuthError{Code: code, Description: description}
+}
+
+// OAuthTokenRequest holds the parameters of a token endpoint request
+type OAuthTokenRequest struct {
+	GrantType    string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	RefreshToken string
+	Scope        string
+}
+
+// OAuthTokenResponse is the token endpoint's successful response (RFC 6749 5.1)
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// UserInfo holds the claims about a user released to a client, depending on the scopes the
+// user granted
+type UserInfo struct {
+	Subject       string `json:"sub"`
+	Name          string `json:"name,omitempty"`
+	GivenName     string `json:"given_name,omitempty"`
+	FamilyName    string `json:"family_name,omitempty"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+	TenantID      string `json:"tenant_id,omitempty"`
+}
+
+// OpenIDConfiguration is the OpenID Connect discovery document
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// OpenIDConfiguration describes the provider to OpenID Connect clients
+func (s *OAuthService) OpenIDConfiguration() *OpenIDConfiguration {
+	issuer := strings.TrimRight(config.AppConfig.PublicURL, "/")
+	return &OpenIDConfiguration{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/oauth/authorize",
+		TokenEndpoint:                     issuer + "/oauth/token",
+		UserInfoEndpoint:                  issuer + "/oauth/userinfo",
+		JWKSURI:                           issuer + "/.well-known/jwks.json",
+		RevocationEndpoint:                issuer + "/oauth/revoke",
+		IntrospectionEndpoint:             issuer + "/oauth/introspect",
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{models.OAuthGrantAuthorizationCode, models.OAuthGrantRefreshToken, models.OAuthGrantClientCredentials},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{utils.SigningAlgRS256, utils.SigningAlgEdDSA},
+		ScopesSupported:                   []string{oauthScopeOpenID, oauthScopeProfile, oauthScopeEmail, oauthScopeOfflineAccess},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{pkceMethodS256},
+		ClaimsSupported:                   []string{"sub", "name", "given_name", "family_name", "email", "email_verified", "tenant_id"},
+	}
+}
+
+// authorizationRequest is a validated authorization request
+type authorizationRequest struct {
+	client      *models.OAuthClient
+	redirectURI string
+	scopes      []string
+}
+
+// ValidateAuthorizationRequest checks an authorization request before the user is asked to
+// sign in and approve it, returning the redirect URI to answer. ErrInvalidOAuthClient and
+// ErrInvalidRedirectURI must be shown to the user; any other error is an *OAuthError to send
+// to the redirect URI with OAuthErrorRedirect.
+func (s *OAuthService) ValidateAuthorizationRequest(input model.OAuthAuthorizeInput) (string, error) {
+	request, err := s.validateAuthorizationRequest(input)
+	if request != nil {
+		return request.redirectURI, err
+	}
+	return "", err
+}
+
+func (s *OAuthService) validateAuthorizationRequest(input model.OAuthAuthorizeInput) (*authorizationRequest, error) {
+	var client models.OAuthClient
+	err := s.db.Where("client_id = ? AND tenant_id IS NOT NULL AND revoked_at IS NULL", input.ClientID).First(&client).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidOAuthClient
+		}
+		return nil, fmt.Errorf("failed to load OAuth client: %v", err)
+	}
+
+	// The redirect URI must match a registered one exactly; it may only be left out when
+	// there is no doubt where to send the user
+	registered := strings.Fields(client.RedirectURIs)
+	request := &authorizationRequest{client: &client}
+	if input.RedirectURI == nil || *input.RedirectURI == "" {
+		if len(registered) != 1 {
+			return nil, ErrInvalidRedirectURI
+		}
+		request.redirectURI = registered[0]
+	} else if containsString(registered, *input.RedirectURI) {
+		request.redirectURI = *input.RedirectURI
+	} else {
+		return nil, ErrInvalidRedirectURI
+	}
+
+	if input.ResponseType != "code" {
+		return request, oauthError("unsupported_response_type", "only the code response type is supported")
+	}
+	if !containsString(strings.Fields(client.GrantTypes), models.OAuthGrantAuthorizationCode) {
+		return request, oauthError("unauthorized_client", "the client may not use the authorization code grant")
+	}
+
+	allowed := strings.Fields(client.Scopes)
+	if input.Scope != nil {
+		request.scopes = uniqueStrings(strings.Fields(*input.Scope))
+	}
+	if len(request.scopes) == 0 {
+		for _, scope := range allowed {
+			if oidcScopes[scope] {
+				request.scopes = append(request.scopes, scope)
+			}
+		}
+	}
+	for _, scope := range request.scopes {
+		if !containsString(allowed, scope) {
+			return request, oauthError("invalid_scope", fmt.Sprintf("the client may not request the %s scope", scope))
+		}
+	}
+
+	// PKCE is required for confidential clients too, it also protects against code injection
+	if input.CodeChallenge == nil || *input.CodeChallenge == "" {
+		return request, oauthError("invalid_request", "code_challenge is required")
+	}
+	if input.CodeChallengeMethod == nil || *input.CodeChallengeMethod != pkceMethodS256 {
+		return request, oauthError("invalid_request", "code_challenge_method must be S256")
+	}
+
+	return request, nil
+}
+
+// OAuthErrorRedirect builds the redirect that reports an authorization error to the client
+func OAuthErrorRedirect(redirectURI string, err *OAuthError, state *string) string {
+	params := url.Values{"error": {err.Code}, "error_description": {err.Description}}
+	if state != nil && *state != "" {
+		params.Set("state", *state)
+	}
+	return appendQuery(redirectURI, params)
+}
+
+// DescribeAuthorizationRequest returns what the consent screen shows the user: the client,
+// its tenant, the scopes requested and whether the user already granted them
+func (s *OAuthService) DescribeAuthorizationRequest(ctx context.Context, userID uuid.UUID, input model.OAuthAuthorizeInput) (*model.OAuthAuthorizationRequest, error) {
+	request, err := s.validateAuthorizationRequest(input)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.loadTenantUser(userID, *request.client.TenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	var consent models.OAuthConsent
+	err = s.db.Where("client_id = ? AND user_id = ?", request.client.ID, userID).First(&consent).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to load consent: %v", err)
+	}
+
+	consentRequired := false
+	granted := strings.Fields(consent.Scope)
+	for _, scope := range request.scopes {
+		if !containsString(granted, scope) {
+			consentRequired = true
+			break
+		}
+	}
+
+	return &model.OAuthAuthorizationRequest{
+		Client:          NewOAuthClientService(s.db).convertToGraphQLModel(request.client),
+		Tenant:          user.Tenant,
+		Scopes:          request.scopes,
+		ConsentRequired: consentRequired,
+	}, nil
+}
+
+// ApproveAuthorization records the user's consent and returns the redirect that hands the
+// client an authorization code. Invalid requests are answered with an error redirect.
+func (s *OAuthService) ApproveAuthorization(ctx context.Context, userID uuid.UUID, input model.OAuthAuthorizeInput) (string, error) {
+	request, err := s.validateAuthorizationRequest(input)
+	if err != nil {
+		var oauthErr *OAuthError
+		if errors.As(err, &oauthErr) {
+			return OAuthErrorRedirect(request.redirectURI, oauthErr, input.State), nil
+		}
+		return "", err
+	}
+
+	tenantID := *request.client.TenantID
+	if _, err := s.loadTenantUser(userID, tenantID); err != nil {
+		return "", err
+	}
+
+	code, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate authorization code: %v", err)
+	}
+
+	var nonce string
+	if input.Nonce != nil {
+		nonce = *input.Nonce
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := saveConsent(tx, request.client.ID, userID, request.scopes); err != nil {
+			return err
+		}
+
+		authorizationCode := models.OAuthAuthorizationCode{
+			ClientID:      request.client.ID,
+			UserID:        userID,
+			TenantID:      tenantID,
+			CodeHash:      utils.HashToken(code),
+			RedirectURI:   request.redirectURI,
+			Scope:         strings.Join(request.scopes, " "),
+			Nonce:         nonce,
+			CodeChallenge: *input.CodeChallenge,
+			ExpiresAt:     time.Now().Add(oauthCodeTTL),
+		}
+		if err := tx.Create(&authorizationCode).Error; err != nil {
+			return fmt.Errorf("failed to create authorization code: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{"code": {code}}
+	if input.State != nil && *input.State != "" {
+		params.Set("state", *input.State)
+	}
+	return appendQuery(request.redirectURI, params), nil
+}
+
+// DenyAuthorization returns the redirect telling the client the user declined its request
+func (s *OAuthService) DenyAuthorization(ctx context.Context, input model.OAuthAuthorizeInput) (string, error) {
+	request, err := s.validateAuthorizationRequest(input)
+	if err != nil {
+		var oauthErr *OAuthError
+		if errors.As(err, &oauthErr) {
+			return OAuthErrorRedirect(request.redirectURI, oauthErr, input.State), nil
+		}
+		return "", err
+	}
+
+	return OAuthErrorRedirect(request.redirectURI, oauthError("access_denied", "the user denied the request"), input.State), nil
+}
+
+// saveConsent adds scopes to those the user granted the client
+func saveConsent(tx *gorm.DB, clientID, userID uuid.UUID, scopes []string) error {
+	var consent models.OAuthConsent
+	err := tx.Where("client_id = ? AND user_id = ?", clientID, userID).First(&consent).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		consent = models.OAuthConsent{
+			ClientID: clientID,
+			UserID:   userID,
+			Scope:    strings.Join(scopes, " "),
+		}
+		err = tx.Create(&consent).Error
+	} else if err == nil {
+		granted := uniqueStrings(append(strings.Fields(consent.Scope), scopes...))
+		err = tx.Model(&consent).Update("scope", strings.Join(granted, " ")).Error
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save consent: %v", err)
+	}
+
+	return nil
+}
+
+// Token implements the token endpoint for an authenticated client
+func (s *OAuthService) Token(ctx context.Context, client *models.OAuthClient, req OAuthTokenRequest) (*OAuthTokenResponse, error) {
+	if client.TenantID == nil {
+		return nil, oauthError("unauthorized_client", "platform clients cannot obtain tokens")
+	}
+	if !containsString(strings.Fields(client.GrantTypes), req.GrantType) {
+		switch req.GrantType {
+		case models.OAuthGrantAuthorizationCode, models.OAuthGrantRefreshToken, models.OAuthGrantClientCredentials:
+			return nil, oauthError("unauthorized_client", "the client may not use the "+req.GrantType+" grant")
+		default:
+			return nil, oauthError("unsupported_grant_type", "unsupported grant type")
+		}
+	}
+
+	switch req.GrantType {
+	case models.OAuthGrantAuthorizationCode:
+		return s.exchangeAuthorizationCode(client, req)
+	case models.OAuthGrantRefreshToken:
+		return s.exchangeRefreshToken(client, req)
+	default:
+		return s.clientCredentials(client, req)
+	}
+}
+
+func (s *OAuthService) exchangeAuthorizationCode(client *models.OAuthClient, req OAuthTokenRequest) (*OAuthTokenResponse, error) {
+	if req.Code == "" || req.CodeVerifier == "" {
+		return nil, oauthError("invalid_request", "code and code_verifier are required")
+	}
+
+	// Claim the code before anything else so it can only be exchanged once
+	now := time.Now()
+	codeHash := utils.HashToken(req.Code)
+	result := s.db.Model(&models.OAuthAuthorizationCode{}).
+		Where("code_hash = ? AND client_id = ? AND used_at IS NULL AND expires_at > ?", codeHash, client.ID, now).
+		Update("used_at", now)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to redeem authorization code: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, oauthError("invalid_grant", "the authorization code is invalid or expired")
+	}
+
+	var code models.OAuthAuthorizationCode
+	if err := s.db.Where("code_hash = ?", codeHash).First(&code).Error; err != nil {
+		return nil, fmt.Errorf("failed to load authorization code: %v", err)
+	}
+
+	if req.RedirectURI != code.RedirectURI {
+		return nil, oauthError("invalid_grant", "redirect_uri does not match the authorization request")
+	}
+	if !verifyPKCE(req.CodeVerifier, code.CodeChallenge) {
+		return nil, oauthError("invalid_grant", "code_verifier does not match the code challenge")
+	}
+
+	scopes := strings.Fields(code.Scope)
+	response, err := s.issueUserTokens(client, code.UserID, code.TenantID, scopes, code.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	if containsString(scopes, oauthScopeOfflineAccess) && containsString(strings.Fields(client.GrantTypes), models.OAuthGrantRefreshToken) {
+		response.RefreshToken, err = s.createRefreshToken(client, code.UserID, code.TenantID, scopes, uuid.New())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return response, nil
+}
+
+func (s *OAuthService) exchangeRefreshToken(client *models.OAuthClient, req OAuthTokenRequest) (*OAuthTokenResponse, error) {
+	if req.RefreshToken == "" {
+		return nil, oauthError("invalid_request", "refresh_token is required")
+	}
+
+	var refreshToken models.OAuthRefreshToken
+	err := s.db.Where("token_hash = ? AND client_id = ?", utils.HashToken(req.RefreshToken), client.ID).First(&refreshToken).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, oauthError("invalid_grant", "the refresh token is invalid")
+		}
+		return nil, fmt.Errorf("failed to load refresh token: %v", err)
+	}
+
+	now := time.Now()
+	if refreshToken.RevokedAt != nil || !refreshToken.ExpiresAt.After(now) {
+		return nil, oauthError("invalid_grant", "the refresh token is invalid")
+	}
+
+	// A rotated token being used again means it leaked: revoke everything issued from it
+	result := s.db.Model(&models.OAuthRefreshToken{}).
+		Where("id = ? AND rotated_at IS NULL", refreshToken.ID).
+		Update("rotated_at", now)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to rotate refresh token: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		if err := s.revokeRefreshTokens("family_id = ?", refreshToken.FamilyID); err != nil {
+			return nil, err
+		}
+		return nil, oauthError("invalid_grant", "the refresh token is invalid")
+	}
+
+	// Access tokens may be narrowed to a subset of the granted scopes; the new refresh token
+	// keeps the full grant
+	granted := strings.Fields(refreshToken.Scope)
+	scopes := granted
+	if req.Scope != "" {
+		scopes = uniqueStrings(strings.Fields(req.Scope))
+		for _, scope := range scopes {
+			if !containsString(granted, scope) {
+				return nil, oauthError("invalid_scope", fmt.Sprintf("the %s scope was not granted", scope))
+			}
+		}
+	}
+
+	response, err := s.issueUserTokens(client, refreshToken.UserID, refreshToken.TenantID, scopes, "")
+	if err != nil {
+		return nil, err
+	}
+
+	response.RefreshToken, err = s.createRefreshToken(client, refreshToken.UserID, refreshToken.TenantID, granted, refreshToken.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (s *OAuthService) clientCredentials(client *models.OAuthClient, req OAuthTokenRequest) (*OAuthTokenResponse, error) {
+	if client.IsPublic() {
+		return nil, oauthError("unauthorized_client", "public clients cannot use the client credentials grant")
+	}
+
+	var allowed []string
+	for _, scope := range strings.Fields(client.Scopes) {
+		if !oidcScopes[scope] {
+			allowed = append(allowed, scope)
+		}
+	}
+
+	scopes := allowed
+	if req.Scope != "" {
+		scopes = uniqueStrings(strings.Fields(req.Scope))
+		for _, scope := range scopes {
+			if !containsString(allowed, scope) {
+				return nil, oauthError("invalid_scope", fmt.Sprintf("the client may not request the %s scope", scope))
+			}
+		}
+	}
+
+	// Like API keys, the client keeps no more access than the user who registered it
+	var permissions []string
+	if client.CreatedByID != nil && len(scopes) > 0 {
+		held, err := NewRBACService(s.db).GetUserPermissionsInTenant(*client.CreatedByID, client.TenantID)
+		if err != nil && !errors.Is(err, ErrNotTenantMember) {
+			return nil, err
+		}
+		for _, scope := range scopes {
+			if containsString(held, scope) {
+				permissions = append(permissions, scope)
+			}
+		}
+	}
+
+	claims := &utils.OAuthClaims{
+		ClientID:    client.ClientID,
+		TenantID:    client.TenantID.String(),
+		Scope:       strings.Join(scopes, " "),
+		Permissions: permissions,
+	}
+	accessToken, err := utils.GenerateOAuthAccessToken(claims, client.ClientID, oauthAccessTokenTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate access token: %v", err)
+	}
+
+	return &OAuthTokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(oauthAccessTokenTTL.Seconds()),
+		Scope:       claims.Scope,
+	}, nil
+}
+
+// issueUserTokens issues the access token, and the ID token for openid, for a user's grant.
+// The access token carries the permissions among the scopes the user still holds in the
+// client's tenant. Refresh tokens are left to the caller, which knows the full grant.
+func (s *OAuthService) issueUserTokens(client *models.OAuthClient, userID, tenantID uuid.UUID, scopes []string, nonce string) (*OAuthTokenResponse, error) {
+	user, err := s.loadTenantUser(userID, tenantID)
+	if err != nil {
+		if errors.Is(err, ErrNotTenantMember) || errors.Is(err, ErrTenantInactive) || errors.Is(err, ErrUserNotFound) {
+			return nil, oauthError("invalid_grant", "the user can no longer sign in to this tenant")
+		}
+		return nil, err
+	}
+
+	held, err := NewRBACService(s.db).GetUserPermissionsInTenant(userID, &tenantID)
+	if err != nil {
+		return nil, err
+	}
+	var permissions []string
+	for _, scope := range scopes {
+		if !oidcScopes[scope] && containsString(held, scope) {
+			permissions = append(permissions, scope)
+		}
+	}
+
+	claims := &utils.OAuthClaims{
+		ClientID:    client.ClientID,
+		TenantID:    tenantID.String(),
+		Scope:       strings.Join(scopes, " "),
+		Role:        user.Role.Name,
+		Permissions: permissions,
+	}
+	accessToken, err := utils.GenerateOAuthAccessToken(claims, userID.String(), oauthAccessTokenTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate access token: %v", err)
+	}
+
+	response := &OAuthTokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(oauthAccessTokenTTL.Seconds()),
+		Scope:       claims.Scope,
+	}
+
+	if containsString(scopes, oauthScopeOpenID) {
+		info := userInfoFor(user, tenantID, scopes)
+		idClaims := &utils.IDTokenClaims{
+			Nonce:         nonce,
+			Email:         info.Email,
+			EmailVerified: info.EmailVerified,
+			Name:          info.Name,
+			GivenName:     info.GivenName,
+			FamilyName:    info.FamilyName,
+			TenantID:      info.TenantID,
+		}
+		response.IDToken, err = utils.GenerateIDToken(idClaims, info.Subject, client.ClientID, oauthIDTokenTTL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate ID token: %v", err)
+		}
+	}
+
+	return response, nil
+}
+
+func (s *OAuthService) createRefreshToken(client *models.OAuthClient, userID, tenantID uuid.UUID, scopes []string, familyID uuid.UUID) (string, error) {
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate refresh token: %v", err)
+	}
+
+	refreshToken := models.OAuthRefreshToken{
+		ClientID:  client.ID,
+		UserID:    userID,
+		TenantID:  tenantID,
+		FamilyID:  familyID,
+		TokenHash: utils.HashToken(token),
+		Scope:     strings.Join(scopes, " "),
+		ExpiresAt: time.Now().Add(time.Duration(config.AppConfig.JWTRefreshExpireHours) * time.Hour),
+	}
+	if err := s.db.Create(&refreshToken).Error; err != nil {
+		return "", fmt.Errorf("failed to create refresh token: %v", err)
+	}
+
+	return token, nil
+}
+
+// revokeRefreshTokens revokes the live refresh tokens matching the condition
+func (s *OAuthService) revokeRefreshTokens(query interface{}, args ...interface{}) error {
+	err := s.db.Model(&models.OAuthRefreshToken{}).
+		Where(query, args...).
+		Where("revoked_at IS NULL").
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %v", err)
+	}
+	return nil
+}
+
+// UserInfo returns the claims about the user an access token was issued for
+func (s *OAuthService) UserInfo(ctx context.Context, accessToken string) (*UserInfo, error) {
+	claims, err := utils.ValidateOAuthAccessToken(accessToken)
+	if err != nil {
+		return nil, ErrInvalidAccessToken
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		// Client credentials tokens are not about a user
+		return nil, ErrInsufficientScope
+	}
+	scopes := strings.Fields(claims.Scope)
+	if !containsString(scopes, oauthScopeOpenID) {
+		return nil, ErrInsufficientScope
+	}
+
+	tenantID, err := uuid.Parse(claims.TenantID)
+	if err != nil {
+		return nil, ErrInvalidAccessToken
+	}
+
+	user, err := s.loadTenantUser(userID, tenantID)
+	if err != nil {
+		if errors.Is(err, ErrNotTenantMember) || errors.Is(err, ErrTenantInactive) || errors.Is(err, ErrUserNotFound) {
+			return nil, ErrInvalidAccessToken
+		}
+		return nil, err
+	}
+
+	return userInfoFor(user, tenantID, scopes), nil
+}
+
+// userInfoFor releases the profile and email claims only for the scopes granted
+func userInfoFor(user *models.User, tenantID uuid.UUID, scopes []string) *UserInfo {
+	info := &UserInfo{
+		Subject:  user.ID.String(),
+		TenantID: tenantID.String(),
+	}
+	if containsString(scopes, oauthScopeProfile) {
+		info.Name = strings.TrimSpace(user.FirstName + " " + user.LastName)
+		info.GivenName = user.FirstName
+		info.FamilyName = user.LastName
+	}
+	if containsString(scopes, oauthScopeEmail) {
+		emailVerified := user.EmailVerified
+		info.Email = user.Email
+		info.EmailVerified = &emailVerified
+	}
+	return info
+}
+
+// loadTenantUser loads an active user acting as a member of an active tenant
+func (s *OAuthService) loadTenantUser(userID, tenantID uuid.UUID) (*models.User, error) {
+	var user models.User
+	err := s.db.Preload("Role").Where("id = ? AND is_active = ?", userID, true).First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to load user: %v", err)
+	}
+
+	if err := NewTenantMembershipService(s.db).ActivateTenant(&user, tenantID); err != nil {
+		return nil, err
+	}
+
+	var tenant models.Tenant
+	if err := s.db.First(&tenant, "id = ?", tenantID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotTenantMember
+		}
+		return nil, fmt.Errorf("failed to load tenant: %v", err)
+	}
+	if tenant.Status != models.TenantStatusActive {
+		return nil, ErrTenantInactive
+	}
+	user.Tenant = &tenant
+
+	return &user, nil
+}
+
+// ListConsents returns the clients a user has granted access to
+func (s *OAuthService) ListConsents(ctx context.Context, userID uuid.UUID) ([]*model.OAuthConsent, error) {
+	var consents []models.OAuthConsent
+	if err := s.db.Where("user_id = ?", userID).Order("updated_at DESC").Find(&consents).Error; err != nil {
+		return nil, fmt.Errorf("failed to list consents: %v", err)
+	}
+
+	clientIDs := make([]uuid.UUID, len(consents))
+	for i := range consents {
+		clientIDs[i] = consents[i].ClientID
+	}
+	var clients []models.OAuthClient
+	if err := s.db.Where("id IN ?", clientIDs).Find(&clients).Error; err != nil {
+		return nil, fmt.Errorf("failed to load OAuth clients: %v", err)
+	}
+	clientsByID := make(map[uuid.UUID]*models.OAuthClient, len(clients))
+	for i := range clients {
+		clientsByID[clients[i].ID] = &clients[i]
+	}
+
+	clientService := NewOAuthClientService(s.db)
+	result := make([]*model.OAuthConsent, 0, len(consents))
+	for i := range consents {
+		consent := &consents[i]
+		client, ok := clientsByID[consent.ClientID]
+		if !ok {
+			continue
+		}
+		result = append(result, &model.OAuthConsent{
+			Client:    clientService.convertToGraphQLModel(client),
+			Scopes:    strings.Fields(consent.Scope),
+			CreatedAt: consent.CreatedAt,
+			UpdatedAt: consent.UpdatedAt,
+		})
+	}
+	return result, nil
+}
+
+// RevokeConsent withdraws the access a user granted a client. Its refresh tokens for the
+// user are revoked; access tokens already issued expire on their own.
+func (s *OAuthService) RevokeConsent(ctx context.Context, userID, clientID uuid.UUID) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Where("client_id = ? AND user_id = ?", clientID, userID).Delete(&models.OAuthConsent{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to revoke consent: %v", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrOAuthConsentNotFound
+		}
+
+		return NewOAuthService(tx).revokeRefreshTokens("client_id = ? AND user_id = ?", clientID, userID)
+	})
+}
+
+// verifyPKCE checks a code verifier against an S256 code challenge (RFC 7636 4.6)
+func verifyPKCE(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// appendQuery adds parameters to a URI that may already have a query
+func appendQuery(uri string, params url.Values) string {
+	separator := "?"
+	if strings.Contains(uri, "?") {
+		separator = "&"
+	}
+	return uri + separator + params.Encode()
+}
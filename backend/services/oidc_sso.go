@@ -0,0 +1,424 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang_saas/config"
+	"golang_saas/graph/model"
+	"golang_saas/models"
+	"golang_saas/utils"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+const oidcDiscoveryTTL = time.Hour
+
+type cachedOIDCProvider struct {
+	provider  *oidc.Provider
+	fetchedAt time.Time
+}
+
+// oidcProviders caches discovery documents (and with them the IdP signing keys) by issuer
+var (
+	oidcProvidersMu sync.Mutex
+	oidcProviders   = map[string]cachedOIDCProvider{}
+)
+
+// discoverOIDCProvider returns the discovered provider for an issuer, fetching it when not cached
+func discoverOIDCProvider(ctx context.Context, issuer string) (*oidc.Provider, error) {
+	oidcProvidersMu.Lock()
+	cached, ok := oidcProviders[issuer]
+	oidcProvidersMu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < oidcDiscoveryTTL {
+		return cached.provider, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover OpenID provider %s: %v", issuer, err)
+	}
+
+	oidcProvidersMu.Lock()
+	oidcProviders[issuer] = cachedOIDCProvider{provider: provider, fetchedAt: time.Now()}
+	oidcProvidersMu.Unlock()
+
+	return provider, nil
+}
+
+// oidcRedirectURI is the callback URL registered with the tenant's IdP
+func oidcRedirectURI(tenantSlug string) string {
+	return fmt.Sprintf("%s/auth/sso/%s/oidc/callback", config.AppConfig.PublicURL, tenantSlug)
+}
+
+// oidcLoginURL starts an SSO login for the tenant
+func oidcLoginURL(tenantSlug string) string {
+	return fmt.Sprintf("%s/auth/sso/%s/oidc/login", config.AppConfig.PublicURL, tenantSlug)
+}
+
+// GetOIDCProvider returns the tenant's OIDC provider configuration, or nil if none is set up
+func (s *SSOService) GetOIDCProvider(ctx context.Context, tenantID uuid.UUID) (*model.TenantOidcProvider, error) {
+	var provider models.TenantOIDCProvider
+	err := s.db.Preload("Tenant").Where("tenant_id = ?", tenantID).First(&provider).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return s.convertOIDCProviderToGraphQL(&provider)
+}
+
+// ConfigureOIDCProvider creates or updates the tenant's OIDC provider
+func (s *SSOService) ConfigureOIDCProvider(ctx context.Context, tenantID uuid.UUID, input model.OidcProviderInput) (*model.TenantOidcProvider, error) {
+	if err := s.requireSSOFeature(tenantID); err != nil {
+		return nil, err
+	}
+
+	issuer := strings.TrimRight(strings.TrimSpace(input.Issuer), "/")
+	if issuer == "" || input.ClientID == "" {
+		return nil, errors.New("issuer and client ID are required")
+	}
+
+	// Fail early on issuers that cannot be discovered
+	if _, err := discoverOIDCProvider(ctx, issuer); err != nil {
+		return nil, err
+	}
+
+	var provider models.TenantOIDCProvider
+	err := s.db.Where("tenant_id = ?", tenantID).First(&provider).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	isNew := errors.Is(err, gorm.ErrRecordNotFound)
+
+	provider.TenantID = tenantID
+	provider.Issuer = issuer
+	provider.ClientID = input.ClientID
+
+	if input.ClientSecret != nil {
+		if *input.ClientSecret == "" {
+			provider.ClientSecret = ""
+		} else if provider.ClientSecret, err = utils.EncryptString(*input.ClientSecret); err != nil {
+			return nil, fmt.Errorf("failed to encrypt client secret: %v", err)
+		}
+	}
+
+	scopes := []string{oidc.ScopeOpenID, "profile", "email"}
+	if len(input.Scopes) > 0 {
+		scopes = input.Scopes
+		if !containsString(scopes, oidc.ScopeOpenID) {
+			scopes = append([]string{oidc.ScopeOpenID}, scopes...)
+		}
+	}
+	provider.Scopes = strings.Join(scopes, " ")
+
+	claimMappings, groupRoleMappings := ssoMappingsFromInput(input.ClaimMappings, input.GroupRoleMappings)
+
+	provider.DefaultRoleID = nil
+	if input.DefaultRoleID != nil {
+		roleUUID, err := uuid.Parse(*input.DefaultRoleID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid default role ID: %v", err)
+		}
+		provider.DefaultRoleID = &roleUUID
+	}
+
+	if err := s.validateRoleMappings(tenantID, groupRoleMappings, provider.DefaultRoleID); err != nil {
+		return nil, err
+	}
+
+	claimMappingsJSON, err := json.Marshal(claimMappings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal claim mappings: %v", err)
+	}
+	provider.ClaimMappings = datatypes.JSON(claimMappingsJSON)
+
+	groupRoleMappingsJSON, err := json.Marshal(groupRoleMappings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal group role mappings: %v", err)
+	}
+	provider.GroupRoleMappings = datatypes.JSON(groupRoleMappingsJSON)
+
+	if input.IsEnabled != nil {
+		provider.IsEnabled = *input.IsEnabled
+	} else if isNew {
+		provider.IsEnabled = true
+	}
+
+	if err := s.db.Save(&provider).Error; err != nil {
+		return nil, fmt.Errorf("failed to save OIDC provider: %v", err)
+	}
+
+	if err := s.db.Preload("Tenant").First(&provider, "id = ?", provider.ID).Error; err != nil {
+		return nil, err
+	}
+
+	return s.convertOIDCProviderToGraphQL(&provider)
+}
+
+// DeleteOIDCProvider removes the tenant's OIDC provider. Linked identities are kept
+// so users are matched again if the provider is set up once more.
+func (s *SSOService) DeleteOIDCProvider(ctx context.Context, tenantID uuid.UUID) error {
+	result := s.db.Where("tenant_id = ?", tenantID).Delete(&models.TenantOIDCProvider{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete OIDC provider: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrSSONotConfigured
+	}
+
+	return nil
+}
+
+// enabledOIDCProvider loads the tenant's enabled OIDC provider along with its OAuth2 config
+func (s *SSOService) enabledOIDCProvider(ctx context.Context, tenant *models.Tenant) (*models.TenantOIDCProvider, *oidc.Provider, *oauth2.Config, error) {
+	var provider models.TenantOIDCProvider
+	err := s.db.Where("tenant_id = ? AND is_enabled = ?", tenant.ID, true).First(&provider).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, nil, ErrSSONotConfigured
+		}
+		return nil, nil, nil, err
+	}
+
+	discovered, err := discoverOIDCProvider(ctx, provider.Issuer)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var clientSecret string
+	if provider.ClientSecret != "" {
+		if clientSecret, err = utils.DecryptString(provider.ClientSecret); err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to decrypt client secret: %v", err)
+		}
+	}
+
+	oauthConfig := &oauth2.Config{
+		ClientID:     provider.ClientID,
+		ClientSecret: clientSecret,
+		Endpoint:     discovered.Endpoint(),
+		RedirectURL:  oidcRedirectURI(tenant.Slug),
+		Scopes:       strings.Fields(provider.Scopes),
+	}
+
+	return &provider, discovered, oauthConfig, nil
+}
+
+// BeginOIDCLogin returns the IdP authorization URL for an authorization code + PKCE login
+func (s *SSOService) BeginOIDCLogin(ctx context.Context, tenantSlug string) (string, error) {
+	return s.beginOIDC(ctx, tenantSlug, nil)
+}
+
+// BeginOIDCLink returns the IdP authorization URL for linking the user's existing account
+// to their identity at the tenant's OpenID provider
+func (s *SSOService) BeginOIDCLink(ctx context.Context, tenantSlug string, userID uuid.UUID) (string, error) {
+	return s.beginOIDC(ctx, tenantSlug, &userID)
+}
+
+func (s *SSOService) beginOIDC(ctx context.Context, tenantSlug string, linkUserID *uuid.UUID) (string, error) {
+	tenant, err := s.activeTenantBySlug(tenantSlug)
+	if err != nil {
+		return "", err
+	}
+
+	provider, _, oauthConfig, err := s.enabledOIDCProvider(ctx, tenant)
+	if err != nil {
+		return "", err
+	}
+
+	nonce, err := utils.GenerateRandomToken(16)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	codeVerifier := oauth2.GenerateVerifier()
+
+	state, err := s.createLoginState(tenant.ID, provider.ID, models.SSOProtocolOIDC, nonce, codeVerifier, linkUserID)
+	if err != nil {
+		return "", err
+	}
+
+	return oauthConfig.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(codeVerifier)), nil
+}
+
+// CompleteOIDCLogin handles the IdP callback: it exchanges the code, verifies the ID token,
+// provisions the user and returns a one-time ticket for the frontend
+func (s *SSOService) CompleteOIDCLogin(ctx context.Context, tenantSlug, code, state string) (string, error) {
+	loginState, err := s.consumeLoginState(state, models.SSOProtocolOIDC)
+	if err != nil {
+		return "", err
+	}
+
+	tenant, err := s.activeTenantBySlug(tenantSlug)
+	if err != nil {
+		return "", err
+	}
+	if tenant.ID != loginState.TenantID {
+		return "", ErrInvalidSSOState
+	}
+
+	provider, discovered, oauthConfig, err := s.enabledOIDCProvider(ctx, tenant)
+	if err != nil {
+		return "", err
+	}
+	if provider.ID != loginState.ProviderID {
+		return "", ErrInvalidSSOState
+	}
+
+	token, err := oauthConfig.Exchange(ctx, code, oauth2.VerifierOption(loginState.CodeVerifier))
+	if err != nil {
+		return "", fmt.Errorf("failed to exchange authorization code: %v", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return "", errors.New("identity provider did not return an ID token")
+	}
+
+	verifier := discovered.Verifier(&oidc.Config{ClientID: provider.ClientID})
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return "", fmt.Errorf("failed to verify ID token: %v", err)
+	}
+	if idToken.Nonce != loginState.Nonce {
+		return "", ErrInvalidSSOState
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return "", fmt.Errorf("failed to decode ID token claims: %v", err)
+	}
+
+	claimMappings, groupRoleMappings, err := decodeSSOMappings(defaultSSOClaimMappings, provider.ClaimMappings, provider.GroupRoleMappings)
+	if err != nil {
+		return "", err
+	}
+
+	// Some IdPs only release profile claims from the userinfo endpoint
+	if claimString(claims, claimMappings.Email) == "" && discovered.UserInfoEndpoint() != "" {
+		userInfo, err := discovered.UserInfo(ctx, oauth2.StaticTokenSource(token))
+		if err != nil {
+			return "", fmt.Errorf("failed to fetch user info: %v", err)
+		}
+		var userInfoClaims map[string]interface{}
+		if err := userInfo.Claims(&userInfoClaims); err != nil {
+			return "", fmt.Errorf("failed to decode user info: %v", err)
+		}
+		for key, value := range userInfoClaims {
+			if _, exists := claims[key]; !exists {
+				claims[key] = value
+			}
+		}
+	}
+
+	// Only an explicit email_verified claim vouches for the address
+	emailVerified, _ := claims["email_verified"].(bool)
+
+	identity := &ssoIdentity{
+		Subject:       idToken.Subject,
+		Email:         strings.ToLower(claimString(claims, claimMappings.Email)),
+		EmailVerified: emailVerified,
+		FirstName:     claimString(claims, claimMappings.FirstName),
+		LastName:      claimString(claims, claimMappings.LastName),
+		Groups:        claimStrings(claims, claimMappings.Groups),
+	}
+
+	user, err := s.provisionUser(ctx, loginState, models.SSOProtocolOIDC, identity, groupRoleMappings, provider.DefaultRoleID)
+	if err != nil {
+		return "", err
+	}
+
+	return s.issueTicket(loginState, user.ID)
+}
+
+// convertOIDCProviderToGraphQL converts a stored provider to its GraphQL model
+func (s *SSOService) convertOIDCProviderToGraphQL(provider *models.TenantOIDCProvider) (*model.TenantOidcProvider, error) {
+	claimMappings, groupRoleMappings, err := decodeSSOMappings(defaultSSOClaimMappings, provider.ClaimMappings, provider.GroupRoleMappings)
+	if err != nil {
+		return nil, err
+	}
+	claims, groups := ssoMappingsToGraphQL(claimMappings, groupRoleMappings)
+
+	result := &model.TenantOidcProvider{
+		ID:                provider.ID.String(),
+		TenantID:          provider.TenantID.String(),
+		Issuer:            provider.Issuer,
+		ClientID:          provider.ClientID,
+		Scopes:            strings.Fields(provider.Scopes),
+		ClaimMappings:     claims,
+		GroupRoleMappings: groups,
+		IsEnabled:         provider.IsEnabled,
+		LoginURL:          oidcLoginURL(provider.Tenant.Slug),
+		RedirectURI:       oidcRedirectURI(provider.Tenant.Slug),
+		CreatedAt:         provider.CreatedAt,
+		UpdatedAt:         provider.UpdatedAt,
+	}
+	if provider.DefaultRoleID != nil {
+		defaultRoleID := provider.DefaultRoleID.String()
+		result.DefaultRoleID = &defaultRoleID
+	}
+
+	return result, nil
+}
+
+// claimValue looks up a claim by name; dotted names address nested claims (e.g. realm_access.roles)
+func claimValue(claims map[string]interface{}, name string) interface{} {
+	if name == "" {
+		return nil
+	}
+	if value, ok := claims[name]; ok {
+		return value
+	}
+
+	var current interface{} = claims
+	for _, part := range strings.Split(name, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = object[part]
+	}
+	return current
+}
+
+// claimString returns a string claim, or "" if it is missing or not a string
+func claimString(claims map[string]interface{}, name string) string {
+	value, _ := claimValue(claims, name).(string)
+	return value
+}
+
+// claimStrings returns a claim that may be a single string or a list of strings
+func claimStrings(claims map[string]interface{}, name string) []string {
+	switch value := claimValue(claims, name).(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			if str, ok := item.(string); ok {
+				values = append(values, str)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
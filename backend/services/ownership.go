@@ -0,0 +1,54 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"golang_saas/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ResourceRef identifies the resource a permission is checked against. Permissions with
+// the OWN scope are only granted for resources the user owns.
+type ResourceRef struct {
+	Type models.ResourceType
+	ID   uuid.UUID
+}
+
+// OwnerResolver returns the ID of the user owning a resource, or nil when no user does
+type OwnerResolver func(db *gorm.DB, id uuid.UUID) (*uuid.UUID, error)
+
+// ownerResolvers declares how to find the owner of each resource type OWN permissions
+// can be checked against
+var ownerResolvers = map[models.ResourceType]OwnerResolver{
+	models.ResourceUser:     userOwner,
+	models.ResourceCustomer: customerProfileOwner,
+}
+
+// ResourceOwner returns the ID of the user owning a resource, or nil when no user does
+func (s *RBACService) ResourceOwner(resource ResourceRef) (*uuid.UUID, error) {
+	resolve, ok := ownerResolvers[resource.Type]
+	if !ok {
+		return nil, fmt.Errorf("resource %s has no owner", resource.Type)
+	}
+	return resolve(s.db, resource.ID)
+}
+
+// userOwner makes every user the owner of their own account
+func userOwner(db *gorm.DB, id uuid.UUID) (*uuid.UUID, error) {
+	return &id, nil
+}
+
+// customerProfileOwner returns the account a customer profile is linked to
+func customerProfileOwner(db *gorm.DB, id uuid.UUID) (*uuid.UUID, error) {
+	var customer models.CustomerProfile
+	if err := db.Select("id", "user_id").First(&customer, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to find customer: %v", err)
+	}
+	return customer.UserID, nil
+}
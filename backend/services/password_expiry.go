@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang_saas/graph/model"
+	"golang_saas/models"
+	"golang_saas/utils"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	passwordChangePurpose = "password_change"
+	passwordChangeTTL     = 10 * time.Minute
+)
+
+var (
+	ErrInvalidPasswordChange = errors.New("invalid or expired password change token")
+	ErrPasswordUnchanged     = errors.New("new password must differ from the current password")
+)
+
+// PasswordChangeChallenge is returned by login instead of tokens when the user's password has expired
+type PasswordChangeChallenge struct {
+	ChangeToken string
+	ExpiresAt   time.Time
+}
+
+// passwordChangeFor returns a challenge if the user's password has expired, or nil otherwise
+func (s *AuthService) passwordChangeFor(user *models.User) (*PasswordChangeChallenge, error) {
+	expired, err := NewPasswordPolicyService(s.db).IsPasswordExpired(user)
+	if err != nil {
+		return nil, err
+	}
+	if !expired {
+		return nil, nil
+	}
+
+	token, err := utils.GenerateTenantActionToken(passwordChangePurpose, user.ID, user.TenantID, user.Email, passwordChangeTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PasswordChangeChallenge{
+		ChangeToken: token,
+		ExpiresAt:   time.Now().Add(passwordChangeTTL),
+	}, nil
+}
+
+// ChangeExpiredPassword replaces an expired password using the token from login and continues
+// the login: the result is a two-factor challenge or tokens.
+func (s *AuthService) ChangeExpiredPassword(ctx context.Context, changeToken, newPassword string) (model.LoginResult, error) {
+	claims, err := utils.ValidateActionToken(changeToken, passwordChangePurpose)
+	if err != nil {
+		return nil, ErrInvalidPasswordChange
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, ErrInvalidPasswordChange
+	}
+
+	var user models.User
+	err = s.db.Preload("Role").Preload("Role.Permissions").Preload("Permissions").
+		Where("id = ? AND email = ? AND is_active = ?", userID, claims.Email, true).
+		First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidPasswordChange
+		}
+		return nil, err
+	}
+	if err := activateTokenTenant(s.db, &user, claims); err != nil {
+		return nil, ErrInvalidPasswordChange
+	}
+
+	// The token is spent once the password no longer needs changing
+	passwordPolicy := NewPasswordPolicyService(s.db)
+	expired, err := passwordPolicy.IsPasswordExpired(&user)
+	if err != nil {
+		return nil, err
+	}
+	if !expired {
+		return nil, ErrInvalidPasswordChange
+	}
+
+	if utils.CheckPasswordHash(newPassword, user.Password) {
+		return nil, ErrPasswordUnchanged
+	}
+	hashedPassword, err := passwordPolicy.HashPassword(user.TenantID, &user.ID, newPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.User{}).Where("id = ?", user.ID).Update("password", hashedPassword).Error
+		if err != nil {
+			return fmt.Errorf("failed to update password: %v", err)
+		}
+		return NewPasswordPolicyService(tx).RecordPasswordChange(user.ID, hashedPassword)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	challenge, err := s.mfaChallengeFor(&user)
+	if err != nil {
+		return nil, err
+	}
+	if challenge != nil {
+		authResp := &AuthResponse{User: &user, Challenge: challenge}
+		return authResp.toLoginResult(), nil
+	}
+
+	authResp, err := s.issueTokens(ctx, &user, uuid.Nil)
+	if err != nil {
+		return nil, err
+	}
+	return authResp.toLoginResult(), nil
+}
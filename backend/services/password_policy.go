@@ -0,0 +1,226 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang_saas/models"
+	"golang_saas/utils"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// passwordHistoryLimit is the most previous passwords a policy may check, and how many are
+	// kept. Each one costs a password hash verification when the password changes.
+	passwordHistoryLimit = 5
+	// passwordMaxLength is the longest password bcrypt accepts, in bytes
+	passwordMaxLength = 72
+)
+
+// PasswordPolicyError lists the rules a password broke
+type PasswordPolicyError struct {
+	Violations []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "password does not meet the password policy: " + strings.Join(e.Violations, "; ")
+}
+
+type PasswordPolicyService struct {
+	db *gorm.DB
+}
+
+func NewPasswordPolicyService(db *gorm.DB) *PasswordPolicyService {
+	return &PasswordPolicyService{db: db}
+}
+
+// GetPolicy returns the tenant's password policy. A nil tenant (system users) and tenants
+// without a stored policy get the defaults.
+func (s *PasswordPolicyService) GetPolicy(tenantID *uuid.UUID) (*models.TenantPasswordPolicy, error) {
+	policy := models.DefaultPasswordPolicy()
+	if tenantID == nil {
+		return &policy, nil
+	}
+
+	settingsService := NewTenantSettingsService(s.db)
+	if err := settingsService.GetSetting(*tenantID, models.TenantSettingPasswordPolicy, &policy); err != nil {
+		return nil, err
+	}
+
+	return &policy, nil
+}
+
+// HashPassword checks a new password against the tenant's policy and hashes it.
+// userID is nil for users that don't exist yet; otherwise the user's password history is checked.
+func (s *PasswordPolicyService) HashPassword(tenantID, userID *uuid.UUID, password string) (string, error) {
+	policy, err := s.GetPolicy(tenantID)
+	if err != nil {
+		return "", err
+	}
+
+	violations := policyViolations(policy, password)
+	// Policies saved while the limit was higher are held to it
+	historyCount := min(policy.HistoryCount, passwordHistoryLimit)
+	if userID != nil && historyCount > 0 {
+		reused, err := s.isRecentPassword(*userID, password, historyCount)
+		if err != nil {
+			return "", err
+		}
+		if reused {
+			violations = append(violations, fmt.Sprintf("must not match any of your last %d passwords", historyCount))
+		}
+	}
+	if len(violations) > 0 {
+		return "", &PasswordPolicyError{Violations: violations}
+	}
+
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %v", err)
+	}
+	return hashedPassword, nil
+}
+
+// RecordPasswordChange stores a newly set password hash in the user's history and restarts
+// its age. Only the most recent passwordHistoryLimit hashes are kept.
+func (s *PasswordPolicyService) RecordPasswordChange(userID uuid.UUID, hashedPassword string) error {
+	now := time.Now()
+	err := s.db.Model(&models.User{}).Where("id = ?", userID).Update("password_changed_at", now).Error
+	if err != nil {
+		return fmt.Errorf("failed to update password age: %v", err)
+	}
+
+	history := models.PasswordHistory{UserID: userID, PasswordHash: hashedPassword}
+	if err := s.db.Create(&history).Error; err != nil {
+		return fmt.Errorf("failed to record password history: %v", err)
+	}
+
+	var stale []uuid.UUID
+	err = s.db.Model(&models.PasswordHistory{}).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Offset(passwordHistoryLimit).
+		Pluck("id", &stale).Error
+	if err != nil {
+		return fmt.Errorf("failed to prune password history: %v", err)
+	}
+	if len(stale) > 0 {
+		if err := s.db.Unscoped().Where("id IN ?", stale).Delete(&models.PasswordHistory{}).Error; err != nil {
+			return fmt.Errorf("failed to prune password history: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// IsPasswordExpired reports whether the user's password is older than the tenant's maximum age.
+// Users whose password was never changed count from account creation.
+func (s *PasswordPolicyService) IsPasswordExpired(user *models.User) (bool, error) {
+	policy, err := s.GetPolicy(user.TenantID)
+	if err != nil {
+		return false, err
+	}
+	if policy.MaxAgeDays <= 0 {
+		return false, nil
+	}
+
+	changedAt := user.CreatedAt
+	if user.PasswordChangedAt != nil {
+		changedAt = *user.PasswordChangedAt
+	}
+	return time.Since(changedAt) > time.Duration(policy.MaxAgeDays)*24*time.Hour, nil
+}
+
+// isRecentPassword compares the password against the user's last count password hashes
+func (s *PasswordPolicyService) isRecentPassword(userID uuid.UUID, password string, count int) (bool, error) {
+	var hashes []string
+	err := s.db.Model(&models.PasswordHistory{}).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Limit(count).
+		Pluck("password_hash", &hashes).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to load password history: %v", err)
+	}
+
+	for _, hash := range hashes {
+		if utils.CheckPasswordHash(password, hash) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// policyViolations checks the rules that only depend on the password itself
+func policyViolations(policy *models.TenantPasswordPolicy, password string) []string {
+	var violations []string
+
+	length := len([]rune(password))
+	if length < policy.MinLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters long", policy.MinLength))
+	}
+	if len(password) > passwordMaxLength {
+		violations = append(violations, fmt.Sprintf("must be at most %d bytes long", passwordMaxLength))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+	if policy.RequireUppercase && !hasUpper {
+		violations = append(violations, "must contain an uppercase letter")
+	}
+	if policy.RequireLowercase && !hasLower {
+		violations = append(violations, "must contain a lowercase letter")
+	}
+	if policy.RequireDigit && !hasDigit {
+		violations = append(violations, "must contain a digit")
+	}
+	if policy.RequireSymbol && !hasSymbol {
+		violations = append(violations, "must contain a symbol")
+	}
+
+	if policy.BlockBreached && utils.IsBreachedPassword(password) {
+		violations = append(violations, "is too common and has appeared in data breaches")
+	}
+
+	return violations
+}
+
+// decodePasswordPolicy validates a password policy setting. Omitted fields keep their defaults.
+func decodePasswordPolicy(valueJSON []byte) (*models.TenantPasswordPolicy, error) {
+	policy := models.DefaultPasswordPolicy()
+	decoder := json.NewDecoder(bytes.NewReader(valueJSON))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&policy); err != nil {
+		return nil, fmt.Errorf("invalid password policy: %v", err)
+	}
+
+	if policy.MinLength < 8 || policy.MinLength > passwordMaxLength {
+		return nil, fmt.Errorf("invalid password policy: min_length must be between 8 and %d", passwordMaxLength)
+	}
+	if policy.HistoryCount < 0 || policy.HistoryCount > passwordHistoryLimit {
+		return nil, fmt.Errorf("invalid password policy: history_count must be between 0 and %d", passwordHistoryLimit)
+	}
+	if policy.MaxAgeDays < 0 {
+		return nil, errors.New("invalid password policy: max_age_days must not be negative")
+	}
+
+	return &policy, nil
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"golang_saas/config"
+	"golang_saas/models"
+	"golang_saas/utils"
+
+	"gorm.io/gorm"
+)
+
+const passwordResetTokenTTL = time.Hour
+
+var ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+
+// RequestPasswordReset emails a single-use reset link to the user if the account exists.
+// It does not reveal whether the email is registered.
+func (s *AuthService) RequestPasswordReset(ctx context.Context, email string, tenantSlug *string) error {
+	tenantID, err := s.resolveTenantID(tenantSlug)
+	if err != nil {
+		return err
+	}
+
+	// Scope the lookup the same way login does
+	var user models.User
+	query := s.db.Where("email = ? AND is_active = ?", email, true)
+	if tenantID != nil {
+		// Users of other tenants belong to the tenants they are a member of
+		query = query.Where("tenant_id = ? OR id IN (?)", *tenantID,
+			s.db.Model(&models.TenantUser{}).Select("user_id").Where("tenant_id = ?", *tenantID))
+	} else {
+		query = query.Where("tenant_id IS NULL")
+	}
+
+	err = query.First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return fmt.Errorf("failed to generate reset token: %v", err)
+	}
+
+	resetToken := models.PasswordResetToken{
+		UserID:    user.ID,
+		TenantID:  user.TenantID,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(passwordResetTokenTTL),
+	}
+	if err := s.db.Create(&resetToken).Error; err != nil {
+		return fmt.Errorf("failed to create reset token: %v", err)
+	}
+
+	link := fmt.Sprintf("%s/auth/reset-password?token=%s", config.AppConfig.FrontendURL, url.QueryEscape(token))
+	body := fmt.Sprintf("Hello %s,\n\nWe received a request to reset your %s password. Use the link below to choose a new password:\n\n%s\n\nThe link expires in %d minutes. If you did not request a password reset, you can ignore this email.\n",
+		user.FirstName, config.AppConfig.AppName, link, int(passwordResetTokenTTL.Minutes()))
+
+	// A failure is only logged: reporting it would tell that the email is registered
+	emailService := NewEmailService()
+	if err := emailService.Send(user.Email, "Reset your password", body); err != nil {
+		log.Printf("Failed to send password reset email to %s: %v", user.Email, err)
+	}
+	return nil
+}
+
+// ResetPassword sets a new password using a reset token and revokes the user's sessions
+func (s *AuthService) ResetPassword(ctx context.Context, token, newPassword string) error {
+	var resetToken models.PasswordResetToken
+	err := s.db.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", utils.HashToken(token), time.Now()).
+		First(&resetToken).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+
+	passwordPolicy := NewPasswordPolicyService(s.db)
+	hashedPassword, err := passwordPolicy.HashPassword(resetToken.TenantID, &resetToken.UserID, newPassword)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		// Consume the token; the used_at guard prevents concurrent reuse
+		now := time.Now()
+		result := tx.Model(&models.PasswordResetToken{}).
+			Where("id = ? AND used_at IS NULL", resetToken.ID).
+			Update("used_at", now)
+		if result.Error != nil {
+			return fmt.Errorf("failed to consume reset token: %v", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidResetToken
+		}
+
+		// Invalidate any other outstanding reset tokens for the user
+		err := tx.Model(&models.PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL", resetToken.UserID).
+			Update("used_at", now).Error
+		if err != nil {
+			return fmt.Errorf("failed to invalidate reset tokens: %v", err)
+		}
+
+		// Completing a reset proves ownership of the mailbox, so it also verifies the address
+		err = tx.Model(&models.User{}).
+			Where("id = ?", resetToken.UserID).
+			Updates(map[string]any{
+				"password":          hashedPassword,
+				"email_verified":    true,
+				"email_verified_at": gorm.Expr("COALESCE(email_verified_at, ?)", now),
+			}).Error
+		if err != nil {
+			return fmt.Errorf("failed to update password: %v", err)
+		}
+
+		if err := NewPasswordPolicyService(tx).RecordPasswordChange(resetToken.UserID, hashedPassword); err != nil {
+			return err
+		}
+
+		return NewSessionService(tx).RevokeAllUserSessions(resetToken.UserID)
+	})
+}
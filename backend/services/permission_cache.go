@@ -0,0 +1,411 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang_saas/config"
+	"golang_saas/models"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// permissionVersionKey holds the role version. Cached permission sets are keyed by it, so
+	// incrementing it invalidates every cached set at once.
+	permissionVersionKey = "rbac:role_version"
+	// permissionInvalidationChannel tells the other API instances the role version changed
+	permissionInvalidationChannel = "rbac:invalidate"
+
+	permissionCacheTTL = 10 * time.Minute
+	// localPermissionCacheTTL is shorter so an instance that missed an invalidation while
+	// disconnected from Redis recovers quickly
+	localPermissionCacheTTL = time.Minute
+)
+
+// permissionSet is what a user is granted in one tenant, with wildcards and implications
+// expanded. It is computed once per user, tenant and role version and cached in Redis and
+// in process.
+type permissionSet struct {
+	UserID      uuid.UUID                    `json:"user_id"`
+	Email       string                       `json:"email"`
+	RoleName    string                       `json:"role_name"`
+	TenantID    *uuid.UUID                   `json:"tenant_id"` // the tenant the user acts in; nil for system users
+	Member      bool                         `json:"member"`    // false when the user does not belong to the tenant
+	Permissions map[string]grantedPermission `json:"permissions"`
+}
+
+type grantedPermission struct {
+	Scope    models.PermissionScope `json:"scope"`
+	IsSystem bool                   `json:"is_system"`
+}
+
+// permissionCacheColumns lists the tables whose changes invalidate cached permission sets.
+// For updates, only the listed columns matter; nil means any column.
+var permissionCacheColumns = map[string][]string{
+	"roles":            nil,
+	"permissions":      nil,
+	"role_permissions": nil,
+	"user_permissions": nil,
+	"users":            {"role_id", "tenant_id", "email", "deleted_at"},
+	"tenant_users":     {"role_id", "tenant_id", "user_id", "is_active", "deleted_at"},
+}
+
+// RegisterPermissionCacheCallbacks invalidates cached permission sets whenever roles, their
+// permissions, direct permission assignments or memberships are written through db, however
+// the write is made. Writes made in a transaction invalidate once it commits, so sets
+// computed from the old data in the meantime are never served. Writes made before
+// registering, such as startup seeding and backfills, are covered by an invalidation here.
+func RegisterPermissionCacheCallbacks(db *gorm.DB) error {
+	const name = "rbac:invalidate_permissions"
+
+	if _, ok := db.ConnPool.(*invalidatingConnPool); !ok {
+		beginner, ok := db.ConnPool.(gorm.TxBeginner)
+		if !ok {
+			return errors.New("failed to register permission cache callback: connection pool does not support transactions")
+		}
+		pool := &invalidatingConnPool{ConnPool: db.ConnPool, beginner: beginner}
+		db.ConnPool = pool
+		db.Statement.ConnPool = pool
+	}
+
+	if err := db.Callback().Create().After("gorm:create").Register(name, invalidateOnWrite(writeCreate)); err != nil {
+		return fmt.Errorf("failed to register permission cache callback: %v", err)
+	}
+	if err := db.Callback().Update().After("gorm:update").Register(name, invalidateOnWrite(writeUpdate)); err != nil {
+		return fmt.Errorf("failed to register permission cache callback: %v", err)
+	}
+	if err := db.Callback().Delete().After("gorm:delete").Register(name, invalidateOnWrite(writeDelete)); err != nil {
+		return fmt.Errorf("failed to register permission cache callback: %v", err)
+	}
+
+	InvalidatePermissionCache(db.Statement.Context)
+	return nil
+}
+
+// invalidatingConnPool begins transactions that invalidate cached permission sets after
+// they commit, if they wrote permission data
+type invalidatingConnPool struct {
+	gorm.ConnPool
+	beginner gorm.TxBeginner
+}
+
+func (p *invalidatingConnPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	tx, err := p.beginner.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &invalidatingTx{Tx: tx, ctx: ctx}, nil
+}
+
+// GetDBConn lets gorm find the underlying *sql.DB
+func (p *invalidatingConnPool) GetDBConn() (*sql.DB, error) {
+	if sqlDB, ok := p.ConnPool.(*sql.DB); ok {
+		return sqlDB, nil
+	}
+	if connector, ok := p.ConnPool.(gorm.GetDBConnector); ok {
+		return connector.GetDBConn()
+	}
+	return nil, gorm.ErrInvalidDB
+}
+
+type invalidatingTx struct {
+	*sql.Tx
+	ctx        context.Context
+	invalidate bool
+}
+
+func (t *invalidatingTx) Commit() error {
+	if err := t.Tx.Commit(); err != nil {
+		return err
+	}
+	if t.invalidate {
+		// The request may be over by the time its transaction commits
+		InvalidatePermissionCache(context.WithoutCancel(t.ctx))
+	}
+	return nil
+}
+
+type writeKind int
+
+const (
+	writeCreate writeKind = iota
+	writeUpdate
+	writeDelete
+)
+
+func invalidateOnWrite(kind writeKind) func(*gorm.DB) {
+	return func(tx *gorm.DB) {
+		if tx.Error != nil || tx.RowsAffected == 0 {
+			return
+		}
+		table := tx.Statement.Table
+		columns, ok := permissionCacheColumns[table]
+		if !ok {
+			return
+		}
+		// Nothing can be cached yet for a user that did not exist
+		if kind == writeCreate && table == "users" {
+			return
+		}
+		if kind == writeUpdate && columns != nil && !updatesColumn(tx.Statement, columns) {
+			return
+		}
+		if pending, ok := tx.Statement.ConnPool.(*invalidatingTx); ok {
+			pending.invalidate = true
+			return
+		}
+		InvalidatePermissionCache(tx.Statement.Context)
+	}
+}
+
+// updatesColumn reports whether an update may change one of the columns. Only map updates
+// name their columns; struct updates are assumed to change everything.
+func updatesColumn(stmt *gorm.Statement, columns []string) bool {
+	values, ok := stmt.Dest.(map[string]any)
+	if !ok {
+		return true
+	}
+	for key := range values {
+		if stmt.Schema != nil {
+			if field := stmt.Schema.LookUpField(key); field != nil {
+				key = field.DBName
+			}
+		}
+		if containsString(columns, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// InvalidatePermissionCache discards every cached permission set, on all API instances
+func InvalidatePermissionCache(ctx context.Context) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	client := config.RedisClient
+	if client == nil {
+		userPermissionCache.advance()
+		return
+	}
+
+	version, err := client.Incr(ctx, permissionVersionKey).Result()
+	if err != nil {
+		log.Printf("Failed to invalidate cached permissions in Redis: %v", err)
+		userPermissionCache.bypass()
+		return
+	}
+	userPermissionCache.setVersion(version)
+	if err := client.Publish(ctx, permissionInvalidationChannel, version).Err(); err != nil {
+		log.Printf("Failed to publish permission cache invalidation: %v", err)
+	}
+}
+
+// ListenForPermissionInvalidations keeps this instance's cache in step with invalidations
+// made by other instances until ctx is done. Without Redis there are no other instances to
+// hear from.
+func ListenForPermissionInvalidations(ctx context.Context) {
+	client := config.RedisClient
+	if client == nil {
+		return
+	}
+
+	pubsub := client.Subscribe(ctx, permissionInvalidationChannel)
+	go func() {
+		defer pubsub.Close()
+		for {
+			message, err := pubsub.Receive(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				log.Printf("Permission cache invalidation listener failed: %v", err)
+				time.Sleep(time.Second)
+				continue
+			}
+
+			switch m := message.(type) {
+			case *redis.Subscription:
+				// Catch up with invalidations made before we (re)subscribed
+				version, err := client.Get(ctx, permissionVersionKey).Int64()
+				if err != nil && !errors.Is(err, redis.Nil) {
+					log.Printf("Failed to load role version: %v", err)
+					userPermissionCache.bypass()
+					continue
+				}
+				userPermissionCache.setVersion(version)
+			case *redis.Message:
+				version, err := strconv.ParseInt(m.Payload, 10, 64)
+				if err != nil {
+					userPermissionCache.bypass()
+					continue
+				}
+				userPermissionCache.setVersion(version)
+			}
+		}
+	}()
+}
+
+// userPermissionCache is shared by all RBACService instances of the process
+var userPermissionCache = &permissionCache{entries: map[string]localPermissionEntry{}}
+
+type localPermissionEntry struct {
+	version   int64
+	expiresAt time.Time
+	set       *permissionSet
+}
+
+// permissionCache keeps permission sets in process, in front of Redis when it is available
+type permissionCache struct {
+	mu      sync.RWMutex
+	version int64
+	// stale is set while Redis cannot tell the current role version. Nothing is cached or
+	// read from the cache until it can again.
+	stale   bool
+	entries map[string]localPermissionEntry
+}
+
+// get returns the cached permission set and the role version it must be stored under if it
+// has to be computed. Reading the version first means a set computed while the roles change
+// is stored under the old version and never read.
+func (c *permissionCache) get(ctx context.Context, userID uuid.UUID, tenantID *uuid.UUID) (*permissionSet, int64) {
+	key := permissionCacheKey(userID, tenantID)
+	client := config.RedisClient
+
+	c.mu.RLock()
+	stale := c.stale
+	c.mu.RUnlock()
+	if stale && !c.refreshVersion(ctx, client) {
+		return nil, 0
+	}
+
+	c.mu.RLock()
+	version := c.version
+	entry, ok := c.entries[key]
+	c.mu.RUnlock()
+	if ok && entry.version == version && time.Now().Before(entry.expiresAt) {
+		return entry.set, version
+	}
+
+	if client == nil {
+		return nil, version
+	}
+	data, err := client.Get(ctx, redisPermissionKey(version, key)).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			log.Printf("Failed to read cached permissions: %v", err)
+		}
+		return nil, version
+	}
+	var set permissionSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, version
+	}
+	c.storeLocal(key, version, &set)
+	return &set, version
+}
+
+// put caches a permission set computed at the given role version
+func (c *permissionCache) put(ctx context.Context, userID uuid.UUID, tenantID *uuid.UUID, version int64, set *permissionSet) {
+	key := permissionCacheKey(userID, tenantID)
+	if !c.storeLocal(key, version, set) {
+		return
+	}
+
+	client := config.RedisClient
+	if client == nil {
+		return
+	}
+	data, err := json.Marshal(set)
+	if err != nil {
+		return
+	}
+	if err := client.Set(ctx, redisPermissionKey(version, key), data, permissionCacheTTL).Err(); err != nil {
+		log.Printf("Failed to cache permissions: %v", err)
+	}
+}
+
+// storeLocal keeps a set in process and reports whether it was computed at the current role
+// version
+func (c *permissionCache) storeLocal(key string, version int64, set *permissionSet) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Sets computed before an invalidation are not worth keeping
+	if c.stale || version != c.version {
+		return false
+	}
+	c.entries[key] = localPermissionEntry{version: version, expiresAt: time.Now().Add(localPermissionCacheTTL), set: set}
+	return true
+}
+
+// setVersion switches to a role version Redis reported, dropping the sets of the previous
+// one. Versions only move forward: an older one may still arrive from a delayed message.
+func (c *permissionCache) setVersion(version int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stale = false
+	if version > c.version {
+		c.version = version
+		c.entries = map[string]localPermissionEntry{}
+	}
+}
+
+// refreshVersion asks Redis for the current role version after it failed to tell
+func (c *permissionCache) refreshVersion(ctx context.Context, client *redis.Client) bool {
+	if client == nil {
+		return false
+	}
+	version, err := client.Get(ctx, permissionVersionKey).Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return false
+	}
+	c.setVersion(version)
+	return true
+}
+
+// bypass stops caching until Redis can tell the current role version again. Versions of
+// this instance's own would collide with the ones Redis assigns later.
+func (c *permissionCache) bypass() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stale = true
+	c.entries = map[string]localPermissionEntry{}
+}
+
+// advance moves to the next role version when there is no Redis, and so no other instance
+// to agree on versions with
+func (c *permissionCache) advance() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.version++
+	c.entries = map[string]localPermissionEntry{}
+}
+
+// permissionCacheKey identifies a user in a tenant; lookups without a tenant use the user's
+// primary tenant
+func permissionCacheKey(userID uuid.UUID, tenantID *uuid.UUID) string {
+	tenant := "primary"
+	if tenantID != nil {
+		tenant = tenantID.String()
+	}
+	return userID.String() + ":" + tenant
+}
+
+func redisPermissionKey(version int64, key string) string {
+	return fmt.Sprintf("rbac:permissions:%d:%s", version, key)
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang_saas/config"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// usePermissionCache swaps in an empty process cache and the given Redis client for a test
+func usePermissionCache(t *testing.T, client *redis.Client) *permissionCache {
+	t.Helper()
+
+	previousCache, previousClient := userPermissionCache, config.RedisClient
+	cache := &permissionCache{entries: map[string]localPermissionEntry{}}
+	userPermissionCache, config.RedisClient = cache, client
+	t.Cleanup(func() {
+		userPermissionCache, config.RedisClient = previousCache, previousClient
+		if client != nil {
+			client.Close()
+		}
+	})
+	return cache
+}
+
+// unreachableRedis returns a client whose commands all fail
+func unreachableRedis() *redis.Client {
+	return redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1, DialTimeout: 100 * time.Millisecond})
+}
+
+func TestPermissionCacheVersionOnlyMovesForward(t *testing.T) {
+	cache := usePermissionCache(t, nil)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	cache.setVersion(5)
+	cache.put(ctx, userID, nil, 5, &permissionSet{UserID: userID})
+
+	// A delayed message for an older version must not bring back the sets it invalidated
+	cache.setVersion(4)
+	set, version := cache.get(ctx, userID, nil)
+	if version != 5 {
+		t.Fatalf("version = %d, want 5", version)
+	}
+	if set == nil {
+		t.Fatal("set cached at the current version was dropped")
+	}
+
+	cache.setVersion(6)
+	if set, _ := cache.get(ctx, userID, nil); set != nil {
+		t.Fatal("set cached at an invalidated version was served")
+	}
+}
+
+func TestPermissionCacheStoresOnlyCurrentVersion(t *testing.T) {
+	cache := usePermissionCache(t, nil)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	_, version := cache.get(ctx, userID, nil)
+	cache.setVersion(version + 1)
+	cache.put(ctx, userID, nil, version, &permissionSet{UserID: userID})
+
+	if set, _ := cache.get(ctx, userID, nil); set != nil {
+		t.Fatal("set computed before an invalidation was stored")
+	}
+}
+
+func TestInvalidatePermissionCacheWithoutRedis(t *testing.T) {
+	cache := usePermissionCache(t, nil)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	cache.put(ctx, userID, nil, 0, &permissionSet{UserID: userID})
+	InvalidatePermissionCache(ctx)
+
+	set, version := cache.get(ctx, userID, nil)
+	if version != 1 {
+		t.Fatalf("version = %d, want 1", version)
+	}
+	if set != nil {
+		t.Fatal("set cached before the invalidation was served")
+	}
+}
+
+func TestInvalidatePermissionCacheBypassesWhileRedisFails(t *testing.T) {
+	cache := usePermissionCache(t, unreachableRedis())
+	ctx := context.Background()
+	userID := uuid.New()
+
+	cache.setVersion(3)
+	cache.put(ctx, userID, nil, 3, &permissionSet{UserID: userID})
+	InvalidatePermissionCache(ctx)
+
+	if cache.version != 3 {
+		t.Fatalf("version = %d, want 3: versions are only assigned by Redis", cache.version)
+	}
+	if set, _ := cache.get(ctx, userID, nil); set != nil {
+		t.Fatal("set cached before a failed invalidation was served")
+	}
+
+	cache.put(ctx, userID, nil, 3, &permissionSet{UserID: userID})
+	if set, _ := cache.get(ctx, userID, nil); set != nil {
+		t.Fatal("set was cached while Redis could not tell the role version")
+	}
+
+	// Caching resumes once Redis reports a version again
+	cache.setVersion(4)
+	cache.put(ctx, userID, nil, 4, &permissionSet{UserID: userID})
+	if set, _ := cache.get(ctx, userID, nil); set == nil {
+		t.Fatal("set was not cached after Redis reported a version")
+	}
+}
@@ -1,9 +1,11 @@
 package services
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"golang_saas/models"
+	"sort"
 	"strings"
 
 	"github.com/google/uuid"
@@ -18,7 +20,10 @@ func NewRBACService(db *gorm.DB) *RBACService {
 	return &RBACService{db: db}
 }
 
-// InitializeSystemRoles creates default system roles and permissions
+// InitializeSystemRoles creates default system roles and permissions. Built-in roles that
+// already exist, including the default roles of every tenant, get the permissions they are
+// defined with, so permissions added to a role in a later version reach existing
+// installations. It runs on every start.
 func (s *RBACService) InitializeSystemRoles() error {
 	// Create system permissions
 	systemPermissions := models.GetSystemPermissions()
@@ -33,10 +38,16 @@ func (s *RBACService) InitializeSystemRoles() error {
 				Action:             string(sysPerm.Action),
 				Description:        &sysPerm.Description,
 				IsSystemPermission: sysPerm.IsSystem,
+				Scope:              sysPerm.Scope,
 			}
 			if err := s.db.Create(&perm).Error; err != nil {
 				return fmt.Errorf("failed to create permission %s: %w", sysPerm.Name, err)
 			}
+		} else if err == nil && existingPerm.Scope != sysPerm.Scope {
+			// Permissions created before scopes were stored
+			if err := s.db.Model(&existingPerm).Update("scope", sysPerm.Scope).Error; err != nil {
+				return fmt.Errorf("failed to update permission %s: %w", sysPerm.Name, err)
+			}
 		}
 	}
 
@@ -62,24 +73,34 @@ func (s *RBACService) InitializeSystemRoles() error {
 			if err := s.AssignPermissionsToRole(role.ID, rp.Permissions); err != nil {
 				return fmt.Errorf("failed to assign permissions to role %s: %w", rp.Role, err)
 			}
+		} else if err == nil {
+			if err := s.syncRolePermissions(&existingRole, rp.Permissions); err != nil {
+				return err
+			}
+		} else {
+			return fmt.Errorf("failed to find role %s: %w", rp.Role, err)
+		}
+	}
+
+	// Default roles of existing tenants
+	var tenantRoles []models.Role
+	err := s.db.Where("tenant_id IS NOT NULL AND name IN ?", defaultTenantRoleNames()).Find(&tenantRoles).Error
+	if err != nil {
+		return fmt.Errorf("failed to find tenant roles: %w", err)
+	}
+	for i := range tenantRoles {
+		if err := s.syncRolePermissions(&tenantRoles[i], definedRolePermissions(models.SystemRole(tenantRoles[i].Name))); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-// InitializeTenantRoles creates default tenant roles for a specific tenant
+// InitializeTenantRoles creates default tenant roles for a specific tenant, and gives
+// existing ones the permissions they are defined with
 func (s *RBACService) InitializeTenantRoles(tenantID uuid.UUID) error {
-	tenantRoles := []models.SystemRole{
-		models.TenantRoleAdmin,
-		models.TenantRoleManager,
-		models.TenantRoleUser,
-		models.TenantRoleCustomer,
-	}
-
-	rolePermissions := models.GetRolePermissions()
-
-	for _, role := range tenantRoles {
+	for _, role := range defaultTenantRoles {
 		var existingRole models.Role
 		err := s.db.Where("name = ? AND tenant_id = ?", string(role), tenantID).First(&existingRole).Error
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -95,21 +116,80 @@ func (s *RBACService) InitializeTenantRoles(tenantID uuid.UUID) error {
 				return fmt.Errorf("failed to create tenant role %s: %w", role, err)
 			}
 
-			// Find permissions for this role
-			for _, rp := range rolePermissions {
-				if rp.Role == role {
-					if err := s.AssignPermissionsToRole(newRole.ID, rp.Permissions); err != nil {
-						return fmt.Errorf("failed to assign permissions to tenant role %s: %w", role, err)
-					}
-					break
-				}
+			if err := s.AssignPermissionsToRole(newRole.ID, definedRolePermissions(role)); err != nil {
+				return fmt.Errorf("failed to assign permissions to tenant role %s: %w", role, err)
 			}
+		} else if err == nil {
+			if err := s.syncRolePermissions(&existingRole, definedRolePermissions(role)); err != nil {
+				return err
+			}
+		} else {
+			return fmt.Errorf("failed to find tenant role %s: %w", role, err)
 		}
 	}
 
 	return nil
 }
 
+// defaultTenantRoles are created in every tenant
+var defaultTenantRoles = []models.SystemRole{
+	models.TenantRoleAdmin,
+	models.TenantRoleManager,
+	models.TenantRoleUser,
+	models.TenantRoleCustomer,
+}
+
+func defaultTenantRoleNames() []string {
+	names := make([]string, len(defaultTenantRoles))
+	for i, role := range defaultTenantRoles {
+		names[i] = string(role)
+	}
+	return names
+}
+
+// definedRolePermissions returns the permissions a built-in role is defined with
+func definedRolePermissions(role models.SystemRole) []string {
+	for _, rp := range models.GetRolePermissions() {
+		if rp.Role == role {
+			return rp.Permissions
+		}
+	}
+	return nil
+}
+
+// syncRolePermissions gives a built-in role exactly the permissions it is defined with.
+// Roles that already match are left alone, so restarts don't rewrite them.
+func (s *RBACService) syncRolePermissions(role *models.Role, permissionNames []string) error {
+	var current []string
+	err := s.db.Table("role_permissions").
+		Joins("JOIN permissions ON permissions.id = role_permissions.permission_id").
+		Where("role_permissions.role_id = ?", role.ID).
+		Pluck("permissions.name", &current).Error
+	if err != nil {
+		return fmt.Errorf("failed to load permissions of role %s: %w", role.Name, err)
+	}
+
+	// Only permissions that exist can be assigned
+	var wanted []string
+	err = s.db.Model(&models.Permission{}).Where("name IN ?", permissionNames).Pluck("name", &wanted).Error
+	if err != nil {
+		return fmt.Errorf("failed to find permissions: %w", err)
+	}
+
+	wanted = uniqueStrings(wanted)
+	current = uniqueStrings(current)
+	sort.Strings(wanted)
+	sort.Strings(current)
+	if strings.Join(wanted, " ") == strings.Join(current, " ") {
+		return nil
+	}
+
+	if err := s.AssignPermissionsToRole(role.ID, wanted); err != nil {
+		return fmt.Errorf("failed to update permissions of role %s: %w", role.Name, err)
+	}
+	return nil
+}
+
 // AssignPermissionsToRole assigns permissions to a role
 func (s *RBACService) AssignPermissionsToRole(roleID uuid.UUID, permissionNames []string) error {
 	var permissions []models.Permission
@@ -121,6 +201,9 @@ func (s *RBACService) AssignPermissionsToRole(roleID uuid.UUID, permissionNames
 	if err := s.db.First(&role, "id = ?", roleID).Error; err != nil {
 		return fmt.Errorf("failed to find role: %w", err)
 	}
+	if role.TenantID != nil && hasSystemPermission(permissions) {
+		return ErrSystemPermissionScope
+	}
 
 	// Clear existing permissions
 	if err := s.db.Model(&role).Association("Permissions").Clear(); err != nil {
@@ -135,70 +218,222 @@ func (s *RBACService) AssignPermissionsToRole(roleID uuid.UUID, permissionNames
 	return nil
 }
 
-// CheckUserPermission checks if a user has a specific permission
-func (s *RBACService) CheckUserPermission(userID uuid.UUID, permission string, tenantID *uuid.UUID) (bool, error) {
-	var user models.User
-	err := s.db.Preload("Role").Preload("Role.Permissions").Preload("Permissions").First(&user, "id = ?", userID).Error
+// CheckUserPermission checks if a user has a specific permission. Permissions with the OWN
+// scope are only granted for a resource the user owns, so they need the resource the
+// permission is checked against; resource may be nil otherwise. In a tenant, the tenant's
+// access policies then refine what the user's roles grant.
+func (s *RBACService) CheckUserPermission(userID uuid.UUID, permission string, tenantID *uuid.UUID, resource *ResourceRef) (bool, error) {
+	set, err := s.userPermissionSet(userID, tenantID)
+	if err != nil || !set.Member {
+		return false, err
+	}
+
+	granted, err := s.checkRoleGrant(set, permission, tenantID, resource)
 	if err != nil {
-		return false, fmt.Errorf("failed to find user: %w", err)
+		return false, err
 	}
 
-	// Check if user has permission through role
-	for _, perm := range user.Role.Permissions {
-		if perm.Name == permission {
-			// If it's a system permission, check if user is system admin
-			if perm.IsSystemPermission && user.TenantID == nil {
-				return true, nil
-			}
-			// If it's a tenant permission, check if user belongs to the tenant
-			if !perm.IsSystemPermission && user.TenantID != nil && tenantID != nil && *user.TenantID == *tenantID {
-				return true, nil
-			}
+	if tenantID == nil || set.TenantID == nil || *set.TenantID != *tenantID {
+		return granted, nil
+	}
+	return s.applyAccessPolicies(set, permission, *tenantID, resource, granted)
+}
+
+// checkRoleGrant checks a permission against what the user's role and direct assignments
+// grant, including the ownership OWN permissions require
+func (s *RBACService) checkRoleGrant(set *permissionSet, permission string, tenantID *uuid.UUID, resource *ResourceRef) (bool, error) {
+	grant := findPermissionGrant(set, permission, tenantID)
+	if grant == nil {
+		return false, nil
+	}
+
+	if grant.Scope == models.ScopeOwn {
+		if resource == nil {
+			return false, nil
 		}
+		owner, err := s.ResourceOwner(*resource)
+		if err != nil {
+			return false, err
+		}
+		return owner != nil && *owner == set.UserID, nil
+	}
+
+	return true, nil
+}
+
+// HoldsPermission reports whether a user is granted a permission in the tenant, whatever
+// its scope. It decides what a user may delegate to roles, API keys and OAuth clients,
+// not what they may do to a particular resource, so access policies do not apply.
+func (s *RBACService) HoldsPermission(userID uuid.UUID, permission string, tenantID *uuid.UUID) (bool, error) {
+	set, err := s.userPermissionSet(userID, tenantID)
+	if err != nil || !set.Member {
+		return false, err
+	}
+	return findPermissionGrant(set, permission, tenantID) != nil, nil
+}
+
+// findPermissionGrant returns the permission if the user holds it in the tenant, through
+// their role or a direct assignment, directly or by a wildcard or implication
+func findPermissionGrant(set *permissionSet, permission string, tenantID *uuid.UUID) *grantedPermission {
+	perm, ok := set.Permissions[permission]
+	if !ok {
+		return nil
 	}
 
-	// Check if user has direct permission assignment
-	for _, perm := range user.Permissions {
+	// If it's a system permission, check if user is system admin
+	if perm.IsSystem && set.TenantID == nil {
+		return &perm
+	}
+	// If it's a tenant permission, check if user belongs to the tenant
+	if !perm.IsSystem && set.TenantID != nil && tenantID != nil && *set.TenantID == *tenantID {
+		return &perm
+	}
+
+	return nil
+}
+
+// userPermissionSet returns the user's effective permissions in the tenant, from the cache
+// when the roles have not changed since they were computed
+func (s *RBACService) userPermissionSet(userID uuid.UUID, tenantID *uuid.UUID) (*permissionSet, error) {
+	ctx := context.Background()
+	set, version := userPermissionCache.get(ctx, userID, tenantID)
+	if set != nil {
+		return set, nil
+	}
+
+	set = &permissionSet{UserID: userID}
+	user, err := s.loadUserInTenant(userID, tenantID)
+	switch {
+	case errors.Is(err, ErrNotTenantMember):
+		// Remembered as well, since users probe tenants they do not belong to
+	case err != nil:
+		return nil, err
+	default:
+		catalog, err := s.permissionCatalog()
+		if err != nil {
+			return nil, err
+		}
+
+		// Role and direct permissions, with wildcards and implications expanded
+		grants := append(append([]models.Permission{}, user.Role.Permissions...), user.Permissions...)
+		expanded := expandPermissions(grants, catalog)
+
+		set.Email = user.Email
+		set.RoleName = user.Role.Name
+		set.TenantID = user.TenantID
+		set.Member = true
+		set.Permissions = make(map[string]grantedPermission, len(expanded))
+		for _, perm := range expanded {
+			set.Permissions[perm.Name] = grantedPermission{Scope: perm.Scope, IsSystem: perm.IsSystemPermission}
+		}
+	}
+
+	userPermissionCache.put(ctx, userID, tenantID, version, set)
+	return set, nil
+}
+
+// CheckAPIKeyPermission checks a request made with an API key. The key must have been
+// granted the permission and the user it acts for must still hold it in the key's tenant.
+func (s *RBACService) CheckAPIKeyPermission(apiKey *models.APIKey, permission string, tenantID *uuid.UUID, resource *ResourceRef) (bool, error) {
+	if tenantID == nil || *tenantID != apiKey.TenantID {
+		return false, nil
+	}
+
+	perm, err := s.findCoveredPermission(apiKey.Permissions, permission)
+	if err != nil || perm == nil {
+		return false, err
+	}
+
+	return s.CheckUserPermission(apiKey.UserID, permission, tenantID, resource)
+}
+
+// ExpandPermissions returns the sorted names of all permissions the grants cover, including
+// the ones granted through wildcards and implications
+func (s *RBACService) ExpandPermissions(grants []models.Permission) ([]string, error) {
+	catalog, err := s.permissionCatalog()
+	if err != nil {
+		return nil, err
+	}
+
+	expanded := expandPermissions(grants, catalog)
+	names := make([]string, len(expanded))
+	for i, perm := range expanded {
+		names[i] = perm.Name
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// findCoveredPermission returns the named permission if the grants cover it
+func (s *RBACService) findCoveredPermission(grants []models.Permission, permission string) (*models.Permission, error) {
+	// Exact grants need no expansion
+	for i := range grants {
+		if grants[i].Name == permission {
+			return &grants[i], nil
+		}
+	}
+
+	catalog, err := s.permissionCatalog()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, perm := range expandPermissions(grants, catalog) {
 		if perm.Name == permission {
-			if perm.IsSystemPermission && user.TenantID == nil {
-				return true, nil
-			}
-			if !perm.IsSystemPermission && user.TenantID != nil && tenantID != nil && *user.TenantID == *tenantID {
-				return true, nil
-			}
+			return &perm, nil
 		}
 	}
+	return nil, nil
+}
 
-	return false, nil
+// permissionCatalog loads every permission wildcards and implications can expand to
+func (s *RBACService) permissionCatalog() ([]models.Permission, error) {
+	var catalog []models.Permission
+	if err := s.db.Find(&catalog).Error; err != nil {
+		return nil, fmt.Errorf("failed to load permissions: %w", err)
+	}
+	return catalog, nil
 }
 
-// GetUserPermissions returns all permissions for a user
-func (s *RBACService) GetUserPermissions(userID uuid.UUID) ([]string, error) {
+// loadUserInTenant loads a user with the role they hold in the given tenant. A nil tenant
+// keeps the user's primary tenant and role.
+func (s *RBACService) loadUserInTenant(userID uuid.UUID, tenantID *uuid.UUID) (*models.User, error) {
 	var user models.User
 	err := s.db.Preload("Role").Preload("Role.Permissions").Preload("Permissions").First(&user, "id = ?", userID).Error
 	if err != nil {
 		return nil, fmt.Errorf("failed to find user: %w", err)
 	}
 
-	permissionSet := make(map[string]bool)
-
-	// Add role permissions
-	for _, perm := range user.Role.Permissions {
-		permissionSet[perm.Name] = true
+	if tenantID != nil {
+		if err := NewTenantMembershipService(s.db).ActivateTenant(&user, *tenantID); err != nil {
+			return nil, err
+		}
 	}
 
-	// Add direct permissions
-	for _, perm := range user.Permissions {
-		permissionSet[perm.Name] = true
-	}
+	return &user, nil
+}
 
-	// Convert to slice
-	permissions := make([]string, 0, len(permissionSet))
-	for perm := range permissionSet {
-		permissions = append(permissions, perm)
+// GetUserPermissions returns all permissions for a user in their primary tenant
+func (s *RBACService) GetUserPermissions(userID uuid.UUID) ([]string, error) {
+	return s.GetUserPermissionsInTenant(userID, nil)
+}
+
+// GetUserPermissionsInTenant returns all permissions a user holds in the given tenant
+func (s *RBACService) GetUserPermissionsInTenant(userID uuid.UUID, tenantID *uuid.UUID) ([]string, error) {
+	set, err := s.userPermissionSet(userID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if !set.Member {
+		return nil, ErrNotTenantMember
 	}
 
-	return permissions, nil
+	names := make([]string, 0, len(set.Permissions))
+	for name := range set.Permissions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
 }
 
 // CreateCustomRole creates a new custom role for a tenant
@@ -212,6 +447,9 @@ func (s *RBACService) CreateCustomRole(tenantID *uuid.UUID, name, description st
 	if len(permissions) != len(permissionNames) {
 		return nil, errors.New("some permissions not found")
 	}
+	if tenantID != nil && hasSystemPermission(permissions) {
+		return nil, ErrSystemPermissionScope
+	}
 
 	// Create role
 	role := models.Role{
@@ -260,7 +498,16 @@ func (s *RBACService) AssignRoleToUser(userID, roleID uuid.UUID) error {
 
 	// Update user role
 	user.RoleID = roleID
-	if err := s.db.Save(&user).Error; err != nil {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&user).Error; err != nil {
+			return err
+		}
+		if user.TenantID != nil {
+			return saveMembership(tx, user.ID, *user.TenantID, roleID)
+		}
+		return nil
+	})
+	if err != nil {
 		return fmt.Errorf("failed to assign role: %w", err)
 	}
 
@@ -288,6 +535,15 @@ func (s *RBACService) GetRolesByTenant(tenantID *uuid.UUID) ([]models.Role, erro
 }
 
 // Helper functions
+func hasSystemPermission(permissions []models.Permission) bool {
+	for _, perm := range permissions {
+		if perm.IsSystemPermission {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *RBACService) isSystemRole(role models.SystemRole) bool {
 	systemRoles := []models.SystemRole{
 		models.SystemRoleSuperAdmin,
@@ -340,6 +596,54 @@ func (s *RBACService) ValidateTenantPermission(permission string) bool {
 	return false
 }
 
+// expandPermissions returns the grants and the permissions of the catalog they cover.
+// Wildcards and implications only reach permissions of their own kind, so system grants
+// never expand into tenant permissions or the other way around.
+func expandPermissions(grants []models.Permission, catalog []models.Permission) []models.Permission {
+	implications := models.GetPermissionImplications()
+
+	expanded := make([]models.Permission, 0, len(grants))
+	seen := make(map[string]bool)
+	for _, grant := range grants {
+		if !seen[grant.Name] {
+			seen[grant.Name] = true
+			expanded = append(expanded, grant)
+		}
+	}
+
+	// Permissions reached through an implication may imply further ones
+	for i := 0; i < len(expanded); i++ {
+		grant := expanded[i]
+		patterns := append([]string{grant.Name}, implications[grant.Name]...)
+		for _, perm := range catalog {
+			if seen[perm.Name] || perm.IsSystemPermission != grant.IsSystemPermission {
+				continue
+			}
+			for _, pattern := range patterns {
+				if permissionMatches(pattern, perm.Name) {
+					seen[perm.Name] = true
+					expanded = append(expanded, perm)
+					break
+				}
+			}
+		}
+	}
+
+	return expanded
+}
+
+// permissionMatches reports whether a permission name, which may be a wildcard, covers
+// another permission
+func permissionMatches(pattern, permission string) bool {
+	if pattern == models.PermissionWildcard || pattern == permission {
+		return true
+	}
+	if resource, ok := strings.CutSuffix(pattern, "."+models.PermissionWildcard); ok {
+		return strings.HasPrefix(permission, resource+".")
+	}
+	return false
+}
+
 // Resource and action helpers
 func (s *RBACService) BuildPermissionName(resource models.ResourceType, action models.ActionType) string {
 	return fmt.Sprintf("%s.%s", resource, action)
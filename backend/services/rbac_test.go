@@ -0,0 +1,123 @@
+package services
+
+import (
+	"slices"
+	"testing"
+
+	"golang_saas/models"
+)
+
+func TestPermissionMatches(t *testing.T) {
+	tests := []struct {
+		pattern    string
+		permission string
+		want       bool
+	}{
+		{"tenant_user.read", "tenant_user.read", true},
+		{"tenant_user.read", "tenant_user.update", false},
+		{"tenant_user.*", "tenant_user.read", true},
+		{"tenant_user.*", "tenant_user.*", true},
+		{"tenant_user.*", "tenant_user", false},
+		{"tenant_user.*", "tenant_role.read", false},
+		{"tenant.*", "tenant.read", true},
+		{"tenant.*", "tenant_user.read", false},
+		{"system.*", "system.view", true},
+		{"system.*", "system_user.read", false},
+		{"system.*", "system_role.update", false},
+		{"*", "tenant_user.read", true},
+		{"*", "system_user.read", true},
+		{"*.read", "tenant_user.read", false},
+		{"tenant_user.re*", "tenant_user.read", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.permission, func(t *testing.T) {
+			if got := permissionMatches(tt.pattern, tt.permission); got != tt.want {
+				t.Errorf("permissionMatches(%q, %q) = %v, want %v", tt.pattern, tt.permission, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandPermissions(t *testing.T) {
+	systemPermission := func(name string) models.Permission {
+		return models.Permission{Name: name, IsSystemPermission: true}
+	}
+	tenantPermission := func(name string) models.Permission {
+		return models.Permission{Name: name}
+	}
+
+	catalog := []models.Permission{
+		systemPermission("*"),
+		systemPermission("system.manage"),
+		systemPermission("system.view"),
+		systemPermission("system_user.read"),
+		systemPermission("system_user.update"),
+		systemPermission("system_role.update"),
+		systemPermission("system_setting.update"),
+		systemPermission("tenant.create"),
+		tenantPermission("*"),
+		tenantPermission("tenant_user.*"),
+		tenantPermission("tenant_user.read"),
+		tenantPermission("tenant_user.update"),
+		tenantPermission("tenant_role.read"),
+		tenantPermission("customer.read"),
+	}
+
+	tests := []struct {
+		name   string
+		grants []models.Permission
+		want   []string
+	}{
+		{
+			name:   "resource wildcard",
+			grants: []models.Permission{tenantPermission("tenant_user.*")},
+			want:   []string{"tenant_user.*", "tenant_user.read", "tenant_user.update"},
+		},
+		{
+			name:   "plain permission",
+			grants: []models.Permission{tenantPermission("tenant_user.read")},
+			want:   []string{"tenant_user.read"},
+		},
+		{
+			name:   "tenant wildcard stays out of system permissions",
+			grants: []models.Permission{tenantPermission("*")},
+			want:   []string{"*", "customer.read", "tenant_role.read", "tenant_user.*", "tenant_user.read", "tenant_user.update"},
+		},
+		{
+			name:   "system wildcard stays out of tenant permissions",
+			grants: []models.Permission{systemPermission("*")},
+			want: []string{"*", "system.manage", "system.view", "system_role.update", "system_setting.update",
+				"system_user.read", "system_user.update", "tenant.create"},
+		},
+		{
+			name:   "system.manage implies system management",
+			grants: []models.Permission{systemPermission("system.manage")},
+			want: []string{"system.manage", "system.view", "system_role.update", "system_setting.update",
+				"system_user.read", "system_user.update"},
+		},
+		{
+			name:   "system permissions without implications",
+			grants: []models.Permission{systemPermission("system.view"), systemPermission("system_user.read")},
+			want:   []string{"system.view", "system_user.read"},
+		},
+		{
+			name:   "duplicate grants",
+			grants: []models.Permission{tenantPermission("tenant_user.read"), tenantPermission("tenant_user.read")},
+			want:   []string{"tenant_user.read"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, permission := range expandPermissions(tt.grants, catalog) {
+				got = append(got, permission.Name)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expandPermissions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
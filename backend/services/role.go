@@ -0,0 +1,403 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang_saas/graph/model"
+	"golang_saas/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrRoleNotFound          = errors.New("role not found")
+	ErrPermissionNotFound    = errors.New("permission not found")
+	ErrBuiltInRole           = errors.New("built-in roles cannot be changed")
+	ErrRoleNameTaken         = errors.New("a role with this name already exists")
+	ErrRoleHasUsers          = errors.New("role is still assigned to users; choose a role to reassign them to")
+	ErrInvalidReassignRole   = errors.New("users can only be reassigned to another role of the same tenant")
+	ErrSystemPermissionScope = errors.New("system permissions cannot be granted to tenant roles")
+)
+
+// RoleService manages the roles tenant and system administrators define on top of the
+// built-in ones
+type RoleService struct {
+	db *gorm.DB
+}
+
+func NewRoleService(db *gorm.DB) *RoleService {
+	return &RoleService{db: db}
+}
+
+// CreateRole creates a custom role. The permissions must exist, tenant roles cannot hold
+// system permissions, and tenant administrators cannot grant permissions they do not hold.
+func (s *RoleService) CreateRole(ctx context.Context, actor *models.User, input model.CreateRoleInput) (*models.Role, error) {
+	var tenantID *uuid.UUID
+	if input.TenantID != nil {
+		id, err := uuid.Parse(*input.TenantID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tenant ID: %v", err)
+		}
+		tenantID = &id
+	}
+
+	name := strings.TrimSpace(input.Name)
+	if err := s.validateRoleName(tenantID, name, uuid.Nil); err != nil {
+		return nil, err
+	}
+
+	permissionNames, err := s.resolvePermissions(actor, tenantID, input.PermissionIds)
+	if err != nil {
+		return nil, err
+	}
+
+	description := ""
+	if input.Description != nil {
+		description = *input.Description
+	}
+
+	role, err := NewRBACService(s.db).CreateCustomRole(tenantID, name, description, permissionNames)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetRole(role.ID)
+}
+
+// UpdateRole renames a custom role or replaces its permissions. Built-in roles are
+// protected so every tenant keeps the same baseline.
+func (s *RoleService) UpdateRole(ctx context.Context, actor *models.User, id uuid.UUID, input model.UpdateRoleInput) (*models.Role, error) {
+	role, err := s.GetRole(id)
+	if err != nil {
+		return nil, err
+	}
+	if isBuiltInRole(role) {
+		return nil, ErrBuiltInRole
+	}
+
+	updates := map[string]any{}
+	if input.Name != nil {
+		name := strings.TrimSpace(*input.Name)
+		if err := s.validateRoleName(role.TenantID, name, role.ID); err != nil {
+			return nil, err
+		}
+		updates["name"] = name
+	}
+	if input.Description != nil {
+		updates["description"] = *input.Description
+	}
+
+	var permissionNames []string
+	if input.PermissionIds != nil {
+		permissionNames, err = s.resolvePermissions(actor, role.TenantID, input.PermissionIds)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(updates) > 0 {
+		if err := s.db.Model(role).Updates(updates).Error; err != nil {
+			return nil, fmt.Errorf("failed to update role: %v", err)
+		}
+	}
+	if input.PermissionIds != nil {
+		if err := NewRBACService(s.db).AssignPermissionsToRole(role.ID, permissionNames); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.GetRole(role.ID)
+}
+
+// DeleteRole deletes a custom role. Users, tenant members and pending invitations that
+// still hold the role are moved to reassignTo, which is required when there are any.
+func (s *RoleService) DeleteRole(ctx context.Context, id uuid.UUID, reassignTo *uuid.UUID) error {
+	role, err := s.GetRole(id)
+	if err != nil {
+		return err
+	}
+	if isBuiltInRole(role) {
+		return ErrBuiltInRole
+	}
+
+	var target *models.Role
+	if reassignTo != nil {
+		target, err = s.GetRole(*reassignTo)
+		if err != nil {
+			return err
+		}
+		if target.ID == role.ID || !sameTenant(target.TenantID, role.TenantID) {
+			return ErrInvalidReassignRole
+		}
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		holders, err := roleHolderCount(tx, role.ID)
+		if err != nil {
+			return err
+		}
+		if holders > 0 && target == nil {
+			return ErrRoleHasUsers
+		}
+
+		if target != nil {
+			if err := tx.Model(&models.User{}).Where("role_id = ?", role.ID).Update("role_id", target.ID).Error; err != nil {
+				return fmt.Errorf("failed to reassign users: %v", err)
+			}
+			if err := tx.Model(&models.TenantUser{}).Where("role_id = ?", role.ID).Update("role_id", target.ID).Error; err != nil {
+				return fmt.Errorf("failed to reassign tenant members: %v", err)
+			}
+			err = tx.Model(&models.Invitation{}).
+				Where("role_id = ? AND accepted_at IS NULL AND revoked_at IS NULL", role.ID).
+				Update("role_id", target.ID).Error
+			if err != nil {
+				return fmt.Errorf("failed to reassign invitations: %v", err)
+			}
+		}
+
+		// Identity providers fall back to the tenant's default role without one
+		var defaultRoleID *uuid.UUID
+		if target != nil {
+			defaultRoleID = &target.ID
+		}
+		for _, provider := range []any{&models.TenantOIDCProvider{}, &models.TenantSAMLProvider{}} {
+			if err := tx.Model(provider).Where("default_role_id = ?", role.ID).Update("default_role_id", defaultRoleID).Error; err != nil {
+				return fmt.Errorf("failed to update identity provider default role: %v", err)
+			}
+		}
+
+		if err := tx.Model(role).Association("Permissions").Clear(); err != nil {
+			return fmt.Errorf("failed to clear role permissions: %v", err)
+		}
+		if err := tx.Delete(&models.Role{}, "id = ?", role.ID).Error; err != nil {
+			return fmt.Errorf("failed to delete role: %v", err)
+		}
+		return nil
+	})
+}
+
+// GetRole returns a role with its permissions and tenant
+func (s *RoleService) GetRole(id uuid.UUID) (*models.Role, error) {
+	var role models.Role
+	if err := s.db.Preload("Permissions").Preload("Tenant").First(&role, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRoleNotFound
+		}
+		return nil, err
+	}
+	return &role, nil
+}
+
+// ListRoles returns a tenant's roles, or the system roles when tenantID is nil
+func (s *RoleService) ListRoles(ctx context.Context, tenantID *uuid.UUID, pagination *model.PaginationInput) (*model.PaginatedRoles, error) {
+	query := s.db.Model(&models.Role{}).Where("tenant_id IS NULL")
+	if tenantID != nil {
+		query = s.db.Model(&models.Role{}).Where("tenant_id = ?", *tenantID)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, fmt.Errorf("failed to count roles: %v", err)
+	}
+
+	page, limit := paginationValues(pagination)
+	var roles []*models.Role
+	err := query.Preload("Permissions").Preload("Tenant").
+		Order("is_system_role DESC, name").
+		Offset(int((page - 1) * limit)).Limit(int(limit)).
+		Find(&roles).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list roles: %v", err)
+	}
+
+	return &model.PaginatedRoles{
+		Roles:      roles,
+		Total:      int32(total),
+		Page:       page,
+		Limit:      limit,
+		TotalPages: int32((total + int64(limit) - 1) / int64(limit)),
+	}, nil
+}
+
+// GetPermission returns a permission by ID
+func (s *RoleService) GetPermission(id uuid.UUID) (*models.Permission, error) {
+	var permission models.Permission
+	if err := s.db.First(&permission, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPermissionNotFound
+		}
+		return nil, err
+	}
+	return &permission, nil
+}
+
+// ListPermissions returns the permissions roles can be built from, optionally only the
+// system or only the tenant ones
+func (s *RoleService) ListPermissions(ctx context.Context, isSystem *bool, pagination *model.PaginationInput) (*model.PaginatedPermissions, error) {
+	query := s.db.Model(&models.Permission{})
+	if isSystem != nil {
+		query = query.Where("is_system_permission = ?", *isSystem)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, fmt.Errorf("failed to count permissions: %v", err)
+	}
+
+	page, limit := paginationValues(pagination)
+	var permissions []*models.Permission
+	err := query.Order("resource, action").
+		Offset(int((page - 1) * limit)).Limit(int(limit)).
+		Find(&permissions).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list permissions: %v", err)
+	}
+
+	return &model.PaginatedPermissions{
+		Permissions: permissions,
+		Total:       int32(total),
+		Page:        page,
+		Limit:       limit,
+		TotalPages:  int32((total + int64(limit) - 1) / int64(limit)),
+	}, nil
+}
+
+// GetRolePermissionMatrix lists the permission names of every role of a tenant, or of the
+// system roles when tenantID is nil
+func (s *RoleService) GetRolePermissionMatrix(ctx context.Context, tenantID *uuid.UUID) ([]*model.RolePermissionMatrix, error) {
+	roles, err := NewRBACService(s.db).GetRolesByTenant(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	matrix := make([]*model.RolePermissionMatrix, len(roles))
+	for i, role := range roles {
+		permissions := make([]string, len(role.Permissions))
+		for j, permission := range role.Permissions {
+			permissions[j] = permission.Name
+		}
+		matrix[i] = &model.RolePermissionMatrix{Role: role.Name, Permissions: permissions}
+	}
+	return matrix, nil
+}
+
+// resolvePermissions maps permission IDs to names for a role of the given tenant
+func (s *RoleService) resolvePermissions(actor *models.User, tenantID *uuid.UUID, ids []string) ([]string, error) {
+	permissionIDs := make([]uuid.UUID, 0, len(ids))
+	for _, id := range uniqueStrings(ids) {
+		permissionID, err := uuid.Parse(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid permission ID: %v", err)
+		}
+		permissionIDs = append(permissionIDs, permissionID)
+	}
+
+	var permissions []models.Permission
+	if len(permissionIDs) > 0 {
+		if err := s.db.Where("id IN ?", permissionIDs).Find(&permissions).Error; err != nil {
+			return nil, fmt.Errorf("failed to find permissions: %v", err)
+		}
+	}
+	if len(permissions) != len(permissionIDs) {
+		return nil, ErrPermissionNotFound
+	}
+
+	// Administrators may only grant the permissions they hold in their own tenant; system
+	// users managing a tenant were already allowed to by tenant.manage
+	rbacService := NewRBACService(s.db)
+	names := make([]string, len(permissions))
+	for i, permission := range permissions {
+		if permission.IsSystemPermission && tenantID != nil {
+			return nil, ErrSystemPermissionScope
+		}
+
+		if sameTenant(actor.TenantID, tenantID) {
+			hasPermission, err := rbacService.HoldsPermission(actor.ID, permission.Name, tenantID)
+			if err != nil {
+				return nil, err
+			}
+			if !hasPermission {
+				return nil, fmt.Errorf("you do not have the %s permission", permission.Name)
+			}
+		}
+		names[i] = permission.Name
+	}
+	return names, nil
+}
+
+// validateRoleName checks that a name is free among the roles of the tenant (or the system
+// roles) and does not impersonate a built-in role
+func (s *RoleService) validateRoleName(tenantID *uuid.UUID, name string, excludeID uuid.UUID) error {
+	if name == "" {
+		return errors.New("name is required")
+	}
+	if isDefaultTenantRole(name) || NewRBACService(s.db).isSystemRole(models.SystemRole(name)) {
+		return ErrRoleNameTaken
+	}
+
+	query := s.db.Model(&models.Role{}).Where("name = ? AND id <> ?", name, excludeID)
+	if tenantID != nil {
+		query = query.Where("tenant_id = ?", *tenantID)
+	} else {
+		query = query.Where("tenant_id IS NULL")
+	}
+
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check role name: %v", err)
+	}
+	if count > 0 {
+		return ErrRoleNameTaken
+	}
+	return nil
+}
+
+// roleHolderCount counts the users, tenant members and pending invitations holding a role
+func roleHolderCount(db *gorm.DB, roleID uuid.UUID) (int64, error) {
+	var users, members, invitations int64
+	if err := db.Model(&models.User{}).Where("role_id = ?", roleID).Count(&users).Error; err != nil {
+		return 0, fmt.Errorf("failed to count role users: %v", err)
+	}
+	if err := db.Model(&models.TenantUser{}).Where("role_id = ?", roleID).Count(&members).Error; err != nil {
+		return 0, fmt.Errorf("failed to count role members: %v", err)
+	}
+	err := db.Model(&models.Invitation{}).
+		Where("role_id = ? AND accepted_at IS NULL AND revoked_at IS NULL", roleID).
+		Count(&invitations).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to count role invitations: %v", err)
+	}
+	return users + members + invitations, nil
+}
+
+// isBuiltInRole reports whether a role is one of the system roles or a default tenant role
+func isBuiltInRole(role *models.Role) bool {
+	return role.IsSystemRole || (role.TenantID != nil && isDefaultTenantRole(role.Name))
+}
+
+// sameTenant reports whether two roles belong to the same tenant, or are both system roles
+func sameTenant(a, b *uuid.UUID) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// paginationValues returns the page and page size requested, defaulting to the first page
+// of ten
+func paginationValues(pagination *model.PaginationInput) (int32, int32) {
+	page := int32(1)
+	limit := int32(10)
+	if pagination != nil {
+		if pagination.Page != nil && *pagination.Page > 0 {
+			page = *pagination.Page
+		}
+		if pagination.Limit != nil && *pagination.Limit > 0 {
+			limit = *pagination.Limit
+		}
+	}
+	return page, limit
+}
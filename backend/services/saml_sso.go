@@ -0,0 +1,531 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang_saas/config"
+	"golang_saas/graph/model"
+	"golang_saas/models"
+	"golang_saas/utils"
+
+	"github.com/crewjam/saml"
+	"github.com/google/uuid"
+	xrv "github.com/mattermost/xml-roundtrip-validator"
+	dsig "github.com/russellhaering/goxmldsig"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// samlCertificateValidity is how long the generated SP certificates are valid.
+// IdPs pin the certificate from the metadata, so it is long lived.
+const samlCertificateValidity = 10 * 365 * 24 * time.Hour
+
+// samlTenantOrigin is the tenant subdomain that hosts its SAML service provider, e.g. https://acme.zplus.vn
+func samlTenantOrigin(tenant *models.Tenant) string {
+	scheme := "https"
+	if publicURL, err := url.Parse(config.AppConfig.PublicURL); err == nil && publicURL.Scheme != "" {
+		scheme = publicURL.Scheme
+	}
+	return fmt.Sprintf("%s://%s.%s", scheme, tenant.Subdomain, config.AppConfig.AppDomain)
+}
+
+// samlMetadataURL serves the SP metadata and doubles as the SP entity ID
+func samlMetadataURL(tenant *models.Tenant) string {
+	return samlTenantOrigin(tenant) + "/api/v1/auth/saml/metadata"
+}
+
+// samlACSURL is the assertion consumer service the IdP posts responses to
+func samlACSURL(tenant *models.Tenant) string {
+	return samlTenantOrigin(tenant) + "/api/v1/auth/saml/acs"
+}
+
+// samlLoginURL starts an SP-initiated SAML login for the tenant
+func samlLoginURL(tenant *models.Tenant) string {
+	return samlTenantOrigin(tenant) + "/api/v1/auth/saml/login"
+}
+
+// subdomainFromHost extracts the tenant subdomain from a request host such as acme.zplus.vn
+func subdomainFromHost(host string) string {
+	host = strings.ToLower(host)
+	appDomain := strings.ToLower(config.AppConfig.AppDomain)
+
+	// The app domain carries a port in development; compare hosts only
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	if hostname, _, err := net.SplitHostPort(appDomain); err == nil {
+		appDomain = hostname
+	}
+
+	subdomain, ok := strings.CutSuffix(host, "."+appDomain)
+	if !ok || subdomain == "" || strings.Contains(subdomain, ".") {
+		return ""
+	}
+	return subdomain
+}
+
+// activeTenantByHost loads the SSO-entitled tenant whose subdomain the request was made on
+func (s *SSOService) activeTenantByHost(host string) (*models.Tenant, error) {
+	subdomain := subdomainFromHost(host)
+	if subdomain == "" {
+		return nil, errors.New("tenant not found")
+	}
+
+	return s.activeTenant("subdomain = ?", subdomain)
+}
+
+// parseIdPMetadata parses IdP metadata, which may be a single EntityDescriptor or an EntitiesDescriptor
+func parseIdPMetadata(data []byte) (*saml.EntityDescriptor, error) {
+	if err := xrv.Validate(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("invalid IdP metadata: %v", err)
+	}
+
+	var entity saml.EntityDescriptor
+	if err := xml.Unmarshal(data, &entity); err != nil {
+		var entities saml.EntitiesDescriptor
+		if xml.Unmarshal(data, &entities) != nil {
+			return nil, fmt.Errorf("invalid IdP metadata: %v", err)
+		}
+
+		found := false
+		for _, candidate := range entities.EntityDescriptors {
+			if len(candidate.IDPSSODescriptors) > 0 {
+				entity = candidate
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errors.New("IdP metadata does not describe an identity provider")
+		}
+	}
+
+	if len(entity.IDPSSODescriptors) == 0 {
+		return nil, errors.New("IdP metadata does not describe an identity provider")
+	}
+	if entity.EntityID == "" {
+		return nil, errors.New("IdP metadata has no entity ID")
+	}
+
+	return &entity, nil
+}
+
+// serviceProvider builds the tenant's SAML service provider. IDPMetadata is nil until the IdP metadata is uploaded.
+func (s *SSOService) serviceProvider(tenant *models.Tenant, provider *models.TenantSAMLProvider) (*saml.ServiceProvider, error) {
+	keyPEM, err := utils.DecryptString(provider.SPPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt SP key: %v", err)
+	}
+	signer, err := utils.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SP key: %v", err)
+	}
+	key, ok := signer.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("SP key is not an RSA key")
+	}
+
+	certificate, err := utils.ParseCertificatePEM(provider.SPCertificate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SP certificate: %v", err)
+	}
+
+	metadataURL, err := url.Parse(samlMetadataURL(tenant))
+	if err != nil {
+		return nil, err
+	}
+	acsURL, err := url.Parse(samlACSURL(tenant))
+	if err != nil {
+		return nil, err
+	}
+
+	sp := &saml.ServiceProvider{
+		EntityID:          metadataURL.String(),
+		Key:               key,
+		Certificate:       certificate,
+		MetadataURL:       *metadataURL,
+		AcsURL:            *acsURL,
+		AuthnNameIDFormat: saml.UnspecifiedNameIDFormat,
+		SignatureMethod:   dsig.RSASHA256SignatureMethod,
+	}
+
+	if provider.IdPMetadata != "" {
+		if sp.IDPMetadata, err = parseIdPMetadata([]byte(provider.IdPMetadata)); err != nil {
+			return nil, err
+		}
+	}
+
+	return sp, nil
+}
+
+// GetSAMLProvider returns the tenant's SAML provider configuration, or nil if none is set up
+func (s *SSOService) GetSAMLProvider(ctx context.Context, tenantID uuid.UUID) (*model.TenantSamlProvider, error) {
+	var provider models.TenantSAMLProvider
+	err := s.db.Preload("Tenant").Where("tenant_id = ?", tenantID).First(&provider).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return s.convertSAMLProviderToGraphQL(&provider)
+}
+
+// ConfigureSAMLProvider creates or updates the tenant's SAML provider. The SP key pair is
+// generated on first use so the SP metadata can be handed to the IdP before its own
+// metadata is uploaded; the provider cannot be enabled until then.
+func (s *SSOService) ConfigureSAMLProvider(ctx context.Context, tenantID uuid.UUID, input model.SamlProviderInput) (*model.TenantSamlProvider, error) {
+	if err := s.requireSSOFeature(tenantID); err != nil {
+		return nil, err
+	}
+
+	var tenant models.Tenant
+	if err := s.db.First(&tenant, "id = ?", tenantID).Error; err != nil {
+		return nil, errors.New("tenant not found")
+	}
+
+	var provider models.TenantSAMLProvider
+	err := s.db.Where("tenant_id = ?", tenantID).First(&provider).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	isNew := errors.Is(err, gorm.ErrRecordNotFound)
+
+	provider.TenantID = tenantID
+	if isNew {
+		certificatePEM, keyPEM, err := utils.GenerateSelfSignedCertificate(samlMetadataURL(&tenant), samlCertificateValidity)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate SP certificate: %v", err)
+		}
+		if provider.SPPrivateKey, err = utils.EncryptString(keyPEM); err != nil {
+			return nil, fmt.Errorf("failed to encrypt SP key: %v", err)
+		}
+		provider.SPCertificate = certificatePEM
+	}
+
+	if input.IdpMetadataXML != nil {
+		metadataXML := strings.TrimSpace(*input.IdpMetadataXML)
+		if metadataXML == "" {
+			provider.IdPMetadata = ""
+			provider.IdPEntityID = ""
+		} else {
+			entity, err := parseIdPMetadata([]byte(metadataXML))
+			if err != nil {
+				return nil, err
+			}
+			provider.IdPMetadata = metadataXML
+			provider.IdPEntityID = entity.EntityID
+		}
+	}
+
+	attributeMappings, groupRoleMappings := ssoMappingsFromInput(input.AttributeMappings, input.GroupRoleMappings)
+
+	provider.DefaultRoleID = nil
+	if input.DefaultRoleID != nil {
+		roleUUID, err := uuid.Parse(*input.DefaultRoleID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid default role ID: %v", err)
+		}
+		provider.DefaultRoleID = &roleUUID
+	}
+
+	if err := s.validateRoleMappings(tenantID, groupRoleMappings, provider.DefaultRoleID); err != nil {
+		return nil, err
+	}
+
+	attributeMappingsJSON, err := json.Marshal(attributeMappings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal attribute mappings: %v", err)
+	}
+	provider.AttributeMappings = datatypes.JSON(attributeMappingsJSON)
+
+	groupRoleMappingsJSON, err := json.Marshal(groupRoleMappings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal group role mappings: %v", err)
+	}
+	provider.GroupRoleMappings = datatypes.JSON(groupRoleMappingsJSON)
+
+	if input.IsEnabled != nil {
+		provider.IsEnabled = *input.IsEnabled
+	} else if isNew {
+		provider.IsEnabled = provider.IdPMetadata != ""
+	}
+	if provider.IsEnabled && provider.IdPMetadata == "" {
+		return nil, errors.New("IdP metadata is required to enable SAML single sign-on")
+	}
+
+	// Validate the complete configuration before saving it
+	sp, err := s.serviceProvider(&tenant, &provider)
+	if err != nil {
+		return nil, err
+	}
+	if sp.IDPMetadata != nil && sp.GetSSOBindingLocation(saml.HTTPRedirectBinding) == "" {
+		return nil, errors.New("identity provider does not support the HTTP-Redirect binding")
+	}
+
+	if err := s.db.Save(&provider).Error; err != nil {
+		return nil, fmt.Errorf("failed to save SAML provider: %v", err)
+	}
+
+	provider.Tenant = tenant
+	return s.convertSAMLProviderToGraphQL(&provider)
+}
+
+// DeleteSAMLProvider removes the tenant's SAML provider. Linked identities are kept so
+// users are matched again if the provider is set up once more; the IdP will need the new SP certificate.
+func (s *SSOService) DeleteSAMLProvider(ctx context.Context, tenantID uuid.UUID) error {
+	result := s.db.Where("tenant_id = ?", tenantID).Delete(&models.TenantSAMLProvider{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete SAML provider: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrSSONotConfigured
+	}
+
+	return nil
+}
+
+// samlProvider loads the tenant's SAML provider along with its service provider.
+// Unless includeDisabled is set, only an enabled provider with IdP metadata is returned.
+func (s *SSOService) samlProvider(tenant *models.Tenant, includeDisabled bool) (*models.TenantSAMLProvider, *saml.ServiceProvider, error) {
+	query := s.db.Where("tenant_id = ?", tenant.ID)
+	if !includeDisabled {
+		query = query.Where("is_enabled = ?", true)
+	}
+
+	var provider models.TenantSAMLProvider
+	if err := query.First(&provider).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrSSONotConfigured
+		}
+		return nil, nil, err
+	}
+
+	sp, err := s.serviceProvider(tenant, &provider)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !includeDisabled && sp.IDPMetadata == nil {
+		return nil, nil, ErrSSONotConfigured
+	}
+
+	return &provider, sp, nil
+}
+
+// SAMLMetadata returns the SP metadata of the tenant the request host belongs to
+func (s *SSOService) SAMLMetadata(ctx context.Context, host string) ([]byte, error) {
+	tenant, err := s.activeTenantByHost(host)
+	if err != nil {
+		return nil, err
+	}
+
+	_, sp, err := s.samlProvider(tenant, true)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := xml.MarshalIndent(sp.Metadata(), "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal SP metadata: %v", err)
+	}
+
+	return append([]byte(xml.Header), metadata...), nil
+}
+
+// BeginSAMLLogin returns the IdP URL carrying a signed AuthnRequest. The request ID is
+// kept with the login state so the response can only be accepted once, for this request.
+func (s *SSOService) BeginSAMLLogin(ctx context.Context, host string) (string, error) {
+	return s.beginSAML(ctx, host, nil)
+}
+
+// BeginSAMLLink returns the IdP URL for linking the user's existing account to their
+// identity at the tenant's SAML IdP
+func (s *SSOService) BeginSAMLLink(ctx context.Context, host string, userID uuid.UUID) (string, error) {
+	return s.beginSAML(ctx, host, &userID)
+}
+
+func (s *SSOService) beginSAML(ctx context.Context, host string, linkUserID *uuid.UUID) (string, error) {
+	tenant, err := s.activeTenantByHost(host)
+	if err != nil {
+		return "", err
+	}
+
+	provider, sp, err := s.samlProvider(tenant, false)
+	if err != nil {
+		return "", err
+	}
+
+	idpURL := sp.GetSSOBindingLocation(saml.HTTPRedirectBinding)
+	if idpURL == "" {
+		return "", errors.New("identity provider does not support the HTTP-Redirect binding")
+	}
+
+	authnRequest, err := sp.MakeAuthenticationRequest(idpURL, saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		return "", fmt.Errorf("failed to create authentication request: %v", err)
+	}
+
+	relayState, err := s.createLoginState(tenant.ID, provider.ID, models.SSOProtocolSAML, authnRequest.ID, "", linkUserID)
+	if err != nil {
+		return "", err
+	}
+
+	redirectURL, err := authnRequest.Redirect(relayState, sp)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign authentication request: %v", err)
+	}
+
+	return redirectURL.String(), nil
+}
+
+// CompleteSAMLLogin handles a response posted to the ACS: it validates the signed assertion,
+// provisions the user and returns a one-time ticket for the frontend. IdP-initiated logins
+// carry no relay state and are rejected.
+func (s *SSOService) CompleteSAMLLogin(ctx context.Context, host, samlResponse, relayState string) (string, error) {
+	loginState, err := s.consumeLoginState(relayState, models.SSOProtocolSAML)
+	if err != nil {
+		return "", err
+	}
+
+	tenant, err := s.activeTenantByHost(host)
+	if err != nil {
+		return "", err
+	}
+	if tenant.ID != loginState.TenantID {
+		return "", ErrInvalidSSOState
+	}
+
+	provider, sp, err := s.samlProvider(tenant, false)
+	if err != nil {
+		return "", err
+	}
+	if provider.ID != loginState.ProviderID {
+		return "", ErrInvalidSSOState
+	}
+
+	rawResponse, err := base64.StdEncoding.DecodeString(samlResponse)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode SAML response: %v", err)
+	}
+
+	assertion, err := sp.ParseXMLResponse(rawResponse, []string{loginState.Nonce})
+	if err != nil {
+		// The library hides the reason behind a generic message
+		var invalidResponse *saml.InvalidResponseError
+		if errors.As(err, &invalidResponse) {
+			return "", fmt.Errorf("invalid SAML response: %v", invalidResponse.PrivateErr)
+		}
+		return "", fmt.Errorf("invalid SAML response: %v", err)
+	}
+
+	if assertion.Subject == nil || assertion.Subject.NameID == nil || assertion.Subject.NameID.Value == "" {
+		return "", errors.New("SAML assertion has no subject")
+	}
+
+	attributeMappings, groupRoleMappings, err := decodeSSOMappings(defaultSAMLAttributeMappings, provider.AttributeMappings, provider.GroupRoleMappings)
+	if err != nil {
+		return "", err
+	}
+
+	attributes := samlAttributes(assertion)
+	nameID := assertion.Subject.NameID.Value
+
+	email := firstString(attributes[attributeMappings.Email])
+	if email == "" && strings.Contains(nameID, "@") {
+		email = nameID
+	}
+
+	identity := &ssoIdentity{
+		Subject:   nameID,
+		Email:     strings.ToLower(strings.TrimSpace(email)),
+		FirstName: firstString(attributes[attributeMappings.FirstName]),
+		LastName:  firstString(attributes[attributeMappings.LastName]),
+		Groups:    attributes[attributeMappings.Groups],
+	}
+
+	user, err := s.provisionUser(ctx, loginState, models.SSOProtocolSAML, identity, groupRoleMappings, provider.DefaultRoleID)
+	if err != nil {
+		return "", err
+	}
+
+	return s.issueTicket(loginState, user.ID)
+}
+
+// samlAttributes collects the assertion's attribute values by both Name and FriendlyName
+func samlAttributes(assertion *saml.Assertion) map[string][]string {
+	attributes := map[string][]string{}
+	for _, statement := range assertion.AttributeStatements {
+		for _, attribute := range statement.Attributes {
+			values := make([]string, 0, len(attribute.Values))
+			for _, value := range attribute.Values {
+				if value.Value != "" {
+					values = append(values, value.Value)
+				}
+			}
+
+			attributes[attribute.Name] = append(attributes[attribute.Name], values...)
+			if attribute.FriendlyName != "" && attribute.FriendlyName != attribute.Name {
+				attributes[attribute.FriendlyName] = append(attributes[attribute.FriendlyName], values...)
+			}
+		}
+	}
+	return attributes
+}
+
+func firstString(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// convertSAMLProviderToGraphQL converts a stored provider to its GraphQL model
+func (s *SSOService) convertSAMLProviderToGraphQL(provider *models.TenantSAMLProvider) (*model.TenantSamlProvider, error) {
+	attributeMappings, groupRoleMappings, err := decodeSSOMappings(defaultSAMLAttributeMappings, provider.AttributeMappings, provider.GroupRoleMappings)
+	if err != nil {
+		return nil, err
+	}
+	attributes, groups := ssoMappingsToGraphQL(attributeMappings, groupRoleMappings)
+
+	result := &model.TenantSamlProvider{
+		ID:                provider.ID.String(),
+		TenantID:          provider.TenantID.String(),
+		AttributeMappings: attributes,
+		GroupRoleMappings: groups,
+		IsEnabled:         provider.IsEnabled,
+		SpEntityID:        samlMetadataURL(&provider.Tenant),
+		SpAcsURL:          samlACSURL(&provider.Tenant),
+		SpMetadataURL:     samlMetadataURL(&provider.Tenant),
+		SpCertificate:     provider.SPCertificate,
+		LoginURL:          samlLoginURL(&provider.Tenant),
+		CreatedAt:         provider.CreatedAt,
+		UpdatedAt:         provider.UpdatedAt,
+	}
+	if provider.IdPMetadata != "" {
+		if entity, err := parseIdPMetadata([]byte(provider.IdPMetadata)); err == nil {
+			sp := saml.ServiceProvider{IDPMetadata: entity}
+			idpEntityID := entity.EntityID
+			idpSSOURL := sp.GetSSOBindingLocation(saml.HTTPRedirectBinding)
+			result.IdpEntityID = &idpEntityID
+			result.IdpSsoURL = &idpSSOURL
+		}
+	}
+	if provider.DefaultRoleID != nil {
+		defaultRoleID := provider.DefaultRoleID.String()
+		result.DefaultRoleID = &defaultRoleID
+	}
+
+	return result, nil
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"golang_saas/config"
+	"golang_saas/models"
+	"golang_saas/utils"
+
+	"github.com/google/uuid"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestParseSCIMFilter(t *testing.T) {
+	tests := []struct {
+		filter    string
+		attribute string
+		value     string
+		wantErr   bool
+	}{
+		{`userName eq "jane@example.com"`, "userName", "jane@example.com", false},
+		{`USERNAME EQ "Jane@Example.com"`, "USERNAME", "Jane@Example.com", false},
+		{`  externalId   eq   "00u1abc"  `, "externalId", "00u1abc", false},
+		{`emails.value eq "jane@example.com"`, "emails.value", "jane@example.com", false},
+		{`displayName eq "Sales \"EU\""`, "displayName", `Sales "EU"`, false},
+		{`displayName eq "back\\slash"`, "displayName", `back\slash`, false},
+		{`displayName eq "ends with \\"`, "displayName", `ends with \`, false},
+		{`displayName eq "both \\\" and \\\\"`, "displayName", `both \" and \\`, false},
+		{`displayName eq ""`, "displayName", "", false},
+		{`active eq true`, "active", "true", false},
+		{`active eq False`, "active", "False", false},
+		{``, "", "", true},
+		{`userName`, "", "", true},
+		{`userName eq jane`, "", "", true},
+		{`userName eq "jane`, "", "", true},
+		{`userName eq "ja"ne"`, "", "", true},
+		{`userName ne "jane"`, "", "", true},
+		{`userName co "jane"`, "", "", true},
+		{`userName sw "j"`, "", "", true},
+		{`userName pr`, "", "", true},
+		{`userName eq "a" and active eq true`, "", "", true},
+		{`userName eq "a" or userName eq "b"`, "", "", true},
+		{`emails[type eq "work"].value eq "a"`, "", "", true},
+		{`not (userName eq "a")`, "", "", true},
+		{`1userName eq "a"`, "", "", true},
+		{`active eq yes`, "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			filter, err := parseSCIMFilter(tt.filter)
+			if tt.wantErr {
+				var scimErr *SCIMError
+				if !errors.As(err, &scimErr) || scimErr.Status != http.StatusBadRequest || scimErr.ScimType != "invalidFilter" {
+					t.Fatalf("parseSCIMFilter() error = %v, want an invalidFilter SCIM error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseSCIMFilter() error = %v", err)
+			}
+			if filter.attribute != tt.attribute || filter.value != tt.value {
+				t.Errorf("parseSCIMFilter() = (%q, %q), want (%q, %q)", filter.attribute, filter.value, tt.attribute, tt.value)
+			}
+		})
+	}
+}
+
+// newSCIMTestService returns a service backed by a throwaway SQLite database holding two
+// tenants with their default roles
+func newSCIMTestService(t *testing.T) (*SCIMService, [2]uuid.UUID) {
+	t.Helper()
+
+	previous := config.AppConfig
+	config.AppConfig = &config.Config{PasswordHashAlgorithm: utils.PasswordHashBcrypt, BCryptCost: 4}
+	t.Cleanup(func() { config.AppConfig = previous })
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger:                                   logger.Default.LogMode(logger.Silent),
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.AutoMigrate(&models.Tenant{}, &models.Role{}, &models.Permission{}, &models.User{}, &models.TenantUser{},
+		&models.TenantSettings{}, &models.PasswordHistory{}, &models.UserSession{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tenantIDs [2]uuid.UUID
+	for i, slug := range []string{"acme", "globex"} {
+		tenant := models.Tenant{Name: slug, Slug: slug, Subdomain: slug}
+		if err := db.Create(&tenant).Error; err != nil {
+			t.Fatal(err)
+		}
+		role := models.Role{Name: string(models.TenantRoleUser), TenantID: &tenant.ID}
+		if err := db.Create(&role).Error; err != nil {
+			t.Fatal(err)
+		}
+		tenantIDs[i] = tenant.ID
+	}
+	return NewSCIMService(db), tenantIDs
+}
+
+func TestSCIMProvisionsExistingAccountAsMember(t *testing.T) {
+	service, tenants := newSCIMTestService(t)
+	ctx := context.Background()
+	primary, other := tenants[0], tenants[1]
+
+	created, err := service.CreateUser(ctx, primary, &SCIMUser{UserName: "jane@example.com", Name: &SCIMName{GivenName: "Jane", FamilyName: "Doe"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The other tenant's identity provider knows the same person under its own name and ID
+	member, err := service.CreateUser(ctx, other, &SCIMUser{UserName: "Jane@Example.com", ExternalID: "00u1abc", Name: &SCIMName{GivenName: "J"}})
+	if err != nil {
+		t.Fatalf("CreateUser() for an account of another tenant: %v", err)
+	}
+	if member.ID != created.ID {
+		t.Fatalf("CreateUser() created account %s, want membership of %s", member.ID, created.ID)
+	}
+	if member.Name.GivenName != "Jane" || member.ExternalID != "00u1abc" {
+		t.Errorf("member = %+v, want the primary tenant's name and this tenant's external ID", member)
+	}
+
+	_, err = service.CreateUser(ctx, other, &SCIMUser{UserName: "jane@example.com"})
+	var scimErr *SCIMError
+	if !errors.As(err, &scimErr) || scimErr.Status != http.StatusConflict {
+		t.Fatalf("CreateUser() for an existing member: error = %v, want a uniqueness error", err)
+	}
+
+	list, err := service.ListUsers(ctx, other, SCIMListParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.TotalResults != 1 {
+		t.Fatalf("ListUsers() total = %d, want 1", list.TotalResults)
+	}
+
+	defaultRole, err := tenantDefaultRole(service.db, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, err := service.GetGroup(ctx, other, defaultRole.ID.String(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(group.Members) != 1 || group.Members[0].Value != created.ID {
+		t.Errorf("group members = %+v, want the member", group.Members)
+	}
+
+	// Deprovisioning in the other tenant leaves the account alone
+	if err := service.DeactivateUser(ctx, other, member.ID); err != nil {
+		t.Fatal(err)
+	}
+	member, err = service.GetUser(ctx, other, member.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *member.Active {
+		t.Error("member is still active in the tenant that deprovisioned them")
+	}
+	created, err = service.GetUser(ctx, primary, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !*created.Active {
+		t.Error("account was deactivated in its primary tenant")
+	}
+
+	for _, tt := range []struct {
+		tenantID uuid.UUID
+		filter   string
+		want     int
+	}{
+		{other, `active eq false`, 1},
+		{other, `active eq true`, 0},
+		{primary, `active eq true`, 1},
+	} {
+		list, err := service.ListUsers(ctx, tt.tenantID, SCIMListParams{Filter: tt.filter})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if list.TotalResults != tt.want {
+			t.Errorf("ListUsers(%q) total = %d, want %d", tt.filter, list.TotalResults, tt.want)
+		}
+	}
+}
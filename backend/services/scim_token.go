@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang_saas/graph/model"
+	"golang_saas/models"
+	"golang_saas/utils"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// scimTokenPrefix marks secrets as SCIM provisioning tokens of this platform
+const scimTokenPrefix = "zst_"
+
+var (
+	ErrInvalidSCIMToken  = errors.New("invalid or revoked SCIM token")
+	ErrSCIMTokenNotFound = errors.New("SCIM token not found")
+)
+
+// SCIMTokenService manages the bearer tokens identity providers use for SCIM provisioning
+type SCIMTokenService struct {
+	db *gorm.DB
+}
+
+func NewSCIMTokenService(db *gorm.DB) *SCIMTokenService {
+	return &SCIMTokenService{db: db}
+}
+
+// CreateSCIMToken issues a provisioning token for a tenant. The full token is returned once
+// and cannot be retrieved later.
+func (s *SCIMTokenService) CreateSCIMToken(ctx context.Context, creator *models.User, tenantID uuid.UUID, name string) (*model.CreatedScimToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, errors.New("name is required")
+	}
+
+	prefix, secret, err := generateAPIKey(scimTokenPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate SCIM token: %v", err)
+	}
+	token := prefix + "_" + secret
+
+	scimToken := models.SCIMToken{
+		TenantID:    tenantID,
+		Name:        name,
+		Prefix:      prefix,
+		SecretHash:  utils.HashToken(token),
+		CreatedByID: &creator.ID,
+	}
+	if err := s.db.Create(&scimToken).Error; err != nil {
+		return nil, fmt.Errorf("failed to create SCIM token: %v", err)
+	}
+
+	return &model.CreatedScimToken{
+		ScimToken: s.convertToGraphQLModel(&scimToken),
+		Token:     token,
+	}, nil
+}
+
+// ListSCIMTokens returns the tenant's provisioning tokens
+func (s *SCIMTokenService) ListSCIMTokens(ctx context.Context, tenantID uuid.UUID) ([]*model.ScimToken, error) {
+	var scimTokens []models.SCIMToken
+	if err := s.db.Where("tenant_id = ?", tenantID).Order("created_at DESC").Find(&scimTokens).Error; err != nil {
+		return nil, fmt.Errorf("failed to list SCIM tokens: %v", err)
+	}
+
+	result := make([]*model.ScimToken, len(scimTokens))
+	for i := range scimTokens {
+		result[i] = s.convertToGraphQLModel(&scimTokens[i])
+	}
+	return result, nil
+}
+
+// GetSCIMToken returns a token by ID
+func (s *SCIMTokenService) GetSCIMToken(id uuid.UUID) (*models.SCIMToken, error) {
+	var scimToken models.SCIMToken
+	if err := s.db.First(&scimToken, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSCIMTokenNotFound
+		}
+		return nil, err
+	}
+	return &scimToken, nil
+}
+
+// RevokeSCIMToken stops a token from being accepted. Revoked tokens stay listed for auditing.
+func (s *SCIMTokenService) RevokeSCIMToken(ctx context.Context, id uuid.UUID) error {
+	result := s.db.Model(&models.SCIMToken{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke SCIM token: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrSCIMTokenNotFound
+	}
+	return nil
+}
+
+// Authenticate resolves a presented token to the token record of an active tenant
+func (s *SCIMTokenService) Authenticate(token string) (*models.SCIMToken, error) {
+	prefixLength := len(scimTokenPrefix) + apiKeyIDLength
+	if len(token) <= prefixLength+1 || !strings.HasPrefix(token, scimTokenPrefix) || token[prefixLength] != '_' {
+		return nil, ErrInvalidSCIMToken
+	}
+
+	var scimToken models.SCIMToken
+	err := s.db.Where("prefix = ? AND revoked_at IS NULL", token[:prefixLength]).First(&scimToken).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidSCIMToken
+		}
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(utils.HashToken(token)), []byte(scimToken.SecretHash)) != 1 {
+		return nil, ErrInvalidSCIMToken
+	}
+
+	var tenant models.Tenant
+	err = s.db.Where("id = ? AND status = ?", scimToken.TenantID, models.TenantStatusActive).First(&tenant).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidSCIMToken
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	if scimToken.LastUsedAt == nil || now.Sub(*scimToken.LastUsedAt) > apiKeyLastUsedInterval {
+		if err := s.db.Model(&scimToken).UpdateColumn("last_used_at", now).Error; err != nil {
+			return nil, fmt.Errorf("failed to record SCIM token use: %v", err)
+		}
+		scimToken.LastUsedAt = &now
+	}
+
+	return &scimToken, nil
+}
+
+func (s *SCIMTokenService) convertToGraphQLModel(scimToken *models.SCIMToken) *model.ScimToken {
+	return &model.ScimToken{
+		ID:         scimToken.ID.String(),
+		TenantID:   scimToken.TenantID.String(),
+		Name:       scimToken.Name,
+		Prefix:     scimToken.Prefix,
+		LastUsedAt: scimToken.LastUsedAt,
+		RevokedAt:  scimToken.RevokedAt,
+		CreatedAt:  scimToken.CreatedAt,
+	}
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"golang_saas/models"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+type SecurityEventService struct {
+	db *gorm.DB
+}
+
+func NewSecurityEventService(db *gorm.DB) *SecurityEventService {
+	return &SecurityEventService{db: db}
+}
+
+// RecordEvent stores a security event along with the client info of the current request
+func (s *SecurityEventService) RecordEvent(ctx context.Context, eventType string, userID, tenantID *uuid.UUID, details map[string]any) error {
+	event := models.SecurityEvent{
+		UserID:   userID,
+		TenantID: tenantID,
+		Type:     eventType,
+	}
+
+	if details != nil {
+		detailsJSON, err := json.Marshal(details)
+		if err != nil {
+			return fmt.Errorf("failed to marshal event details: %v", err)
+		}
+		event.Details = datatypes.JSON(detailsJSON)
+	}
+
+	info := ClientInfoFromContext(ctx)
+	if info.IPAddress != "" {
+		event.IPAddress = &info.IPAddress
+	}
+	if info.UserAgent != "" {
+		event.UserAgent = &info.UserAgent
+	}
+
+	if err := s.db.Create(&event).Error; err != nil {
+		return fmt.Errorf("failed to record security event: %v", err)
+	}
+
+	return nil
+}
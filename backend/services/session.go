@@ -0,0 +1,317 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang_saas/config"
+	"golang_saas/graph/model"
+	"golang_saas/models"
+	"golang_saas/utils"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ClientInfo describes the client a request originated from
+type ClientInfo struct {
+	IPAddress string
+	UserAgent string
+}
+
+type clientInfoKey struct{}
+
+// WithClientInfo stores the request client info in context
+func WithClientInfo(ctx context.Context, info ClientInfo) context.Context {
+	return context.WithValue(ctx, clientInfoKey{}, info)
+}
+
+// ClientInfoFromContext extracts the request client info from context
+func ClientInfoFromContext(ctx context.Context) ClientInfo {
+	info, _ := ctx.Value(clientInfoKey{}).(ClientInfo)
+	return info
+}
+
+// sessionActivityInterval throttles last-activity updates so active sessions don't write on every request
+const sessionActivityInterval = time.Minute
+
+var (
+	ErrSessionInvalid     = errors.New("session is invalid or has been revoked")
+	ErrRefreshTokenReused = errors.New("refresh token has already been used")
+	ErrSessionNotFound    = errors.New("session not found")
+)
+
+type SessionService struct {
+	db *gorm.DB
+}
+
+func NewSessionService(db *gorm.DB) *SessionService {
+	return &SessionService{db: db}
+}
+
+// CreateSession records a new session for an issued access/refresh token pair.
+// Passing uuid.Nil as familyID starts a new token family.
+func (s *SessionService) CreateSession(ctx context.Context, userID uuid.UUID, tenantID *uuid.UUID, familyID uuid.UUID, accessToken, refreshToken string) (*models.UserSession, error) {
+	refreshTokenHash := utils.HashToken(refreshToken)
+	now := time.Now()
+
+	session := models.UserSession{
+		UserID:           userID,
+		TenantID:         tenantID,
+		TokenHash:        utils.HashToken(accessToken),
+		RefreshTokenHash: &refreshTokenHash,
+		FamilyID:         familyID,
+		ExpiresAt:        now.Add(time.Duration(config.AppConfig.JWTRefreshExpireHours) * time.Hour),
+		LastActivity:     now,
+	}
+
+	// The first session of a family gives the family its ID
+	if session.FamilyID == uuid.Nil {
+		session.ID = uuid.New()
+		session.FamilyID = session.ID
+	}
+
+	info := ClientInfoFromContext(ctx)
+	if info.IPAddress != "" {
+		session.IPAddress = &info.IPAddress
+	}
+	if info.UserAgent != "" {
+		session.UserAgent = &info.UserAgent
+	}
+
+	if err := s.db.Create(&session).Error; err != nil {
+		return nil, fmt.Errorf("failed to create session: %v", err)
+	}
+
+	return &session, nil
+}
+
+// CreateImpersonationSession records the session of an impersonation access token. It has no
+// refresh token and ends when the access token expires.
+func (s *SessionService) CreateImpersonationSession(ctx context.Context, user *models.User, impersonatorID uuid.UUID, accessToken string, expiresAt time.Time) (*models.UserSession, error) {
+	session := models.UserSession{
+		UserID:         user.ID,
+		TenantID:       user.TenantID,
+		TokenHash:      utils.HashToken(accessToken),
+		ExpiresAt:      expiresAt,
+		LastActivity:   time.Now(),
+		ImpersonatorID: &impersonatorID,
+	}
+	session.ID = uuid.New()
+	session.FamilyID = session.ID
+
+	info := ClientInfoFromContext(ctx)
+	if info.IPAddress != "" {
+		session.IPAddress = &info.IPAddress
+	}
+	if info.UserAgent != "" {
+		session.UserAgent = &info.UserAgent
+	}
+
+	if err := s.db.Create(&session).Error; err != nil {
+		return nil, fmt.Errorf("failed to create session: %v", err)
+	}
+
+	return &session, nil
+}
+
+// ValidateAccessToken returns the active session an access token was issued for
+func (s *SessionService) ValidateAccessToken(accessToken string) (*models.UserSession, error) {
+	var session models.UserSession
+	err := s.db.Where("token_hash = ? AND is_revoked = ? AND expires_at > ?", utils.HashToken(accessToken), false, time.Now()).
+		First(&session).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionInvalid
+		}
+		return nil, fmt.Errorf("failed to load session: %v", err)
+	}
+
+	return &session, nil
+}
+
+// RotateRefreshToken consumes a refresh token and returns the session it was issued for.
+// Refresh tokens are single-use: presenting one that was already rotated revokes its
+// whole family and returns ErrRefreshTokenReused.
+func (s *SessionService) RotateRefreshToken(refreshToken string) (*models.UserSession, error) {
+	var session models.UserSession
+	err := s.db.Where("refresh_token_hash = ?", utils.HashToken(refreshToken)).First(&session).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionInvalid
+		}
+		return nil, fmt.Errorf("failed to load session: %v", err)
+	}
+
+	if session.RotatedAt != nil {
+		if err := s.RevokeFamily(session.FamilyID); err != nil {
+			return nil, err
+		}
+		return &session, ErrRefreshTokenReused
+	}
+
+	if session.IsRevoked || !session.ExpiresAt.After(time.Now()) {
+		return nil, ErrSessionInvalid
+	}
+
+	// Mark the token as used; the rotated_at guard makes concurrent exchanges of the same token fail
+	now := time.Now()
+	result := s.db.Model(&models.UserSession{}).
+		Where("id = ? AND rotated_at IS NULL", session.ID).
+		Updates(map[string]any{"is_revoked": true, "rotated_at": now})
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to rotate session: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		if err := s.RevokeFamily(session.FamilyID); err != nil {
+			return nil, err
+		}
+		return &session, ErrRefreshTokenReused
+	}
+
+	session.IsRevoked = true
+	session.RotatedAt = &now
+	return &session, nil
+}
+
+// RevokeSession revokes a single session
+func (s *SessionService) RevokeSession(sessionID uuid.UUID) error {
+	err := s.db.Model(&models.UserSession{}).
+		Where("id = ?", sessionID).
+		Update("is_revoked", true).Error
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %v", err)
+	}
+
+	return nil
+}
+
+// RevokeFamily revokes every session in a refresh token family
+func (s *SessionService) RevokeFamily(familyID uuid.UUID) error {
+	err := s.db.Model(&models.UserSession{}).
+		Where("family_id = ? AND is_revoked = ?", familyID, false).
+		Update("is_revoked", true).Error
+	if err != nil {
+		return fmt.Errorf("failed to revoke session family: %v", err)
+	}
+
+	return nil
+}
+
+// RevokeAllUserSessions revokes every active session of a user
+func (s *SessionService) RevokeAllUserSessions(userID uuid.UUID) error {
+	err := s.db.Model(&models.UserSession{}).
+		Where("user_id = ? AND is_revoked = ?", userID, false).
+		Update("is_revoked", true).Error
+	if err != nil {
+		return fmt.Errorf("failed to revoke sessions: %v", err)
+	}
+
+	return nil
+}
+
+// ListActiveSessions returns a user's signed-in sessions, most recently active first. A
+// refreshed session keeps the ID of its current token. tenantID limits the list to sessions
+// in one tenant; currentSessionID marks the caller's own session.
+func (s *SessionService) ListActiveSessions(userID uuid.UUID, tenantID *uuid.UUID, currentSessionID uuid.UUID) ([]*model.UserSession, error) {
+	query := s.db.Where("user_id = ? AND is_revoked = ? AND expires_at > ?", userID, false, time.Now())
+	if tenantID != nil {
+		query = query.Where("tenant_id = ?", *tenantID)
+	}
+
+	var sessions []models.UserSession
+	if err := query.Order("last_activity DESC").Find(&sessions).Error; err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %v", err)
+	}
+
+	// A session was signed in when the first token of its family was issued
+	familyIDs := make([]uuid.UUID, len(sessions))
+	for i, session := range sessions {
+		familyIDs[i] = session.FamilyID
+	}
+	var firstSessions []models.UserSession
+	if len(familyIDs) > 0 {
+		if err := s.db.Select("id", "created_at").Where("id IN ?", familyIDs).Find(&firstSessions).Error; err != nil {
+			return nil, fmt.Errorf("failed to load session families: %v", err)
+		}
+	}
+	signedInAt := make(map[uuid.UUID]time.Time, len(firstSessions))
+	for _, session := range firstSessions {
+		signedInAt[session.ID] = session.CreatedAt
+	}
+
+	result := make([]*model.UserSession, len(sessions))
+	for i, session := range sessions {
+		var sessionTenantID *string
+		if session.TenantID != nil {
+			id := session.TenantID.String()
+			sessionTenantID = &id
+		}
+
+		started, ok := signedInAt[session.FamilyID]
+		if !ok {
+			started = session.CreatedAt
+		}
+
+		result[i] = &model.UserSession{
+			ID:              session.ID.String(),
+			TenantID:        sessionTenantID,
+			IPAddress:       session.IPAddress,
+			UserAgent:       session.UserAgent,
+			SignedInAt:      started,
+			LastActivity:    session.LastActivity,
+			ExpiresAt:       session.ExpiresAt,
+			IsCurrent:       session.ID == currentSessionID,
+			IsImpersonation: session.ImpersonatorID != nil,
+		}
+	}
+
+	return result, nil
+}
+
+// RevokeUserSession signs a user out of one of their sessions, including tokens refreshed from it
+func (s *SessionService) RevokeUserSession(userID, sessionID uuid.UUID) error {
+	var session models.UserSession
+	err := s.db.Where("id = ? AND user_id = ? AND is_revoked = ?", sessionID, userID, false).First(&session).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrSessionNotFound
+		}
+		return fmt.Errorf("failed to load session: %v", err)
+	}
+
+	return s.RevokeFamily(session.FamilyID)
+}
+
+// RevokeUserSessionsInTenant revokes a user's active sessions in one tenant, or all of them
+// when tenantID is nil
+func (s *SessionService) RevokeUserSessionsInTenant(userID uuid.UUID, tenantID *uuid.UUID) error {
+	if tenantID == nil {
+		return s.RevokeAllUserSessions(userID)
+	}
+
+	err := s.db.Model(&models.UserSession{}).
+		Where("user_id = ? AND tenant_id = ? AND is_revoked = ?", userID, *tenantID, false).
+		Update("is_revoked", true).Error
+	if err != nil {
+		return fmt.Errorf("failed to revoke sessions: %v", err)
+	}
+
+	return nil
+}
+
+// TouchSession records activity on a session, writing at most once per sessionActivityInterval
+func (s *SessionService) TouchSession(session *models.UserSession) error {
+	now := time.Now()
+	if now.Sub(session.LastActivity) < sessionActivityInterval {
+		return nil
+	}
+
+	if err := s.db.Model(session).UpdateColumn("last_activity", now).Error; err != nil {
+		return fmt.Errorf("failed to record session activity: %v", err)
+	}
+	session.LastActivity = now
+	return nil
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"golang_saas/config"
+	"golang_saas/models"
+	"golang_saas/utils"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type SigningKeyService struct {
+	db *gorm.DB
+}
+
+func NewSigningKeyService(db *gorm.DB) *SigningKeyService {
+	return &SigningKeyService{db: db}
+}
+
+// InitKeyRing makes sure a signing key exists and loads the JWT key ring from the database
+func (s *SigningKeyService) InitKeyRing() error {
+	var count int64
+	err := s.db.Model(&models.SigningKey{}).Where("status = ?", models.SigningKeyStatusActive).Count(&count).Error
+	if err != nil {
+		return fmt.Errorf("failed to check signing keys: %v", err)
+	}
+
+	if count == 0 {
+		log.Println("No active JWT signing key found, generating one...")
+		if _, err := s.createKey(s.db, models.SigningKeyStatusActive); err != nil {
+			return err
+		}
+	}
+
+	return utils.InitKeyRing(s.LoadKeys)
+}
+
+// LoadKeys returns every published key. Only the active key is decrypted for signing.
+func (s *SigningKeyService) LoadKeys() ([]utils.SigningKey, error) {
+	var records []models.SigningKey
+	err := s.db.Order("activated_at DESC").Find(&records).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load signing keys: %v", err)
+	}
+
+	keys := make([]utils.SigningKey, 0, len(records))
+	signerFound := false
+	for _, record := range records {
+		publicKey, err := utils.ParsePublicKeyPEM(record.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key %s: %v", record.KID, err)
+		}
+
+		key := utils.SigningKey{
+			KID:       record.KID,
+			Algorithm: record.Algorithm,
+			PublicKey: publicKey,
+		}
+
+		// The most recently activated key signs if an interrupted rotation left several active
+		if record.Status == models.SigningKeyStatusActive && !signerFound {
+			privatePEM, err := utils.DecryptString(record.PrivateKey)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt signing key %s: %v", record.KID, err)
+			}
+			if key.PrivateKey, err = utils.ParsePrivateKeyPEM(privatePEM); err != nil {
+				return nil, fmt.Errorf("failed to parse signing key %s: %v", record.KID, err)
+			}
+			key.Signing = true
+			signerFound = true
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// Rotate promotes the pending key to active, retires the previous active key and
+// publishes a new pending key for the next rotation. Keys are published before they
+// sign anything so verifiers caching the JWKS already know them. Retired keys are
+// removed once every token they signed has expired.
+func (s *SigningKeyService) Rotate() error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var pending models.SigningKey
+		err := tx.Where("status = ?", models.SigningKeyStatusPending).Order("created_at ASC").First(&pending).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("failed to load pending key: %v", err)
+		}
+
+		if err == nil {
+			now := time.Now()
+			err = tx.Model(&models.SigningKey{}).
+				Where("status = ?", models.SigningKeyStatusActive).
+				Updates(map[string]any{"status": models.SigningKeyStatusRetired, "retired_at": now}).Error
+			if err != nil {
+				return fmt.Errorf("failed to retire signing key: %v", err)
+			}
+
+			err = tx.Model(&pending).
+				Updates(map[string]any{"status": models.SigningKeyStatusActive, "activated_at": now}).Error
+			if err != nil {
+				return fmt.Errorf("failed to activate signing key: %v", err)
+			}
+			log.Printf("Activated JWT signing key %s", pending.KID)
+		} else {
+			log.Println("No pending key to activate; publishing one for the next rotation")
+		}
+
+		next, err := s.createKey(tx, models.SigningKeyStatusPending)
+		if err != nil {
+			return err
+		}
+		log.Printf("Published pending JWT signing key %s", next.KID)
+
+		// Refresh tokens live longest, so they bound how long a retired key must stay published
+		cutoff := time.Now().Add(-time.Duration(config.AppConfig.JWTRefreshExpireHours) * time.Hour)
+		err = tx.Unscoped().
+			Where("status = ? AND retired_at < ?", models.SigningKeyStatusRetired, cutoff).
+			Delete(&models.SigningKey{}).Error
+		if err != nil {
+			return fmt.Errorf("failed to remove expired signing keys: %v", err)
+		}
+
+		return nil
+	})
+}
+
+// createKey generates a key pair with the configured algorithm and stores it with the given status
+func (s *SigningKeyService) createKey(tx *gorm.DB, status string) (*models.SigningKey, error) {
+	algorithm := config.AppConfig.JWTSigningAlgorithm
+	privatePEM, publicPEM, err := utils.GenerateSigningKeyPair(algorithm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %v", err)
+	}
+
+	encryptedPrivate, err := utils.EncryptString(privatePEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt signing key: %v", err)
+	}
+
+	key := models.SigningKey{
+		KID:        uuid.NewString(),
+		Algorithm:  algorithm,
+		PrivateKey: encryptedPrivate,
+		PublicKey:  publicPEM,
+		Status:     status,
+	}
+	if status == models.SigningKeyStatusActive {
+		now := time.Now()
+		key.ActivatedAt = &now
+	}
+
+	if err := tx.Create(&key).Error; err != nil {
+		return nil, fmt.Errorf("failed to save signing key: %v", err)
+	}
+
+	return &key, nil
+}
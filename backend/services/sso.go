@@ -0,0 +1,531 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang_saas/config"
+	"golang_saas/graph/model"
+	"golang_saas/models"
+	"golang_saas/utils"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	ssoLoginStateTTL = 10 * time.Minute
+	ssoTicketTTL     = time.Minute
+)
+
+var (
+	ErrSSONotConfigured  = errors.New("single sign-on is not configured for this tenant")
+	ErrSSONotInPlan      = errors.New("single sign-on is not included in the tenant's plan")
+	ErrInvalidSSOState   = errors.New("invalid or expired single sign-on request")
+	ErrInvalidSSOTicket  = errors.New("invalid or expired single sign-on ticket")
+	ErrSSOEmailMissing   = errors.New("identity provider did not return an email address")
+	ErrSSOUserDisabled   = errors.New("user account is disabled")
+	ErrSSOAccountExists  = errors.New("an account with this email address already exists; sign in with your password and link single sign-on from your account settings")
+	ErrSSOIdentityLinked = errors.New("this identity provider account is already linked to another user")
+)
+
+// defaultSSOClaimMappings are the standard OIDC claim names
+var defaultSSOClaimMappings = models.SSOClaimMappings{
+	Email:     "email",
+	FirstName: "given_name",
+	LastName:  "family_name",
+	Groups:    "groups",
+}
+
+// defaultSAMLAttributeMappings are the attribute names most IdPs release by default.
+// Attributes are matched on their Name or FriendlyName.
+var defaultSAMLAttributeMappings = models.SSOClaimMappings{
+	Email:     "email",
+	FirstName: "firstName",
+	LastName:  "lastName",
+	Groups:    "groups",
+}
+
+// ssoIdentity is the user information asserted by an identity provider
+type ssoIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool // the IdP vouches for the address; SAML assertions never do
+	FirstName     string
+	LastName      string
+	Groups        []string
+}
+
+type SSOService struct {
+	db *gorm.DB
+}
+
+func NewSSOService(db *gorm.DB) *SSOService {
+	return &SSOService{db: db}
+}
+
+// activeTenantBySlug loads an active tenant that is entitled to SSO
+func (s *SSOService) activeTenantBySlug(slug string) (*models.Tenant, error) {
+	return s.activeTenant("slug = ?", slug)
+}
+
+// activeTenant loads the active tenant matching the condition and checks it is entitled to SSO
+func (s *SSOService) activeTenant(condition string, value string) (*models.Tenant, error) {
+	var tenant models.Tenant
+	err := s.db.Where(condition, value).Where("status = ?", models.TenantStatusActive).First(&tenant).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("tenant not found")
+		}
+		return nil, err
+	}
+
+	if err := s.requireSSOFeature(tenant.ID); err != nil {
+		return nil, err
+	}
+
+	return &tenant, nil
+}
+
+// requireSSOFeature checks the tenant's plan includes single sign-on
+func (s *SSOService) requireSSOFeature(tenantID uuid.UUID) error {
+	tenantService := NewTenantService(s.db)
+	enabled, err := tenantService.HasPlanFeature(tenantID, "sso")
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return ErrSSONotInPlan
+	}
+	return nil
+}
+
+// createLoginState records an SSO login in progress and returns the opaque state value.
+// linkUserID is set when a signed-in user is linking the identity to their account.
+func (s *SSOService) createLoginState(tenantID, providerID uuid.UUID, protocol, nonce, codeVerifier string, linkUserID *uuid.UUID) (string, error) {
+	state, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate state: %v", err)
+	}
+
+	loginState := models.SSOLoginState{
+		TenantID:   tenantID,
+		ProviderID: providerID,
+		Protocol:   protocol,
+		StateHash:  utils.HashToken(state),
+		Nonce:      nonce,
+		ExpiresAt:  time.Now().Add(ssoLoginStateTTL),
+		LinkUserID: linkUserID,
+	}
+	if codeVerifier != "" {
+		if loginState.CodeVerifier, err = utils.EncryptString(codeVerifier); err != nil {
+			return "", fmt.Errorf("failed to encrypt code verifier: %v", err)
+		}
+	}
+
+	if err := s.db.Create(&loginState).Error; err != nil {
+		return "", fmt.Errorf("failed to save login state: %v", err)
+	}
+
+	return state, nil
+}
+
+// consumeLoginState marks a login state as used and returns it. Each state can only be used once.
+func (s *SSOService) consumeLoginState(state, protocol string) (*models.SSOLoginState, error) {
+	var loginState models.SSOLoginState
+	err := s.db.Where("state_hash = ? AND protocol = ? AND used_at IS NULL AND expires_at > ?", utils.HashToken(state), protocol, time.Now()).
+		First(&loginState).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidSSOState
+		}
+		return nil, err
+	}
+
+	result := s.db.Model(&models.SSOLoginState{}).
+		Where("id = ? AND used_at IS NULL", loginState.ID).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to consume login state: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvalidSSOState
+	}
+
+	if loginState.CodeVerifier != "" {
+		if loginState.CodeVerifier, err = utils.DecryptString(loginState.CodeVerifier); err != nil {
+			return nil, fmt.Errorf("failed to decrypt code verifier: %v", err)
+		}
+	}
+
+	return &loginState, nil
+}
+
+// issueTicket attaches the signed-in user to the login state and returns a
+// one-time ticket the frontend exchanges for tokens
+func (s *SSOService) issueTicket(loginState *models.SSOLoginState, userID uuid.UUID) (string, error) {
+	ticket, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate ticket: %v", err)
+	}
+
+	ticketHash := utils.HashToken(ticket)
+	err = s.db.Model(loginState).Updates(map[string]any{
+		"user_id":     userID,
+		"ticket_hash": ticketHash,
+		"expires_at":  time.Now().Add(ssoTicketTTL),
+	}).Error
+	if err != nil {
+		return "", fmt.Errorf("failed to save ticket: %v", err)
+	}
+
+	return ticket, nil
+}
+
+// provisionUser finds or just-in-time creates the tenant user for an IdP identity,
+// links the identity and applies the role mapped from the user's groups. Identities are
+// only ever matched by their IdP subject: an existing account is linked when its signed-in
+// owner started the login to link it, never because the IdP asserts its email address.
+func (s *SSOService) provisionUser(ctx context.Context, loginState *models.SSOLoginState, protocol string, identity *ssoIdentity, groupRoleMappings []models.SSOGroupRoleMapping, defaultRoleID *uuid.UUID) (*models.User, error) {
+	if identity.Email == "" {
+		return nil, ErrSSOEmailMissing
+	}
+	tenantID, providerID := loginState.TenantID, loginState.ProviderID
+
+	var user models.User
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		mappedRole, err := s.mappedRole(tx, tenantID, identity.Groups, groupRoleMappings)
+		if err != nil {
+			return err
+		}
+
+		members := tx.Model(&models.TenantUser{}).Select("user_id").Where("tenant_id = ?", tenantID)
+		var link models.UserIdentity
+		err = tx.Where("provider_id = ? AND subject = ?", providerID, identity.Subject).First(&link).Error
+		switch {
+		case err == nil:
+			if loginState.LinkUserID != nil && *loginState.LinkUserID != link.UserID {
+				return ErrSSOIdentityLinked
+			}
+			err = tx.Where("id = ?", link.UserID).Where("tenant_id = ? OR id IN (?)", tenantID, members).First(&user).Error
+		case errors.Is(err, gorm.ErrRecordNotFound) && loginState.LinkUserID != nil:
+			err = tx.Where("id = ?", *loginState.LinkUserID).Where("tenant_id = ? OR id IN (?)", tenantID, members).First(&user).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidSSOState
+			}
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			// The address may belong to an account the IdP has no claim on
+			var existing int64
+			if err := tx.Model(&models.User{}).Where("email = ?", identity.Email).Count(&existing).Error; err != nil {
+				return err
+			}
+			if existing > 0 {
+				return ErrSSOAccountExists
+			}
+		}
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		now := time.Now()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			role := mappedRole
+			if role == nil {
+				if role, err = s.defaultRole(tx, tenantID, defaultRoleID); err != nil {
+					return err
+				}
+			}
+
+			// SSO users never sign in with a password; give them an unguessable one
+			randomPassword, err := utils.GenerateRandomToken(32)
+			if err != nil {
+				return err
+			}
+			hashedPassword, err := utils.HashPassword(randomPassword)
+			if err != nil {
+				return err
+			}
+
+			tenantIDCopy := tenantID
+			user = models.User{
+				Email:          identity.Email,
+				Password:       hashedPassword,
+				FirstName:      identity.FirstName,
+				LastName:       identity.LastName,
+				IsActive:       true,
+				TenantID:       &tenantIDCopy,
+				RoleID:         role.ID,
+				EmailVerified:  identity.EmailVerified,
+				SelfRegistered: true,
+			}
+			if identity.EmailVerified {
+				user.EmailVerifiedAt = &now
+			}
+			if err := tx.Create(&user).Error; err != nil {
+				return fmt.Errorf("failed to provision user: %v", err)
+			}
+			if err := saveMembership(tx, user.ID, tenantID, role.ID); err != nil {
+				return err
+			}
+		} else {
+			if !user.IsActive {
+				return ErrSSOUserDisabled
+			}
+
+			updates := map[string]any{}
+			if !user.EmailVerified && identity.EmailVerified && strings.EqualFold(user.Email, identity.Email) {
+				updates["email_verified"] = true
+				updates["email_verified_at"] = now
+			}
+			if len(updates) > 0 {
+				if err := tx.Model(&user).Updates(updates).Error; err != nil {
+					return fmt.Errorf("failed to update user: %v", err)
+				}
+			}
+			if mappedRole != nil {
+				if err := setMemberRole(tx, &user, tenantID, mappedRole.ID); err != nil {
+					return err
+				}
+			}
+		}
+
+		if link.ID == uuid.Nil {
+			link = models.UserIdentity{
+				UserID:     user.ID,
+				TenantID:   tenantID,
+				Protocol:   protocol,
+				ProviderID: providerID,
+				Subject:    identity.Subject,
+			}
+		}
+		link.Email = identity.Email
+		link.LastLoginAt = &now
+		if err := tx.Save(&link).Error; err != nil {
+			return fmt.Errorf("failed to link identity: %v", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// mappedRole returns the role of the first mapping whose group the user belongs to, or nil
+func (s *SSOService) mappedRole(tx *gorm.DB, tenantID uuid.UUID, groups []string, mappings []models.SSOGroupRoleMapping) (*models.Role, error) {
+	memberOf := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		memberOf[group] = true
+	}
+
+	for _, mapping := range mappings {
+		if !memberOf[mapping.Group] {
+			continue
+		}
+
+		var role models.Role
+		err := tx.Where("name = ? AND tenant_id = ?", mapping.Role, tenantID).First(&role).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, fmt.Errorf("role %s mapped from group %s not found", mapping.Role, mapping.Group)
+			}
+			return nil, err
+		}
+		return &role, nil
+	}
+
+	return nil, nil
+}
+
+// defaultRole returns the provider's default role, falling back to the tenant's TENANT_USER role
+func (s *SSOService) defaultRole(tx *gorm.DB, tenantID uuid.UUID, defaultRoleID *uuid.UUID) (*models.Role, error) {
+	var role models.Role
+	var err error
+	if defaultRoleID != nil {
+		err = tx.Where("id = ? AND tenant_id = ?", *defaultRoleID, tenantID).First(&role).Error
+	} else {
+		err = tx.Where("name = ? AND tenant_id = ?", string(models.TenantRoleUser), tenantID).First(&role).Error
+	}
+	if err != nil {
+		return nil, errors.New("default role not found")
+	}
+
+	return &role, nil
+}
+
+// validateRoleMappings checks that every mapped role exists in the tenant
+func (s *SSOService) validateRoleMappings(tenantID uuid.UUID, mappings []models.SSOGroupRoleMapping, defaultRoleID *uuid.UUID) error {
+	for _, mapping := range mappings {
+		if mapping.Group == "" || mapping.Role == "" {
+			return errors.New("group role mappings need both a group and a role")
+		}
+
+		var count int64
+		if err := s.db.Model(&models.Role{}).Where("name = ? AND tenant_id = ?", mapping.Role, tenantID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return fmt.Errorf("role %s not found in tenant", mapping.Role)
+		}
+	}
+
+	if defaultRoleID != nil {
+		if _, err := s.defaultRole(s.db, tenantID, defaultRoleID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SSOErrorRedirectURL is where the browser is sent when an SSO login fails.
+// Only errors meant for users are shown; anything else gets a generic message.
+func SSOErrorRedirectURL(err error) string {
+	message := "single sign-on failed"
+	for _, userErr := range []error{ErrSSONotConfigured, ErrSSONotInPlan, ErrInvalidSSOState, ErrSSOEmailMissing, ErrSSOUserDisabled, ErrSSOAccountExists, ErrSSOIdentityLinked} {
+		if errors.Is(err, userErr) {
+			message = userErr.Error()
+			break
+		}
+	}
+
+	return fmt.Sprintf("%s/auth/login?error=sso_failed&message=%s", config.AppConfig.FrontendURL, url.QueryEscape(message))
+}
+
+// SSOTicketRedirectURL is where the browser is sent with the one-time ticket after a successful SSO login
+func SSOTicketRedirectURL(ticket string) string {
+	return fmt.Sprintf("%s/auth/sso/complete?ticket=%s", config.AppConfig.FrontendURL, url.QueryEscape(ticket))
+}
+
+// decodeSSOMappings decodes the stored claim and group role mappings, applying the given claim defaults
+func decodeSSOMappings(defaults models.SSOClaimMappings, claimMappingsJSON, groupRoleMappingsJSON []byte) (models.SSOClaimMappings, []models.SSOGroupRoleMapping, error) {
+	claimMappings := defaults
+	if len(claimMappingsJSON) > 0 {
+		var stored models.SSOClaimMappings
+		if err := json.Unmarshal(claimMappingsJSON, &stored); err != nil {
+			return claimMappings, nil, fmt.Errorf("failed to decode claim mappings: %v", err)
+		}
+		mergeClaimMappings(&claimMappings, stored)
+	}
+
+	var groupRoleMappings []models.SSOGroupRoleMapping
+	if len(groupRoleMappingsJSON) > 0 {
+		if err := json.Unmarshal(groupRoleMappingsJSON, &groupRoleMappings); err != nil {
+			return claimMappings, nil, fmt.Errorf("failed to decode group role mappings: %v", err)
+		}
+	}
+
+	return claimMappings, groupRoleMappings, nil
+}
+
+// mergeClaimMappings overrides the mappings set in src
+func mergeClaimMappings(dst *models.SSOClaimMappings, src models.SSOClaimMappings) {
+	if src.Email != "" {
+		dst.Email = src.Email
+	}
+	if src.FirstName != "" {
+		dst.FirstName = src.FirstName
+	}
+	if src.LastName != "" {
+		dst.LastName = src.LastName
+	}
+	if src.Groups != "" {
+		dst.Groups = src.Groups
+	}
+}
+
+// ssoMappingsFromInput converts GraphQL mapping inputs to their stored form
+func ssoMappingsFromInput(claimInput *model.SsoClaimMappingsInput, groupInput []*model.GroupRoleMappingInput) (models.SSOClaimMappings, []models.SSOGroupRoleMapping) {
+	var claimMappings models.SSOClaimMappings
+	if claimInput != nil {
+		if claimInput.Email != nil {
+			claimMappings.Email = strings.TrimSpace(*claimInput.Email)
+		}
+		if claimInput.FirstName != nil {
+			claimMappings.FirstName = strings.TrimSpace(*claimInput.FirstName)
+		}
+		if claimInput.LastName != nil {
+			claimMappings.LastName = strings.TrimSpace(*claimInput.LastName)
+		}
+		if claimInput.Groups != nil {
+			claimMappings.Groups = strings.TrimSpace(*claimInput.Groups)
+		}
+	}
+
+	groupRoleMappings := make([]models.SSOGroupRoleMapping, 0, len(groupInput))
+	for _, mapping := range groupInput {
+		groupRoleMappings = append(groupRoleMappings, models.SSOGroupRoleMapping{
+			Group: strings.TrimSpace(mapping.Group),
+			Role:  strings.TrimSpace(mapping.Role),
+		})
+	}
+
+	return claimMappings, groupRoleMappings
+}
+
+// ssoMappingsToGraphQL converts stored mappings to their GraphQL representation
+func ssoMappingsToGraphQL(claimMappings models.SSOClaimMappings, groupRoleMappings []models.SSOGroupRoleMapping) (*model.SsoClaimMappings, []*model.GroupRoleMapping) {
+	groups := make([]*model.GroupRoleMapping, len(groupRoleMappings))
+	for i, mapping := range groupRoleMappings {
+		groups[i] = &model.GroupRoleMapping{Group: mapping.Group, Role: mapping.Role}
+	}
+
+	return &model.SsoClaimMappings{
+		Email:     claimMappings.Email,
+		FirstName: claimMappings.FirstName,
+		LastName:  claimMappings.LastName,
+		Groups:    claimMappings.Groups,
+	}, groups
+}
+
+// CompleteSSOLogin exchanges a one-time SSO ticket for a token pair. The login goes through
+// the same checks as a password login, so the result may be a challenge instead.
+func (s *AuthService) CompleteSSOLogin(ctx context.Context, ticket string) (model.LoginResult, error) {
+	var loginState models.SSOLoginState
+	err := s.db.Where("ticket_hash = ? AND ticket_used_at IS NULL AND expires_at > ?", utils.HashToken(ticket), time.Now()).
+		First(&loginState).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidSSOTicket
+		}
+		return nil, err
+	}
+
+	result := s.db.Model(&models.SSOLoginState{}).
+		Where("id = ? AND ticket_used_at IS NULL", loginState.ID).
+		Update("ticket_used_at", time.Now())
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to consume ticket: %v", result.Error)
+	}
+	if result.RowsAffected == 0 || loginState.UserID == nil {
+		return nil, ErrInvalidSSOTicket
+	}
+
+	var user models.User
+	err = s.db.Preload("Role").Preload("Role.Permissions").Preload("Permissions").
+		Where("id = ? AND is_active = ?", *loginState.UserID, true).
+		First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidSSOTicket
+		}
+		return nil, err
+	}
+	if err := NewTenantMembershipService(s.db).ActivateTenant(&user, loginState.TenantID); err != nil {
+		return nil, ErrInvalidSSOTicket
+	}
+
+	authResp, err := s.continueLogin(ctx, &user)
+	if err != nil {
+		return nil, err
+	}
+
+	return authResp.toLoginResult(), nil
+}
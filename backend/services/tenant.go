@@ -9,7 +9,6 @@ import (
 
 	"golang_saas/graph/model"
 	"golang_saas/models"
-	"golang_saas/utils"
 
 	"github.com/google/uuid"
 	"gorm.io/datatypes"
@@ -102,11 +101,12 @@ func (s *TenantService) CreateTenant(ctx context.Context, input model.CreateTena
 		return nil, fmt.Errorf("failed to find tenant admin role: %v", err)
 	}
 
-	// Create admin user
-	hashedPassword, err := utils.HashPassword(input.AdminPassword)
+	// Create admin user; a new tenant starts with the default password policy
+	passwordPolicy := NewPasswordPolicyService(tx)
+	hashedPassword, err := passwordPolicy.HashPassword(&tenant.ID, nil, input.AdminPassword)
 	if err != nil {
 		tx.Rollback()
-		return nil, fmt.Errorf("failed to hash password: %v", err)
+		return nil, err
 	}
 
 	adminUser := models.User{
@@ -125,6 +125,16 @@ func (s *TenantService) CreateTenant(ctx context.Context, input model.CreateTena
 		return nil, fmt.Errorf("failed to create admin user: %v", err)
 	}
 
+	if err := saveMembership(tx, adminUser.ID, tenant.ID, adminRole.ID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := passwordPolicy.RecordPasswordChange(adminUser.ID, hashedPassword); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
 	// Create subscription
 	subscription := models.Subscription{
 		TenantID: tenant.ID,
@@ -302,6 +312,31 @@ func (s *TenantService) ListTenants(ctx context.Context, filter *model.TenantFil
 	}, nil
 }
 
+// HasPlanFeature reports whether the tenant's active subscription plan enables a boolean feature
+func (s *TenantService) HasPlanFeature(tenantID uuid.UUID, feature string) (bool, error) {
+	var subscription models.Subscription
+	err := s.db.Preload("Plan").
+		Where("tenant_id = ? AND status IN ?", tenantID, []models.SubscriptionStatus{models.SubscriptionStatusActive, models.SubscriptionStatusPastDue}).
+		Order("current_period_end DESC").
+		First(&subscription).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to load subscription: %v", err)
+	}
+
+	var features map[string]interface{}
+	if len(subscription.Plan.Features) > 0 {
+		if err := json.Unmarshal(subscription.Plan.Features, &features); err != nil {
+			return false, fmt.Errorf("failed to decode plan features: %v", err)
+		}
+	}
+
+	enabled, _ := features[feature].(bool)
+	return enabled, nil
+}
+
 // ValidateSlug validates and normalizes a tenant slug
 func (s *TenantService) ValidateSlug(slug string) (string, error) {
 	// Convert to lowercase and replace spaces with hyphens
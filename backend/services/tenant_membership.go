@@ -0,0 +1,254 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"golang_saas/graph/model"
+	"golang_saas/models"
+	"golang_saas/utils"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrNotTenantMember        = errors.New("you are not a member of this tenant")
+	ErrTenantInactive         = errors.New("tenant is not active")
+	ErrSwitchPasswordExpired  = errors.New("your password has expired for this tenant; sign in to it to change your password")
+	ErrSwitchTwoFactorMissing = errors.New("this tenant requires two-factor authentication; sign in to it to set it up")
+)
+
+// TenantMembershipService manages the tenants a user belongs to. The user's primary tenant
+// and role are stored on the user; memberships in other tenants only exist as TenantUser rows.
+type TenantMembershipService struct {
+	db *gorm.DB
+}
+
+func NewTenantMembershipService(db *gorm.DB) *TenantMembershipService {
+	return &TenantMembershipService{db: db}
+}
+
+// ActivateTenant makes the user act as a member of the given tenant: TenantID, RoleID, Role
+// and Tenant are replaced by the membership's. The user is only changed in memory. A
+// deactivated membership counts as none.
+func (s *TenantMembershipService) ActivateTenant(user *models.User, tenantID uuid.UUID) error {
+	if user.TenantID == nil {
+		return ErrNotTenantMember
+	}
+	if *user.TenantID == tenantID {
+		return nil
+	}
+
+	var membership models.TenantUser
+	err := s.db.Preload("Role").Preload("Role.Permissions").Preload("Tenant").
+		Where("user_id = ? AND tenant_id = ? AND is_active = ?", user.ID, tenantID, true).
+		First(&membership).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotTenantMember
+		}
+		return fmt.Errorf("failed to load tenant membership: %v", err)
+	}
+
+	user.TenantID = &membership.TenantID
+	user.Tenant = &membership.Tenant
+	user.RoleID = membership.RoleID
+	user.Role = membership.Role
+	// Directly assigned permissions belong to the primary tenant
+	user.Permissions = nil
+
+	return nil
+}
+
+// ListMemberships returns the active tenants a user belongs to, marking the primary tenant
+// and the one the current token was issued for
+func (s *TenantMembershipService) ListMemberships(userID uuid.UUID, currentTenantID *uuid.UUID) ([]*model.TenantMembership, error) {
+	var user models.User
+	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, fmt.Errorf("user not found: %v", err)
+	}
+
+	var memberships []models.TenantUser
+	err := s.db.Preload("Tenant").Preload("Role").
+		Joins("JOIN tenants ON tenants.id = tenant_users.tenant_id AND tenants.deleted_at IS NULL").
+		Where("tenant_users.user_id = ? AND tenant_users.is_active = ? AND tenants.status = ?", userID, true, models.TenantStatusActive).
+		Order("tenant_users.created_at").
+		Find(&memberships).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tenant memberships: %v", err)
+	}
+
+	result := make([]*model.TenantMembership, len(memberships))
+	for i := range memberships {
+		membership := &memberships[i]
+		result[i] = &model.TenantMembership{
+			Tenant:    &membership.Tenant,
+			Role:      &membership.Role,
+			IsPrimary: user.TenantID != nil && *user.TenantID == membership.TenantID,
+			IsCurrent: currentTenantID != nil && *currentTenantID == membership.TenantID,
+		}
+	}
+
+	return result, nil
+}
+
+// IsMember reports whether a user belongs to a tenant, as its primary tenant or through a
+// membership. Deactivated members still belong to it.
+func (s *TenantMembershipService) IsMember(user *models.User, tenantID uuid.UUID) (bool, error) {
+	if user.TenantID != nil && *user.TenantID == tenantID {
+		return true, nil
+	}
+
+	var count int64
+	err := s.db.Model(&models.TenantUser{}).Where("user_id = ? AND tenant_id = ?", user.ID, tenantID).Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to load tenant membership: %v", err)
+	}
+	return count > 0, nil
+}
+
+// saveMembership adds a user to a tenant with the given role, or changes the role they hold
+// there. A previously removed membership is restored, active.
+func saveMembership(tx *gorm.DB, userID, tenantID, roleID uuid.UUID) error {
+	var membership models.TenantUser
+	err := tx.Unscoped().Where("user_id = ? AND tenant_id = ?", userID, tenantID).First(&membership).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		membership = models.TenantUser{
+			UserID:   userID,
+			TenantID: tenantID,
+			RoleID:   roleID,
+		}
+		err = tx.Create(&membership).Error
+	} else if err == nil {
+		updates := map[string]any{"role_id": roleID}
+		if membership.DeletedAt.Valid {
+			updates["deleted_at"] = nil
+			updates["is_active"] = true
+		}
+		err = tx.Unscoped().Model(&models.TenantUser{}).Where("id = ?", membership.ID).Updates(updates).Error
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save tenant membership: %v", err)
+	}
+
+	return nil
+}
+
+// setMemberRole changes the role a user holds in one of their tenants. For the primary
+// tenant the role stored on the user is updated as well.
+func setMemberRole(tx *gorm.DB, user *models.User, tenantID, roleID uuid.UUID) error {
+	if user.TenantID == nil {
+		return ErrNotTenantMember
+	}
+
+	if *user.TenantID == tenantID {
+		if user.RoleID != roleID {
+			if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Update("role_id", roleID).Error; err != nil {
+				return fmt.Errorf("failed to update user role: %v", err)
+			}
+			user.RoleID = roleID
+		}
+	} else {
+		var count int64
+		err := tx.Model(&models.TenantUser{}).Where("user_id = ? AND tenant_id = ?", user.ID, tenantID).Count(&count).Error
+		if err != nil {
+			return fmt.Errorf("failed to load tenant membership: %v", err)
+		}
+		if count == 0 {
+			return ErrNotTenantMember
+		}
+	}
+
+	return saveMembership(tx, user.ID, tenantID, roleID)
+}
+
+// setMemberActive deactivates or reactivates a user's membership in a tenant other than their
+// primary one; the primary tenant deactivates the account itself
+func setMemberActive(tx *gorm.DB, userID, tenantID uuid.UUID, active bool) error {
+	result := tx.Model(&models.TenantUser{}).Where("user_id = ? AND tenant_id = ?", userID, tenantID).Update("is_active", active)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update tenant membership: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotTenantMember
+	}
+	return nil
+}
+
+// activateTokenTenant switches a user loaded for an action token to the tenant the token
+// was issued for
+func activateTokenTenant(db *gorm.DB, user *models.User, claims *utils.ActionClaims) error {
+	if claims.TenantID == "" {
+		return nil
+	}
+
+	tenantID, err := uuid.Parse(claims.TenantID)
+	if err != nil {
+		return err
+	}
+	return NewTenantMembershipService(db).ActivateTenant(user, tenantID)
+}
+
+// SwitchTenant issues tokens for another tenant the user belongs to and ends the current
+// session. The target tenant's login requirements have to be met already.
+func (s *AuthService) SwitchTenant(ctx context.Context, userID, tenantID, sessionID uuid.UUID) (*model.AuthPayload, error) {
+	var user models.User
+	err := s.db.Preload("Role").Preload("Role.Permissions").Preload("Permissions").Preload("Tenant").
+		Where("id = ? AND is_active = ?", userID, true).
+		First(&user).Error
+	if err != nil {
+		return nil, fmt.Errorf("user not found: %v", err)
+	}
+
+	if err := NewTenantMembershipService(s.db).ActivateTenant(&user, tenantID); err != nil {
+		return nil, err
+	}
+	if user.Tenant == nil || user.Tenant.Status != models.TenantStatusActive {
+		return nil, ErrTenantInactive
+	}
+
+	verificationRequired, err := s.requiresEmailVerification(&user)
+	if err != nil {
+		return nil, err
+	}
+	if verificationRequired {
+		return nil, ErrEmailNotVerified
+	}
+
+	expired, err := NewPasswordPolicyService(s.db).IsPasswordExpired(&user)
+	if err != nil {
+		return nil, err
+	}
+	if expired {
+		return nil, ErrSwitchPasswordExpired
+	}
+
+	// A user who signed in with two-factor authentication has already passed it; one who
+	// has not set it up cannot skip the enrollment the target tenant requires
+	twoFactorEnabled, err := NewTwoFactorService(s.db).IsEnabled(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !twoFactorEnabled {
+		authSettings, err := NewTenantSettingsService(s.db).GetAuthSettings(user.TenantID)
+		if err != nil {
+			return nil, err
+		}
+		if authSettings.RequireTwoFactor {
+			return nil, ErrSwitchTwoFactorMissing
+		}
+	}
+
+	authResp, err := s.issueTokens(ctx, &user, uuid.Nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.Logout(ctx, sessionID); err != nil {
+		return nil, err
+	}
+
+	return authResp.toGraphQL(), nil
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"golang_saas/graph/model"
+	"golang_saas/models"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type TenantSettingsService struct {
+	db *gorm.DB
+}
+
+func NewTenantSettingsService(db *gorm.DB) *TenantSettingsService {
+	return &TenantSettingsService{db: db}
+}
+
+// ListSettings returns every setting stored for a tenant
+func (s *TenantSettingsService) ListSettings(ctx context.Context, tenantID uuid.UUID) ([]*model.TenantSetting, error) {
+	var settings []models.TenantSettings
+	err := s.db.Where("tenant_id = ?", tenantID).Order("key ASC").Find(&settings).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tenant settings: %v", err)
+	}
+
+	result := make([]*model.TenantSetting, len(settings))
+	for i := range settings {
+		result[i] = s.convertToGraphQLModel(&settings[i])
+	}
+
+	return result, nil
+}
+
+// GetSetting decodes a tenant setting into dest. A missing setting leaves dest untouched.
+func (s *TenantSettingsService) GetSetting(tenantID uuid.UUID, key string, dest any) error {
+	var setting models.TenantSettings
+	err := s.db.Where("tenant_id = ? AND key = ?", tenantID, key).First(&setting).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to load tenant setting: %v", err)
+	}
+
+	if len(setting.Value) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(setting.Value, dest); err != nil {
+		return fmt.Errorf("failed to decode tenant setting %s: %v", key, err)
+	}
+
+	return nil
+}
+
+// GetAuthSettings returns the tenant's authentication settings. A nil tenant
+// (system users) always gets the defaults.
+func (s *TenantSettingsService) GetAuthSettings(tenantID *uuid.UUID) (*models.TenantAuthSettings, error) {
+	settings := &models.TenantAuthSettings{}
+	if tenantID == nil {
+		return settings, nil
+	}
+
+	if err := s.GetSetting(*tenantID, models.TenantSettingAuth, settings); err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+// UpdateSetting creates or replaces a tenant setting. Known keys are validated
+// against their typed representation.
+func (s *TenantSettingsService) UpdateSetting(ctx context.Context, tenantID uuid.UUID, key string, value map[string]any) (*model.TenantSetting, error) {
+	if key == "" {
+		return nil, errors.New("setting key is required")
+	}
+
+	valueJSON, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal setting value: %v", err)
+	}
+
+	switch key {
+	case models.TenantSettingAuth:
+		var authSettings models.TenantAuthSettings
+		decoder := json.NewDecoder(bytes.NewReader(valueJSON))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&authSettings); err != nil {
+			return nil, fmt.Errorf("invalid auth settings: %v", err)
+		}
+		if valueJSON, err = json.Marshal(authSettings); err != nil {
+			return nil, fmt.Errorf("failed to marshal setting value: %v", err)
+		}
+	case models.TenantSettingPasswordPolicy:
+		policy, err := decodePasswordPolicy(valueJSON)
+		if err != nil {
+			return nil, err
+		}
+		if valueJSON, err = json.Marshal(policy); err != nil {
+			return nil, fmt.Errorf("failed to marshal setting value: %v", err)
+		}
+	}
+
+	setting := models.TenantSettings{
+		TenantID: tenantID,
+		Key:      key,
+		Value:    datatypes.JSON(valueJSON),
+	}
+
+	err = s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "tenant_id"}, {Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value", "updated_at", "deleted_at"}),
+	}).Create(&setting).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to save tenant setting: %v", err)
+	}
+
+	// Reload so the returned row reflects the stored values after an upsert
+	err = s.db.Where("tenant_id = ? AND key = ?", tenantID, key).First(&setting).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return s.convertToGraphQLModel(&setting), nil
+}
+
+// Helper function to convert database model to GraphQL model
+func (s *TenantSettingsService) convertToGraphQLModel(setting *models.TenantSettings) *model.TenantSetting {
+	result := &model.TenantSetting{
+		Key:       setting.Key,
+		UpdatedAt: setting.UpdatedAt,
+	}
+
+	if setting.Value != nil {
+		var value map[string]interface{}
+		if err := json.Unmarshal(setting.Value, &value); err == nil {
+			result.Value = value
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,352 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang_saas/config"
+	"golang_saas/graph/model"
+	"golang_saas/models"
+	"golang_saas/utils"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const recoveryCodeCount = 10
+
+var (
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnrolled    = errors.New("two-factor authentication has not been set up")
+	ErrTwoFactorRequired       = errors.New("two-factor authentication is required by your organization")
+	ErrInvalidTwoFactorCode    = errors.New("invalid two-factor authentication code")
+)
+
+type TwoFactorService struct {
+	db *gorm.DB
+}
+
+func NewTwoFactorService(db *gorm.DB) *TwoFactorService {
+	return &TwoFactorService{db: db}
+}
+
+// IsEnabled reports whether the user has completed two-factor enrollment
+func (s *TwoFactorService) IsEnabled(userID uuid.UUID) (bool, error) {
+	var count int64
+	err := s.db.Model(&models.UserTwoFactor{}).
+		Where("user_id = ? AND enabled = ?", userID, true).
+		Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to check two-factor status: %v", err)
+	}
+
+	return count > 0, nil
+}
+
+// Enroll generates a new TOTP secret for the user. The secret only takes effect
+// once it is confirmed with Enable.
+func (s *TwoFactorService) Enroll(ctx context.Context, user *models.User) (*model.TwoFactorEnrollment, error) {
+	enabled, err := s.IsEnabled(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate secret: %v", err)
+	}
+
+	encryptedSecret, err := utils.EncryptString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt secret: %v", err)
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// Replace any unconfirmed enrollment
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&models.UserTwoFactor{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&models.UserTwoFactor{
+			UserID: user.ID,
+			Secret: encryptedSecret,
+		}).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save two-factor enrollment: %v", err)
+	}
+
+	return &model.TwoFactorEnrollment{
+		Secret:     secret,
+		OtpauthURI: utils.TOTPURI(config.AppConfig.AppName, user.Email, secret),
+	}, nil
+}
+
+// Enable confirms a pending enrollment with a TOTP code and returns a fresh set of recovery codes
+func (s *TwoFactorService) Enable(ctx context.Context, user *models.User, code string) ([]string, error) {
+	var twoFactor models.UserTwoFactor
+	err := s.db.Where("user_id = ?", user.ID).First(&twoFactor).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTwoFactorNotEnrolled
+		}
+		return nil, err
+	}
+	if twoFactor.Enabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	valid, err := s.throttled(ctx, user, func() (bool, error) { return s.verifyTOTP(&twoFactor, code) })
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	var recoveryCodes []string
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Model(&twoFactor).Updates(map[string]any{
+			"enabled":    true,
+			"enabled_at": now,
+		}).Error
+		if err != nil {
+			return fmt.Errorf("failed to enable two-factor authentication: %v", err)
+		}
+
+		if recoveryCodes, err = replaceRecoveryCodes(tx, user.ID); err != nil {
+			return err
+		}
+
+		return syncTenantUserTwoFactor(tx, user, true)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	securityEventService := NewSecurityEventService(s.db)
+	if err := securityEventService.RecordEvent(ctx, models.SecurityEventTwoFactorEnabled, &user.ID, user.TenantID, nil); err != nil {
+		return nil, err
+	}
+
+	return recoveryCodes, nil
+}
+
+// Disable removes the user's two-factor enrollment after checking a TOTP or recovery code
+func (s *TwoFactorService) Disable(ctx context.Context, user *models.User, code string) error {
+	settingsService := NewTenantSettingsService(s.db)
+	authSettings, err := settingsService.GetAuthSettings(user.TenantID)
+	if err != nil {
+		return err
+	}
+	if authSettings.RequireTwoFactor {
+		return ErrTwoFactorRequired
+	}
+
+	valid, err := s.VerifyCode(ctx, user, code)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return ErrInvalidTwoFactorCode
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&models.UserTwoFactor{}).Error; err != nil {
+			return fmt.Errorf("failed to disable two-factor authentication: %v", err)
+		}
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return fmt.Errorf("failed to delete recovery codes: %v", err)
+		}
+
+		return syncTenantUserTwoFactor(tx, user, false)
+	})
+	if err != nil {
+		return err
+	}
+
+	securityEventService := NewSecurityEventService(s.db)
+	return securityEventService.RecordEvent(ctx, models.SecurityEventTwoFactorDisabled, &user.ID, user.TenantID, nil)
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes after checking a TOTP code
+func (s *TwoFactorService) RegenerateRecoveryCodes(ctx context.Context, user *models.User, code string) ([]string, error) {
+	var twoFactor models.UserTwoFactor
+	err := s.db.Where("user_id = ? AND enabled = ?", user.ID, true).First(&twoFactor).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTwoFactorNotEnrolled
+		}
+		return nil, err
+	}
+
+	valid, err := s.throttled(ctx, user, func() (bool, error) { return s.verifyTOTP(&twoFactor, code) })
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	var recoveryCodes []string
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		recoveryCodes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return recoveryCodes, nil
+}
+
+// VerifyCode checks a TOTP code or, failing that, consumes a matching recovery code.
+// Repeated wrong codes lock the user's second factor for a while.
+func (s *TwoFactorService) VerifyCode(ctx context.Context, user *models.User, code string) (bool, error) {
+	var twoFactor models.UserTwoFactor
+	err := s.db.Where("user_id = ? AND enabled = ?", user.ID, true).First(&twoFactor).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, ErrTwoFactorNotEnrolled
+		}
+		return false, err
+	}
+
+	return s.throttled(ctx, user, func() (bool, error) { return s.verifyCode(ctx, user, &twoFactor, code) })
+}
+
+// throttled runs a code check unless the user is locked out after too many wrong codes,
+// and counts its outcome
+func (s *TwoFactorService) throttled(ctx context.Context, user *models.User, check func() (bool, error)) (bool, error) {
+	throttle := NewLoginThrottleService(s.db)
+	if err := throttle.CheckTwoFactor(ctx, user.ID); err != nil {
+		return false, err
+	}
+
+	valid, err := check()
+	if err != nil {
+		return false, err
+	}
+	if valid {
+		throttle.RecordTwoFactorSuccess(ctx, user.ID)
+	} else {
+		throttle.RecordTwoFactorFailure(ctx, user)
+	}
+	return valid, nil
+}
+
+func (s *TwoFactorService) verifyCode(ctx context.Context, user *models.User, twoFactor *models.UserTwoFactor, code string) (bool, error) {
+	valid, err := s.verifyTOTP(twoFactor, code)
+	if err != nil || valid {
+		return valid, err
+	}
+
+	// Recovery codes are single-use; the used_at guard prevents concurrent reuse
+	result := s.db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, utils.HashToken(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to check recovery code: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+
+	securityEventService := NewSecurityEventService(s.db)
+	if err := securityEventService.RecordEvent(ctx, models.SecurityEventRecoveryCodeUsed, &user.ID, user.TenantID, nil); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// verifyTOTP checks a TOTP code against the stored secret. Each time step can
+// only be used once, so a code observed in transit cannot be replayed.
+func (s *TwoFactorService) verifyTOTP(twoFactor *models.UserTwoFactor, code string) (bool, error) {
+	secret, err := utils.DecryptString(twoFactor.Secret)
+	if err != nil {
+		return false, fmt.Errorf("failed to decrypt two-factor secret: %v", err)
+	}
+
+	step, ok := utils.ValidateTOTP(secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	result := s.db.Model(&models.UserTwoFactor{}).
+		Where("id = ? AND last_used_step < ?", twoFactor.ID, step).
+		Update("last_used_step", step)
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to record two-factor code: %v", result.Error)
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+// replaceRecoveryCodes deletes the user's recovery codes and stores hashes of a new set
+func replaceRecoveryCodes(tx *gorm.DB, userID uuid.UUID) ([]string, error) {
+	if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, fmt.Errorf("failed to delete recovery codes: %v", err)
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	records := make([]models.RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate recovery code: %v", err)
+		}
+		codes[i] = code
+		records[i] = models.RecoveryCode{
+			UserID:   userID,
+			CodeHash: utils.HashToken(normalizeRecoveryCode(code)),
+		}
+	}
+
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to save recovery codes: %v", err)
+	}
+
+	return codes, nil
+}
+
+// syncTenantUserTwoFactor mirrors the two-factor state onto the user's tenant memberships
+func syncTenantUserTwoFactor(tx *gorm.DB, user *models.User, enabled bool) error {
+	if user.TenantID == nil {
+		return nil
+	}
+
+	err := tx.Model(&models.TenantUser{}).Where("user_id = ?", user.ID).Update("two_factor_enabled", enabled).Error
+	if err != nil {
+		return fmt.Errorf("failed to update tenant user: %v", err)
+	}
+
+	return nil
+}
+
+// generateRecoveryCode returns a random code formatted as xxxx-xxxx-xxxx-xxxx
+func generateRecoveryCode() (string, error) {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	raw := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf))
+	return fmt.Sprintf("%s-%s-%s-%s", raw[0:4], raw[4:8], raw[8:12], raw[12:16]), nil
+}
+
+// normalizeRecoveryCode strips formatting so codes can be entered loosely
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang_saas/config"
+	"golang_saas/models"
+	"golang_saas/utils"
+
+	"github.com/google/uuid"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const testTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// newTwoFactorTestService returns a service backed by a throwaway SQLite database
+func newTwoFactorTestService(t *testing.T) *TwoFactorService {
+	t.Helper()
+
+	previous := config.AppConfig
+	config.AppConfig = &config.Config{JWTSecret: "two-factor-test"}
+	t.Cleanup(func() { config.AppConfig = previous })
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger:                                   logger.Default.LogMode(logger.Silent),
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.UserTwoFactor{}, &models.RecoveryCode{}, &models.SecurityEvent{}); err != nil {
+		t.Fatal(err)
+	}
+	return NewTwoFactorService(db)
+}
+
+// totpCode computes the code of a time step the way authenticator apps do (RFC 6238)
+func totpCode(t *testing.T, step int64) string {
+	t.Helper()
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(testTOTPSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+func TestVerifyTOTPRejectsReplayedSteps(t *testing.T) {
+	s := newTwoFactorTestService(t)
+
+	twoFactor := models.UserTwoFactor{UserID: uuid.New(), Secret: mustEncrypt(t, testTOTPSecret), Enabled: true}
+	if err := s.db.Create(&twoFactor).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	current := time.Now().Unix() / 30
+	// Run in order: each step builds on the codes accepted before it
+	steps := []struct {
+		name   string
+		offset int64
+		want   bool
+	}{
+		{"current code", 0, true},
+		{"current code replayed", 0, false},
+		{"older code after a newer one", -1, false},
+		{"next code", 1, true},
+		{"next code replayed", 1, false},
+		{"current code after the next one", 0, false},
+	}
+
+	for _, step := range steps {
+		got, err := s.verifyTOTP(&twoFactor, totpCode(t, current+step.offset))
+		if err != nil {
+			t.Fatalf("%s: verifyTOTP() error = %v", step.name, err)
+		}
+		if got != step.want {
+			t.Errorf("%s: verifyTOTP() = %v, want %v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestRecoveryCodesAreSingleUse(t *testing.T) {
+	s := newTwoFactorTestService(t)
+	ctx := context.Background()
+
+	user := &models.User{BaseModel: models.BaseModel{ID: uuid.New()}}
+	other := &models.User{BaseModel: models.BaseModel{ID: uuid.New()}}
+	twoFactor := &models.UserTwoFactor{UserID: user.ID, Secret: mustEncrypt(t, testTOTPSecret)}
+
+	codes, err := replaceRecoveryCodes(s.db, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("replaceRecoveryCodes() returned %d codes, want %d", len(codes), recoveryCodeCount)
+	}
+
+	steps := []struct {
+		name string
+		user *models.User
+		code string
+		want bool
+	}{
+		{"unused code", user, codes[0], true},
+		{"used code", user, codes[0], false},
+		{"another unused code", user, codes[1], true},
+		{"code without dashes in upper case", user, strings.ToUpper(strings.ReplaceAll(codes[2], "-", "")), true},
+		{"code with spaces", user, strings.ReplaceAll(codes[3], "-", " "), true},
+		{"unknown code", user, "aaaa-bbbb-cccc-dddd", false},
+		{"someone else's code", other, codes[4], false},
+	}
+
+	for _, step := range steps {
+		got, err := s.verifyCode(ctx, step.user, twoFactor, step.code)
+		if err != nil {
+			t.Fatalf("%s: verifyCode() error = %v", step.name, err)
+		}
+		if got != step.want {
+			t.Errorf("%s: verifyCode() = %v, want %v", step.name, got, step.want)
+		}
+	}
+
+	// Regenerating replaces every earlier code
+	if _, err := replaceRecoveryCodes(s.db, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.verifyCode(ctx, user, twoFactor, codes[4]); err != nil || got {
+		t.Errorf("verifyCode() with a replaced code = %v, %v, want false", got, err)
+	}
+
+	var events int64
+	s.db.Model(&models.SecurityEvent{}).Where("type = ?", models.SecurityEventRecoveryCodeUsed).Count(&events)
+	if events != 4 {
+		t.Errorf("recorded %d recovery code events, want 4", events)
+	}
+}
+
+func mustEncrypt(t *testing.T, value string) string {
+	t.Helper()
+
+	encrypted, err := utils.EncryptString(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encrypted
+}
@@ -7,12 +7,15 @@ import (
 
 	"golang_saas/graph/model"
 	"golang_saas/models"
-	"golang_saas/utils"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// ErrPrimaryTenantOnly is returned when a tenant the user is only a member of tries to change
+// the account itself, which the user's primary tenant owns
+var ErrPrimaryTenantOnly = errors.New("only the user's primary tenant can change this")
+
 type UserService struct {
 	db *gorm.DB
 }
@@ -45,10 +48,11 @@ func (s *UserService) CreateUser(ctx context.Context, input model.CreateUserInpu
 		return nil, errors.New("role not found")
 	}
 
-	// Hash password
-	hashedPassword, err := utils.HashPassword(input.Password)
+	// Hash password according to the tenant's policy
+	passwordPolicy := NewPasswordPolicyService(s.db)
+	hashedPassword, err := passwordPolicy.HashPassword(role.TenantID, nil, input.Password)
 	if err != nil {
-		return nil, fmt.Errorf("failed to hash password: %v", err)
+		return nil, err
 	}
 
 	// Create user
@@ -62,11 +66,23 @@ func (s *UserService) CreateUser(ctx context.Context, input model.CreateUserInpu
 		TenantID:  role.TenantID, // Inherit tenant from role
 	}
 
-	err = s.db.Create(&user).Error
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		if user.TenantID != nil {
+			return saveMembership(tx, user.ID, *user.TenantID, user.RoleID)
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create user: %v", err)
 	}
 
+	if err := passwordPolicy.RecordPasswordChange(user.ID, hashedPassword); err != nil {
+		return nil, err
+	}
+
 	// Load relationships
 	err = s.db.Preload("Role").Preload("Role.Permissions").Preload("Permissions").Preload("Tenant").First(&user, user.ID).Error
 	if err != nil {
@@ -76,8 +92,10 @@ func (s *UserService) CreateUser(ctx context.Context, input model.CreateUserInpu
 	return &user, nil
 }
 
-// UpdateUser updates an existing user
-func (s *UserService) UpdateUser(ctx context.Context, id string, input model.UpdateUserInput) (*models.User, error) {
+// UpdateUser updates an existing user as administered in the given tenant. A tenant other
+// than the user's primary one only changes the user's membership there; nil administers the
+// account itself.
+func (s *UserService) UpdateUser(ctx context.Context, id string, tenantID *uuid.UUID, input model.UpdateUserInput) (*models.User, error) {
 	userUUID, err := uuid.Parse(id)
 	if err != nil {
 		return nil, fmt.Errorf("invalid user ID: %v", err)
@@ -89,6 +107,10 @@ func (s *UserService) UpdateUser(ctx context.Context, id string, input model.Upd
 		return nil, fmt.Errorf("user not found: %v", err)
 	}
 
+	if !isPrimaryTenant(&user, tenantID) {
+		return s.updateMember(ctx, &user, *tenantID, input)
+	}
+
 	// Update fields
 	if input.FirstName != nil {
 		user.FirstName = *input.FirstName
@@ -112,10 +134,23 @@ func (s *UserService) UpdateUser(ctx context.Context, id string, input model.Upd
 			return nil, errors.New("role not found")
 		}
 
+		// Roles of other tenants are held through the user's membership there
+		if role.TenantID != nil && (user.TenantID == nil || *role.TenantID != *user.TenantID) {
+			return nil, errors.New("cannot assign role from different tenant")
+		}
+
 		user.RoleID = roleUUID
 	}
 
-	err = s.db.Save(&user).Error
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&user).Error; err != nil {
+			return err
+		}
+		if user.TenantID != nil {
+			return saveMembership(tx, user.ID, *user.TenantID, user.RoleID)
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to update user: %v", err)
 	}
@@ -129,13 +164,77 @@ func (s *UserService) UpdateUser(ctx context.Context, id string, input model.Upd
 	return &user, nil
 }
 
-// DeleteUser soft deletes a user
-func (s *UserService) DeleteUser(ctx context.Context, id string) (bool, error) {
+// updateMember changes the role and status a user holds in a tenant other than their primary
+// one. Their name and account status belong to the primary tenant.
+func (s *UserService) updateMember(ctx context.Context, user *models.User, tenantID uuid.UUID, input model.UpdateUserInput) (*models.User, error) {
+	if input.FirstName != nil || input.LastName != nil {
+		return nil, ErrPrimaryTenantOnly
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if input.RoleID != nil {
+			roleUUID, err := uuid.Parse(*input.RoleID)
+			if err != nil {
+				return fmt.Errorf("invalid role ID: %v", err)
+			}
+
+			var role models.Role
+			if err := tx.First(&role, "id = ?", roleUUID).Error; err != nil {
+				return errors.New("role not found")
+			}
+			if role.TenantID == nil || *role.TenantID != tenantID {
+				return errors.New("cannot assign role from different tenant")
+			}
+
+			if err := setMemberRole(tx, user, tenantID, roleUUID); err != nil {
+				return err
+			}
+		}
+		if input.IsActive != nil {
+			return setMemberActive(tx, user.ID, tenantID, *input.IsActive)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if input.IsActive != nil && !*input.IsActive {
+		if err := NewSessionService(s.db).RevokeUserSessionsInTenant(user.ID, &tenantID); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.GetUser(ctx, user.ID.String())
+}
+
+// DeleteUser soft deletes a user as administered in the given tenant. A tenant other than
+// the user's primary one only removes the user's membership there; nil deletes the account.
+func (s *UserService) DeleteUser(ctx context.Context, id string, tenantID *uuid.UUID) (bool, error) {
 	userUUID, err := uuid.Parse(id)
 	if err != nil {
 		return false, fmt.Errorf("invalid user ID: %v", err)
 	}
 
+	var user models.User
+	if err := s.db.First(&user, "id = ?", userUUID).Error; err != nil {
+		return false, fmt.Errorf("user not found: %v", err)
+	}
+
+	if !isPrimaryTenant(&user, tenantID) {
+		result := s.db.Where("user_id = ? AND tenant_id = ?", user.ID, *tenantID).Delete(&models.TenantUser{})
+		if result.Error != nil {
+			return false, fmt.Errorf("failed to remove user from tenant: %v", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return false, ErrNotTenantMember
+		}
+		if err := NewSessionService(s.db).RevokeUserSessionsInTenant(user.ID, tenantID); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
 	err = s.db.Delete(&models.User{}, "id = ?", userUUID).Error
 	if err != nil {
 		return false, fmt.Errorf("failed to delete user: %v", err)
@@ -144,6 +243,12 @@ func (s *UserService) DeleteUser(ctx context.Context, id string) (bool, error) {
 	return true, nil
 }
 
+// isPrimaryTenant reports whether a user administered in the given tenant is administered by
+// the owner of the account: their primary tenant, or the system for a nil tenant
+func isPrimaryTenant(user *models.User, tenantID *uuid.UUID) bool {
+	return tenantID == nil || (user.TenantID != nil && *user.TenantID == *tenantID)
+}
+
 // GetUser gets a user by ID
 func (s *UserService) GetUser(ctx context.Context, id string) (*models.User, error) {
 	userUUID, err := uuid.Parse(id)
@@ -184,7 +289,8 @@ func (s *UserService) ListUsers(ctx context.Context, filter *model.UserFilter, p
 			if err != nil {
 				return nil, fmt.Errorf("invalid tenant ID: %v", err)
 			}
-			query = query.Where("tenant_id = ?", tenantUUID)
+			query = query.Where("tenant_id = ? OR id IN (?)", tenantUUID,
+				s.db.Model(&models.TenantUser{}).Select("user_id").Where("tenant_id = ?", tenantUUID))
 		}
 	}
 
@@ -260,8 +366,9 @@ func (s *UserService) GetUserPermissions(ctx context.Context, userID string) ([]
 	return rbacService.GetUserPermissions(userUUID)
 }
 
-// AssignRole assigns a role to a user
-func (s *UserService) AssignRole(ctx context.Context, input model.AssignRoleInput) (*models.User, error) {
+// AssignRole assigns a role to a user in the given tenant. Outside the user's primary tenant
+// the role of their membership there is changed.
+func (s *UserService) AssignRole(ctx context.Context, tenantID *uuid.UUID, input model.AssignRoleInput) (*models.User, error) {
 	userUUID, err := uuid.Parse(input.UserID)
 	if err != nil {
 		return nil, fmt.Errorf("invalid user ID: %v", err)
@@ -272,6 +379,14 @@ func (s *UserService) AssignRole(ctx context.Context, input model.AssignRoleInpu
 		return nil, fmt.Errorf("invalid role ID: %v", err)
 	}
 
+	var user models.User
+	if err := s.db.First(&user, "id = ?", userUUID).Error; err != nil {
+		return nil, fmt.Errorf("user not found: %v", err)
+	}
+	if !isPrimaryTenant(&user, tenantID) {
+		return s.updateMember(ctx, &user, *tenantID, model.UpdateUserInput{RoleID: &input.RoleID})
+	}
+
 	rbacService := NewRBACService(s.db)
 	err = rbacService.AssignRoleToUser(userUUID, roleUUID)
 	if err != nil {
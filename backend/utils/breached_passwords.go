@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang_saas/config"
+)
+
+//go:embed breached_passwords.txt
+var breachedPasswordList string
+
+// breachedPasswords is the bundled list, lowercased. It ships with the binary so checks
+// work without calling an external breach API.
+var breachedPasswords = func() map[string]struct{} {
+	passwords := make(map[string]struct{})
+	for _, line := range strings.Split(breachedPasswordList, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwords[strings.ToLower(line)] = struct{}{}
+	}
+	return passwords
+}()
+
+// pwnedPasswordsClient calls the range API. A slow API must not hold up password changes for long.
+var pwnedPasswordsClient = &http.Client{Timeout: 3 * time.Second}
+
+// IsBreachedPassword reports whether the password appears on the bundled list of common and
+// breached passwords, ignoring case, or, when PWNED_PASSWORDS_URL is set, in the Pwned
+// Passwords corpus. If the API cannot be reached only the bundled list is used.
+func IsBreachedPassword(password string) bool {
+	if _, found := breachedPasswords[strings.ToLower(password)]; found {
+		return true
+	}
+
+	if config.AppConfig == nil || config.AppConfig.PwnedPasswordsURL == "" {
+		return false
+	}
+	found, err := isPwnedPassword(config.AppConfig.PwnedPasswordsURL, password)
+	if err != nil {
+		log.Printf("Failed to check password against Pwned Passwords: %v", err)
+		return false
+	}
+	return found
+}
+
+// isPwnedPassword looks the password up with the k-anonymity range API: only the first five
+// characters of its SHA-1 hash are sent, and the matching suffixes come back
+func isPwnedPassword(baseURL, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(baseURL, "/")+"/range/"+prefix, nil)
+	if err != nil {
+		return false, err
+	}
+	// Padding hides the real number of suffixes from anyone watching the response size
+	req.Header.Set("Add-Padding", "true")
+	req.Header.Set("User-Agent", "golang_saas")
+
+	resp, err := pwnedPasswordsClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("range API returned %s", resp.Status)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		candidate, count, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !ok || !strings.EqualFold(candidate, suffix) {
+			continue
+		}
+		// Padding entries have a count of zero
+		n, err := strconv.Atoi(count)
+		return err == nil && n > 0, nil
+	}
+	return false, scanner.Err()
+}
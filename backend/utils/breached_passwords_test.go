@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang_saas/config"
+)
+
+// SHA-1 of "P@ssw0rd" is 21BD12DC183F740EE76F27B78EB39C8AD972A757: prefix 21BD1, suffix 2DC18...
+func TestIsPwnedPassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		password string
+		want     bool
+		wantErr  bool
+	}{
+		{"listed", http.StatusOK, "0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n2DC183F740EE76F27B78EB39C8AD972A757:52579\r\n", "P@ssw0rd", true, false},
+		{"lowercase suffix", http.StatusOK, "2dc183f740ee76f27b78eb39c8ad972a757:3\n", "P@ssw0rd", true, false},
+		{"padding entry", http.StatusOK, "2DC183F740EE76F27B78EB39C8AD972A757:0\n", "P@ssw0rd", false, false},
+		{"not listed", http.StatusOK, "0018A45C4D1DEF81644B54AB7F969B88D65:1\n", "P@ssw0rd", false, false},
+		{"empty response", http.StatusOK, "", "P@ssw0rd", false, false},
+		{"server error", http.StatusServiceUnavailable, "", "P@ssw0rd", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/range/21BD1" {
+					t.Errorf("request path = %q, want /range/21BD1", r.URL.Path)
+				}
+				if r.Header.Get("Add-Padding") != "true" {
+					t.Error("request did not ask for padding")
+				}
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			}))
+			defer server.Close()
+
+			got, err := isPwnedPassword(server.URL+"/", tt.password)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("isPwnedPassword() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("isPwnedPassword() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsBreachedPassword(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/21BD1") {
+			fmt.Fprint(w, "2DC183F740EE76F27B78EB39C8AD972A757:52579\n")
+		}
+	}))
+	defer server.Close()
+
+	previous := config.AppConfig
+	t.Cleanup(func() { config.AppConfig = previous })
+
+	tests := []struct {
+		name     string
+		url      string
+		password string
+		want     bool
+	}{
+		{"bundled list", "", "password", true},
+		{"bundled list ignores case", "", "PassWord", true},
+		{"not bundled without API", "", "P@ssw0rd", false},
+		{"found by API", server.URL, "P@ssw0rd", true},
+		{"unknown to API", server.URL, "correct horse battery staple", false},
+		{"API unreachable", "http://127.0.0.1:1", "P@ssw0rd", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.AppConfig = &config.Config{PwnedPasswordsURL: tt.url}
+			if got := IsBreachedPassword(tt.password); got != tt.want {
+				t.Errorf("IsBreachedPassword(%q) = %v, want %v", tt.password, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,896 @@
+package utils
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Condition is a compiled boolean expression in a small CEL-like language, used by access
+// policies. Expressions read variables such as subject.attributes.region and support:
+//
+//   - literals: numbers, 'strings' or "strings", true, false, null and [lists]
+//   - field access with a.b or a["b"], and list indexing with a[0]
+//   - operators: ! - * / % + - < <= > >= == != in && || and cond ? a : b
+//   - has(a.b), size(x), and the methods contains, startsWith, endsWith and matches
+//
+// As in CEL, reading a field that does not exist is an error; has() tests for it first.
+// Numbers are compared as float64, which is what JSON attributes decode to.
+type Condition struct {
+	source string
+	root   exprNode
+}
+
+var ErrInvalidCondition = errors.New("invalid condition")
+
+const (
+	// maxConditionLength and maxConditionDepth keep hostile conditions from exhausting the
+	// stack while they are parsed or evaluated
+	maxConditionLength = 4096
+	maxConditionDepth  = 32
+	// conditionCacheSize is how many compiled conditions are kept
+	conditionCacheSize = 1024
+)
+
+// conditionCache keeps recently used compiled conditions by source, since policies are
+// evaluated far more often than they change
+var conditionCache = newConditionLRU(conditionCacheSize)
+
+// CompileCondition parses an expression
+func CompileCondition(source string) (*Condition, error) {
+	if cached, ok := conditionCache.get(source); ok {
+		return cached, nil
+	}
+
+	if len(source) > maxConditionLength {
+		return nil, fmt.Errorf("%w: longer than %d characters", ErrInvalidCondition, maxConditionLength)
+	}
+	tokens, err := tokenizeCondition(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &conditionParser{tokens: tokens}
+	root, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.at(tokenEOF, "") {
+		return nil, p.errorf("unexpected %q", p.peek().text)
+	}
+
+	condition := &Condition{source: source, root: root}
+	conditionCache.put(source, condition)
+	return condition, nil
+}
+
+// conditionLRU is a fixed-size cache of compiled conditions that evicts the least recently
+// used one
+type conditionLRU struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // most recently used first
+	entries map[string]*list.Element
+}
+
+func newConditionLRU(size int) *conditionLRU {
+	return &conditionLRU{size: size, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+func (c *conditionLRU) get(source string) (*Condition, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[source]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*Condition), true
+}
+
+func (c *conditionLRU) put(source string, condition *Condition) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[source]; ok {
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[source] = c.order.PushFront(condition)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*Condition).source)
+	}
+}
+
+// String returns the source of the condition
+func (c *Condition) String() string {
+	return c.source
+}
+
+// Evaluate evaluates the condition against the variables, which must hold JSON-like values:
+// nil, bool, float64, string, []any and map[string]any
+func (c *Condition) Evaluate(vars map[string]any) (bool, error) {
+	value, err := c.root.eval(vars)
+	if err != nil {
+		return false, err
+	}
+	result, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("condition evaluated to %s, not a boolean", typeName(value))
+	}
+	return result, nil
+}
+
+// Tokenizer
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenString
+	tokenOperator
+)
+
+type conditionToken struct {
+	kind  tokenKind
+	text  string
+	value any
+	pos   int
+}
+
+// conditionOperators lists the operators longest first so two-character ones match first
+var conditionOperators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "+", "-", "*", "/", "%", "(", ")", "[", "]", ".", ",", "?", ":"}
+
+func tokenizeCondition(source string) ([]conditionToken, error) {
+	var tokens []conditionToken
+	i := 0
+	for i < len(source) {
+		c := source[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '_' || isLetter(c):
+			start := i
+			for i < len(source) && (source[i] == '_' || isLetter(source[i]) || isDigit(source[i])) {
+				i++
+			}
+			tokens = append(tokens, conditionToken{kind: tokenIdent, text: source[start:i], pos: start})
+		case isDigit(c):
+			start := i
+			for i < len(source) && (isDigit(source[i]) || source[i] == '.') {
+				i++
+			}
+			number, err := strconv.ParseFloat(source[start:i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid number %q at %d", ErrInvalidCondition, source[start:i], start)
+			}
+			tokens = append(tokens, conditionToken{kind: tokenNumber, text: source[start:i], value: number, pos: start})
+		case c == '"' || c == '\'':
+			start := i
+			var sb strings.Builder
+			i++
+			for {
+				if i >= len(source) {
+					return nil, fmt.Errorf("%w: unterminated string at %d", ErrInvalidCondition, start)
+				}
+				if source[i] == c {
+					i++
+					break
+				}
+				if source[i] == '\\' && i+1 < len(source) {
+					i++
+					switch source[i] {
+					case 'n':
+						sb.WriteByte('\n')
+					case 't':
+						sb.WriteByte('\t')
+					default:
+						sb.WriteByte(source[i])
+					}
+					i++
+					continue
+				}
+				sb.WriteByte(source[i])
+				i++
+			}
+			tokens = append(tokens, conditionToken{kind: tokenString, text: source[start:i], value: sb.String(), pos: start})
+		default:
+			matched := false
+			for _, op := range conditionOperators {
+				if strings.HasPrefix(source[i:], op) {
+					tokens = append(tokens, conditionToken{kind: tokenOperator, text: op, pos: i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("%w: unexpected character %q at %d", ErrInvalidCondition, c, i)
+			}
+		}
+	}
+	return append(tokens, conditionToken{kind: tokenEOF, pos: len(source)}), nil
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Parser
+
+type conditionParser struct {
+	tokens []conditionToken
+	pos    int
+	depth  int
+}
+
+func (p *conditionParser) peek() conditionToken {
+	return p.tokens[p.pos]
+}
+
+func (p *conditionParser) next() conditionToken {
+	token := p.tokens[p.pos]
+	if token.kind != tokenEOF {
+		p.pos++
+	}
+	return token
+}
+
+// at reports whether the next token is of the kind and, if text is not empty, has the text
+func (p *conditionParser) at(kind tokenKind, text string) bool {
+	token := p.peek()
+	return token.kind == kind && (text == "" || token.text == text)
+}
+
+func (p *conditionParser) accept(kind tokenKind, text string) bool {
+	if p.at(kind, text) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *conditionParser) expect(text string) error {
+	if !p.accept(tokenOperator, text) {
+		return p.errorf("expected %q", text)
+	}
+	return nil
+}
+
+func (p *conditionParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w: %s at %d", ErrInvalidCondition, fmt.Sprintf(format, args...), p.peek().pos)
+}
+
+// enter descends one level of nesting; the caller must call leave when it is done
+func (p *conditionParser) enter() error {
+	if p.depth >= maxConditionDepth {
+		return p.errorf("nested more than %d levels deep", maxConditionDepth)
+	}
+	p.depth++
+	return nil
+}
+
+func (p *conditionParser) leave() {
+	p.depth--
+}
+
+// parseExpr parses a conditional expression, the lowest precedence level
+func (p *conditionParser) parseExpr() (exprNode, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	cond, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if !p.accept(tokenOperator, "?") {
+		return cond, nil
+	}
+	then, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	return &conditionalNode{cond: cond, then: then, otherwise: otherwise}, nil
+}
+
+// binaryPrecedence lists the binary operators from the loosest to the tightest binding
+var binaryPrecedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">=", "in"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *conditionParser) parseBinary(level int) (exprNode, error) {
+	if level == len(binaryPrecedence) {
+		return p.parseUnary()
+	}
+
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.binaryOperator(binaryPrecedence[level])
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+}
+
+// binaryOperator consumes the next token if it is one of the operators
+func (p *conditionParser) binaryOperator(ops []string) (string, bool) {
+	token := p.peek()
+	if token.kind != tokenOperator && !(token.kind == tokenIdent && token.text == "in") {
+		return "", false
+	}
+	for _, op := range ops {
+		if token.text == op {
+			p.next()
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *conditionParser) parseUnary() (exprNode, error) {
+	if p.at(tokenOperator, "!") || p.at(tokenOperator, "-") {
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+
+		op := p.next().text
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: op, operand: operand}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *conditionParser) parsePostfix() (exprNode, error) {
+	node, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept(tokenOperator, "."):
+			name := p.next()
+			if name.kind != tokenIdent {
+				return nil, p.errorf("expected a field name")
+			}
+			if p.accept(tokenOperator, "(") {
+				args, err := p.parseArgs()
+				if err != nil {
+					return nil, err
+				}
+				node = &callNode{name: name.text, target: node, args: args}
+			} else {
+				node = &fieldNode{target: node, index: &literalNode{value: name.text}}
+			}
+		case p.accept(tokenOperator, "["):
+			index, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			node = &fieldNode{target: node, index: index}
+		default:
+			return node, nil
+		}
+	}
+}
+
+// parseArgs parses call arguments after the opening parenthesis
+func (p *conditionParser) parseArgs() ([]exprNode, error) {
+	var args []exprNode
+	if p.accept(tokenOperator, ")") {
+		return args, nil
+	}
+	for {
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.accept(tokenOperator, ")") {
+			return args, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *conditionParser) parsePrimary() (exprNode, error) {
+	token := p.next()
+	switch token.kind {
+	case tokenNumber, tokenString:
+		return &literalNode{value: token.value}, nil
+	case tokenIdent:
+		switch token.text {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		case "null":
+			return &literalNode{value: nil}, nil
+		}
+		if p.accept(tokenOperator, "(") {
+			args, err := p.parseArgs()
+			if err != nil {
+				return nil, err
+			}
+			if token.text == "has" {
+				if len(args) != 1 {
+					return nil, p.errorf("has takes one field")
+				}
+				field, ok := args[0].(*fieldNode)
+				if !ok {
+					return nil, p.errorf("has takes a field such as has(a.b)")
+				}
+				return &hasNode{field: field}, nil
+			}
+			return &callNode{name: token.text, args: args}, nil
+		}
+		return &variableNode{name: token.text}, nil
+	case tokenOperator:
+		switch token.text {
+		case "(":
+			node, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return node, nil
+		case "[":
+			list := &listNode{}
+			if p.accept(tokenOperator, "]") {
+				return list, nil
+			}
+			for {
+				item, err := p.parseExpr()
+				if err != nil {
+					return nil, err
+				}
+				list.items = append(list.items, item)
+				if p.accept(tokenOperator, "]") {
+					return list, nil
+				}
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if token.kind == tokenEOF {
+		return nil, p.errorf("unexpected end of condition")
+	}
+	p.pos--
+	return nil, p.errorf("unexpected %q", token.text)
+}
+
+// Evaluation
+
+type exprNode interface {
+	eval(vars map[string]any) (any, error)
+}
+
+type literalNode struct{ value any }
+
+func (n *literalNode) eval(vars map[string]any) (any, error) {
+	return n.value, nil
+}
+
+type variableNode struct{ name string }
+
+func (n *variableNode) eval(vars map[string]any) (any, error) {
+	value, ok := vars[n.name]
+	if !ok {
+		return nil, fmt.Errorf("undeclared variable %s", n.name)
+	}
+	return value, nil
+}
+
+type listNode struct{ items []exprNode }
+
+func (n *listNode) eval(vars map[string]any) (any, error) {
+	list := make([]any, len(n.items))
+	for i, item := range n.items {
+		value, err := item.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		list[i] = value
+	}
+	return list, nil
+}
+
+type fieldNode struct {
+	target exprNode
+	index  exprNode
+}
+
+func (n *fieldNode) eval(vars map[string]any) (any, error) {
+	target, key, err := n.operands(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := target.(type) {
+	case map[string]any:
+		name, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("map keys are strings, not %s", typeName(key))
+		}
+		value, ok := t[name]
+		if !ok {
+			return nil, fmt.Errorf("no such key: %s", name)
+		}
+		return value, nil
+	case []any:
+		index, ok := key.(float64)
+		if !ok || index != float64(int(index)) {
+			return nil, fmt.Errorf("list indexes are integers, not %s", typeName(key))
+		}
+		if index < 0 || int(index) >= len(t) {
+			return nil, fmt.Errorf("index %d out of range", int(index))
+		}
+		return t[int(index)], nil
+	}
+	return nil, fmt.Errorf("cannot read %v of %s", key, typeName(target))
+}
+
+// exists reports whether the field is present, for has()
+func (n *fieldNode) exists(vars map[string]any) (bool, error) {
+	target, key, err := n.operands(vars)
+	if err != nil {
+		return false, err
+	}
+	if m, ok := target.(map[string]any); ok {
+		name, ok := key.(string)
+		if !ok {
+			return false, nil
+		}
+		_, exists := m[name]
+		return exists, nil
+	}
+	return false, nil
+}
+
+func (n *fieldNode) operands(vars map[string]any) (any, any, error) {
+	target, err := n.target.eval(vars)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := n.index.eval(vars)
+	if err != nil {
+		return nil, nil, err
+	}
+	return target, key, nil
+}
+
+type hasNode struct{ field *fieldNode }
+
+func (n *hasNode) eval(vars map[string]any) (any, error) {
+	return n.field.exists(vars)
+}
+
+type unaryNode struct {
+	op      string
+	operand exprNode
+}
+
+func (n *unaryNode) eval(vars map[string]any) (any, error) {
+	value, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "!":
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("cannot negate %s", typeName(value))
+		}
+		return !b, nil
+	default:
+		number, ok := value.(float64)
+		if !ok {
+			return nil, fmt.Errorf("cannot negate %s", typeName(value))
+		}
+		return -number, nil
+	}
+}
+
+type conditionalNode struct {
+	cond, then, otherwise exprNode
+}
+
+func (n *conditionalNode) eval(vars map[string]any) (any, error) {
+	value, err := n.cond.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	cond, ok := value.(bool)
+	if !ok {
+		return nil, fmt.Errorf("condition is %s, not a boolean", typeName(value))
+	}
+	if cond {
+		return n.then.eval(vars)
+	}
+	return n.otherwise.eval(vars)
+}
+
+type binaryNode struct {
+	op          string
+	left, right exprNode
+}
+
+func (n *binaryNode) eval(vars map[string]any) (any, error) {
+	left, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	// && and || short-circuit
+	if n.op == "&&" || n.op == "||" {
+		l, ok := left.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s needs booleans, not %s", n.op, typeName(left))
+		}
+		if (n.op == "&&" && !l) || (n.op == "||" && l) {
+			return l, nil
+		}
+		right, err := n.right.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		r, ok := right.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s needs booleans, not %s", n.op, typeName(right))
+		}
+		return r, nil
+	}
+
+	right, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return valuesEqual(left, right), nil
+	case "!=":
+		return !valuesEqual(left, right), nil
+	case "in":
+		return valueIn(left, right)
+	case "<", "<=", ">", ">=":
+		return compareValues(n.op, left, right)
+	case "+":
+		if l, ok := left.(string); ok {
+			if r, ok := right.(string); ok {
+				return l + r, nil
+			}
+		}
+		if l, ok := left.([]any); ok {
+			if r, ok := right.([]any); ok {
+				return append(append([]any{}, l...), r...), nil
+			}
+		}
+	}
+
+	l, lok := left.(float64)
+	r, rok := right.(float64)
+	if !lok || !rok {
+		return nil, fmt.Errorf("cannot apply %s to %s and %s", n.op, typeName(left), typeName(right))
+	}
+	switch n.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return l / r, nil
+	default:
+		if r == 0 || l != float64(int64(l)) || r != float64(int64(r)) {
+			return nil, errors.New("% needs non-zero integers")
+		}
+		return float64(int64(l) % int64(r)), nil
+	}
+}
+
+type callNode struct {
+	name   string
+	target exprNode // nil for global functions
+	args   []exprNode
+}
+
+func (n *callNode) eval(vars map[string]any) (any, error) {
+	args := make([]any, len(n.args))
+	for i, arg := range n.args {
+		value, err := arg.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+
+	if n.target == nil {
+		switch n.name {
+		case "size":
+			if len(args) != 1 {
+				return nil, errors.New("size takes one argument")
+			}
+			return sizeOf(args[0])
+		}
+		return nil, fmt.Errorf("unknown function %s", n.name)
+	}
+
+	target, err := n.target.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	if n.name == "size" && len(args) == 0 {
+		return sizeOf(target)
+	}
+	if len(args) != 1 {
+		return nil, fmt.Errorf("%s takes one argument", n.name)
+	}
+
+	if n.name == "contains" {
+		if list, ok := target.([]any); ok {
+			return valueIn(args[0], list)
+		}
+	}
+	s, ok := target.(string)
+	arg, argOK := args[0].(string)
+	if !ok || !argOK {
+		return nil, fmt.Errorf("%s needs strings, not %s and %s", n.name, typeName(target), typeName(args[0]))
+	}
+	switch n.name {
+	case "contains":
+		return strings.Contains(s, arg), nil
+	case "startsWith":
+		return strings.HasPrefix(s, arg), nil
+	case "endsWith":
+		return strings.HasSuffix(s, arg), nil
+	case "matches":
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %v", err)
+		}
+		return re.MatchString(s), nil
+	}
+	return nil, fmt.Errorf("unknown method %s", n.name)
+}
+
+func sizeOf(value any) (any, error) {
+	switch v := value.(type) {
+	case string:
+		return float64(len([]rune(v))), nil
+	case []any:
+		return float64(len(v)), nil
+	case map[string]any:
+		return float64(len(v)), nil
+	}
+	return nil, fmt.Errorf("%s has no size", typeName(value))
+}
+
+func valuesEqual(left, right any) bool {
+	return reflect.DeepEqual(left, right)
+}
+
+// valueIn implements the in operator: membership in a list, a key of a map
+func valueIn(value, container any) (any, error) {
+	switch c := container.(type) {
+	case []any:
+		for _, item := range c {
+			if valuesEqual(value, item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case map[string]any:
+		key, ok := value.(string)
+		if !ok {
+			return false, nil
+		}
+		_, exists := c[key]
+		return exists, nil
+	}
+	return nil, fmt.Errorf("cannot look for a value in %s", typeName(container))
+}
+
+func compareValues(op string, left, right any) (any, error) {
+	var cmp int
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare number and %s", typeName(right))
+		}
+		switch {
+		case l < r:
+			cmp = -1
+		case l > r:
+			cmp = 1
+		}
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare string and %s", typeName(right))
+		}
+		cmp = strings.Compare(l, r)
+	default:
+		return nil, fmt.Errorf("cannot compare %s", typeName(left))
+	}
+
+	switch op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	default:
+		return cmp >= 0, nil
+	}
+}
+
+func typeName(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "list"
+	case map[string]any:
+		return "map"
+	}
+	return fmt.Sprintf("%T", value)
+}
@@ -0,0 +1,216 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenizeCondition(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		want    []string
+		wantErr bool
+	}{
+		{"empty", "", nil, false},
+		{"identifiers and dots", "subject.attributes.region", []string{"subject", ".", "attributes", ".", "region"}, false},
+		{"two-character operators", "a<=b&&c!=d||e>=f", []string{"a", "<=", "b", "&&", "c", "!=", "d", "||", "e", ">=", "f"}, false},
+		{"numbers", "1 + 2.5", []string{"1", "+", "2.5"}, false},
+		{"single-quoted string", `'eu-west'`, []string{`'eu-west'`}, false},
+		{"escaped quote", `"a\"b"`, []string{`"a\"b"`}, false},
+		{"in keyword", "x in [1, 2]", []string{"x", "in", "[", "1", ",", "2", "]"}, false},
+		{"whitespace", " a\t==\n'b' ", []string{"a", "==", "'b'"}, false},
+		{"unterminated string", `'abc`, nil, true},
+		{"invalid number", "1.2.3", nil, true},
+		{"unexpected character", "a # b", nil, true},
+		{"lone ampersand", "a & b", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := tokenizeCondition(tt.source)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("tokenizeCondition() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if !errors.Is(err, ErrInvalidCondition) {
+					t.Errorf("tokenizeCondition() error = %v, want ErrInvalidCondition", err)
+				}
+				return
+			}
+			if last := tokens[len(tokens)-1]; last.kind != tokenEOF {
+				t.Fatalf("last token = %q, want EOF", last.text)
+			}
+			var got []string
+			for _, token := range tokens[:len(tokens)-1] {
+				got = append(got, token.text)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tokenizeCondition() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenizeConditionStringValues(t *testing.T) {
+	tokens, err := tokenizeCondition(`'it\'s' "a\tb" "line\n"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []any{"it's", "a\tb", "line\n"}
+	for i, value := range want {
+		if tokens[i].kind != tokenString || tokens[i].value != value {
+			t.Errorf("token %d = %v (%v), want string %q", i, tokens[i].value, tokens[i].kind, value)
+		}
+	}
+}
+
+func TestCompileConditionErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+	}{
+		{"empty", ""},
+		{"dangling operator", "a &&"},
+		{"unclosed parenthesis", "(a == 1"},
+		{"unclosed list", "[1, 2"},
+		{"unclosed index", `a["b"`},
+		{"missing ternary branch", "a ? b"},
+		{"trailing tokens", "a == 1 b"},
+		{"field name expected", "a.1"},
+		{"has without field", "has(a)"},
+		{"has with two arguments", "has(a.b, a.c)"},
+		{"missing comma", "size(a b)"},
+		{"too deeply nested parentheses", strings.Repeat("(", maxConditionDepth+1) + "true" + strings.Repeat(")", maxConditionDepth+1)},
+		{"too deeply nested lists", strings.Repeat("[", maxConditionDepth+1) + strings.Repeat("]", maxConditionDepth+1)},
+		{"too many negations", strings.Repeat("!", maxConditionDepth+1) + "true"},
+		{"too long", "a == '" + strings.Repeat("x", maxConditionLength) + "'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := CompileCondition(tt.source); !errors.Is(err, ErrInvalidCondition) {
+				t.Errorf("CompileCondition(%q) error = %v, want ErrInvalidCondition", tt.source, err)
+			}
+		})
+	}
+}
+
+func TestConditionEvaluate(t *testing.T) {
+	vars := map[string]any{
+		"subject": map[string]any{
+			"id":    "u1",
+			"roles": []any{"TENANT_ADMIN", "TENANT_USER"},
+			"attributes": map[string]any{
+				"region": "eu-west",
+				"level":  float64(3),
+			},
+		},
+		"resource": map[string]any{
+			"owner_id": "u1",
+			"tags":     []any{"finance"},
+		},
+	}
+
+	tests := []struct {
+		source  string
+		want    bool
+		wantErr bool
+	}{
+		{"true", true, false},
+		{"subject.attributes.region == 'eu-west'", true, false},
+		{`subject["attributes"]["level"] >= 3`, true, false},
+		{"subject.id == resource.owner_id && 'finance' in resource.tags", true, false},
+		{"'TENANT_ADMIN' in subject.roles", true, false},
+		{"subject.roles[1] == 'TENANT_USER'", true, false},
+		{"1 + 2 * 3 == 7", true, false},
+		{"(1 + 2) * 3 == 9", true, false},
+		{"7 % 4 == 3 && -1 < 0", true, false},
+		{"!(1 > 2) || missing", true, false},
+		{"false && missing", false, false},
+		{"subject.attributes.level > 5 ? false : true", true, false},
+		{"size(subject.roles) == 2 && subject.roles.size() == 2", true, false},
+		{"subject.attributes.region.startsWith('eu') && subject.attributes.region.endsWith('west')", true, false},
+		{"subject.attributes.region.matches('^[a-z]+-[a-z]+$')", true, false},
+		{"resource.tags.contains('finance')", true, false},
+		{"has(subject.attributes.region) && !has(subject.attributes.team)", true, false},
+		{"[1, 2] + [3] == [1, 2, 3]", true, false},
+		{"'a' + 'b' == 'ab'", true, false},
+		{"null == null", true, false},
+		{"subject.attributes.team == 'x'", false, true},
+		{"missing", false, true},
+		{"1 / 0 == 1", false, true},
+		{"1 < 'a'", false, true},
+		{"subject.attributes.region", false, true},
+		{"subject.roles[5] == 'x'", false, true},
+		{"unknown(1)", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			condition, err := CompileCondition(tt.source)
+			if err != nil {
+				t.Fatalf("CompileCondition() error = %v", err)
+			}
+			got, err := condition.Evaluate(vars)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Evaluate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileConditionNestingLimit(t *testing.T) {
+	source := strings.Repeat("(", maxConditionDepth-1) + "true" + strings.Repeat(")", maxConditionDepth-1)
+	if _, err := CompileCondition(source); err != nil {
+		t.Errorf("CompileCondition() at the nesting limit error = %v", err)
+	}
+}
+
+func TestConditionLRU(t *testing.T) {
+	cache := newConditionLRU(2)
+	conditions := map[string]*Condition{}
+	for _, source := range []string{"a", "b", "c"} {
+		conditions[source] = &Condition{source: source}
+	}
+
+	cache.put("a", conditions["a"])
+	cache.put("b", conditions["b"])
+	if _, ok := cache.get("a"); !ok {
+		t.Fatal("get(a) missed")
+	}
+	// b is now the least recently used
+	cache.put("c", conditions["c"])
+
+	for source, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		got, ok := cache.get(source)
+		if ok != want {
+			t.Errorf("get(%s) found = %v, want %v", source, ok, want)
+		}
+		if ok && got != conditions[source] {
+			t.Errorf("get(%s) returned another condition", source)
+		}
+	}
+	if cache.order.Len() != len(cache.entries) || len(cache.entries) != 2 {
+		t.Errorf("cache holds %d entries in %d slots, want 2", cache.order.Len(), len(cache.entries))
+	}
+}
+
+func TestCompileConditionCacheIsBounded(t *testing.T) {
+	for i := 0; i < conditionCacheSize+10; i++ {
+		if _, err := CompileCondition(fmt.Sprintf("subject.id == 'u%d'", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conditionCache.mu.Lock()
+	defer conditionCache.mu.Unlock()
+	if len(conditionCache.entries) > conditionCacheSize {
+		t.Errorf("condition cache holds %d entries, want at most %d", len(conditionCache.entries), conditionCacheSize)
+	}
+}
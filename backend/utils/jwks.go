@@ -0,0 +1,289 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Supported JWT signing algorithms
+const (
+	SigningAlgRS256 = "RS256"
+	SigningAlgEdDSA = "EdDSA"
+)
+
+const (
+	keyRingRefreshInterval = time.Minute
+	// keyRingMinReload throttles reloads triggered by tokens carrying an unknown kid
+	keyRingMinReload = 10 * time.Second
+)
+
+var ErrNoSigningKey = errors.New("no active JWT signing key")
+
+// SigningKey is a key in the JWT key ring. Only the signing key needs a private key;
+// the others are kept to verify tokens issued before a rotation.
+type SigningKey struct {
+	KID        string
+	Algorithm  string
+	PrivateKey crypto.Signer
+	PublicKey  crypto.PublicKey
+	Signing    bool
+}
+
+// KeyLoader returns the current set of keys, e.g. from the database
+type KeyLoader func() ([]SigningKey, error)
+
+type keyRing struct {
+	mu       sync.RWMutex
+	loader   KeyLoader
+	keys     map[string]*SigningKey
+	signer   *SigningKey
+	loadedAt time.Time
+}
+
+var signingKeys = &keyRing{}
+
+// InitKeyRing sets the loader for JWT keys and loads them. Keys are reloaded
+// periodically so rotations made by other instances are picked up.
+func InitKeyRing(loader KeyLoader) error {
+	signingKeys.mu.Lock()
+	signingKeys.loader = loader
+	signingKeys.mu.Unlock()
+
+	return signingKeys.reload()
+}
+
+func (k *keyRing) reload() error {
+	k.mu.RLock()
+	loader := k.loader
+	k.mu.RUnlock()
+	if loader == nil {
+		return errors.New("JWT key ring is not initialized")
+	}
+
+	loaded, err := loader()
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]*SigningKey, len(loaded))
+	var signer *SigningKey
+	for i := range loaded {
+		key := &loaded[i]
+		keys[key.KID] = key
+		if key.Signing && key.PrivateKey != nil {
+			signer = key
+		}
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.signer = signer
+	k.loadedAt = time.Now()
+	k.mu.Unlock()
+
+	return nil
+}
+
+// refreshIfStale reloads the keys if they are older than maxAge
+func (k *keyRing) refreshIfStale(maxAge time.Duration) {
+	k.mu.RLock()
+	stale := time.Since(k.loadedAt) > maxAge
+	k.mu.RUnlock()
+
+	if stale {
+		// Keep serving the previous keys if the reload fails
+		_ = k.reload()
+	}
+}
+
+func (k *keyRing) signingKey() (*SigningKey, error) {
+	k.refreshIfStale(keyRingRefreshInterval)
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.signer == nil {
+		return nil, ErrNoSigningKey
+	}
+	return k.signer, nil
+}
+
+func (k *keyRing) verificationKey(kid string) (*SigningKey, bool) {
+	k.refreshIfStale(keyRingRefreshInterval)
+
+	k.mu.RLock()
+	key, ok := k.keys[kid]
+	k.mu.RUnlock()
+	if ok {
+		return key, true
+	}
+
+	// The token may have been signed with a key rotated in after our last load
+	k.refreshIfStale(keyRingMinReload)
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok = k.keys[kid]
+	return key, ok
+}
+
+func (k *keyRing) publicKeys() []*SigningKey {
+	k.refreshIfStale(keyRingRefreshInterval)
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	keys := make([]*SigningKey, 0, len(k.keys))
+	for _, key := range k.keys {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// signToken signs claims with the current signing key and sets the kid header
+func signToken(claims jwt.Claims) (string, error) {
+	key, err := signingKeys.signingKey()
+	if err != nil {
+		return "", err
+	}
+
+	method := jwt.GetSigningMethod(key.Algorithm)
+	if method == nil {
+		return "", fmt.Errorf("unsupported signing algorithm %s", key.Algorithm)
+	}
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = key.KID
+	return token.SignedString(key.PrivateKey)
+}
+
+// verificationKeyFunc resolves the public key for a token from its kid header
+func verificationKeyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("token has no key ID")
+	}
+
+	key, ok := signingKeys.verificationKey(kid)
+	if !ok {
+		return nil, errors.New("unknown signing key")
+	}
+
+	// Reject tokens whose header algorithm does not match the key
+	if token.Method.Alg() != key.Algorithm {
+		return nil, errors.New("unexpected signing method")
+	}
+
+	return key.PublicKey, nil
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicJWKS returns the public half of every key in the key ring
+func PublicJWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	for _, key := range signingKeys.publicKeys() {
+		jwk := JWK{Kid: key.KID, Use: "sig", Alg: key.Algorithm}
+
+		switch pub := key.PublicKey.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+
+	return jwks
+}
+
+// GenerateSigningKeyPair creates a new key pair for the algorithm and returns it PEM encoded
+// (PKCS#8 private key, PKIX public key)
+func GenerateSigningKeyPair(algorithm string) (privatePEM, publicPEM string, err error) {
+	var private crypto.Signer
+	switch algorithm {
+	case SigningAlgRS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case SigningAlgEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return "", "", fmt.Errorf("unsupported signing algorithm %s", algorithm)
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return "", "", err
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		return "", "", err
+	}
+
+	privatePEM = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}))
+	publicPEM = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))
+	return privatePEM, publicPEM, nil
+}
+
+// ParsePrivateKeyPEM parses a PKCS#8 PEM private key
+func ParsePrivateKeyPEM(data string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("invalid private key PEM")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key type")
+	}
+	return signer, nil
+}
+
+// ParsePublicKeyPEM parses a PKIX PEM public key
+func ParsePublicKeyPEM(data string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("invalid public key PEM")
+	}
+
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
@@ -16,9 +16,15 @@ type Claims struct {
 	Role        string   `json:"role"`
 	Permissions []string `json:"permissions"`
 	IsSystem    bool     `json:"is_system"`
+	Act         *Actor   `json:"act,omitempty"` // set when a system user impersonates UserID
 	jwt.RegisteredClaims
 }
 
+// Actor identifies the party acting on behalf of the token subject (RFC 8693 "act" claim)
+type Actor struct {
+	UserID string `json:"sub"`
+}
+
 func GenerateJWT(userID, tenantID uuid.UUID, role string, permissions []string, isSystem bool) (string, error) {
 	expirationTime := time.Now().Add(time.Duration(config.AppConfig.JWTExpireHours) * time.Hour)
 
@@ -34,14 +40,34 @@ func GenerateJWT(userID, tenantID uuid.UUID, role string, permissions []string,
 		Permissions: permissions,
 		IsSystem:    isSystem,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Issuer:    config.AppConfig.AppName,
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(config.AppConfig.JWTSecret))
+	return signToken(claims)
+}
+
+// GenerateImpersonationJWT issues a short-lived access token for userID that records the
+// impersonating system user in the act claim
+func GenerateImpersonationJWT(userID, tenantID uuid.UUID, role string, permissions []string, impersonatorID uuid.UUID, ttl time.Duration) (string, error) {
+	claims := &Claims{
+		UserID:      userID.String(),
+		TenantID:    tenantID.String(),
+		Role:        role,
+		Permissions: permissions,
+		Act:         &Actor{UserID: impersonatorID.String()},
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    config.AppConfig.AppName,
+		},
+	}
+
+	return signToken(claims)
 }
 
 func GenerateRefreshJWT(userID, tenantID uuid.UUID) (string, error) {
@@ -56,25 +82,20 @@ func GenerateRefreshJWT(userID, tenantID uuid.UUID) (string, error) {
 		UserID:   userID.String(),
 		TenantID: tenantIDStr,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Issuer:    config.AppConfig.AppName,
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(config.AppConfig.JWTSecret))
+	return signToken(claims)
 }
 
 func ValidateJWT(tokenString string) (*Claims, error) {
 	claims := &Claims{}
 
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
-		}
-		return []byte(config.AppConfig.JWTSecret), nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, claims, verificationKeyFunc)
 
 	if err != nil {
 		return nil, err
@@ -87,37 +108,119 @@ func ValidateJWT(tokenString string) (*Claims, error) {
 	return claims, nil
 }
 
-func RefreshJWT(refreshToken string) (string, string, error) {
-	claims, err := ValidateJWT(refreshToken)
-	if err != nil {
-		return "", "", err
+// ActionClaims are carried by short-lived signed tokens embedded in emailed links
+type ActionClaims struct {
+	Purpose  string `json:"purpose"`
+	Email    string `json:"email"`
+	TenantID string `json:"tenant_id,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// GenerateActionToken signs a token for a single purpose (e.g. email verification) on behalf of a user
+func GenerateActionToken(purpose string, userID uuid.UUID, email string, ttl time.Duration) (string, error) {
+	return GenerateTenantActionToken(purpose, userID, nil, email, ttl)
+}
+
+// GenerateTenantActionToken signs an action token that also names the tenant the user is signing in to
+func GenerateTenantActionToken(purpose string, userID uuid.UUID, tenantID *uuid.UUID, email string, ttl time.Duration) (string, error) {
+	claims := &ActionClaims{
+		Purpose: purpose,
+		Email:   email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Subject:   userID.String(),
+			Audience:  jwt.ClaimStrings{purpose},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    config.AppConfig.AppName,
+		},
 	}
+	if tenantID != nil {
+		claims.TenantID = tenantID.String()
+	}
+
+	return signToken(claims)
+}
+
+// ValidateActionToken validates a token generated by GenerateActionToken for the given purpose
+func ValidateActionToken(tokenString, purpose string) (*ActionClaims, error) {
+	claims := &ActionClaims{}
+
+	token, err := jwt.ParseWithClaims(tokenString, claims, verificationKeyFunc)
 
-	// Parse UUIDs from claims
-	userID, err := uuid.Parse(claims.UserID)
 	if err != nil {
-		return "", "", errors.New("invalid user ID in token")
+		return nil, err
 	}
 
-	var tenantID uuid.UUID
-	if claims.TenantID != "" {
-		tenantID, err = uuid.Parse(claims.TenantID)
-		if err != nil {
-			return "", "", errors.New("invalid tenant ID in token")
-		}
+	if !token.Valid || claims.Purpose != purpose || !claims.VerifyAudience(purpose, true) {
+		return nil, errors.New("invalid token")
 	}
 
-	// Generate new access token
-	accessToken, err := GenerateJWT(userID, tenantID, claims.Role, claims.Permissions, claims.IsSystem)
+	return claims, nil
+}
+
+// OAuthClaims are carried by access tokens issued to OAuth clients. The subject is the
+// user, or the client itself for the client credentials grant; the audience is the client.
+type OAuthClaims struct {
+	ClientID    string   `json:"client_id"`
+	TenantID    string   `json:"tenant_id,omitempty"`
+	Scope       string   `json:"scope,omitempty"`
+	Role        string   `json:"role,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// IDTokenClaims are the claims of an OpenID Connect ID token
+type IDTokenClaims struct {
+	Nonce         string `json:"nonce,omitempty"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+	Name          string `json:"name,omitempty"`
+	GivenName     string `json:"given_name,omitempty"`
+	FamilyName    string `json:"family_name,omitempty"`
+	TenantID      string `json:"tenant_id,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// GenerateOAuthAccessToken signs an access token for an OAuth client
+func GenerateOAuthAccessToken(claims *OAuthClaims, subject string, ttl time.Duration) (string, error) {
+	claims.RegisteredClaims = oauthRegisteredClaims(subject, claims.ClientID, ttl)
+	return signToken(claims)
+}
+
+// GenerateIDToken signs an OpenID Connect ID token for a user signing in to a client
+func GenerateIDToken(claims *IDTokenClaims, subject, clientID string, ttl time.Duration) (string, error) {
+	claims.RegisteredClaims = oauthRegisteredClaims(subject, clientID, ttl)
+	return signToken(claims)
+}
+
+// ValidateOAuthAccessToken validates an access token generated by GenerateOAuthAccessToken
+func ValidateOAuthAccessToken(tokenString string) (*OAuthClaims, error) {
+	claims := &OAuthClaims{}
+
+	token, err := jwt.ParseWithClaims(tokenString, claims, verificationKeyFunc)
+
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 
-	// Generate new refresh token
-	newRefreshToken, err := GenerateRefreshJWT(userID, tenantID)
-	if err != nil {
-		return "", "", err
+	// Platform access tokens share the signing keys but carry no client
+	if !token.Valid || claims.ClientID == "" || !claims.VerifyAudience(claims.ClientID, true) {
+		return nil, errors.New("invalid token")
 	}
 
-	return accessToken, newRefreshToken, nil
+	return claims, nil
+}
+
+// oauthRegisteredClaims are issued by the platform's public URL, the OpenID issuer
+func oauthRegisteredClaims(subject, clientID string, ttl time.Duration) jwt.RegisteredClaims {
+	now := time.Now()
+	return jwt.RegisteredClaims{
+		ID:        uuid.NewString(),
+		Subject:   subject,
+		Audience:  jwt.ClaimStrings{clientID},
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		IssuedAt:  jwt.NewNumericDate(now),
+		Issuer:    config.AppConfig.PublicURL,
+	}
 }
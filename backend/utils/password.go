@@ -1,17 +1,217 @@
 package utils
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
 	"golang_saas/config"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Password hashing algorithms
+const (
+	PasswordHashArgon2id = "argon2id"
+	PasswordHashBcrypt   = "bcrypt"
+)
+
+var ErrInvalidPasswordHash = errors.New("invalid password hash")
+
+// PasswordHasher produces self-describing password hashes. Argon2id hashes use the PHC string
+// format ($argon2id$v=19$m=...,t=...,p=...$salt$hash); bcrypt keeps its $2a$/$2b$ format.
+// Because every hash names its algorithm and parameters, stored hashes keep verifying after
+// the configured algorithm or costs change.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether the password matches a hash this hasher recognizes
+	Verify(password, encoded string) (bool, error)
+	// Recognizes reports whether the hash was produced by this hasher's algorithm
+	Recognizes(encoded string) bool
+	// NeedsRehash reports whether a recognized hash uses weaker parameters than the hasher's
+	NeedsRehash(encoded string) bool
+}
+
+// NewPasswordHasher returns the hasher for an algorithm, configured from the application settings
+func NewPasswordHasher(algorithm string) (PasswordHasher, error) {
+	switch algorithm {
+	case PasswordHashArgon2id:
+		return &Argon2idHasher{
+			Memory:      uint32(config.AppConfig.Argon2Memory),
+			Iterations:  uint32(config.AppConfig.Argon2Iterations),
+			Parallelism: uint8(config.AppConfig.Argon2Parallelism),
+			SaltLength:  16,
+			KeyLength:   32,
+		}, nil
+	case PasswordHashBcrypt:
+		return &BcryptHasher{Cost: config.AppConfig.BCryptCost}, nil
+	default:
+		return nil, fmt.Errorf("unsupported password hash algorithm: %s", algorithm)
+	}
+}
+
+// passwordHasherFor returns the hasher that recognizes an existing hash, or nil
+func passwordHasherFor(encoded string) PasswordHasher {
+	for _, algorithm := range []string{PasswordHashArgon2id, PasswordHashBcrypt} {
+		hasher, _ := NewPasswordHasher(algorithm)
+		if hasher.Recognizes(encoded) {
+			return hasher
+		}
+	}
+	return nil
+}
+
+// HashPassword hashes a password with the configured algorithm
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), config.AppConfig.BCryptCost)
-	return string(bytes), err
+	hasher, err := NewPasswordHasher(config.AppConfig.PasswordHashAlgorithm)
+	if err != nil {
+		return "", err
+	}
+	return hasher.Hash(password)
 }
 
+// CheckPasswordHash reports whether the password matches a hash of any supported algorithm
 func CheckPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
-}
\ No newline at end of file
+	hasher := passwordHasherFor(hash)
+	if hasher == nil {
+		return false
+	}
+
+	valid, err := hasher.Verify(password, hash)
+	return err == nil && valid
+}
+
+// PasswordNeedsRehash reports whether a hash was made with a different algorithm than the
+// configured one, or with weaker parameters
+func PasswordNeedsRehash(hash string) bool {
+	hasher, err := NewPasswordHasher(config.AppConfig.PasswordHashAlgorithm)
+	if err != nil {
+		return false
+	}
+	return !hasher.Recognizes(hash) || hasher.NeedsRehash(hash)
+}
+
+// Argon2idHasher hashes passwords with argon2id. Memory is in KiB.
+type Argon2idHasher struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+type argon2idHash struct {
+	version     int
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h *Argon2idHasher) Verify(password, encoded string) (bool, error) {
+	decoded, err := decodeArgon2idHash(encoded)
+	if err != nil {
+		return false, err
+	}
+	if decoded.version != argon2.Version {
+		return false, ErrInvalidPasswordHash
+	}
+
+	key := argon2.IDKey([]byte(password), decoded.salt, decoded.iterations, decoded.memory, decoded.parallelism, uint32(len(decoded.key)))
+	return subtle.ConstantTimeCompare(key, decoded.key) == 1, nil
+}
+
+func (h *Argon2idHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	decoded, err := decodeArgon2idHash(encoded)
+	if err != nil {
+		return true
+	}
+
+	return decoded.version != argon2.Version ||
+		decoded.memory < h.Memory ||
+		decoded.iterations < h.Iterations ||
+		decoded.parallelism < h.Parallelism ||
+		uint32(len(decoded.salt)) < h.SaltLength ||
+		uint32(len(decoded.key)) < h.KeyLength
+}
+
+// decodeArgon2idHash parses a PHC formatted argon2id hash
+func decodeArgon2idHash(encoded string) (*argon2idHash, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != PasswordHashArgon2id {
+		return nil, ErrInvalidPasswordHash
+	}
+
+	decoded := &argon2idHash{}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &decoded.version); err != nil {
+		return nil, ErrInvalidPasswordHash
+	}
+	var parallelism uint32
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &decoded.memory, &decoded.iterations, &parallelism); err != nil {
+		return nil, ErrInvalidPasswordHash
+	}
+	// argon2 panics on zero iterations or lanes, so such hashes are rejected up front
+	if decoded.iterations < 1 || parallelism < 1 || parallelism > 255 || decoded.memory < 8*parallelism {
+		return nil, ErrInvalidPasswordHash
+	}
+	decoded.parallelism = uint8(parallelism)
+
+	var err error
+	if decoded.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil || len(decoded.salt) == 0 {
+		return nil, ErrInvalidPasswordHash
+	}
+	if decoded.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(decoded.key) == 0 {
+		return nil, ErrInvalidPasswordHash
+	}
+
+	return decoded, nil
+}
+
+// BcryptHasher hashes passwords with bcrypt
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	return string(bytes), err
+}
+
+func (h *BcryptHasher) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h *BcryptHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost < h.Cost
+}
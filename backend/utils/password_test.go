@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"golang_saas/config"
+)
+
+func withPasswordConfig(t *testing.T, algorithm string) {
+	t.Helper()
+	previous := config.AppConfig
+	config.AppConfig = &config.Config{
+		PasswordHashAlgorithm: algorithm,
+		Argon2Memory:          64,
+		Argon2Iterations:      1,
+		Argon2Parallelism:     1,
+		BCryptCost:            4,
+	}
+	t.Cleanup(func() { config.AppConfig = previous })
+}
+
+func TestHashPasswordRoundTrip(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		prefix    string
+	}{
+		{PasswordHashArgon2id, "$argon2id$v=19$m=64,t=1,p=1$"},
+		{PasswordHashBcrypt, "$2a$04$"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			withPasswordConfig(t, tt.algorithm)
+
+			hash, err := HashPassword("correct horse battery staple")
+			if err != nil {
+				t.Fatalf("HashPassword() error = %v", err)
+			}
+			if !strings.HasPrefix(hash, tt.prefix) {
+				t.Errorf("HashPassword() = %q, want prefix %q", hash, tt.prefix)
+			}
+			if !CheckPasswordHash("correct horse battery staple", hash) {
+				t.Error("CheckPasswordHash() rejected the correct password")
+			}
+			if CheckPasswordHash("correct horse battery stapler", hash) {
+				t.Error("CheckPasswordHash() accepted a wrong password")
+			}
+			if PasswordNeedsRehash(hash) {
+				t.Error("PasswordNeedsRehash() = true for a hash with the configured parameters")
+			}
+		})
+	}
+}
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	withPasswordConfig(t, PasswordHashArgon2id)
+	argon2Hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	withPasswordConfig(t, PasswordHashBcrypt)
+	bcryptHash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		algorithm string
+		adjust    func(*config.Config)
+		hash      string
+		want      bool
+	}{
+		{"same argon2id parameters", PasswordHashArgon2id, nil, argon2Hash, false},
+		{"more argon2id memory", PasswordHashArgon2id, func(c *config.Config) { c.Argon2Memory = 128 }, argon2Hash, true},
+		{"more argon2id iterations", PasswordHashArgon2id, func(c *config.Config) { c.Argon2Iterations = 2 }, argon2Hash, true},
+		{"fewer argon2id iterations", PasswordHashArgon2id, func(c *config.Config) { c.Argon2Iterations = 1 }, argon2Hash, false},
+		{"bcrypt to argon2id", PasswordHashArgon2id, nil, bcryptHash, true},
+		{"argon2id to bcrypt", PasswordHashBcrypt, nil, argon2Hash, true},
+		{"higher bcrypt cost", PasswordHashBcrypt, func(c *config.Config) { c.BCryptCost = 5 }, bcryptHash, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withPasswordConfig(t, tt.algorithm)
+			if tt.adjust != nil {
+				tt.adjust(config.AppConfig)
+			}
+			if got := PasswordNeedsRehash(tt.hash); got != tt.want {
+				t.Errorf("PasswordNeedsRehash() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeArgon2idHash(t *testing.T) {
+	const salt = "c29tZXNhbHRzb21lc2FsdA"
+	const key = "a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5"
+
+	tests := []struct {
+		name    string
+		encoded string
+		wantErr bool
+	}{
+		{"valid", "$argon2id$v=19$m=65536,t=3,p=2$" + salt + "$" + key, false},
+		{"wrong algorithm", "$argon2i$v=19$m=65536,t=3,p=2$" + salt + "$" + key, true},
+		{"missing part", "$argon2id$v=19$m=65536,t=3,p=2$" + salt, true},
+		{"malformed parameters", "$argon2id$v=19$m=65536;t=3;p=2$" + salt + "$" + key, true},
+		{"zero iterations", "$argon2id$v=19$m=65536,t=0,p=2$" + salt + "$" + key, true},
+		{"zero parallelism", "$argon2id$v=19$m=65536,t=3,p=0$" + salt + "$" + key, true},
+		{"parallelism out of range", "$argon2id$v=19$m=65536,t=3,p=256$" + salt + "$" + key, true},
+		{"memory below lanes", "$argon2id$v=19$m=8,t=3,p=2$" + salt + "$" + key, true},
+		{"negative memory", "$argon2id$v=19$m=-1,t=3,p=2$" + salt + "$" + key, true},
+		{"bad salt encoding", "$argon2id$v=19$m=65536,t=3,p=2$!!!$" + key, true},
+		{"empty salt", "$argon2id$v=19$m=65536,t=3,p=2$$" + key, true},
+		{"empty key", "$argon2id$v=19$m=65536,t=3,p=2$" + salt + "$", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeArgon2idHash(tt.encoded)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("decodeArgon2idHash() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckPasswordHashRejectsMalformedHashes(t *testing.T) {
+	withPasswordConfig(t, PasswordHashArgon2id)
+
+	// None of these may panic inside argon2 or bcrypt
+	hashes := []string{
+		"",
+		"plaintext",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdHNhbHQ$a2V5a2V5",
+		"$argon2id$v=19$m=64,t=1,p=0$c2FsdHNhbHQ$a2V5a2V5",
+		"$argon2id$v=18$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5",
+		"$2a$04$tooshort",
+	}
+
+	for _, hash := range hashes {
+		if CheckPasswordHash("secret", hash) {
+			t.Errorf("CheckPasswordHash(%q) = true, want false", hash)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors, "12345678901234567890"
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestValidateTOTPTestVectors(t *testing.T) {
+	// The RFC lists 8-digit codes; 6-digit codes are their last six digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			step, ok := ValidateTOTP(rfc6238Secret, tt.code, time.Unix(tt.unix, 0))
+			if !ok {
+				t.Fatalf("ValidateTOTP() rejected the code for %d", tt.unix)
+			}
+			if want := tt.unix / totpPeriod; step != want {
+				t.Errorf("ValidateTOTP() step = %d, want %d", step, want)
+			}
+		})
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	current := now.Unix() / totpPeriod
+	key, err := totpEncoding.DecodeString(rfc6238Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codeAt := func(step int64) string { return hotp(key, step) }
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", rfc6238Secret, codeAt(current), current, true},
+		{"previous step", rfc6238Secret, codeAt(current - 1), current - 1, true},
+		{"next step", rfc6238Secret, codeAt(current + 1), current + 1, true},
+		{"two steps old", rfc6238Secret, codeAt(current - 2), 0, false},
+		{"two steps ahead", rfc6238Secret, codeAt(current + 2), 0, false},
+		{"surrounding spaces", rfc6238Secret, " " + codeAt(current) + " ", current, true},
+		{"lowercase secret", strings.ToLower(rfc6238Secret), codeAt(current), current, true},
+		{"too short", rfc6238Secret, codeAt(current)[:5], 0, false},
+		{"too long", rfc6238Secret, codeAt(current) + "0", 0, false},
+		{"invalid secret", "not base32!", codeAt(current), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := ValidateTOTP(tt.secret, tt.code, now)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("ValidateTOTP() = (%d, %v), want (%d, %v)", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatalf("secret %q is not base32: %v", secret, err)
+	}
+	if len(key) != 20 {
+		t.Errorf("secret has %d bytes, want 20", len(key))
+	}
+
+	now := time.Now()
+	if _, ok := ValidateTOTP(secret, hotp(key, now.Unix()/totpPeriod), now); !ok {
+		t.Error("ValidateTOTP() rejected a code for a generated secret")
+	}
+}
@@ -4,31 +4,42 @@ import { gql } from '@apollo/client';
 export const LOGIN_MUTATION = gql`
   mutation Login($input: LoginInput!) {
     login(input: $input) {
-      token
-      refreshToken
-      user {
-        id
-        email
-        firstName
-        lastName
-        isActive
-        role {
-          id
-          name
-        }
-        tenantId
-        tenant {
-          id
-          name
-          slug
-        }
-        permissions {
+      ... on AuthPayload {
+        token
+        refreshToken
+        user {
           id
-          name
-          resource
-          action
+          email
+          firstName
+          lastName
+          isActive
+          role {
+            id
+            name
+          }
+          tenantId
+          tenant {
+            id
+            name
+            slug
+          }
+          permissions {
+            id
+            name
+            resource
+            action
+          }
         }
       }
+      ... on MfaChallenge {
+        challengeToken
+        expiresAt
+        enrollmentRequired
+      }
+      ... on PasswordChangeRequired {
+        changeToken
+        expiresAt
+      }
     }
   }
 `;
@@ -103,16 +103,27 @@ export const LOGIN_MUTATION = gql`
   ${USER_FRAGMENT}
   mutation Login($input: LoginInput!) {
     login(input: $input) {
-      token
-      refreshToken
-      user {
-        ...UserFragment
+      ... on AuthPayload {
+        token
+        refreshToken
+        user {
+          ...UserFragment
+          tenant {
+            ...TenantFragment
+          }
+        }
         tenant {
           ...TenantFragment
         }
       }
-      tenant {
-        ...TenantFragment
+      ... on MfaChallenge {
+        challengeToken
+        expiresAt
+        enrollmentRequired
+      }
+      ... on PasswordChangeRequired {
+        changeToken
+        expiresAt
       }
     }
   }